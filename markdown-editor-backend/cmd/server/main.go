@@ -4,30 +4,66 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/rs/cors"
+	"markdown-editor-backend/internal/cluster"
+	"markdown-editor-backend/internal/config"
 	"markdown-editor-backend/internal/handlers"
 	"markdown-editor-backend/internal/storage"
 	"markdown-editor-backend/internal/websocket"
 )
 
 func main() {
+	cfg := config.Load()
+
 	// Initialize storage
 	storage := storage.NewMemoryStorage()
 
-	// Initialize WebSocket hub
-	hub := websocket.NewHub()
+	// Initialize WebSocket hub, relaying through Redis when clustered
+	var hub *websocket.Hub
+	var ownership cluster.Ownership
+	if redisAddr := cfg.RedisAddr; redisAddr != "" {
+		nodeID := cfg.NodeID
+		if nodeID == "" {
+			nodeID = uuid.New().String()
+		}
+
+		broker, err := cluster.NewRedisBroker(redisAddr)
+		if err != nil {
+			log.Fatal("Failed to connect to Redis broker:", err)
+		}
+		hub, err = websocket.NewHubWithBroker(nodeID, broker)
+		if err != nil {
+			log.Fatal("Failed to subscribe to Redis broker:", err)
+		}
+		ownership, err = cluster.NewRedisOwnership(redisAddr, cluster.DefaultLeaseTTL)
+		if err != nil {
+			log.Fatal("Failed to connect to Redis ownership table:", err)
+		}
+		log.Printf("Running as cluster node %s via Redis at %s", nodeID, redisAddr)
+	} else {
+		hub = websocket.NewHub()
+		ownership = cluster.NewMemoryOwnership(cluster.DefaultLeaseTTL)
+	}
 	go hub.Run()
 
 	// Initialize handlers
-	h := handlers.NewHandlers(storage, hub)
+	h := handlers.NewHandlers(storage, hub, ownership, cfg)
 
 	// Create router
 	mux := http.NewServeMux()
 
 	// API routes
-	mux.HandleFunc("/api/documents", h.CreateDocument)
-	mux.HandleFunc("/api/documents/get", h.GetDocument)
-	mux.HandleFunc("/api/users", h.CreateUser)
+	mux.HandleFunc("/api/documents", h.WithRateLimit(h.CreateDocument))
+	mux.HandleFunc("/api/documents/get", h.WithRateLimit(h.GetDocument))
+	mux.HandleFunc("/api/documents/import", h.WithRateLimit(h.ImportDocument))
+	mux.HandleFunc("/api/documents/", h.DocumentRoutes) // rate limited per resource
+	mux.HandleFunc("/api/users", h.WithRateLimit(h.CreateUser))
+	mux.HandleFunc("/api/notifications", h.WithRateLimit(h.ListNotifications))
+	mux.HandleFunc("/api/notifications/read", h.WithRateLimit(h.MarkNotificationsRead))
+	mux.HandleFunc("/api/webhooks", h.WithRateLimit(h.Webhooks))
+	mux.HandleFunc("/api/webhooks/", h.WithRateLimit(h.WebhookRoutes))
+	mux.HandleFunc("/api/ws-token", h.GetWSToken)
 	
 	// WebSocket route
 	mux.HandleFunc("/ws", h.HandleWebSocket)
@@ -40,13 +76,13 @@ func main() {
 
 	// Setup CORS
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:5173", "http://localhost:3000"}, // Frontend URLs
+		AllowedOrigins:   cfg.AllowedOrigins, // Frontend URLs, also enforced on WebSocket upgrades
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
 	})
 
-	handler := c.Handler(mux)
+	handler := h.Recover(c.Handler(mux))
 
 	// Start server
 	port := ":8080"
@@ -57,4 +93,5 @@ func main() {
 	if err := http.ListenAndServe(port, handler); err != nil {
 		log.Fatal("Server failed to start:", err)
 	}
-}
\ No newline at end of file
+}
+
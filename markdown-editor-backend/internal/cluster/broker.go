@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"sync"
+)
+
+// Channel names shared by every node in the cluster
+const (
+	DocumentChannel   = "markdowntogether:documents"
+	nodeChannelPrefix = "markdowntogether:node:"
+)
+
+// NodeChannel returns the channel used to address a single node
+func NodeChannel(nodeID string) string {
+	return nodeChannelPrefix + nodeID
+}
+
+// Handler receives messages published on a subscribed channel
+type Handler func(channel string, payload []byte)
+
+// Broker fans messages out between server instances
+type Broker interface {
+	Publish(channel string, payload []byte) error
+	Subscribe(channel string, handler Handler) error
+	Close() error
+}
+
+// MemoryBroker is an in-process broker, used when running a single node
+// or several hubs inside one process
+type MemoryBroker struct {
+	handlers map[string][]Handler
+	mutex    sync.RWMutex
+}
+
+// NewMemoryBroker creates a new in-memory broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		handlers: make(map[string][]Handler),
+	}
+}
+
+// Publish delivers the payload to every handler subscribed to the channel
+func (mb *MemoryBroker) Publish(channel string, payload []byte) error {
+	mb.mutex.RLock()
+	handlers := append([]Handler(nil), mb.handlers[channel]...)
+	mb.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(channel, payload)
+	}
+	return nil
+}
+
+// Subscribe registers a handler for the channel
+func (mb *MemoryBroker) Subscribe(channel string, handler Handler) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.handlers[channel] = append(mb.handlers[channel], handler)
+	return nil
+}
+
+// Close drops all subscriptions
+func (mb *MemoryBroker) Close() error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.handlers = make(map[string][]Handler)
+	return nil
+}
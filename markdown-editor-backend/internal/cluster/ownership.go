@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTTL is how long a node owns a document without renewing
+const DefaultLeaseTTL = 30 * time.Second
+
+// Ownership assigns a single writer node to each document so that
+// operations are sequenced in one place
+type Ownership interface {
+	// Acquire claims or renews the lease for nodeID and reports whether
+	// nodeID is the owner afterwards
+	Acquire(documentID, nodeID string) (bool, error)
+	// Owner returns the node currently holding the lease, or "" if none
+	Owner(documentID string) (string, error)
+	// Release gives up the lease if nodeID holds it
+	Release(documentID, nodeID string) error
+}
+
+type lease struct {
+	nodeID  string
+	expires time.Time
+}
+
+// MemoryOwnership keeps leases in process memory
+type MemoryOwnership struct {
+	leases map[string]*lease
+	ttl    time.Duration
+	mutex  sync.Mutex
+}
+
+// NewMemoryOwnership creates a new in-memory lease table
+func NewMemoryOwnership(ttl time.Duration) *MemoryOwnership {
+	return &MemoryOwnership{
+		leases: make(map[string]*lease),
+		ttl:    ttl,
+	}
+}
+
+func (mo *MemoryOwnership) Acquire(documentID, nodeID string) (bool, error) {
+	mo.mutex.Lock()
+	defer mo.mutex.Unlock()
+
+	now := time.Now()
+	current, exists := mo.leases[documentID]
+	if exists && current.nodeID != nodeID && now.Before(current.expires) {
+		return false, nil
+	}
+
+	mo.leases[documentID] = &lease{nodeID: nodeID, expires: now.Add(mo.ttl)}
+	return true, nil
+}
+
+func (mo *MemoryOwnership) Owner(documentID string) (string, error) {
+	mo.mutex.Lock()
+	defer mo.mutex.Unlock()
+
+	current, exists := mo.leases[documentID]
+	if !exists || time.Now().After(current.expires) {
+		return "", nil
+	}
+	return current.nodeID, nil
+}
+
+func (mo *MemoryOwnership) Release(documentID, nodeID string) error {
+	mo.mutex.Lock()
+	defer mo.mutex.Unlock()
+
+	if current, exists := mo.leases[documentID]; exists && current.nodeID == nodeID {
+		delete(mo.leases, documentID)
+	}
+	return nil
+}
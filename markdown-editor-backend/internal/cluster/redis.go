@@ -0,0 +1,368 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrRedisClosed = errors.New("redis connection closed")
+
+// redisError is an error reply from the server. The connection stays in
+// step after one, unlike after a network or protocol error.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// respConn is a minimal client for the Redis serialization protocol.
+// It only implements what the broker and ownership table need, so that
+// any RESP-speaking server (Redis, KeyDB, a local stand-in) can be used.
+type respConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialRESP(addr string) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &respConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *respConn) writeCommand(args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// readReply returns string, int64, []interface{} or nil depending on the
+// reply type. Error replies are returned as a redisError.
+func (c *respConn) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed redis reply: %q", line)
+	}
+	body := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				// The rest of the array is left unread
+				return nil, fmt.Errorf("redis array element %d: %v", i, err)
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type: %q", line[0])
+	}
+}
+
+func (c *respConn) do(args ...string) (interface{}, error) {
+	if err := c.writeCommand(args...); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *respConn) close() error {
+	return c.conn.Close()
+}
+
+// redisClient serializes commands over a single connection, redialling
+// when the connection breaks
+type redisClient struct {
+	addr  string
+	conn  *respConn
+	mutex sync.Mutex
+}
+
+func (rc *redisClient) do(args ...string) (interface{}, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if rc.conn == nil {
+		conn, err := dialRESP(rc.addr)
+		if err != nil {
+			return nil, err
+		}
+		rc.conn = conn
+	}
+
+	// Any failure but an error reply may leave a partial reply behind
+	reply, err := rc.conn.do(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		rc.conn.close()
+		rc.conn = nil
+	}
+	return reply, err
+}
+
+func (rc *redisClient) close() error {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if rc.conn == nil {
+		return nil
+	}
+	err := rc.conn.close()
+	rc.conn = nil
+	return err
+}
+
+// RedisBroker publishes through Redis PUBLISH/SUBSCRIBE so every node
+// behind the load balancer sees every broadcast
+type RedisBroker struct {
+	client   *redisClient
+	addr     string
+	handlers map[string][]Handler
+	sub      *respConn
+	closed   bool
+	mutex    sync.Mutex
+}
+
+// NewRedisBroker connects to the Redis server at addr
+func NewRedisBroker(addr string) (*RedisBroker, error) {
+	client := &redisClient{addr: addr}
+	if _, err := client.do("PING"); err != nil {
+		return nil, err
+	}
+
+	return &RedisBroker{
+		client:   client,
+		addr:     addr,
+		handlers: make(map[string][]Handler),
+	}, nil
+}
+
+// Publish sends the payload to every node subscribed to the channel
+func (rb *RedisBroker) Publish(channel string, payload []byte) error {
+	_, err := rb.client.do("PUBLISH", channel, string(payload))
+	return err
+}
+
+// Subscribe registers a handler and subscribes the listener connection
+func (rb *RedisBroker) Subscribe(channel string, handler Handler) error {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	if rb.closed {
+		return ErrRedisClosed
+	}
+
+	if _, subscribed := rb.handlers[channel]; subscribed {
+		rb.handlers[channel] = append(rb.handlers[channel], handler)
+		return nil
+	}
+
+	if rb.sub == nil {
+		sub, err := dialRESP(rb.addr)
+		if err != nil {
+			return err
+		}
+		rb.sub = sub
+		go rb.listen(sub)
+	}
+
+	// Replies are consumed by listen. After a failed write it reconnects
+	// and resubscribes the channels that have handlers, so the handler is
+	// only registered once the command is sent.
+	if err := rb.sub.writeCommand("SUBSCRIBE", channel); err != nil {
+		rb.sub.close()
+		return err
+	}
+	rb.handlers[channel] = []Handler{handler}
+	return nil
+}
+
+// listen dispatches pushed messages until the connection fails, then
+// reconnects and resubscribes
+func (rb *RedisBroker) listen(sub *respConn) {
+	for {
+		reply, err := sub.readReply()
+		if err != nil {
+			sub.close()
+			if !rb.reconnect(err) {
+				return
+			}
+			rb.mutex.Lock()
+			sub = rb.sub
+			rb.mutex.Unlock()
+			continue
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 3 {
+			continue
+		}
+		if kind, _ := items[0].(string); kind != "message" {
+			continue
+		}
+		channel, _ := items[1].(string)
+		payload, _ := items[2].(string)
+
+		rb.mutex.Lock()
+		handlers := append([]Handler(nil), rb.handlers[channel]...)
+		rb.mutex.Unlock()
+
+		for _, handler := range handlers {
+			handler(channel, []byte(payload))
+		}
+	}
+}
+
+func (rb *RedisBroker) reconnect(cause error) bool {
+	for attempt := 1; ; attempt++ {
+		rb.mutex.Lock()
+		if rb.closed {
+			rb.mutex.Unlock()
+			return false
+		}
+		rb.mutex.Unlock()
+
+		log.Printf("Redis subscription lost (%v), reconnecting (attempt %d)", cause, attempt)
+		time.Sleep(time.Duration(attempt) * time.Second)
+
+		sub, err := dialRESP(rb.addr)
+		if err != nil {
+			cause = err
+			continue
+		}
+
+		rb.mutex.Lock()
+		for channel := range rb.handlers {
+			if err = sub.writeCommand("SUBSCRIBE", channel); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			rb.mutex.Unlock()
+			sub.close()
+			cause = err
+			continue
+		}
+		rb.sub = sub
+		rb.mutex.Unlock()
+		return true
+	}
+}
+
+// Close shuts down both connections
+func (rb *RedisBroker) Close() error {
+	rb.mutex.Lock()
+	rb.closed = true
+	if rb.sub != nil {
+		rb.sub.close()
+	}
+	rb.mutex.Unlock()
+
+	return rb.client.close()
+}
+
+const ownerKeyPrefix = "markdowntogether:owner:"
+
+// Compare-and-set scripts so a node never renews or drops a lease it
+// lost to another node
+const (
+	renewScript   = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+	releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+)
+
+// RedisOwnership stores document leases as expiring Redis keys
+type RedisOwnership struct {
+	client *redisClient
+	ttl    time.Duration
+}
+
+// NewRedisOwnership connects to the Redis server at addr
+func NewRedisOwnership(addr string, ttl time.Duration) (*RedisOwnership, error) {
+	client := &redisClient{addr: addr}
+	if _, err := client.do("PING"); err != nil {
+		return nil, err
+	}
+	return &RedisOwnership{client: client, ttl: ttl}, nil
+}
+
+func (ro *RedisOwnership) Acquire(documentID, nodeID string) (bool, error) {
+	key := ownerKeyPrefix + documentID
+	ttl := strconv.FormatInt(ro.ttl.Milliseconds(), 10)
+
+	reply, err := ro.client.do("SET", key, nodeID, "NX", "PX", ttl)
+	if err != nil {
+		return false, err
+	}
+	if reply == "OK" {
+		return true, nil
+	}
+
+	reply, err = ro.client.do("EVAL", renewScript, "1", key, nodeID, ttl)
+	if err != nil {
+		return false, err
+	}
+	renewed, _ := reply.(int64)
+	return renewed == 1, nil
+}
+
+func (ro *RedisOwnership) Owner(documentID string) (string, error) {
+	reply, err := ro.client.do("GET", ownerKeyPrefix+documentID)
+	if err != nil {
+		return "", err
+	}
+	owner, _ := reply.(string)
+	return owner, nil
+}
+
+func (ro *RedisOwnership) Release(documentID, nodeID string) error {
+	_, err := ro.client.do("EVAL", releaseScript, "1", ownerKeyPrefix+documentID, nodeID)
+	return err
+}
@@ -0,0 +1,343 @@
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a local stand-in speaking just enough RESP for the broker
+// and the ownership table
+type fakeRedis struct {
+	listener    net.Listener
+	values      map[string]fakeValue
+	subscribers map[string][]*fakeConn
+	mutex       sync.Mutex
+}
+
+type fakeValue struct {
+	value   string
+	expires time.Time
+}
+
+type fakeConn struct {
+	conn  net.Conn
+	mutex sync.Mutex
+}
+
+func (fc *fakeConn) write(reply string) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.conn.Write([]byte(reply))
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &fakeRedis{
+		listener:    listener,
+		values:      make(map[string]fakeValue),
+		subscribers: make(map[string][]*fakeConn),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+	return fr
+}
+
+func (fr *fakeRedis) addr() string {
+	return fr.listener.Addr().String()
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	client := &fakeConn{conn: conn}
+	reader := &respConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	for {
+		reply, err := reader.readReply()
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) > 0 {
+			client.write(fr.execute(client, args))
+		}
+	}
+}
+
+// get must be called with the mutex held
+func (fr *fakeRedis) get(key string) (string, bool) {
+	entry, exists := fr.values[key]
+	if !exists || (!entry.expires.IsZero() && time.Now().After(entry.expires)) {
+		delete(fr.values, key)
+		return "", false
+	}
+	return entry.value, true
+}
+
+func (fr *fakeRedis) execute(client *fakeConn, args []string) string {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		if value, exists := fr.get(args[1]); exists {
+			return bulk(value)
+		}
+		return "$-1\r\n"
+	case "SET":
+		// SET key value NX PX ttl is the only form used
+		if _, exists := fr.get(args[1]); exists {
+			return "$-1\r\n"
+		}
+		ttl, _ := strconv.Atoi(args[5])
+		fr.values[args[1]] = fakeValue{args[2], time.Now().Add(time.Duration(ttl) * time.Millisecond)}
+		return "+OK\r\n"
+	case "EVAL":
+		key, nodeID := args[3], args[4]
+		if value, exists := fr.get(key); !exists || value != nodeID {
+			return ":0\r\n"
+		}
+		switch args[1] {
+		case renewScript:
+			ttl, _ := strconv.Atoi(args[5])
+			fr.values[key] = fakeValue{nodeID, time.Now().Add(time.Duration(ttl) * time.Millisecond)}
+		case releaseScript:
+			delete(fr.values, key)
+		default:
+			return "-ERR unknown script\r\n"
+		}
+		return ":1\r\n"
+	case "SUBSCRIBE":
+		fr.subscribers[args[1]] = append(fr.subscribers[args[1]], client)
+		return "*3\r\n" + bulk("subscribe") + bulk(args[1]) + ":1\r\n"
+	case "PUBLISH":
+		subscribers := fr.subscribers[args[1]]
+		for _, subscriber := range subscribers {
+			go subscriber.write("*3\r\n" + bulk("message") + bulk(args[1]) + bulk(args[2]))
+		}
+		return fmt.Sprintf(":%d\r\n", len(subscribers))
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func TestRedisBrokerDeliversToEverySubscriber(t *testing.T) {
+	fr := startFakeRedis(t)
+
+	received := make(chan string, 4)
+	var brokers []*RedisBroker
+	for i := 0; i < 2; i++ {
+		broker, err := NewRedisBroker(fr.addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer broker.Close()
+		node := i
+		if err := broker.Subscribe(DocumentChannel, func(channel string, payload []byte) {
+			received <- fmt.Sprintf("%d:%s:%s", node, channel, payload)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		brokers = append(brokers, broker)
+	}
+
+	// Subscriptions are acknowledged asynchronously
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fr.mutex.Lock()
+		subscribed := len(fr.subscribers[DocumentChannel])
+		fr.mutex.Unlock()
+		if subscribed == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("brokers did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := brokers[0].Publish(DocumentChannel, []byte("hello\r\nworld")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case message := <-received:
+			got[message] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %v, want a message on both nodes", got)
+		}
+	}
+	for node := 0; node < 2; node++ {
+		want := fmt.Sprintf("%d:%s:hello\r\nworld", node, DocumentChannel)
+		if !got[want] {
+			t.Errorf("node %d did not receive the message, got %v", node, got)
+		}
+	}
+}
+
+func TestRedisOwnershipSingleWriter(t *testing.T) {
+	fr := startFakeRedis(t)
+
+	ownership, err := NewRedisOwnership(fr.addr(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if owned, err := ownership.Acquire("doc", "a"); err != nil || !owned {
+		t.Fatalf("first Acquire = %v, %v; want owned", owned, err)
+	}
+	if owned, err := ownership.Acquire("doc", "b"); err != nil || owned {
+		t.Fatalf("Acquire by another node = %v, %v; want refused", owned, err)
+	}
+	if owned, err := ownership.Acquire("doc", "a"); err != nil || !owned {
+		t.Fatalf("renewal = %v, %v; want owned", owned, err)
+	}
+	if owner, err := ownership.Owner("doc"); err != nil || owner != "a" {
+		t.Fatalf("Owner = %q, %v; want a", owner, err)
+	}
+
+	// Releasing a lease held by someone else is a no-op
+	if err := ownership.Release("doc", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := ownership.Owner("doc"); owner != "a" {
+		t.Fatalf("Owner after foreign release = %q, want a", owner)
+	}
+
+	if err := ownership.Release("doc", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if owned, err := ownership.Acquire("doc", "b"); err != nil || !owned {
+		t.Fatalf("Acquire after release = %v, %v; want owned", owned, err)
+	}
+
+	// An expired lease can be taken over
+	time.Sleep(150 * time.Millisecond)
+	if owned, err := ownership.Acquire("doc", "a"); err != nil || !owned {
+		t.Fatalf("Acquire after expiry = %v, %v; want owned", owned, err)
+	}
+}
+
+func TestRedisClientRedialsAfterBadReplies(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// The first connection answers with a reply the client cannot parse,
+	// later ones speak RESP
+	replies := []string{"?garbage\r\n", "-ERR no such command\r\n+PONG\r\n"}
+	accepted := make(chan int, len(replies))
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- i
+			reply := replies[len(replies)-1]
+			if i < len(replies) {
+				reply = replies[i]
+			}
+			go func() {
+				defer conn.Close()
+				reader := &respConn{conn: conn, reader: bufio.NewReader(conn)}
+				for _, line := range strings.SplitAfter(reply, "\r\n") {
+					if line == "" {
+						break
+					}
+					if _, err := reader.readReply(); err != nil {
+						return
+					}
+					conn.Write([]byte(line))
+				}
+				// Hold the connection open until the client closes it
+				reader.readReply()
+			}()
+		}
+	}()
+
+	client := &redisClient{addr: listener.Addr().String()}
+	defer client.close()
+
+	if _, err := client.do("PING"); err == nil {
+		t.Fatal("malformed reply was accepted")
+	}
+	if client.conn != nil {
+		t.Fatal("connection kept after a malformed reply")
+	}
+
+	// An error reply leaves the connection usable
+	if _, err := client.do("NOPE"); err == nil || err.Error() != "ERR no such command" {
+		t.Fatalf("error reply: err = %v", err)
+	}
+	if reply, err := client.do("PING"); err != nil || reply != "PONG" {
+		t.Fatalf("PING after an error reply = %v, %v", reply, err)
+	}
+	if connections := len(accepted); connections != 2 {
+		t.Fatalf("dialled %d times, want 2", connections)
+	}
+}
+
+func TestRedisBrokerSubscribeFailureDropsHandler(t *testing.T) {
+	fr := startFakeRedis(t)
+
+	broker, err := NewRedisBroker(fr.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	if err := broker.Subscribe(DocumentChannel, func(string, []byte) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the listener connection; it reconnects after a second
+	broker.mutex.Lock()
+	broker.sub.close()
+	broker.mutex.Unlock()
+
+	if err := broker.Subscribe(NodeChannel("a"), func(string, []byte) {}); err == nil {
+		t.Fatal("Subscribe on a broken connection succeeded")
+	}
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if _, registered := broker.handlers[NodeChannel("a")]; registered {
+		t.Fatal("handler kept although the channel was not subscribed")
+	}
+	if len(broker.handlers[DocumentChannel]) != 1 {
+		t.Fatalf("handlers = %v", broker.handlers)
+	}
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds server settings read from the environment
+type Config struct {
+	// Cluster settings, RedisAddr empty for a single node
+	NodeID    string
+	RedisAddr string
+
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For, only
+	// safe behind a load balancer that sets it
+	TrustProxyHeaders bool
+
+	// Origins allowed for both CORS and WebSocket upgrades
+	AllowedOrigins []string
+	// RequireWSToken makes upgrades present a token from /api/ws-token
+	RequireWSToken bool
+	// CSRFSecret signs those tokens; must be shared by all cluster nodes
+	CSRFSecret string
+
+	// WebSocket limits
+	EnableCompression bool
+	MaxMessageSize    int64 // bytes per incoming frame
+	MaxOperationSize  int   // characters inserted by one operation
+
+	// PresenceIdleTimeout marks a connection idle after this long without
+	// operations or cursor moves
+	PresenceIdleTimeout time.Duration
+
+	// Webhook URLs that receive every event, signed with WebhookSecret.
+	// Subscriptions added through the API only live on the node that
+	// created them. Webhooks cannot reach loopback or private addresses
+	// unless WebhookAllowPrivate is set.
+	WebhookURLs          []string
+	WebhookSecret        string
+	WebhookEditDebounce  time.Duration
+	WebhookSnapshotEvery int // versions between document.snapshot events
+	WebhookAllowPrivate  bool
+
+	// PDFFontDir holds the DejaVu TrueType fonts used for PDF exports;
+	// without them only Windows-1252 text can be rendered
+	PDFFontDir string
+}
+
+// Load reads the configuration, falling back to defaults for unset values
+func Load() *Config {
+	return &Config{
+		NodeID:    getEnv("NODE_ID", ""),
+		RedisAddr: getEnv("REDIS_ADDR", ""),
+
+		TrustProxyHeaders: getEnvBool("TRUST_PROXY_HEADERS", false),
+
+		AllowedOrigins: getEnvList("ALLOWED_ORIGINS", []string{"http://localhost:5173", "http://localhost:3000"}),
+		RequireWSToken: getEnvBool("WS_REQUIRE_TOKEN", false),
+		CSRFSecret:     getEnv("CSRF_SECRET", randomSecret()),
+
+		EnableCompression: getEnvBool("WS_ENABLE_COMPRESSION", false),
+		MaxMessageSize:    int64(getEnvInt("WS_MAX_MESSAGE_SIZE", 1<<20)),
+		MaxOperationSize:  getEnvInt("WS_MAX_OPERATION_SIZE", 256<<10),
+
+		PresenceIdleTimeout: time.Duration(getEnvInt("PRESENCE_IDLE_SECONDS", 60)) * time.Second,
+
+		WebhookURLs:          getEnvList("WEBHOOK_URLS", nil),
+		WebhookSecret:        getEnv("WEBHOOK_SECRET", ""),
+		WebhookEditDebounce:  time.Duration(getEnvInt("WEBHOOK_EDIT_DEBOUNCE_MS", 5000)) * time.Millisecond,
+		WebhookSnapshotEvery: getEnvInt("WEBHOOK_SNAPSHOT_EVERY", 100),
+		WebhookAllowPrivate:  getEnvBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false),
+
+		PDFFontDir: getEnv("PDF_FONT_DIR", ""),
+	}
+}
+
+// getEnv returns the environment variable or a fallback when unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvList splits a comma-separated variable
+func getEnvList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value %q for %s, using %d", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value %q for %s, using %t", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// randomSecret generates a per-process secret for when none is configured
+func randomSecret() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatal("Failed to generate secret:", err)
+	}
+	return hex.EncodeToString(buf)
+}
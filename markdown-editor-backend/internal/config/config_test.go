@@ -0,0 +1,41 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg := Load()
+	if cfg.EnableCompression {
+		t.Fatal("WebSocket compression must be opted into with WS_ENABLE_COMPRESSION")
+	}
+	if cfg.MaxMessageSize != 1<<20 || cfg.MaxOperationSize != 256<<10 {
+		t.Fatalf("limits = %d bytes, %d characters", cfg.MaxMessageSize, cfg.MaxOperationSize)
+	}
+}
+
+func TestLoadFromEnvironment(t *testing.T) {
+	t.Setenv("NODE_ID", "node-a")
+	t.Setenv("WS_ENABLE_COMPRESSION", "true")
+	t.Setenv("WS_MAX_MESSAGE_SIZE", "4096")
+	// Invalid values fall back to the default
+	t.Setenv("WS_MAX_OPERATION_SIZE", "lots")
+	t.Setenv("ALLOWED_ORIGINS", " https://editor.example.com,,https://docs.example.com ")
+	t.Setenv("PRESENCE_IDLE_SECONDS", "90")
+
+	cfg := Load()
+	if cfg.NodeID != "node-a" || !cfg.EnableCompression || cfg.MaxMessageSize != 4096 {
+		t.Fatalf("config = %+v", cfg)
+	}
+	if cfg.MaxOperationSize != 256<<10 {
+		t.Fatalf("MaxOperationSize = %d, want the default", cfg.MaxOperationSize)
+	}
+	if want := []string{"https://editor.example.com", "https://docs.example.com"}; !reflect.DeepEqual(cfg.AllowedOrigins, want) {
+		t.Fatalf("AllowedOrigins = %q, want %q", cfg.AllowedOrigins, want)
+	}
+	if cfg.PresenceIdleTimeout != 90*time.Second {
+		t.Fatalf("PresenceIdleTimeout = %v", cfg.PresenceIdleTimeout)
+	}
+}
@@ -0,0 +1,305 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"gopkg.in/yaml.v3"
+	"markdown-editor-backend/internal/render"
+	"markdown-editor-backend/pkg/types"
+)
+
+// Export formats
+const (
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+	FormatText     = "txt"
+	FormatZip      = "zip"
+)
+
+// Formats lists the supported formats
+var Formats = []string{FormatMarkdown, FormatHTML, FormatText, FormatZip, FormatPDF}
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// File is an exported document ready to be downloaded
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Exporter converts documents to downloadable files. PDFs are cached for
+// each document version.
+type Exporter struct {
+	renderer *render.Renderer
+	fontDir  string               // "" for the core PDF fonts
+	pdfs     map[string]cachedPDF // documentID -> latest PDF
+	mutex    sync.Mutex
+}
+
+// NewExporter creates an exporter sharing the renderer and its cache. PDFs
+// use the DejaVu fonts in fontDir when it has them.
+func NewExporter(renderer *render.Renderer, fontDir string) *Exporter {
+	return &Exporter{
+		renderer: renderer,
+		fontDir:  usablePDFFontDir(fontDir),
+		pdfs:     make(map[string]cachedPDF),
+	}
+}
+
+// Export converts a document to the given format
+func (e *Exporter) Export(doc *types.Document, format string) (*File, error) {
+	name := fileName(doc.Title)
+
+	switch format {
+	case FormatMarkdown:
+		return &File{
+			Name:        name + ".md",
+			ContentType: "text/markdown; charset=utf-8",
+			Data:        []byte(Markdown(doc, doc.Content)),
+		}, nil
+	case FormatHTML:
+		data, err := e.html(doc)
+		if err != nil {
+			return nil, err
+		}
+		return &File{Name: name + ".html", ContentType: "text/html; charset=utf-8", Data: data}, nil
+	case FormatText:
+		return &File{
+			Name:        name + ".txt",
+			ContentType: "text/plain; charset=utf-8",
+			Data:        []byte(e.renderer.PlainText(doc.Content)),
+		}, nil
+	case FormatZip:
+		data, err := e.bundle(doc, name)
+		if err != nil {
+			return nil, err
+		}
+		return &File{Name: name + ".zip", ContentType: "application/zip", Data: data}, nil
+	case FormatPDF:
+		data, err := e.pdf(doc)
+		if err != nil {
+			return nil, err
+		}
+		return &File{Name: name + ".pdf", ContentType: "application/pdf", Data: data}, nil
+	default:
+		return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnknownFormat, format, strings.Join(Formats, ", "))
+	}
+}
+
+// Markdown prefixes content with YAML front matter describing doc,
+// followed by the metadata it was imported with
+func Markdown(doc *types.Document, content string) string {
+	// JSON strings are valid double-quoted YAML scalars
+	title, _ := json.Marshal(doc.Title)
+
+	var buf strings.Builder
+	buf.WriteString("---\n")
+	fmt.Fprintf(&buf, "title: %s\n", title)
+	fmt.Fprintf(&buf, "version: %d\n", doc.Version)
+	fmt.Fprintf(&buf, "lastModified: %s\n", doc.LastModified.UTC().Format(time.RFC3339))
+	if len(doc.Metadata) > 0 {
+		if metadata, err := yaml.Marshal(doc.Metadata); err == nil {
+			buf.Write(metadata)
+		}
+	}
+	buf.WriteString("---\n\n")
+	buf.WriteString(content)
+	if !strings.HasSuffix(content, "\n") {
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="generator" content="MarkdownTogether">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 46rem; margin: 2rem auto; padding: 0 1rem; font: 16px/1.6 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #1f2328; }
+h1, h2 { border-bottom: 1px solid #d1d9e0; padding-bottom: .3em; }
+a { color: #0969da; }
+code { font: 85% ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; background: #f6f8fa; padding: .2em .4em; border-radius: 6px; }
+pre { background: #f6f8fa; padding: 1em; overflow: auto; border-radius: 6px; }
+pre code { background: none; padding: 0; }
+blockquote { margin: 0; padding: 0 1em; color: #59636e; border-left: .25em solid #d1d9e0; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d1d9e0; padding: 6px 13px; }
+img { max-width: 100%; }
+li:has(> input[type=checkbox]) { list-style: none; }
+.footnotes { font-size: 85%; color: #59636e; }
+</style>
+</head>
+<body>
+{{.Body}}
+</body>
+</html>
+`))
+
+// html renders the document as a standalone page with embedded styles
+func (e *Exporter) html(doc *types.Document) ([]byte, error) {
+	body, err := e.renderer.RenderDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = pageTemplate.Execute(&buf, struct {
+		Title string
+		Body  template.HTML
+	}{
+		Title: doc.Title,
+		// Already sanitized by the renderer
+		Body: template.HTML(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// attachmentTypes maps the image types that are bundled to extensions
+var attachmentTypes = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+var dataURIPattern = regexp.MustCompile(`^data:([\w.+-]+/[\w.+-]+)(?:;[\w-]+=[^;,]*)*;base64,`)
+
+// bundle zips the Markdown, the standalone HTML and the attachments. The
+// server keeps no uploaded files, so the attachments are the images
+// embedded as data URIs; they are written to attachments/ and the Markdown
+// refers to them there. Remote images are left as links.
+func (e *Exporter) bundle(doc *types.Document, name string) ([]byte, error) {
+	content := doc.Content
+	var attachments []bundleFile
+
+	for _, destination := range e.imageDestinations(content) {
+		data, mediaType, ok := decodeDataImage(destination)
+		if !ok {
+			continue
+		}
+		extension, known := attachmentTypes[mediaType]
+		if !known {
+			continue
+		}
+
+		path := fmt.Sprintf("attachments/image-%d%s", len(attachments)+1, extension)
+		attachments = append(attachments, bundleFile{path, data})
+		content = strings.Replace(content, destination, path, 1)
+	}
+
+	page, err := e.html(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := append([]bundleFile{
+		{name + ".md", []byte(Markdown(doc, content))},
+		{name + ".html", page},
+	}, attachments...)
+
+	for _, file := range files {
+		writer, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.path,
+			Method:   zip.Deflate,
+			Modified: doc.LastModified,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type bundleFile struct {
+	path string
+	data []byte
+}
+
+// imageDestinations returns the targets of the images in the Markdown
+func (e *Exporter) imageDestinations(content string) []string {
+	var destinations []string
+	ast.Walk(e.renderer.Parse([]byte(content)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if image, ok := n.(*ast.Image); ok && entering {
+			destinations = append(destinations, string(image.Destination))
+		}
+		return ast.WalkContinue, nil
+	})
+	return destinations
+}
+
+// decodeDataImage returns the data and lower-cased media type of a base64
+// data URI
+func decodeDataImage(destination string) ([]byte, string, bool) {
+	match := dataURIPattern.FindStringSubmatch(destination)
+	if match == nil {
+		return nil, "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Map(dropSpace, destination[len(match[0]):]))
+	if err != nil {
+		return nil, "", false
+	}
+	return data, strings.ToLower(match[1]), true
+}
+
+func dropSpace(r rune) rune {
+	if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+		return -1
+	}
+	return r
+}
+
+// fileName turns a title into a safe download name
+func fileName(title string) string {
+	var buf strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			buf.WriteRune(r)
+			dash = false
+		} else if !dash && buf.Len() > 0 {
+			buf.WriteByte('-')
+			dash = true
+		}
+		if buf.Len() >= 64 {
+			break
+		}
+	}
+
+	name := strings.Trim(buf.String(), "-")
+	if name == "" {
+		return "document"
+	}
+	return name
+}
+
+// ContentDisposition returns the header offering the file as a download
+func (f *File) ContentDisposition() string {
+	return fmt.Sprintf("attachment; filename=%q", f.Name)
+}
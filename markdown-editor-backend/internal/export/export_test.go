@@ -0,0 +1,146 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"markdown-editor-backend/internal/render"
+	"markdown-editor-backend/pkg/types"
+)
+
+// pngDataURI returns a data URI of a PNG of the given size
+func pngDataURI(t *testing.T, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func testDocument(content string) *types.Document {
+	return &types.Document{
+		ID:           "doc",
+		Title:        "Trip: Notes",
+		Content:      content,
+		Version:      7,
+		LastModified: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Metadata:     map[string]interface{}{"tags": []interface{}{"travel"}},
+	}
+}
+
+func TestMarkdownFrontMatter(t *testing.T) {
+	got := Markdown(testDocument("# Day one"), "# Day one")
+	want := "---\n" +
+		"title: \"Trip: Notes\"\n" +
+		"version: 7\n" +
+		"lastModified: 2024-05-01T12:00:00Z\n" +
+		"tags:\n    - travel\n" +
+		"---\n\n" +
+		"# Day one\n"
+	if got != want {
+		t.Fatalf("Markdown =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBundleWritesAttachments(t *testing.T) {
+	first, second := pngDataURI(t, 1, 1), pngDataURI(t, 2, 2)
+	content := "![first](" + first + ")\n\n![remote](https://example.com/a.png)\n\n![second](" + second + ")\n"
+	exporter := NewExporter(render.NewRenderer(), "")
+
+	file, err := exporter.Export(testDocument(content), FormatZip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "trip-notes.zip" || file.ContentType != "application/zip" {
+		t.Fatalf("file = %s %s", file.Name, file.ContentType)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(file.Data), int64(len(file.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, entry := range archive.File {
+		reader, err := entry.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name], _ = io.ReadAll(reader)
+		reader.Close()
+	}
+
+	for _, name := range []string{"trip-notes.md", "trip-notes.html", "attachments/image-1.png", "attachments/image-2.png"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("bundle lacks %s, has %d files", name, len(files))
+		}
+	}
+	if len(files) != 4 {
+		t.Fatalf("bundle has %d files, want 4", len(files))
+	}
+	for name, uri := range map[string]string{"attachments/image-1.png": first, "attachments/image-2.png": second} {
+		want, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/png;base64,"))
+		if !bytes.Equal(files[name], want) {
+			t.Fatalf("%s does not hold the embedded image", name)
+		}
+	}
+
+	markdown := string(files["trip-notes.md"])
+	for _, link := range []string{
+		"![first](attachments/image-1.png)",
+		"![remote](https://example.com/a.png)",
+		"![second](attachments/image-2.png)",
+	} {
+		if !strings.Contains(markdown, link) {
+			t.Fatalf("bundled Markdown lacks %q:\n%s", link, markdown)
+		}
+	}
+	if strings.Contains(markdown, "data:") {
+		t.Fatal("bundled Markdown still embeds the images")
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	exporter := NewExporter(render.NewRenderer(), "")
+	doc := testDocument("# Day one\n\n<script>alert(1)</script>\n\n**bold**\n")
+
+	tests := []struct {
+		format      string
+		name        string
+		contentType string
+		contains    string
+	}{
+		{FormatMarkdown, "trip-notes.md", "text/markdown; charset=utf-8", "title: \"Trip: Notes\""},
+		{FormatHTML, "trip-notes.html", "text/html; charset=utf-8", "<title>Trip: Notes</title>"},
+		{FormatText, "trip-notes.txt", "text/plain; charset=utf-8", "bold"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			file, err := exporter.Export(doc, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.Name != tt.name || file.ContentType != tt.contentType {
+				t.Fatalf("file = %s %s", file.Name, file.ContentType)
+			}
+			if !strings.Contains(string(file.Data), tt.contains) {
+				t.Fatalf("%s export lacks %q:\n%s", tt.format, tt.contains, file.Data)
+			}
+			if tt.format != FormatMarkdown && strings.Contains(string(file.Data), "alert") {
+				t.Fatalf("%s export kept the script", tt.format)
+			}
+		})
+	}
+
+	if _, err := exporter.Export(doc, "docx"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("unknown format: %v, want ErrUnknownFormat", err)
+	}
+}
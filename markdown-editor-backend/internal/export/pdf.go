@@ -0,0 +1,740 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"markdown-editor-backend/pkg/types"
+)
+
+// FormatPDF renders the document to a paginated PDF with a title page
+const FormatPDF = "pdf"
+
+// pdfFontFiles are the TrueType fonts looked up in the font directory by
+// style. The core PDF fonts used without them only cover Windows-1252.
+var pdfFontFiles = map[string]string{
+	"":   "DejaVuSans.ttf",
+	"B":  "DejaVuSans-Bold.ttf",
+	"I":  "DejaVuSans-Oblique.ttf",
+	"BI": "DejaVuSans-BoldOblique.ttf",
+}
+
+const pdfMonoFontFile = "DejaVuSansMono.ttf"
+
+// Page layout in millimetres and font sizes in points
+const (
+	pdfMargin      = 20.0
+	pdfBodySize    = 11.0
+	pdfCodeSize    = 9.0
+	pdfTableSize   = 10.0
+	pdfListIndent  = 7.0
+	pdfQuoteIndent = 6.0
+	pdfCellPadding = 1.5
+)
+
+var pdfHeadingSizes = []float64{22, 18, 15, 13, 12, 11}
+
+// Images are decoded whole while embedding, so their size is bounded by
+// what their header claims before they are accepted
+const (
+	pdfMaxImagePixels    = 4096 * 4096
+	pdfMaxDocumentPixels = 4 * pdfMaxImagePixels
+)
+
+// pdfLinkSchemes are the URL schemes links may use, besides relative
+// links and fragments. Other links are written as plain text.
+var pdfLinkSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// maxCachedPDFs bounds the PDF cache; one entry is dropped when full. PDFs
+// embed their images, so fewer are kept than renderings.
+const maxCachedPDFs = 100
+
+type cachedPDF struct {
+	version int
+	data    []byte
+}
+
+// usablePDFFontDir returns dir if it holds the regular, bold and mono
+// fonts, or "" to fall back to the core fonts
+func usablePDFFontDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	for _, name := range []string{pdfFontFiles[""], pdfFontFiles["B"], pdfMonoFontFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			log.Printf("PDF font %s not found in %s, using core fonts", name, dir)
+			return ""
+		}
+	}
+	return dir
+}
+
+// pdfWriter lays out the Markdown syntax tree on PDF pages
+type pdfWriter struct {
+	pdf    *gofpdf.Fpdf
+	source []byte
+	utf8   bool
+	sans   string
+	mono   string
+	// translate converts text to what the current fonts can encode
+	translate func(string) string
+
+	size   float64
+	bold   bool
+	italic bool
+	strike bool
+	code   bool
+	quote  int
+	link   string
+	images int
+	pixels int64 // of the images embedded so far
+	// outline is the level of the last bookmark, -1 before the first
+	outline int
+}
+
+// pdf returns the document as a PDF, reusing the cached one while the
+// version is unchanged. The data is shared and must not be modified.
+func (e *Exporter) pdf(doc *types.Document) ([]byte, error) {
+	documentID, version := doc.ID, doc.Version
+
+	e.mutex.Lock()
+	cached, exists := e.pdfs[documentID]
+	e.mutex.Unlock()
+	if exists && cached.version == version {
+		return cached.data, nil
+	}
+
+	data, err := e.renderPDF(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, exists := e.pdfs[documentID]; !exists && len(e.pdfs) >= maxCachedPDFs {
+		for id := range e.pdfs {
+			delete(e.pdfs, id)
+			break
+		}
+	}
+	// A slower export of an older version must not replace a newer one
+	if current, exists := e.pdfs[documentID]; !exists || current.version <= version {
+		e.pdfs[documentID] = cachedPDF{version: version, data: data}
+	}
+	return data, nil
+}
+
+// Forget drops the cached exports of a deleted document
+func (e *Exporter) Forget(documentID string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.pdfs, documentID)
+}
+
+// renderPDF lays out the document with a title page and numbered pages
+func (e *Exporter) renderPDF(doc *types.Document) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", e.fontDir)
+	w := &pdfWriter{pdf: pdf, sans: "Helvetica", mono: "Courier", size: pdfBodySize, outline: -1}
+
+	if e.fontDir != "" {
+		w.utf8, w.sans, w.mono = true, "DejaVu", "DejaVuMono"
+		for _, style := range []string{"", "B", "I", "BI"} {
+			file := pdfFontFiles[style]
+			if _, err := os.Stat(filepath.Join(e.fontDir, file)); err != nil {
+				// Fall back to the upright font of the same weight
+				file = pdfFontFiles[strings.TrimSuffix(style, "I")]
+			}
+			pdf.AddUTF8Font(w.sans, style, file)
+		}
+		for _, style := range []string{"", "B", "I", "BI"} {
+			pdf.AddUTF8Font(w.mono, style, pdfMonoFontFile)
+		}
+		w.translate = basicPlane
+	} else {
+		w.translate = pdf.UnicodeTranslatorFromDescriptor("")
+	}
+
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetTitle(doc.Title, true)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		// The title page is not numbered
+		if pdf.PageNo() == 1 {
+			return
+		}
+		pdf.SetY(-pdfMargin + 5)
+		pdf.SetFont(w.sans, "", 9)
+		pdf.SetTextColor(110, 110, 110)
+		pdf.CellFormat(0, 5, fmt.Sprintf("%d / {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	w.titlePage(doc)
+
+	pdf.AddPage()
+	w.source = []byte(doc.Content)
+	w.blocks(e.renderer.Parse(w.source), false)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// basicPlane replaces characters the TrueType fonts cannot index
+func basicPlane(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r > 0xFFFF {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+func (w *pdfWriter) titlePage(doc *types.Document) {
+	pdf := w.pdf
+	pdf.AddPage()
+	_, pageHeight := pdf.GetPageSize()
+
+	pdf.SetY(pageHeight / 3)
+	pdf.SetFont(w.sans, "B", 28)
+	pdf.SetTextColor(31, 35, 40)
+	pdf.MultiCell(0, 12, w.translate(doc.Title), "", "C", false)
+
+	pdf.Ln(6)
+	pdf.SetFont(w.sans, "", 11)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.CellFormat(0, 6, fmt.Sprintf("Version %d", doc.Version), "", 1, "C", false, 0, "")
+	pdf.CellFormat(0, 6, w.translate(doc.LastModified.UTC().Format("2 January 2006 15:04 MST")), "", 1, "C", false, 0, "")
+}
+
+// lineHeight is the distance between lines at the current size
+func (w *pdfWriter) lineHeight() float64 {
+	return w.size * 0.5
+}
+
+// applyFont sets the font and color for the current inline state
+func (w *pdfWriter) applyFont() {
+	style := ""
+	if w.bold {
+		style += "B"
+	}
+	if w.italic {
+		style += "I"
+	}
+	if w.strike {
+		style += "S"
+	}
+
+	family := w.sans
+	if w.code {
+		family = w.mono
+	}
+	switch {
+	case w.link != "":
+		style += "U"
+		w.pdf.SetTextColor(9, 105, 218)
+	case w.quote > 0:
+		w.pdf.SetTextColor(89, 99, 110)
+	default:
+		w.pdf.SetTextColor(31, 35, 40)
+	}
+	w.pdf.SetFont(family, style, w.size)
+}
+
+// write adds inline text, wrapping at the right margin
+func (w *pdfWriter) write(s string) {
+	w.applyFont()
+	if w.link != "" {
+		w.pdf.WriteLinkString(w.lineHeight(), w.translate(s), w.link)
+		return
+	}
+	w.pdf.Write(w.lineHeight(), w.translate(s))
+}
+
+// indent runs fn with the left margin moved right by width
+func (w *pdfWriter) indent(width float64, fn func()) {
+	left, _, _, _ := w.pdf.GetMargins()
+	w.pdf.SetLeftMargin(left + width)
+	if w.pdf.GetX() < left+width {
+		w.pdf.SetX(left + width)
+	}
+	fn()
+	w.pdf.SetLeftMargin(left)
+	w.pdf.SetX(left)
+}
+
+// contentWidth is the width between the current margins
+func (w *pdfWriter) contentWidth() float64 {
+	pageWidth, _ := w.pdf.GetPageSize()
+	left, _, right, _ := w.pdf.GetMargins()
+	return pageWidth - left - right
+}
+
+// ensureSpace starts a new page unless height fits on this one
+func (w *pdfWriter) ensureSpace(height float64) {
+	_, pageHeight := w.pdf.GetPageSize()
+	if w.pdf.GetY()+height > pageHeight-pdfMargin {
+		w.pdf.AddPage()
+	}
+}
+
+// blocks lays out the block children of n; tight lists keep paragraphs
+// without spacing
+func (w *pdfWriter) blocks(n ast.Node, tight bool) {
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		w.block(child, tight)
+	}
+}
+
+func (w *pdfWriter) block(n ast.Node, tight bool) {
+	pdf := w.pdf
+
+	switch node := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		w.inline(node)
+		pdf.Ln(w.lineHeight())
+		if !tight {
+			pdf.Ln(2)
+		}
+	case *ast.Heading:
+		level := node.Level
+		if level > len(pdfHeadingSizes) {
+			level = len(pdfHeadingSizes)
+		}
+		w.size, w.bold = pdfHeadingSizes[level-1], true
+		// Keep headings with the text that follows them
+		w.ensureSpace(w.lineHeight() + 3*pdfBodySize*0.5)
+		pdf.Ln(2)
+		w.bookmark(altText(node, w.source), level-1)
+		w.inline(node)
+		pdf.Ln(w.lineHeight())
+		w.size, w.bold = pdfBodySize, false
+		pdf.Ln(2)
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		var buf bytes.Buffer
+		lines := node.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			buf.Write(segment.Value(w.source))
+		}
+		w.size, w.code = pdfCodeSize, true
+		w.applyFont()
+		pdf.SetFillColor(246, 248, 250)
+		// Neither font set has a tab glyph
+		code := strings.ReplaceAll(strings.TrimRight(buf.String(), "\n"), "\t", "    ")
+		pdf.MultiCell(0, w.lineHeight(), w.translate(code), "", "L", true)
+		w.size, w.code = pdfBodySize, false
+		pdf.Ln(3)
+	case *ast.Blockquote:
+		w.quote++
+		w.indent(pdfQuoteIndent, func() { w.blocks(node, false) })
+		w.quote--
+	case *ast.List:
+		number := node.Start
+		for item := node.FirstChild(); item != nil; item = item.NextSibling() {
+			marker := "•"
+			if !w.utf8 {
+				marker = "-"
+			}
+			if node.IsOrdered() {
+				marker = fmt.Sprintf("%d.", number)
+				number++
+			}
+			left, _, _, _ := pdf.GetMargins()
+			w.applyFont()
+			pdf.SetX(left)
+			pdf.CellFormat(pdfListIndent, w.lineHeight(), w.translate(marker), "", 0, "L", false, 0, "")
+			w.indent(pdfListIndent, func() { w.blocks(item, node.IsTight) })
+		}
+		if node.IsTight {
+			pdf.Ln(2)
+		}
+	case *ast.ThematicBreak:
+		w.rule()
+	case *ast.HTMLBlock:
+		// Raw HTML is not rendered
+	case *east.Table:
+		w.table(node)
+	case *east.FootnoteList:
+		w.rule()
+		w.size = pdfCodeSize
+		for note := node.FirstChild(); note != nil; note = note.NextSibling() {
+			if footnote, ok := note.(*east.Footnote); ok {
+				left, _, _, _ := pdf.GetMargins()
+				w.applyFont()
+				pdf.SetX(left)
+				pdf.CellFormat(pdfListIndent, w.lineHeight(), fmt.Sprintf("%d.", footnote.Index), "", 0, "L", false, 0, "")
+				w.indent(pdfListIndent, func() { w.blocks(footnote, true) })
+			}
+		}
+		w.size = pdfBodySize
+	default:
+		w.blocks(node, tight)
+	}
+}
+
+// bookmark adds an outline entry; levels cannot skip one, so a heading
+// nested deeper than its predecessor allows is moved up
+func (w *pdfWriter) bookmark(title string, level int) {
+	if level > w.outline+1 {
+		level = w.outline + 1
+	}
+	w.outline = level
+
+	// Bookmarks are encoded like text in the current font
+	w.applyFont()
+	if !w.utf8 {
+		title = w.translate(title)
+	}
+	w.pdf.Bookmark(title, level, -1)
+}
+
+func (w *pdfWriter) rule() {
+	pdf := w.pdf
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+
+	pdf.Ln(2)
+	pdf.SetDrawColor(209, 217, 224)
+	pdf.Line(left, pdf.GetY(), pageWidth-right, pdf.GetY())
+	pdf.Ln(4)
+}
+
+// inline writes the inline children of n
+func (w *pdfWriter) inline(n ast.Node) {
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		switch node := child.(type) {
+		case *ast.Text:
+			w.write(string(node.Segment.Value(w.source)))
+			if node.HardLineBreak() {
+				w.pdf.Ln(w.lineHeight())
+			} else if node.SoftLineBreak() {
+				w.write(" ")
+			}
+		case *ast.String:
+			w.write(string(node.Value))
+		case *ast.CodeSpan:
+			w.code = true
+			w.inline(node)
+			w.code = false
+		case *ast.Emphasis:
+			if node.Level >= 2 {
+				bold := w.bold
+				w.bold = true
+				w.inline(node)
+				w.bold = bold
+			} else {
+				italic := w.italic
+				w.italic = true
+				w.inline(node)
+				w.italic = italic
+			}
+		case *east.Strikethrough:
+			w.strike = true
+			w.inline(node)
+			w.strike = false
+		case *ast.Link:
+			w.link = safeLink(string(node.Destination))
+			w.inline(node)
+			w.link = ""
+		case *ast.AutoLink:
+			w.link = safeLink(string(node.URL(w.source)))
+			w.write(string(node.Label(w.source)))
+			w.link = ""
+		case *ast.Image:
+			w.image(node)
+		case *ast.RawHTML:
+		case *east.TaskCheckBox:
+			if node.IsChecked {
+				w.write("[x] ")
+			} else {
+				w.write("[ ] ")
+			}
+		case *east.FootnoteLink:
+			w.write(fmt.Sprintf("[%d]", node.Index))
+		case *east.FootnoteBacklink:
+		default:
+			w.inline(node)
+		}
+	}
+}
+
+// image embeds an image given as a data URI on its own line. Other images
+// are not fetched and show their alternative text instead.
+func (w *pdfWriter) image(node *ast.Image) {
+	pdf := w.pdf
+	alt := altText(node, w.source)
+
+	data, mediaType, ok := decodeDataImage(string(node.Destination))
+	imageType := map[string]string{"image/png": "PNG", "image/jpeg": "JPG", "image/gif": "GIF"}[mediaType]
+	if ok && imageType != "" {
+		// gofpdf fails the whole document on a broken image
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || !w.allowPixels(config.Width, config.Height) {
+			ok = false
+		}
+	}
+	if !ok || imageType == "" {
+		italic := w.italic
+		w.italic = true
+		w.write("[image: " + alt + "]")
+		w.italic = italic
+		return
+	}
+
+	w.images++
+	name := fmt.Sprintf("image-%d", w.images)
+	options := gofpdf.ImageOptions{ImageType: imageType}
+	info := pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(data))
+	if pdf.Err() || info == nil {
+		pdf.ClearError()
+		w.write("[image: " + alt + "]")
+		return
+	}
+
+	width, height := info.Extent()
+	if maxWidth := w.contentWidth(); width > maxWidth {
+		width, height = maxWidth, height*maxWidth/width
+	}
+	_, pageHeight := pdf.GetPageSize()
+	if maxHeight := pageHeight - 2*pdfMargin - 10; height > maxHeight {
+		width, height = width*maxHeight/height, maxHeight
+	}
+
+	left, _, _, _ := pdf.GetMargins()
+	if pdf.GetX() > left {
+		pdf.Ln(w.lineHeight())
+	}
+	w.ensureSpace(height)
+	pdf.ImageOptions(name, left, pdf.GetY(), width, height, false, options, 0, "")
+	pdf.SetY(pdf.GetY() + height + 1)
+}
+
+// allowPixels counts an image against the pixel budgets, refusing it if
+// it is too large
+func (w *pdfWriter) allowPixels(width, height int) bool {
+	pixels := int64(width) * int64(height)
+	if width <= 0 || height <= 0 || pixels > pdfMaxImagePixels || w.pixels+pixels > pdfMaxDocumentPixels {
+		return false
+	}
+	w.pixels += pixels
+	return true
+}
+
+// safeLink returns the destination if it may be linked to, "" otherwise
+func safeLink(destination string) string {
+	destination = strings.TrimSpace(destination)
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return ""
+	}
+	if parsed.Scheme == "" || pdfLinkSchemes[parsed.Scheme] {
+		return destination
+	}
+	return ""
+}
+
+// table lays out a table with columns sized to their content
+func (w *pdfWriter) table(table *east.Table) {
+	pdf := w.pdf
+
+	var rows [][]string
+	var aligns []string
+	columns := 0
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, w.translate(altText(cell, w.source)))
+			if row == table.FirstChild() {
+				aligns = append(aligns, pdfAlign(cell))
+			}
+		}
+		if len(cells) > columns {
+			columns = len(cells)
+		}
+		rows = append(rows, cells)
+	}
+	if columns == 0 {
+		return
+	}
+
+	w.size = pdfTableSize
+	lineHeight := w.lineHeight()
+
+	// Natural widths, shrunk when wider than the page
+	widths := make([]float64, columns)
+	for i, cells := range rows {
+		w.bold = i == 0
+		w.applyFont()
+		for j, cell := range cells {
+			if width := pdf.GetStringWidth(cell) + 2*pdfCellPadding + 1; width > widths[j] {
+				widths[j] = width
+			}
+		}
+	}
+	for j := range widths {
+		if widths[j] < 12 {
+			widths[j] = 12
+		}
+	}
+	fitColumns(widths, w.contentWidth())
+
+	left, _, _, _ := pdf.GetMargins()
+	pdf.SetDrawColor(209, 217, 224)
+	pdf.SetFillColor(246, 248, 250)
+	for i, cells := range rows {
+		w.bold = i == 0
+		w.applyFont()
+
+		wrapped := make([][]string, columns)
+		lines := 1
+		for j := 0; j < columns; j++ {
+			cell := ""
+			if j < len(cells) {
+				cell = cells[j]
+			}
+			wrapped[j] = w.split(cell, widths[j]-2*pdfCellPadding)
+			if len(wrapped[j]) > lines {
+				lines = len(wrapped[j])
+			}
+		}
+
+		height := float64(lines)*lineHeight + 2*pdfCellPadding
+		w.ensureSpace(height)
+		x, y := left, pdf.GetY()
+		for j := 0; j < columns; j++ {
+			style := "D"
+			if i == 0 {
+				style = "FD"
+			}
+			pdf.Rect(x, y, widths[j], height, style)
+			for k, line := range wrapped[j] {
+				pdf.SetXY(x+pdfCellPadding, y+pdfCellPadding+float64(k)*lineHeight)
+				align := "L"
+				if j < len(aligns) {
+					align = aligns[j]
+				}
+				pdf.CellFormat(widths[j]-2*pdfCellPadding, lineHeight, line, "", 0, align, false, 0, "")
+			}
+			x += widths[j]
+		}
+		pdf.SetXY(left, y+height)
+	}
+
+	w.size, w.bold = pdfBodySize, false
+	pdf.Ln(4)
+}
+
+// fitColumns shrinks the widths to fit available. Columns narrower than an
+// equal share keep their width and the wide ones share the rest in
+// proportion, so short columns are not wrapped to make room for long text.
+func fitColumns(widths []float64, available float64) {
+	total := 0.0
+	for _, width := range widths {
+		total += width
+	}
+	if total <= available {
+		return
+	}
+
+	wide := make([]bool, len(widths))
+	for j := range wide {
+		wide[j] = true
+	}
+	remaining, count := available, len(widths)
+	for changed := true; changed && count > 0; {
+		changed = false
+		share := remaining / float64(count)
+		for j, width := range widths {
+			if wide[j] && width <= share {
+				wide[j] = false
+				remaining -= width
+				count--
+				changed = true
+			}
+		}
+	}
+
+	wideTotal := 0.0
+	for j, width := range widths {
+		if wide[j] {
+			wideTotal += width
+		}
+	}
+	for j := range widths {
+		if wide[j] {
+			widths[j] *= remaining / wideTotal
+		}
+	}
+}
+
+// pdfAlign maps a table cell's alignment to a CellFormat alignment
+func pdfAlign(cell ast.Node) string {
+	if cell, ok := cell.(*east.TableCell); ok {
+		switch cell.Alignment {
+		case east.AlignCenter:
+			return "C"
+		case east.AlignRight:
+			return "R"
+		}
+	}
+	return "L"
+}
+
+// split wraps translated text to width in the current font
+func (w *pdfWriter) split(text string, width float64) []string {
+	if text == "" {
+		return nil
+	}
+	// SplitText indexes glyph widths by rune, which only suits the
+	// TrueType fonts; the core fonts take the translated bytes
+	if w.utf8 {
+		return w.pdf.SplitText(text, width+2*w.pdf.GetCellMargin())
+	}
+	var lines []string
+	for _, line := range w.pdf.SplitLines([]byte(text), width) {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// altText collects the text of inline children, for image descriptions,
+// table cells and outline entries
+func altText(n ast.Node, source []byte) string {
+	var buf strings.Builder
+	ast.Walk(n, func(child ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := child.(type) {
+		case *ast.Text:
+			buf.Write(node.Segment.Value(source))
+			if node.SoftLineBreak() || node.HardLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(node.Value)
+		case *ast.AutoLink:
+			buf.Write(node.Label(source))
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(buf.String())
+}
@@ -0,0 +1,151 @@
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"markdown-editor-backend/internal/render"
+)
+
+// pngHeaderURI returns a data URI of a PNG that only has a header, claiming
+// the given size
+func pngHeaderURI(width, height uint32) string {
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:], width)
+	binary.BigEndian.PutUint32(header[4:], height)
+	header[8] = 8 // bit depth, grayscale
+
+	chunk := append([]byte("IHDR"), header...)
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(header)))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func embeddedImages(data []byte) int {
+	return bytes.Count(data, []byte("/Subtype /Image"))
+}
+
+func TestPDFImages(t *testing.T) {
+	exporter := NewExporter(render.NewRenderer(), "")
+
+	tests := []struct {
+		name    string
+		content string
+		want    int
+	}{
+		{"small image", "![ok](" + pngDataURI(t, 4, 4) + ")", 1},
+		{"image over the pixel budget", "![large](" + pngDataURI(t, 4097, 4097) + ")", 0},
+		{"header claiming a huge image", "![bomb](" + pngHeaderURI(100000, 100000) + ")", 0},
+		{"broken image", "![broken](data:image/png;base64,aGVsbG8=)", 0},
+		{"remote image", "![remote](https://example.com/a.png)", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := testDocument(tt.content)
+			doc.ID = tt.name
+			file, err := exporter.Export(doc, FormatPDF)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(file.Data, []byte("%PDF-")) {
+				t.Fatal("export is not a PDF")
+			}
+			if got := embeddedImages(file.Data); got != tt.want {
+				t.Fatalf("embedded %d images, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFPixelBudgetCoversTheDocument(t *testing.T) {
+	w := &pdfWriter{}
+	for i := 0; i < pdfMaxDocumentPixels/pdfMaxImagePixels; i++ {
+		if !w.allowPixels(4096, 4096) {
+			t.Fatalf("image %d refused within the budget", i+1)
+		}
+	}
+	if w.allowPixels(1, 1) {
+		t.Fatal("image allowed past the document budget")
+	}
+	if (&pdfWriter{}).allowPixels(4097, 4096) {
+		t.Fatal("image over the image budget allowed")
+	}
+}
+
+func TestPDFLinks(t *testing.T) {
+	tests := []struct {
+		destination string
+		want        string
+	}{
+		{"https://example.com/a", "https://example.com/a"},
+		{"http://example.com", "http://example.com"},
+		{"mailto:ada@example.com", "mailto:ada@example.com"},
+		{"#section", "#section"},
+		{"docs/page.md", "docs/page.md"},
+		{"javascript:alert(1)", ""},
+		{"JaVaScRiPt:alert(1)", ""},
+		{" javascript:alert(1)", ""},
+		{"data:text/html,<script>alert(1)</script>", ""},
+		{"file:///etc/passwd", ""},
+		{"vbscript:msgbox", ""},
+	}
+	for _, tt := range tests {
+		if got := safeLink(tt.destination); got != tt.want {
+			t.Errorf("safeLink(%q) = %q, want %q", tt.destination, got, tt.want)
+		}
+	}
+
+	exporter := NewExporter(render.NewRenderer(), "")
+	content := "[safe](https://example.com/page) [evil](javascript:alert(1)) <file:///etc/passwd>"
+	file, err := exporter.Export(testDocument(content), FormatPDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(file.Data, []byte("https://example.com/page")) {
+		t.Fatal("safe link missing from the PDF")
+	}
+	for _, target := range []string{"javascript", "file:"} {
+		if bytes.Contains(file.Data, []byte(target)) {
+			t.Fatalf("PDF links to %s", target)
+		}
+	}
+}
+
+func TestPDFsAreCachedByVersion(t *testing.T) {
+	exporter := NewExporter(render.NewRenderer(), "")
+	doc := testDocument("# Cached")
+
+	first, err := exporter.Export(doc, FormatPDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := exporter.Export(doc, FormatPDF)
+	if &first.Data[0] != &second.Data[0] {
+		t.Fatal("same version rendered again")
+	}
+
+	edited := *doc
+	edited.Version++
+	edited.Content = "# Edited"
+	third, _ := exporter.Export(&edited, FormatPDF)
+	if &third.Data[0] == &second.Data[0] {
+		t.Fatal("new version served from the cache")
+	}
+
+	// An older version finishing late does not replace the newer one
+	exporter.Export(doc, FormatPDF)
+	if again, _ := exporter.Export(&edited, FormatPDF); &again.Data[0] != &third.Data[0] {
+		t.Fatal("older version replaced the cached newer one")
+	}
+
+	exporter.Forget(doc.ID)
+	if fresh, _ := exporter.Export(&edited, FormatPDF); &fresh.Data[0] == &third.Data[0] {
+		t.Fatal("forgotten document served from the cache")
+	}
+}
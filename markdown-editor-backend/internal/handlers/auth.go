@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// signUserToken returns the bearer token proving a caller is userID. It is
+// issued with the user by POST /api/users.
+func (h *Handlers) signUserToken(userID string) string {
+	mac := hmac.New(sha256.New, []byte(h.config.CSRFSecret))
+	mac.Write([]byte("user|" + userID))
+	return userID + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// authenticatedUser returns the user whose token the request carries in
+// its Authorization header
+func (h *Handlers) authenticatedUser(r *http.Request) (string, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return "", false
+	}
+	return h.tokenUser(token)
+}
+
+// tokenUser returns the user a token was issued to
+func (h *Handlers) tokenUser(token string) (string, bool) {
+	// The hex signature follows the last dot, the user ID may contain some
+	separator := strings.LastIndex(token, ".")
+	if separator <= 0 {
+		return "", false
+	}
+	userID := token[:separator]
+	if !hmac.Equal([]byte(token), []byte(h.signUserToken(userID))) {
+		return "", false
+	}
+	return userID, true
+}
+
+// authenticateJoin binds a connection to the user it joins as. A token,
+// when given, must have been issued to that user; without one the
+// connection joins as a guest. It sends an error and returns false for a
+// token issued to someone else.
+func (h *Handlers) authenticateJoin(client *ws.Client, userID, token string) bool {
+	if token == "" {
+		client.Authenticated = false
+		return true
+	}
+	if tokenUserID, ok := h.tokenUser(token); !ok || tokenUserID != userID {
+		h.sendError(client, "Token does not match the user", "UNAUTHORIZED")
+		return false
+	}
+	client.Authenticated = true
+	return true
+}
+
+// ownerFor returns the owner of a document a connection creates while
+// joining as userID. Guests create documents without an owner, which
+// nobody can manage.
+func ownerFor(client *ws.Client, userID string) string {
+	if !client.Authenticated {
+		return ""
+	}
+	return userID
+}
+
+// requireAuthenticated sends an error unless the connection joined with a
+// user token
+func (h *Handlers) requireAuthenticated(client *ws.Client, action string) bool {
+	if !client.Authenticated {
+		h.sendError(client, "A user token is required for "+action, "UNAUTHORIZED")
+		return false
+	}
+	return true
+}
+
+// requireUser answers 401 unless the request carries a valid user token,
+// and 403 if claimedID names someone else
+func (h *Handlers) requireUser(w http.ResponseWriter, r *http.Request, claimedID string) (string, bool) {
+	userID, ok := h.authenticatedUser(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "A user token is required", http.StatusUnauthorized)
+		return "", false
+	}
+	if claimedID != "" && claimedID != userID {
+		http.Error(w, "Token does not match userId", http.StatusForbidden)
+		return "", false
+	}
+	return userID, true
+}
+
+// requireDocumentOwner answers 401 without a user token, 404 if the
+// document does not exist and 403 unless the token's user owns it.
+// Documents without an owner cannot be managed by anyone.
+func (h *Handlers) requireDocumentOwner(w http.ResponseWriter, r *http.Request, documentID string) (*types.Document, bool) {
+	userID, ok := h.requireUser(w, r, "")
+	if !ok {
+		return nil, false
+	}
+
+	doc, err := h.documentService.GetDocument(documentID)
+	if err != nil {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return nil, false
+	}
+	if doc.OwnerID == "" || doc.OwnerID != userID {
+		http.Error(w, "Only the document owner can do this", http.StatusForbidden)
+		return nil, false
+	}
+	return doc, true
+}
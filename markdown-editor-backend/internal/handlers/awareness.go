@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// awarenessInterval is the minimum delay between awareness broadcasts for
+// one connection
+const awarenessInterval = 100 * time.Millisecond
+
+// awarenessStore keeps the latest awareness state of each connection in
+// memory only; it is never persisted and is dropped on disconnect
+type awarenessStore struct {
+	states map[string]map[string]types.AwarenessPayload // documentID -> clientID -> state
+	mutex  sync.RWMutex
+}
+
+func newAwarenessStore() *awarenessStore {
+	return &awarenessStore{
+		states: make(map[string]map[string]types.AwarenessPayload),
+	}
+}
+
+// set replaces a connection's state, removing it when the state is empty
+func (as *awarenessStore) set(awareness types.AwarenessPayload) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	if len(awareness.State) == 0 {
+		as.removeLocked(awareness.DocumentID, awareness.ClientID)
+		return
+	}
+
+	clients, exists := as.states[awareness.DocumentID]
+	if !exists {
+		clients = make(map[string]types.AwarenessPayload)
+		as.states[awareness.DocumentID] = clients
+	}
+	clients[awareness.ClientID] = awareness
+}
+
+// remove drops a connection's state and reports whether it had one
+func (as *awarenessStore) remove(documentID, clientID string) bool {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	return as.removeLocked(documentID, clientID)
+}
+
+func (as *awarenessStore) removeLocked(documentID, clientID string) bool {
+	clients := as.states[documentID]
+	if _, exists := clients[clientID]; !exists {
+		return false
+	}
+
+	delete(clients, clientID)
+	if len(clients) == 0 {
+		delete(as.states, documentID)
+	}
+	return true
+}
+
+// document returns the states of every connection in a document
+func (as *awarenessStore) document(documentID string) []types.AwarenessPayload {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	states := make([]types.AwarenessPayload, 0, len(as.states[documentID]))
+	for _, awareness := range as.states[documentID] {
+		states = append(states, awareness)
+	}
+	return states
+}
+
+// handleAwarenessMessage stores the client's awareness state and relays it
+// to the room, coalescing rapid updates
+func (h *Handlers) handleAwarenessMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.AwarenessPayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	// Awareness always belongs to the connection
+	payload.UserID = client.UserID
+	payload.ClientID = client.ID
+
+	h.awarenessStates.set(payload)
+
+	awarenessMessage := types.WebSocketMessage{
+		Type:    types.MessageTypeAwareness,
+		Payload: payload,
+		UserID:  client.UserID,
+	}
+	h.awareness.publish(client, &awarenessMessage)
+}
+
+// syncRemoteAwareness stores the state of a connection on another node
+func (h *Handlers) syncRemoteAwareness(documentID string, payload *types.AwarenessPayload) {
+	h.awarenessStates.set(*payload)
+}
+
+// clearAwareness drops the state of a closing connection and tells the
+// room to forget it
+func (h *Handlers) clearAwareness(client *ws.Client) {
+	h.awareness.forget(client)
+	if !h.awarenessStates.remove(client.DocumentID, client.ID) {
+		return
+	}
+
+	clearMessage := types.WebSocketMessage{
+		Type: types.MessageTypeAwareness,
+		Payload: types.AwarenessPayload{
+			DocumentID: client.DocumentID,
+			UserID:     client.UserID,
+			ClientID:   client.ID,
+		},
+		UserID: client.UserID,
+	}
+	h.hub.BroadcastToDocument(client.DocumentID, &clearMessage, client)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestAwarenessIsRelayedAndDroppedOnLeave(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Awareness", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	bob := types.User{ID: "bob", Name: "Bob", Color: types.UserColors[1]}
+	adaClient, bobClient := newTestClient(h.hub, ada.ID), newTestClient(h.hub, bob.ID)
+	h.joinDocument(adaClient, &ada, doc.ID)
+	h.joinDocument(bobClient, &bob, doc.ID)
+	eventually(t, "both users joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 2
+	})
+
+	state := map[string]interface{}{"typing": true, "heading": "Intro"}
+	h.processMessage(adaClient, &types.WebSocketMessage{
+		Type: types.MessageTypeAwareness,
+		// The user and connection come from the server, not the client
+		Payload: types.AwarenessPayload{DocumentID: doc.ID, UserID: "bob", ClientID: "bob-conn", State: state},
+	})
+
+	message := nextMessage(t, bobClient, types.MessageTypeAwareness)
+	var relayed types.AwarenessPayload
+	if err := message.DecodePayload(&relayed); err != nil {
+		t.Fatal(err)
+	}
+	want := types.AwarenessPayload{DocumentID: doc.ID, UserID: ada.ID, ClientID: adaClient.ID, State: state}
+	if !reflect.DeepEqual(relayed, want) {
+		t.Fatalf("relayed %+v, want %+v", relayed, want)
+	}
+
+	// Joiners get the current states with the document
+	if states := h.documentSyncPayload(doc).Awareness; len(states) != 1 || states[0].ClientID != adaClient.ID {
+		t.Fatalf("sync awareness = %+v", states)
+	}
+
+	// Disconnecting clears the state and tells the room
+	h.leaveDocument(adaClient)
+	message = nextMessage(t, bobClient, types.MessageTypeAwareness)
+	var cleared types.AwarenessPayload
+	if err := message.DecodePayload(&cleared); err != nil {
+		t.Fatal(err)
+	}
+	if cleared.ClientID != adaClient.ID || len(cleared.State) != 0 {
+		t.Fatalf("clear message = %+v", cleared)
+	}
+	if states := h.awarenessStates.document(doc.ID); len(states) != 0 {
+		t.Fatalf("states kept after leaving: %+v", states)
+	}
+}
+
+func TestEmptyAwarenessStateIsRemoved(t *testing.T) {
+	store := newAwarenessStore()
+	store.set(types.AwarenessPayload{DocumentID: "doc", ClientID: "a", State: map[string]interface{}{"typing": true}})
+	store.set(types.AwarenessPayload{DocumentID: "doc", ClientID: "b", State: map[string]interface{}{"status": "busy"}})
+
+	store.set(types.AwarenessPayload{DocumentID: "doc", ClientID: "a"})
+	if states := store.document("doc"); len(states) != 1 || states[0].ClientID != "b" {
+		t.Fatalf("states = %+v, want only b", states)
+	}
+
+	if !store.remove("doc", "b") || store.remove("doc", "b") {
+		t.Fatal("remove should report only a state it dropped")
+	}
+	if _, exists := store.states["doc"]; exists {
+		t.Fatal("empty document kept in the store")
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"markdown-editor-backend/internal/storage"
+)
+
+// GetBlame handles GET /api/documents/{id}/blame, attributing each line
+// to the users who wrote it
+func (h *Handlers) GetBlame(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	blame, err := h.documentService.Blame(documentID, h.userName)
+	if err != nil {
+		if err == storage.ErrDocumentNotFound {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blame)
+}
+
+// userName returns the display name of a user, empty if unknown
+func (h *Handlers) userName(userID string) string {
+	user, err := h.userService.GetUser(userID)
+	if err != nil {
+		return ""
+	}
+	return user.Name
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestBlameEndpoint(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Blame", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	client := newTestClient(h.hub, ada.ID)
+	h.joinDocument(client, &ada, doc.ID)
+	h.processMessage(client, &types.WebSocketMessage{
+		Type:    types.MessageTypeOperation,
+		Payload: types.OperationPayload{DocumentID: doc.ID, Operation: types.Operation{Type: "insert", Content: "one\ntwo", Version: doc.Version}},
+	})
+	eventually(t, "the operation was applied", func() bool {
+		current, err := h.documentService.GetDocument(doc.ID)
+		return err == nil && current.Content == "one\ntwo"
+	})
+
+	blame := func(method, documentID string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.DocumentRoutes(recorder, httptest.NewRequest(method, "/api/documents/"+documentID+"/blame", nil))
+		return recorder
+	}
+
+	recorder := blame(http.MethodGet, doc.ID)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+	var response types.BlameResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Lines) != 2 {
+		t.Fatalf("lines = %+v", response.Lines)
+	}
+	// Operations are attributed to the connection's user
+	for _, line := range response.Lines {
+		if line.UserID != "ada" || line.UserName != "Ada" || line.Version != response.Version || line.Timestamp.IsZero() {
+			t.Fatalf("line %d = %+v", line.Line, line)
+		}
+	}
+
+	if recorder := blame(http.MethodGet, "missing"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("missing document: %d, want 404", recorder.Code)
+	}
+	if recorder := blame(http.MethodPost, doc.ID); recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: %d, want 405", recorder.Code)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"markdown-editor-backend/internal/models"
+	"markdown-editor-backend/internal/storage"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// Chat history sizes, in messages
+const (
+	chatSyncLimit    = 50
+	chatPageLimit    = 50
+	chatMaxPageLimit = 200
+)
+
+func (h *Handlers) handleChatMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.ChatPayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	author, ok := h.joinedUser(client, "chatting")
+	if !ok {
+		return
+	}
+
+	chatMessage, err := h.chatService.Post(payload.DocumentID, author, payload.Content)
+	if err != nil {
+		h.sendChatError(client, err)
+		return
+	}
+
+	h.touchPresence(client)
+	h.broadcastChatMessage(types.MessageTypeChat, client, chatMessage)
+}
+
+func (h *Handlers) handleChatEditMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.ChatEditPayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	chatMessage, err := h.chatService.Edit(payload.DocumentID, payload.MessageID, client.UserID, payload.Content)
+	if err != nil {
+		h.sendChatError(client, err)
+		return
+	}
+
+	h.broadcastChatMessage(types.MessageTypeChatEdit, client, chatMessage)
+}
+
+func (h *Handlers) handleChatDeleteMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.ChatDeletePayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	chatMessage, err := h.chatService.Delete(payload.DocumentID, payload.MessageID, client.UserID)
+	if err != nil {
+		h.sendChatError(client, err)
+		return
+	}
+
+	h.broadcastChatMessage(types.MessageTypeChatDelete, client, chatMessage)
+}
+
+// broadcastChatMessage sends the message to the whole room, including the
+// sender, which learns the server-assigned ID and timestamp
+func (h *Handlers) broadcastChatMessage(messageType string, client *ws.Client, chatMessage *types.ChatMessage) {
+	broadcast := types.WebSocketMessage{
+		Type: messageType,
+		Payload: types.ChatMessagePayload{
+			DocumentID: chatMessage.DocumentID,
+			Message:    *chatMessage,
+		},
+		UserID: client.UserID,
+	}
+	h.hub.BroadcastToDocument(chatMessage.DocumentID, &broadcast, nil)
+}
+
+// syncRemoteChat stores a message posted, edited or deleted on another node
+func (h *Handlers) syncRemoteChat(documentID string, payload *types.ChatMessagePayload) {
+	if err := h.chatService.SyncMessage(&payload.Message); err != nil {
+		log.Printf("Error syncing chat message %s: %v", payload.Message.ID, err)
+	}
+}
+
+func (h *Handlers) sendChatError(client *ws.Client, err error) {
+	switch err {
+	case storage.ErrChatNotFound:
+		h.sendError(client, "Chat message not found", "CHAT_NOT_FOUND")
+	case models.ErrNotChatAuthor, models.ErrChatDeleted:
+		h.sendError(client, err.Error(), "FORBIDDEN")
+	default:
+		log.Printf("Error updating chat: %v", err)
+		h.sendError(client, "Failed to update chat", "CHAT_ERROR")
+	}
+}
+
+// documentChat returns the latest chat messages of a document for sync
+func (h *Handlers) documentChat(documentID string) []types.ChatMessage {
+	messages, _, err := h.chatService.History(documentID, "", chatSyncLimit)
+	if err != nil {
+		log.Printf("Error getting chat history: %v", err)
+		return []types.ChatMessage{}
+	}
+	return messages
+}
+
+// ListChat handles GET /api/documents/{id}/chat. It returns the latest
+// messages, or those before ?before=<messageId>, up to ?limit=.
+func (h *Handlers) ListChat(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := chatPageLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > chatMaxPageLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(chatMaxPageLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	messages, hasMore, err := h.chatService.History(documentID, r.URL.Query().Get("before"), limit)
+	if err != nil {
+		switch err {
+		case storage.ErrDocumentNotFound:
+			http.Error(w, "Document not found", http.StatusNotFound)
+		case storage.ErrChatNotFound:
+			http.Error(w, "Chat message not found", http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.ChatHistoryResponse{
+		Messages: messages,
+		HasMore:  hasMore,
+	})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// nextChat waits for a chat message of the given type and returns it
+func nextChat(t *testing.T, client *ws.Client, messageType string) types.ChatMessage {
+	t.Helper()
+	message := nextMessage(t, client, messageType)
+	var payload types.ChatMessagePayload
+	if err := message.DecodePayload(&payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload.Message
+}
+
+func TestChatOverWebSocket(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Chat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	bob := types.User{ID: "bob", Name: "Bob", Color: types.UserColors[1]}
+	adaClient, bobClient := newTestClient(h.hub, ada.ID), newTestClient(h.hub, bob.ID)
+	h.joinDocument(adaClient, &ada, doc.ID)
+	h.joinDocument(bobClient, &bob, doc.ID)
+	eventually(t, "both users joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 2
+	})
+
+	h.processMessage(adaClient, &types.WebSocketMessage{
+		Type:    types.MessageTypeChat,
+		Payload: types.ChatPayload{DocumentID: doc.ID, Content: "hello"},
+	})
+	// The sender learns the server-assigned ID and timestamp from the room
+	posted := nextChat(t, adaClient, types.MessageTypeChat)
+	if posted.ID == "" || posted.CreatedAt.IsZero() || posted.UserID != "ada" || posted.Content != "hello" {
+		t.Fatalf("posted %+v", posted)
+	}
+	if received := nextChat(t, bobClient, types.MessageTypeChat); received.ID != posted.ID {
+		t.Fatalf("room got message %s, sender got %s", received.ID, posted.ID)
+	}
+
+	h.processMessage(bobClient, &types.WebSocketMessage{
+		Type:    types.MessageTypeChatEdit,
+		Payload: types.ChatEditPayload{DocumentID: doc.ID, MessageID: posted.ID, Content: "hijacked"},
+	})
+	message := nextMessage(t, bobClient, types.MessageTypeError)
+	var reply types.ErrorPayload
+	if err := message.DecodePayload(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Code != "FORBIDDEN" {
+		t.Fatalf("editing another user's message: error %+v", reply)
+	}
+
+	h.processMessage(adaClient, &types.WebSocketMessage{
+		Type:    types.MessageTypeChatEdit,
+		Payload: types.ChatEditPayload{DocumentID: doc.ID, MessageID: posted.ID, Content: "hello all"},
+	})
+	if edited := nextChat(t, bobClient, types.MessageTypeChatEdit); edited.Content != "hello all" || edited.EditedAt == nil {
+		t.Fatalf("edited %+v", edited)
+	}
+
+	h.processMessage(adaClient, &types.WebSocketMessage{
+		Type:    types.MessageTypeChatDelete,
+		Payload: types.ChatDeletePayload{DocumentID: doc.ID, MessageID: posted.ID},
+	})
+	if deleted := nextChat(t, bobClient, types.MessageTypeChatDelete); !deleted.Deleted || deleted.Content != "" {
+		t.Fatalf("deleted %+v", deleted)
+	}
+
+	// Joiners get the history with the document
+	if chat := h.documentSyncPayload(doc).Chat; len(chat) != 1 || chat[0].ID != posted.ID || !chat[0].Deleted {
+		t.Fatalf("sync chat = %+v", chat)
+	}
+}
+
+func TestChatHistoryEndpoint(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Chat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ada := &types.User{ID: "ada", Name: "Ada"}
+	var ids []string
+	for _, content := range []string{"one", "two", "three"} {
+		posted, err := h.chatService.Post(doc.ID, ada, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, posted.ID)
+	}
+
+	history := func(documentID, query string) (*httptest.ResponseRecorder, types.ChatHistoryResponse) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		h.DocumentRoutes(recorder, httptest.NewRequest(http.MethodGet, "/api/documents/"+documentID+"/chat"+query, nil))
+		var page types.ChatHistoryResponse
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recorder, page
+	}
+
+	_, page := history(doc.ID, "?limit=2")
+	if len(page.Messages) != 2 || page.Messages[0].ID != ids[1] || page.Messages[1].ID != ids[2] || !page.HasMore {
+		t.Fatalf("latest page = %+v", page)
+	}
+	_, page = history(doc.ID, "?limit=2&before="+ids[1])
+	if len(page.Messages) != 1 || page.Messages[0].ID != ids[0] || page.HasMore {
+		t.Fatalf("older page = %+v", page)
+	}
+
+	tests := []struct {
+		name       string
+		documentID string
+		query      string
+		want       int
+	}{
+		{"limit too large", doc.ID, "?limit=201", http.StatusBadRequest},
+		{"limit not a number", doc.ID, "?limit=all", http.StatusBadRequest},
+		{"unknown message", doc.ID, "?before=missing", http.StatusBadRequest},
+		{"missing document", "missing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if recorder, _ := history(tt.documentID, tt.query); recorder.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, recorder.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// forwardedOperation carries an operation from the node a client is
+// connected to over to the node that owns the document. When
+// AcceptSuggestion is set it carries the acceptance of that suggestion
+// instead, with only Payload.DocumentID filled in.
+type forwardedOperation struct {
+	UserID           string                 `json:"userId"`
+	ClientID         string                 `json:"clientId"`
+	Payload          types.OperationPayload `json:"payload"`
+	AcceptSuggestion string                 `json:"acceptSuggestion,omitempty"`
+}
+
+// documentOwner claims the document for this node if nobody holds it and
+// returns the ID of the node that sequences its operations. A node never
+// claims a document it holds no copy of, it would sequence operations
+// against content it has not synced.
+func (h *Handlers) documentOwner(documentID string) (string, error) {
+	if _, err := h.documentService.GetDocument(documentID); err != nil {
+		owner, ownerErr := h.ownership.Owner(documentID)
+		if ownerErr != nil {
+			return "", ownerErr
+		}
+		if owner == "" || owner == h.hub.NodeID() {
+			return "", err
+		}
+		return owner, nil
+	}
+
+	owned, err := h.ownership.Acquire(documentID, h.hub.NodeID())
+	if err != nil {
+		return "", err
+	}
+	if owned {
+		return h.hub.NodeID(), nil
+	}
+	return h.ownership.Owner(documentID)
+}
+
+func (h *Handlers) forwardOperation(owner string, client *ws.Client, payload *types.OperationPayload) {
+	forwarded := forwardedOperation{
+		UserID:   client.UserID,
+		ClientID: client.ID,
+		Payload:  *payload,
+	}
+
+	forwardedBytes, err := json.Marshal(forwarded)
+	if err != nil {
+		log.Printf("Error marshaling forwarded operation: %v", err)
+		return
+	}
+
+	if err := h.hub.SendToNode(owner, forwardedBytes); err != nil {
+		log.Printf("Error forwarding operation to node %s: %v", owner, err)
+		h.sendError(client, "Failed to apply operation", "FORWARD_ERROR")
+		return
+	}
+	log.Printf("Operation for document %s forwarded to owner node %s", payload.DocumentID, owner)
+}
+
+// handleNodeMessage applies operations forwarded by other nodes
+func (h *Handlers) handleNodeMessage(message []byte) {
+	var forwarded forwardedOperation
+	if err := json.Unmarshal(message, &forwarded); err != nil {
+		log.Printf("Error unmarshaling forwarded operation: %v", err)
+		return
+	}
+
+	// The lease may have moved since the sender looked it up
+	owner, err := h.documentOwner(forwarded.Payload.DocumentID)
+	if err != nil {
+		log.Printf("Error resolving owner of document %s: %v", forwarded.Payload.DocumentID, err)
+		return
+	}
+	if owner != h.hub.NodeID() {
+		if err := h.hub.SendToNode(owner, message); err != nil {
+			log.Printf("Error re-forwarding operation to node %s: %v", owner, err)
+		}
+		return
+	}
+
+	if forwarded.AcceptSuggestion != "" {
+		if err := h.acceptSuggestion(forwarded.UserID, forwarded.Payload.DocumentID, forwarded.AcceptSuggestion); err != nil {
+			log.Printf("Error accepting forwarded suggestion %s: %v", forwarded.AcceptSuggestion, err)
+		}
+		return
+	}
+
+	h.commitOperation(forwarded.UserID, forwarded.ClientID, &forwarded.Payload)
+}
+
+// remoteSync mirrors one type of message broadcast by another node
+type remoteSync func(documentID string, message *types.WebSocketMessage)
+
+// syncPayload decodes the payload of a remote message for sync
+func syncPayload[P any](sync func(documentID string, payload *P)) remoteSync {
+	return func(documentID string, message *types.WebSocketMessage) {
+		var payload P
+		if err := message.DecodePayload(&payload); err != nil {
+			log.Printf("Error unmarshaling remote %s: %v", message.Type, err)
+			return
+		}
+		sync(documentID, &payload)
+	}
+}
+
+// remoteSyncs maps the broadcasts that change state to the method of the
+// feature keeping this node's copy of that state in step
+func (h *Handlers) remoteSyncs() map[string]remoteSync {
+	return map[string]remoteSync{
+		types.MessageTypeDocumentUpdate:   syncPayload(h.syncRemoteDocument),
+		types.MessageTypeTitleUpdate:      syncPayload(h.syncRemoteTitle),
+		types.MessageTypeOperation:        syncPayload(h.syncRemoteOperation),
+		types.MessageTypeCursor:           syncPayload(h.syncRemoteCursor),
+		types.MessageTypeLeave:            syncPayload(h.syncRemoteLeave),
+		types.MessageTypeAwareness:        syncPayload(h.syncRemoteAwareness),
+		types.MessageTypeCommentAdd:       syncPayload(h.syncRemoteCommentThread),
+		types.MessageTypeCommentReply:     syncPayload(h.syncRemoteCommentThread),
+		types.MessageTypeCommentResolve:   syncPayload(h.syncRemoteCommentThread),
+		types.MessageTypeSuggestion:       syncPayload(h.syncRemoteSuggestion),
+		types.MessageTypeChat:             syncPayload(h.syncRemoteChat),
+		types.MessageTypeChatEdit:         syncPayload(h.syncRemoteChat),
+		types.MessageTypeChatDelete:       syncPayload(h.syncRemoteChat),
+		types.MessageTypeNotification:     syncPayload(h.syncRemoteNotification),
+		types.MessageTypeNotificationRead: syncPayload(h.syncRemoteNotificationRead),
+		types.MessageTypeDocumentDeleted:  syncPayload(h.syncRemoteDeletion),
+		types.MessageTypeConnection:       syncPayload(h.syncRemoteConnection),
+	}
+}
+
+// handleRemoteBroadcast keeps this node's state in step with changes made
+// elsewhere in the cluster
+func (h *Handlers) handleRemoteBroadcast(documentID string, message *types.WebSocketMessage) {
+	if sync, exists := h.remote[message.Type]; exists {
+		sync(documentID, message)
+	}
+}
+
+// replicateDocument hands a new document to the other nodes, so that they
+// can serve joins before its first edit
+func (h *Handlers) replicateDocument(doc *types.Document) {
+	h.hub.Replicate(doc.ID, &types.WebSocketMessage{
+		Type:    types.MessageTypeDocumentUpdate,
+		Payload: types.DocumentUpdatePayload{Document: *doc},
+	})
+}
+
+// documentCreated announces a document created on this node
+func (h *Handlers) documentCreated(doc *types.Document) {
+	h.replicateDocument(doc)
+	h.emitDocumentCreated(doc)
+}
+
+func (h *Handlers) syncRemoteDocument(documentID string, payload *types.DocumentUpdatePayload) {
+	if err := h.documentService.SyncDocument(&payload.Document); err != nil {
+		log.Printf("Error syncing document %s: %v", documentID, err)
+	}
+}
+
+// syncRemoteOperation arrives ahead of the document update carrying the
+// new content
+func (h *Handlers) syncRemoteOperation(documentID string, payload *types.OperationPayload) {
+	if err := h.documentService.MirrorOperation(documentID, &payload.Operation); err != nil {
+		log.Printf("Error mirroring operation on document %s: %v", documentID, err)
+	}
+}
+
+func (h *Handlers) syncRemoteTitle(documentID string, payload *types.TitleUpdatePayload) {
+	if _, err := h.documentService.UpdateDocumentTitle(payload.DocumentID, payload.NewTitle); err != nil {
+		log.Printf("Error syncing title of document %s: %v", documentID, err)
+	}
+}
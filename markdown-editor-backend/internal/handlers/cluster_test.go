@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"markdown-editor-backend/internal/cluster"
+	"markdown-editor-backend/internal/config"
+	"markdown-editor-backend/internal/storage"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// testNode is one server of an in-process cluster
+type testNode struct {
+	handlers *Handlers
+	hub      *ws.Hub
+	storage  *storage.MemoryStorage
+}
+
+func newTestNode(t *testing.T, nodeID string, broker cluster.Broker, ownership cluster.Ownership) *testNode {
+	t.Helper()
+	hub, err := ws.NewHubWithBroker(nodeID, broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go hub.Run()
+
+	store := storage.NewMemoryStorage()
+	return &testNode{
+		handlers: NewHandlers(store, hub, ownership, config.Load()),
+		hub:      hub,
+		storage:  store,
+	}
+}
+
+func newTestCluster(t *testing.T) (*testNode, *testNode) {
+	t.Helper()
+	broker := cluster.NewMemoryBroker()
+	ownership := cluster.NewMemoryOwnership(cluster.DefaultLeaseTTL)
+	return newTestNode(t, "node-a", broker, ownership), newTestNode(t, "node-b", broker, ownership)
+}
+
+// newTestClient returns a client whose outgoing messages can be read from
+// its Send channel
+func newTestClient(hub *ws.Hub, userID string) *ws.Client {
+	return &ws.Client{ID: userID + "-conn", Hub: hub, Send: make(chan []byte, 64), UserID: userID}
+}
+
+// nextMessage waits for a message of the given type sent to client
+func nextMessage(t *testing.T, client *ws.Client, messageType string) types.WebSocketMessage {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-client.Send:
+			var message types.WebSocketMessage
+			if err := json.Unmarshal(data, &message); err != nil {
+				t.Fatalf("invalid message %s: %v", data, err)
+			}
+			if message.Type == messageType {
+				return message
+			}
+		case <-timeout:
+			t.Fatalf("no %s message received", messageType)
+		}
+	}
+}
+
+// eventually polls condition until it holds, for state the hub updates on
+// its own goroutine
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCreatedDocumentReplicatesToOtherNodes(t *testing.T) {
+	nodeA, nodeB := newTestCluster(t)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(`{"title":"Plans","content":"# Q3"}`))
+	nodeA.handlers.CreateDocument(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("create returned %d: %s", recorder.Code, recorder.Body)
+	}
+	var created types.Document
+	if err := json.NewDecoder(recorder.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	var replica *types.Document
+	eventually(t, "the document reached node-b", func() bool {
+		replica, _ = nodeB.storage.GetDocument(created.ID)
+		return replica != nil
+	})
+	if replica.Title != "Plans" || replica.Content != "# Q3" || replica.Version != created.Version {
+		t.Fatalf("replica = %q %q v%d, want %q %q v%d", replica.Title, replica.Content, replica.Version, created.Title, created.Content, created.Version)
+	}
+}
+
+func TestNodeDoesNotOwnUnsyncedDocument(t *testing.T) {
+	nodeA, nodeB := newTestCluster(t)
+
+	// Written on node-a only, as if the creation broadcast were still in flight
+	doc, err := nodeA.handlers.documentService.CreateDocument("Draft", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := nodeB.handlers.documentOwner(doc.ID); err != storage.ErrDocumentNotFound {
+		t.Fatalf("documentOwner on node-b = %v, want ErrDocumentNotFound", err)
+	}
+	if owner, _ := nodeA.handlers.ownership.Owner(doc.ID); owner != "" {
+		t.Fatalf("node-b took the lease of an unsynced document: owner %q", owner)
+	}
+
+	owner, err := nodeA.handlers.documentOwner(doc.ID)
+	if err != nil || owner != "node-a" {
+		t.Fatalf("documentOwner on node-a = %q, %v; want node-a", owner, err)
+	}
+	if owner, err := nodeB.handlers.documentOwner(doc.ID); err != nil || owner != "node-a" {
+		t.Fatalf("documentOwner on node-b = %q, %v; want node-a", owner, err)
+	}
+
+	// Joining on node-b must not create a second document under the same ID
+	client := newTestClient(nodeB.hub, "user-1")
+	nodeB.handlers.handleJoinMessage(client, &types.WebSocketMessage{
+		Type: types.MessageTypeJoin,
+		Payload: types.JoinPayload{
+			User:       types.User{ID: "user-1", Name: "Ada", Color: "#FF6B6B"},
+			DocumentID: doc.ID,
+		},
+	})
+	reply := nextMessage(t, client, types.MessageTypeError)
+	var payload types.ErrorPayload
+	if err := reply.DecodePayload(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Code != "DOCUMENT_UNAVAILABLE" {
+		t.Fatalf("join error code = %q, want DOCUMENT_UNAVAILABLE", payload.Code)
+	}
+	if _, err := nodeB.storage.GetDocument(doc.ID); err != storage.ErrDocumentNotFound {
+		t.Fatalf("node-b created a conflicting document: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"markdown-editor-backend/internal/models"
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/internal/validation"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+func (h *Handlers) handleCommentAddMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.CommentAddPayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	author, ok := h.joinedUser(client, "commenting")
+	if !ok {
+		return
+	}
+
+	thread, err := h.commentService.AddThread(payload.DocumentID, payload.Start, payload.End, author, payload.Content)
+	if err != nil {
+		h.sendCommentError(client, err)
+		return
+	}
+
+	log.Printf("User %s opened comment thread %s in document %s", client.UserID, thread.ID, thread.DocumentID)
+	h.broadcastCommentThread(types.MessageTypeCommentAdd, client, thread)
+	h.notifyCommentMentions(thread, author, payload.Content)
+}
+
+func (h *Handlers) handleCommentReplyMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.CommentReplyPayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	author, ok := h.joinedUser(client, "commenting")
+	if !ok {
+		return
+	}
+
+	thread, err := h.commentService.Reply(payload.DocumentID, payload.ThreadID, author, payload.Content)
+	if err != nil {
+		h.sendCommentError(client, err)
+		return
+	}
+
+	h.broadcastCommentThread(types.MessageTypeCommentReply, client, thread)
+	h.notifyCommentMentions(thread, author, payload.Content)
+}
+
+func (h *Handlers) handleCommentResolveMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.CommentResolvePayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	thread, err := h.commentService.Resolve(payload.DocumentID, payload.ThreadID, client.UserID)
+	if err != nil {
+		h.sendCommentError(client, err)
+		return
+	}
+
+	log.Printf("User %s resolved comment thread %s in document %s", client.UserID, thread.ID, thread.DocumentID)
+	h.broadcastCommentThread(types.MessageTypeCommentResolve, client, thread)
+}
+
+// joinedUser looks up the connection's user to sign comments and
+// suggestions with. action completes "Join a document before ...".
+func (h *Handlers) joinedUser(client *ws.Client, action string) (*types.User, bool) {
+	user, err := h.userService.GetUser(client.UserID)
+	if err != nil {
+		log.Printf("Error getting user %s: %v", client.UserID, err)
+		h.sendError(client, "Join a document before "+action, "NOT_JOINED")
+		return nil, false
+	}
+	return user, true
+}
+
+// broadcastCommentThread sends the updated thread to the whole room,
+// including the sender, which learns the server-assigned IDs
+func (h *Handlers) broadcastCommentThread(messageType string, client *ws.Client, thread *types.CommentThread) {
+	threadMessage := types.WebSocketMessage{
+		Type: messageType,
+		Payload: types.CommentThreadPayload{
+			DocumentID: thread.DocumentID,
+			Thread:     *thread,
+		},
+		UserID: client.UserID,
+	}
+	h.hub.BroadcastToDocument(thread.DocumentID, &threadMessage, nil)
+}
+
+// syncRemoteCommentThread stores a thread changed on another node
+func (h *Handlers) syncRemoteCommentThread(documentID string, payload *types.CommentThreadPayload) {
+	if err := h.commentService.SyncThread(&payload.Thread); err != nil {
+		log.Printf("Error syncing comment thread %s: %v", payload.Thread.ID, err)
+	}
+}
+
+func (h *Handlers) sendCommentError(client *ws.Client, err error) {
+	switch err {
+	case storage.ErrCommentNotFound:
+		h.sendError(client, "Comment thread not found", "COMMENT_NOT_FOUND")
+	case models.ErrInvalidCommentRange:
+		h.sendInvalidPayload(client, &validation.FieldError{Field: "end", Message: "is outside the document"})
+	default:
+		log.Printf("Error updating comments: %v", err)
+		h.sendError(client, "Failed to update comments", "COMMENT_ERROR")
+	}
+}
+
+// documentComments returns the open threads of a document for sync
+func (h *Handlers) documentComments(documentID string) []types.CommentThread {
+	threads, err := h.commentService.GetThreads(documentID, false)
+	if err != nil {
+		log.Printf("Error getting comment threads: %v", err)
+		return []types.CommentThread{}
+	}
+
+	result := make([]types.CommentThread, len(threads))
+	for i, thread := range threads {
+		result[i] = *thread
+	}
+	return result
+}
+
+// ListComments handles GET /api/documents/{id}/comments, returning open
+// threads, or every thread with ?status=all
+func (h *Handlers) ListComments(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := h.documentService.GetDocument(documentID); err != nil {
+		if err == storage.ErrDocumentNotFound {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	includeResolved := false
+	switch status := r.URL.Query().Get("status"); status {
+	case "", "open":
+	case "all":
+		includeResolved = true
+	default:
+		http.Error(w, "status must be open or all", http.StatusBadRequest)
+		return
+	}
+
+	threads, err := h.commentService.GetThreads(documentID, includeResolved)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(threads)
+}
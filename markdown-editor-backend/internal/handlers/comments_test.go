@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// listComments calls GET /api/documents/{id}/comments
+func listComments(h *Handlers, documentID, query string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.DocumentRoutes(recorder, httptest.NewRequest(http.MethodGet, "/api/documents/"+documentID+"/comments"+query, nil))
+	return recorder
+}
+
+// nextThread waits for a comment message and returns its thread
+func nextThread(t *testing.T, client *ws.Client, messageType string) types.CommentThread {
+	t.Helper()
+	message := nextMessage(t, client, messageType)
+	var payload types.CommentThreadPayload
+	if err := message.DecodePayload(&payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload.Thread
+}
+
+func TestCommentThreadsOverWebSocket(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Spec", "The quick brown fox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	bob := types.User{ID: "bob", Name: "Bob", Color: types.UserColors[1]}
+	adaClient, bobClient := newTestClient(h.hub, ada.ID), newTestClient(h.hub, bob.ID)
+	h.joinDocument(adaClient, &ada, doc.ID)
+	h.joinDocument(bobClient, &bob, doc.ID)
+	eventually(t, "both users joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 2
+	})
+
+	h.processMessage(adaClient, &types.WebSocketMessage{
+		Type:    types.MessageTypeCommentAdd,
+		Payload: types.CommentAddPayload{DocumentID: doc.ID, Start: 4, End: 9, Content: "Too fast?"},
+	})
+	// The author learns the thread's ID from the broadcast too
+	thread := nextThread(t, adaClient, types.MessageTypeCommentAdd)
+	if received := nextThread(t, bobClient, types.MessageTypeCommentAdd); received.ID != thread.ID {
+		t.Fatalf("room got thread %s, author got %s", received.ID, thread.ID)
+	}
+	if thread.Quote != "quick" || len(thread.Comments) != 1 || thread.Comments[0].UserName != "Ada" {
+		t.Fatalf("thread = %+v", thread)
+	}
+
+	h.processMessage(bobClient, &types.WebSocketMessage{
+		Type:    types.MessageTypeCommentReply,
+		Payload: types.CommentReplyPayload{DocumentID: doc.ID, ThreadID: thread.ID, Content: "Fine by me"},
+	})
+	if replied := nextThread(t, adaClient, types.MessageTypeCommentReply); len(replied.Comments) != 2 {
+		t.Fatalf("thread after reply = %+v", replied)
+	}
+
+	var open []types.CommentThread
+	recorder := listComments(h, doc.ID, "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &open); err != nil || len(open) != 1 {
+		t.Fatalf("open threads = %s (%v)", recorder.Body, err)
+	}
+
+	h.processMessage(bobClient, &types.WebSocketMessage{
+		Type:    types.MessageTypeCommentResolve,
+		Payload: types.CommentResolvePayload{DocumentID: doc.ID, ThreadID: thread.ID},
+	})
+	if resolved := nextThread(t, adaClient, types.MessageTypeCommentResolve); !resolved.Resolved || resolved.ResolvedBy != "bob" {
+		t.Fatalf("thread after resolve = %+v", resolved)
+	}
+
+	recorder = listComments(h, doc.ID, "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &open); err != nil || len(open) != 0 {
+		t.Fatalf("open threads after resolve = %s (%v)", recorder.Body, err)
+	}
+	var all []types.CommentThread
+	recorder = listComments(h, doc.ID, "?status=all")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &all); err != nil || len(all) != 1 {
+		t.Fatalf("all threads = %s (%v)", recorder.Body, err)
+	}
+}
+
+func TestCommentErrors(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Spec", "short")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A connection that never joined has no user to sign with
+	stranger := newTestClient(h.hub, "stranger")
+	stranger.DocumentID = doc.ID
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	client := newTestClient(h.hub, ada.ID)
+	h.joinDocument(client, &ada, doc.ID)
+
+	tests := []struct {
+		name    string
+		client  *ws.Client
+		message types.WebSocketMessage
+		code    string
+	}{
+		{"not joined", stranger, types.WebSocketMessage{
+			Type:    types.MessageTypeCommentAdd,
+			Payload: types.CommentAddPayload{DocumentID: doc.ID, Start: 0, End: 1, Content: "hm"},
+		}, "NOT_JOINED"},
+		{"range past the end", client, types.WebSocketMessage{
+			Type:    types.MessageTypeCommentAdd,
+			Payload: types.CommentAddPayload{DocumentID: doc.ID, Start: 0, End: 6, Content: "hm"},
+		}, "INVALID_PAYLOAD"},
+		{"missing thread", client, types.WebSocketMessage{
+			Type:    types.MessageTypeCommentResolve,
+			Payload: types.CommentResolvePayload{DocumentID: doc.ID, ThreadID: "missing"},
+		}, "COMMENT_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.processMessage(tt.client, &tt.message)
+			message := nextMessage(t, tt.client, types.MessageTypeError)
+			var reply types.ErrorPayload
+			if err := message.DecodePayload(&reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Code != tt.code {
+				t.Fatalf("error = %+v, want %s", reply, tt.code)
+			}
+		})
+	}
+
+	if recorder := listComments(h, doc.ID, "?status=closed"); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("unknown status: %d, want 400", recorder.Code)
+	}
+	if recorder := listComments(h, "missing", ""); recorder.Code != http.StatusNotFound {
+		t.Fatalf("missing document: %d, want 404", recorder.Code)
+	}
+}
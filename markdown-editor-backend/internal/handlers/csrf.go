@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wsTokenTTL is how long a token from /api/ws-token can be used to open
+// a WebSocket connection
+const wsTokenTTL = 5 * time.Minute
+
+// signWSToken binds a token to the requesting origin and an expiry
+func (h *Handlers) signWSToken(origin string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(h.config.CSRFSecret))
+	mac.Write([]byte(expiry + "|" + origin))
+	return expiry + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWSToken checks a token presented on upgrade against the Origin
+func (h *Handlers) verifyWSToken(token, origin string) bool {
+	expiry, _, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return false
+	}
+
+	expected := h.signWSToken(origin, time.Unix(unix, 0))
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+// GetWSToken issues a short-lived token for the WebSocket upgrade. The
+// endpoint is only readable from allowed origins thanks to CORS, so a
+// third-party page cannot obtain one.
+func (h *Handlers) GetWSToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if !h.upgrader.CheckOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	expires := time.Now().Add(wsTokenTTL)
+	response := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{
+		Token:     h.signWSToken(origin, expires),
+		ExpiresAt: expires,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
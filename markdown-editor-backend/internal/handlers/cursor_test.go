@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// storedCursors returns the cursors a node keeps for a document
+func storedCursors(t *testing.T, h *Handlers, documentID string) []*types.CursorPosition {
+	t.Helper()
+	cursors, err := h.userService.GetCursors(documentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cursors
+}
+
+func TestSelectionsAreStoredAndBroadcast(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Selections", "one two three four")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	bob := types.User{ID: "bob", Name: "Bob", Color: types.UserColors[1]}
+	adaClient := newTestClient(h.hub, ada.ID)
+	bobClient := newTestClient(h.hub, bob.ID)
+	h.joinDocument(adaClient, &ada, doc.ID)
+	h.joinDocument(bobClient, &bob, doc.ID)
+	eventually(t, "both users joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 2
+	})
+
+	selections := []types.SelectionRange{{Anchor: 0, Head: 3}, {Anchor: 8, Head: 13}}
+	h.processMessage(adaClient, &types.WebSocketMessage{
+		Type: types.MessageTypeCursor,
+		Payload: types.CursorPayload{
+			DocumentID: doc.ID,
+			// The caret comes from the primary selection, and the user
+			// and color from the connection
+			Position: types.CursorPosition{UserID: "bob", Position: 17, Color: "#000000", Selections: selections},
+		},
+	})
+
+	message := nextMessage(t, bobClient, types.MessageTypeCursor)
+	var broadcast types.CursorPayload
+	if err := message.DecodePayload(&broadcast); err != nil {
+		t.Fatal(err)
+	}
+	want := types.CursorPosition{UserID: ada.ID, Position: 3, Color: ada.Color, Selections: selections}
+	if !reflect.DeepEqual(broadcast.Position, want) {
+		t.Fatalf("broadcast %+v, want %+v", broadcast.Position, want)
+	}
+
+	cursors := storedCursors(t, h, doc.ID)
+	if len(cursors) != 1 || !reflect.DeepEqual(cursors[0].Selections, selections) || cursors[0].Position != 3 {
+		t.Fatalf("stored cursors %+v", cursors)
+	}
+
+	// A user's cursor goes with their last connection
+	h.leaveDocument(adaClient)
+	if cursors := storedCursors(t, h, doc.ID); len(cursors) != 0 {
+		t.Fatalf("cursor kept after leaving: %+v", cursors)
+	}
+}
+
+func TestRemoteCursorsAreMirrored(t *testing.T) {
+	nodeA, nodeB := newTestCluster(t)
+	doc, err := nodeA.handlers.documentService.CreateDocument("Shared", "some text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeA.handlers.documentCreated(doc)
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	adaOnA := newTestClient(nodeA.hub, ada.ID)
+	nodeA.handlers.joinDocument(adaOnA, &ada, doc.ID)
+	eventually(t, "node-b has the document", func() bool {
+		_, err := nodeB.handlers.documentService.GetDocument(doc.ID)
+		return err == nil
+	})
+
+	nodeA.handlers.processMessage(adaOnA, &types.WebSocketMessage{
+		Type: types.MessageTypeCursor,
+		Payload: types.CursorPayload{
+			DocumentID: doc.ID,
+			Position:   types.CursorPosition{Selections: []types.SelectionRange{{Anchor: 1, Head: 4}}},
+		},
+	})
+	eventually(t, "node-b stored the selection", func() bool {
+		cursors := storedCursors(t, nodeB.handlers, doc.ID)
+		return len(cursors) == 1 && len(cursors[0].Selections) == 1 && cursors[0].Selections[0].Head == 4
+	})
+
+	nodeA.handlers.leaveDocument(adaOnA)
+	eventually(t, "node-b dropped the cursor", func() bool {
+		return len(storedCursors(t, nodeB.handlers, doc.ID)) == 0
+	})
+}
+
+func TestJoinersReceiveCursors(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	room, err := h.documentService.CreateRoom("Cursors", "hello world", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	adaClient := newTestClient(h.hub, ada.ID)
+	h.joinDocument(adaClient, &ada, room.ID)
+	h.processMessage(adaClient, &types.WebSocketMessage{
+		Type: types.MessageTypeCursor,
+		Payload: types.CursorPayload{
+			DocumentID: room.ID,
+			Position:   types.CursorPosition{Selections: []types.SelectionRange{{Anchor: 0, Head: 5}}},
+		},
+	})
+	// A cursor left by someone no longer in the document is not sent
+	h.userService.UpdateCursor(room.ID, &types.CursorPosition{UserID: "gone", Position: 1})
+
+	want := []types.CursorPosition{{
+		UserID: ada.ID, Position: 5, Color: ada.Color,
+		Selections: []types.SelectionRange{{Anchor: 0, Head: 5}},
+	}}
+	checkCursors := func(source string, sync types.DocumentSyncPayload) {
+		t.Helper()
+		if !reflect.DeepEqual(sync.Cursors, want) {
+			t.Fatalf("%s cursors = %+v, want %+v", source, sync.Cursors, want)
+		}
+	}
+	joinSync := func(client *ws.Client, message *types.WebSocketMessage) types.DocumentSyncPayload {
+		t.Helper()
+		h.processMessage(client, message)
+		received := nextMessage(t, client, types.MessageTypeDocumentSync)
+		var sync types.DocumentSyncPayload
+		if err := received.DecodePayload(&sync); err != nil {
+			t.Fatal(err)
+		}
+		return sync
+	}
+
+	bob := types.User{ID: "bob", Name: "Bob", Color: types.UserColors[1]}
+	checkCursors("join", joinSync(newTestClient(h.hub, bob.ID), &types.WebSocketMessage{
+		Type:    types.MessageTypeJoin,
+		Payload: types.JoinPayload{User: bob, DocumentID: room.ID},
+	}))
+
+	eve := types.User{ID: "eve", Name: "Eve", Color: types.UserColors[2]}
+	checkCursors("join_room", joinSync(newTestClient(h.hub, eve.ID), &types.WebSocketMessage{
+		Type:    types.MessageTypeJoinRoom,
+		Payload: types.JoinRoomPayload{User: eve, RoomCode: room.RoomCode},
+	}))
+
+	recorder := httptest.NewRecorder()
+	h.GetDocument(recorder, httptest.NewRequest(http.MethodGet, "/api/documents/get?id="+room.ID, nil))
+	var sync types.DocumentSyncPayload
+	if err := json.Unmarshal(recorder.Body.Bytes(), &sync); err != nil {
+		t.Fatal(err)
+	}
+	checkCursors("GET", sync)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"markdown-editor-backend/internal/export"
+	"markdown-editor-backend/internal/storage"
+)
+
+// ExportDocument handles GET /api/documents/{id}/export?format=, offering
+// the document as a download in one of export.Formats, Markdown when the
+// format is omitted
+func (h *Handlers) ExportDocument(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatMarkdown
+	}
+
+	// Edits may land while exporting, work on one version
+	doc, err := h.documentService.GetDocumentCopy(documentID)
+	if err != nil {
+		if err == storage.ErrDocumentNotFound {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := h.exporter.Export(&doc, format)
+	if err != nil {
+		if errors.Is(err, export.ErrUnknownFormat) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Disposition", file.ContentDisposition())
+	w.Header().Set("Content-Length", strconv.Itoa(len(file.Data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(file.Data)
+}
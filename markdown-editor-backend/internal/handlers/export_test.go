@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// exportDocument fetches GET /api/documents/{id}/export with a query
+func exportDocument(h *Handlers, documentID, query string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.ExportDocument(recorder, httptest.NewRequest(http.MethodGet, "/api/documents/"+documentID+"/export"+query, nil), documentID)
+	return recorder
+}
+
+func TestExportDocument(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Meeting Notes", "# Agenda\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		documentID  string
+		query       string
+		want        int
+		disposition string
+	}{
+		{"markdown by default", doc.ID, "", http.StatusOK, `attachment; filename="meeting-notes.md"`},
+		{"html", doc.ID, "?format=html", http.StatusOK, `attachment; filename="meeting-notes.html"`},
+		{"unknown format", doc.ID, "?format=docx", http.StatusBadRequest, ""},
+		{"missing document", "missing", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := exportDocument(h, tt.documentID, tt.query)
+			if recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+			if got := recorder.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Fatalf("Content-Disposition = %q, want %q", got, tt.disposition)
+			}
+		})
+	}
+
+	if body := exportDocument(h, doc.ID, "?format=docx").Body.String(); !strings.Contains(body, "md, html") {
+		t.Fatalf("unknown format error does not list the formats: %s", body)
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"markdown-editor-backend/internal/validation"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// viewportInterval is the minimum delay between viewport updates relayed
+// for one connection
+const viewportInterval = 100 * time.Millisecond
+
+// handleFollowMessage starts relaying another user's viewport to the client
+func (h *Handlers) handleFollowMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.FollowPayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	if payload.UserID == "" {
+		h.sendInvalidPayload(client, &validation.FieldError{Field: "userId", Message: "is required"})
+		return
+	}
+	if payload.UserID == client.UserID {
+		h.sendInvalidPayload(client, &validation.FieldError{Field: "userId", Message: "cannot follow yourself"})
+		return
+	}
+
+	if previous := h.hub.Follow(client, payload.UserID); previous != "" && previous != payload.UserID {
+		h.announceFollow(types.MessageTypeUnfollow, client, previous)
+	}
+	h.announceFollow(types.MessageTypeFollow, client, payload.UserID)
+
+	log.Printf("User %s follows %s in document %s", client.UserID, payload.UserID, client.DocumentID)
+}
+
+// handleUnfollowMessage stops relaying viewports to the client
+func (h *Handlers) handleUnfollowMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.FollowPayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	h.stopFollowing(client)
+}
+
+// stopFollowing releases the client from the user it follows, if any
+func (h *Handlers) stopFollowing(client *ws.Client) {
+	if previous := h.hub.Unfollow(client); previous != "" {
+		h.announceFollow(types.MessageTypeUnfollow, client, previous)
+	}
+}
+
+// announceFollow tells the room that the client started or stopped
+// following a user, so the followed user knows to report its viewport
+func (h *Handlers) announceFollow(messageType string, client *ws.Client, userID string) {
+	announcement := types.WebSocketMessage{
+		Type: messageType,
+		Payload: types.FollowPayload{
+			DocumentID: client.DocumentID,
+			UserID:     userID,
+			FollowerID: client.UserID,
+		},
+		UserID: client.UserID,
+	}
+	h.hub.BroadcastToDocument(client.DocumentID, &announcement, nil)
+}
+
+// handleViewportMessage relays the client's visible lines to its followers
+func (h *Handlers) handleViewportMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.ViewportPayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	// Viewports always belong to the connection's user
+	payload.UserID = client.UserID
+
+	viewportMessage := types.WebSocketMessage{
+		Type:    types.MessageTypeViewport,
+		Payload: payload,
+		UserID:  client.UserID,
+	}
+	h.viewports.publish(client, &viewportMessage)
+}
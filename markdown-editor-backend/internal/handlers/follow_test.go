@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"testing"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestViewportsAreRelayedOnlyToFollowers(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Follow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := func(index int, name string) *ws.Client {
+		user := types.User{ID: name, Name: name, Color: types.UserColors[index]}
+		client := newTestClient(h.hub, user.ID)
+		h.joinDocument(client, &user, doc.ID)
+		return client
+	}
+	ada, bob, eve := join(0, "ada"), join(1, "bob"), join(2, "eve")
+	eventually(t, "everyone joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 3
+	})
+
+	h.processMessage(bob, &types.WebSocketMessage{
+		Type:    types.MessageTypeFollow,
+		Payload: types.FollowPayload{DocumentID: doc.ID, UserID: "ada"},
+	})
+	message := nextMessage(t, ada, types.MessageTypeFollow)
+	var announcement types.FollowPayload
+	if err := message.DecodePayload(&announcement); err != nil {
+		t.Fatal(err)
+	}
+	if announcement.UserID != "ada" || announcement.FollowerID != "bob" {
+		t.Fatalf("follow announced as %+v", announcement)
+	}
+
+	viewport := &types.WebSocketMessage{
+		Type:    types.MessageTypeViewport,
+		Payload: types.ViewportPayload{DocumentID: doc.ID, StartLine: 10, EndLine: 40},
+	}
+	h.processMessage(ada, viewport)
+	message = nextMessage(t, bob, types.MessageTypeViewport)
+	var relayed types.ViewportPayload
+	if err := message.DecodePayload(&relayed); err != nil {
+		t.Fatal(err)
+	}
+	if relayed.UserID != "ada" || relayed.StartLine != 10 || relayed.EndLine != 40 {
+		t.Fatalf("relayed %+v", relayed)
+	}
+	if count(drain(t, eve), types.MessageTypeViewport) > 0 {
+		t.Fatal("viewport relayed to a user not following")
+	}
+
+	// Leaving releases the followers, even if the user comes back
+	h.leaveDocument(ada)
+	ada = join(0, "ada")
+	eventually(t, "ada rejoined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 3
+	})
+	h.viewports.forget(ada)
+	h.processMessage(ada, viewport)
+	if count(drain(t, bob), types.MessageTypeViewport) > 0 {
+		t.Fatal("viewport relayed after the followed user left")
+	}
+}
+
+func TestFollowRejectsInvalidTargets(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Follow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	client := newTestClient(h.hub, ada.ID)
+	h.joinDocument(client, &ada, doc.ID)
+
+	for _, userID := range []string{"", "ada"} {
+		h.processMessage(client, &types.WebSocketMessage{
+			Type:    types.MessageTypeFollow,
+			Payload: types.FollowPayload{DocumentID: doc.ID, UserID: userID},
+		})
+		message := nextMessage(t, client, types.MessageTypeError)
+		var reply types.ErrorPayload
+		if err := message.DecodePayload(&reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Code != "INVALID_PAYLOAD" || reply.Field != "userId" {
+			t.Fatalf("following %q: error = %+v", userID, reply)
+		}
+	}
+}
+
+func TestLeavingAnnouncesUnfollow(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Follow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	bob := types.User{ID: "bob", Name: "Bob", Color: types.UserColors[1]}
+	adaClient, bobClient := newTestClient(h.hub, ada.ID), newTestClient(h.hub, bob.ID)
+	h.joinDocument(adaClient, &ada, doc.ID)
+	h.joinDocument(bobClient, &bob, doc.ID)
+
+	h.processMessage(bobClient, &types.WebSocketMessage{
+		Type:    types.MessageTypeFollow,
+		Payload: types.FollowPayload{DocumentID: doc.ID, UserID: "ada"},
+	})
+	h.leaveDocument(bobClient)
+
+	message := nextMessage(t, adaClient, types.MessageTypeUnfollow)
+	var announcement types.FollowPayload
+	if err := message.DecodePayload(&announcement); err != nil {
+		t.Fatal(err)
+	}
+	if announcement.UserID != "ada" || announcement.FollowerID != "bob" {
+		t.Fatalf("unfollow announced as %+v", announcement)
+	}
+}
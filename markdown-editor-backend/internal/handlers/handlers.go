@@ -2,12 +2,25 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"time"
+	"unicode/utf8"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"markdown-editor-backend/internal/cluster"
+	"markdown-editor-backend/internal/config"
+	"markdown-editor-backend/internal/export"
 	"markdown-editor-backend/internal/models"
+	"markdown-editor-backend/internal/protocol"
+	"markdown-editor-backend/internal/ratelimit"
+	"markdown-editor-backend/internal/render"
 	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/internal/validation"
+	"markdown-editor-backend/internal/webhooks"
 	ws "markdown-editor-backend/internal/websocket"
 	"markdown-editor-backend/pkg/types"
 )
@@ -16,16 +29,78 @@ import (
 type Handlers struct {
 	documentService *models.DocumentService
 	userService     *models.UserService
+	commentService  *models.CommentService
+	suggestions     *models.SuggestionService
+	chatService     *models.ChatService
+	notifications   *models.NotificationService
+	webhooks        *webhooks.Dispatcher
+	renderer        *render.Renderer
+	exporter        *export.Exporter
 	hub             *ws.Hub
+	ownership       cluster.Ownership
+	config          *config.Config
+	upgrader        websocket.Upgrader
+	messageLimits   map[string]*messageLimits
+	restLimiter     *ratelimit.Limiter
+	cursors         *broadcastThrottle
+	viewports       *broadcastThrottle
+	awareness       *broadcastThrottle
+	awarenessStates *awarenessStore
+	remote          map[string]remoteSync // message type -> cluster sync
+	presence        *presenceTracker
+	mentions        *mentionScanner
 }
 
 // NewHandlers creates a new handlers instance
-func NewHandlers(storage *storage.MemoryStorage, hub *ws.Hub) *Handlers {
-	return &Handlers{
-		documentService: models.NewDocumentService(storage),
+func NewHandlers(storage *storage.MemoryStorage, hub *ws.Hub, ownership cluster.Ownership, cfg *config.Config) *Handlers {
+	documentService := models.NewDocumentService(storage)
+	renderer := render.NewRenderer()
+	h := &Handlers{
+		documentService: documentService,
 		userService:     models.NewUserService(storage),
+		commentService:  models.NewCommentService(storage),
+		suggestions:     models.NewSuggestionService(storage, documentService),
+		chatService:     models.NewChatService(storage),
+		notifications:   models.NewNotificationService(storage),
+		webhooks:        newWebhookDispatcher(cfg),
+		renderer:        renderer,
+		exporter:        export.NewExporter(renderer, cfg.PDFFontDir),
 		hub:             hub,
-	}
+		ownership:       ownership,
+		config:          cfg,
+		upgrader: websocket.Upgrader{
+			Subprotocols:      protocol.Subprotocols(),
+			EnableCompression: cfg.EnableCompression,
+			CheckOrigin:       ws.NewOriginChecker(cfg.AllowedOrigins),
+		},
+		messageLimits:   defaultMessageLimits(),
+		restLimiter:     ratelimit.NewLimiter(ratelimit.Limit{Rate: 2, Burst: 20}),
+		presence:        newPresenceTracker(cfg.PresenceIdleTimeout),
+		awarenessStates: newAwarenessStore(),
+		mentions:        newMentionScanner(),
+	}
+
+	h.cursors = newBroadcastThrottle(cursorInterval, func(client *ws.Client, message *types.WebSocketMessage) {
+		h.hub.BroadcastToDocument(client.DocumentID, message, client)
+	})
+	h.viewports = newBroadcastThrottle(viewportInterval, func(client *ws.Client, message *types.WebSocketMessage) {
+		h.hub.SendToFollowers(client.DocumentID, client.UserID, message)
+	})
+	h.awareness = newBroadcastThrottle(awarenessInterval, func(client *ws.Client, message *types.WebSocketMessage) {
+		h.hub.BroadcastToDocument(client.DocumentID, message, client)
+	})
+	h.remote = h.remoteSyncs()
+
+	hub.HandleNodeMessages(func(message []byte) {
+		recoverCallback("node message handler", func() { h.handleNodeMessage(message) })
+	})
+	hub.HandleRemoteBroadcasts(func(documentID string, message *types.WebSocketMessage) {
+		recoverCallback("remote broadcast handler", func() { h.handleRemoteBroadcast(documentID, message) })
+	})
+
+	go h.runPresenceSweep()
+
+	return h
 }
 
 // CreateDocument handles document creation
@@ -40,16 +115,33 @@ func (h *Handlers) CreateDocument(w http.ResponseWriter, r *http.Request) {
 		Content string `json:"content"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !h.decodeJSONBody(w, r, &request) {
+		return
+	}
+
+	// The user of the bearer token owns the document; without one nobody
+	// does, but a token that does not verify is refused
+	var ownerID string
+	if r.Header.Get("Authorization") != "" {
+		userID, ok := h.requireUser(w, r, "")
+		if !ok {
+			return
+		}
+		ownerID = userID
+	}
+
+	if err := validation.ValidateTitle("title", &request.Title); err != nil {
+		writeValidationError(w, err)
 		return
 	}
+	request.Content = validation.StripControl(request.Content, true)
 
-	doc, err := h.documentService.CreateDocument(request.Title, request.Content)
+	doc, err := h.documentService.CreateRoom(request.Title, request.Content, ownerID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.documentCreated(doc)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(doc)
@@ -78,20 +170,7 @@ func (h *Handlers) GetDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := h.userService.GetDocumentUsers(documentID)
-	if err != nil {
-		log.Printf("Error getting document users: %v", err)
-		users = []*types.User{}
-	}
-
-	response := types.DocumentSyncPayload{
-		Document: *doc,
-		Users:    make([]types.User, len(users)),
-	}
-
-	for i, user := range users {
-		response.Users[i] = *user
-	}
+	response := h.documentSyncPayload(doc)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -108,13 +187,12 @@ func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 		Name string `json:"name"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !h.decodeJSONBody(w, r, &request) {
 		return
 	}
 
-	if request.Name == "" {
-		http.Error(w, "Name is required", http.StatusBadRequest)
+	if err := validation.ValidateName("name", &request.Name); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
@@ -124,26 +202,47 @@ func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
-}
+	// The token is presented as a bearer token to the per-user endpoints
+	response := struct {
+		*types.User
+		Token string `json:"token"`
+	}{
+		User:  user,
+		Token: h.signUserToken(user.ID),
+	}
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 // HandleWebSocket handles WebSocket connections with enhanced message processing
 func (h *Handlers) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if h.config.RequireWSToken && !h.verifyWSToken(r.URL.Query().Get("token"), r.Header.Get("Origin")) {
+		log.Printf("Rejected WebSocket upgrade from %s: missing or invalid token", h.clientIP(r))
+		http.Error(w, "Invalid WebSocket token", http.StatusForbidden)
+		return
+	}
+
+	// Upgrade answers 403 itself when the Origin is not allowed
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
 
+	// Oversized frames fail the read and close the connection with 1009,
+	// readMessage applies the same bound after decompression
+	conn.SetReadLimit(h.config.MaxMessageSize)
+	conn.EnableWriteCompression(h.config.EnableCompression)
+
 	client := &ws.Client{
-		Conn: conn,
-		Hub:  h.hub,
-		Send: make(chan []byte, 256),
+		ID:         uuid.New().String(),
+		Conn:       conn,
+		Hub:        h.hub,
+		Send:       make(chan []byte, 256),
+		RemoteAddr: h.clientIP(r),
+		// Subprotocol is empty unless the client asked for one
+		Codec: protocol.ForSubprotocol(conn.Subprotocol()),
 	}
 
 	// Don't register client until JOIN message is received
@@ -154,20 +253,11 @@ func (h *Handlers) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 // handleClientMessages processes messages from a WebSocket client
 func (h *Handlers) handleClientMessages(client *ws.Client) {
 	defer func() {
+		h.cursors.forget(client)
+		h.viewports.forget(client)
+
 		if client.UserID != "" && client.DocumentID != "" {
-			h.userService.LeaveDocument(client.UserID, client.DocumentID)
-			
-			// Broadcast user leave
-			leaveMessage := types.WebSocketMessage{
-				Type: types.MessageTypeLeave,
-				Payload: types.LeavePayload{
-					UserID: client.UserID,
-				},
-			}
-			
-			if messageBytes, err := json.Marshal(leaveMessage); err == nil {
-				h.hub.BroadcastToDocument(client.DocumentID, messageBytes, client)
-			}
+			h.leaveDocument(client)
 			
 			// Only unregister if client was actually registered (has UserID/DocumentID)
 			h.hub.UnregisterClient(client)
@@ -177,26 +267,59 @@ func (h *Handlers) handleClientMessages(client *ws.Client) {
 	}()
 
 	for {
-		_, messageBytes, err := client.Conn.ReadMessage()
+		messageBytes, err := h.readMessage(client)
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if errors.Is(err, websocket.ErrReadLimit) || err == errMessageTooLarge {
+				log.Printf("Message from user %s exceeded %d bytes, closing connection", client.UserID, h.config.MaxMessageSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
 
-		var message types.WebSocketMessage
-		if err := json.Unmarshal(messageBytes, &message); err != nil {
+		message, err := client.Codec.Decode(messageBytes)
+		if err != nil {
 			log.Printf("Error unmarshaling message: %v", err)
 			continue
 		}
 
-		h.processMessage(client, &message)
+		h.dispatchMessage(client, message)
 	}
 }
 
+// errMessageTooLarge reports a message that inflates past MaxMessageSize
+var errMessageTooLarge = errors.New("message too large")
+
+// closeTimeout bounds how long sending a close frame may block
+const closeTimeout = time.Second
+
+// readMessage reads the next message from a client. The connection read
+// limit only counts bytes on the wire, so a compressed message is also
+// cut off once it inflates past MaxMessageSize.
+func (h *Handlers) readMessage(client *ws.Client) ([]byte, error) {
+	_, reader, err := client.Conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	messageBytes, err := io.ReadAll(io.LimitReader(reader, h.config.MaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(messageBytes)) > h.config.MaxMessageSize {
+		closeMessage := websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "")
+		client.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(closeTimeout))
+		return nil, errMessageTooLarge
+	}
+	return messageBytes, nil
+}
+
 // processMessage handles different types of WebSocket messages
 func (h *Handlers) processMessage(client *ws.Client, message *types.WebSocketMessage) {
+	if !h.checkMessageRate(client, message) {
+		return
+	}
+
 	switch message.Type {
 	case types.MessageTypeJoin:
 		h.handleJoinMessage(client, message)
@@ -210,34 +333,67 @@ func (h *Handlers) processMessage(client *ws.Client, message *types.WebSocketMes
 		h.handleCreateRoomMessage(client, message)
 	case types.MessageTypeJoinRoom:
 		h.handleJoinRoomMessage(client, message)
+	case types.MessageTypePresence:
+		h.handlePresenceMessage(client, message)
+	case types.MessageTypeFollow:
+		h.handleFollowMessage(client, message)
+	case types.MessageTypeUnfollow:
+		h.handleUnfollowMessage(client, message)
+	case types.MessageTypeViewport:
+		h.handleViewportMessage(client, message)
+	case types.MessageTypeAwareness:
+		h.handleAwarenessMessage(client, message)
+	case types.MessageTypeCommentAdd:
+		h.handleCommentAddMessage(client, message)
+	case types.MessageTypeCommentReply:
+		h.handleCommentReplyMessage(client, message)
+	case types.MessageTypeCommentResolve:
+		h.handleCommentResolveMessage(client, message)
+	case types.MessageTypeSuggestionAccept:
+		h.handleSuggestionAcceptMessage(client, message)
+	case types.MessageTypeSuggestionReject:
+		h.handleSuggestionRejectMessage(client, message)
+	case types.MessageTypeChat:
+		h.handleChatMessage(client, message)
+	case types.MessageTypeChatEdit:
+		h.handleChatEditMessage(client, message)
+	case types.MessageTypeChatDelete:
+		h.handleChatDeleteMessage(client, message)
 	default:
 		log.Printf("Unknown message type: %s", message.Type)
 	}
 }
 
 func (h *Handlers) handleJoinMessage(client *ws.Client, message *types.WebSocketMessage) {
-	payloadBytes, _ := json.Marshal(message.Payload)
 	var payload types.JoinPayload
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Printf("Error unmarshaling join payload: %v", err)
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.authenticateJoin(client, payload.User.ID, payload.Token) {
 		return
 	}
 
-	client.UserID = payload.User.ID
-	client.DocumentID = payload.DocumentID
-
-	// Now register client to hub with proper UserID and DocumentID
-	h.hub.RegisterClient(client)
+	// A document written on another node that has not reached this one
+	// must not be recreated here under the same ID
+	if _, err := h.documentService.GetDocument(payload.DocumentID); err == storage.ErrDocumentNotFound {
+		if h.documentService.IsDeleted(payload.DocumentID) {
+			h.sendError(client, "Document was deleted", "DOCUMENT_DELETED")
+			return
+		}
+		if owner, err := h.ownership.Owner(payload.DocumentID); err != nil || (owner != "" && owner != h.hub.NodeID()) {
+			log.Printf("Document %s is owned by node %q but not synced here", payload.DocumentID, owner)
+			h.sendError(client, "Document is not available on this server yet", "DOCUMENT_UNAVAILABLE")
+			return
+		}
 
-	// Add user to storage first
-	err := h.userService.AddUser(&payload.User)
-	if err != nil {
-		log.Printf("Error adding user to storage: %v", err)
-		// Continue anyway, user might already exist
+		// The document is created below, like a room
+		if !h.checkMessageRate(client, &types.WebSocketMessage{Type: types.MessageTypeCreateRoom}) {
+			return
+		}
 	}
 
-	// Add user to document
-	h.userService.JoinDocument(client.UserID, client.DocumentID)
+	// Add user to document and announce them to the room
+	h.joinDocument(client, &payload.User, payload.DocumentID)
 
 	// Get current document state
 	doc, err := h.documentService.GetDocument(client.DocumentID)
@@ -248,59 +404,73 @@ func (h *Handlers) handleJoinMessage(client *ws.Client, message *types.WebSocket
 				client.DocumentID, 
 				"Untitled Document", 
 				"# Welcome to collaborative editing!\n\nStart typing to see real-time collaboration in action.",
+				ownerFor(client, payload.User.ID),
 			)
 			if err != nil {
 				log.Printf("Error creating document: %v", err)
 				return
 			}
+			h.documentCreated(doc)
 		} else {
 			log.Printf("Error getting document: %v", err)
 			return
 		}
 	}
 
-	// Get all users in the document
-	users, err := h.userService.GetDocumentUsers(client.DocumentID)
-	if err != nil {
-		log.Printf("Error getting document users: %v", err)
+	// Send document sync, including all users, to the joining user
+	h.sendDocumentSync(client, doc)
+
+	log.Printf("User %s joined document %s", client.UserID, client.DocumentID)
+}
+
+func (h *Handlers) handleOperationMessage(client *ws.Client, message *types.WebSocketMessage) {
+	log.Printf("Received operation message from user %s for document %s", client.UserID, client.DocumentID)
+	
+	var payload types.OperationPayload
+	if !h.decodePayload(client, message, &payload) {
 		return
 	}
 
-	// Send document sync to the joining user
-	syncPayload := types.DocumentSyncPayload{
-		Document: *doc,
-		Users:    make([]types.User, len(users)),
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
 	}
 
-	for i, user := range users {
-		syncPayload.Users[i] = *user
-	}
+	log.Printf("Operation details: %+v", payload.Operation)
 
-	syncMessage := types.WebSocketMessage{
-		Type:    types.MessageTypeDocumentSync,
-		Payload: syncPayload,
-	}
+	h.touchPresence(client)
 
-	if syncBytes, err := json.Marshal(syncMessage); err == nil {
-		client.Send <- syncBytes
+	if utf8.RuneCountInString(payload.Operation.Content) > h.config.MaxOperationSize {
+		log.Printf("Operation from user %s exceeds %d characters", client.UserID, h.config.MaxOperationSize)
+		h.sendError(client, "Operation content too large", "MESSAGE_TOO_LARGE")
+		return
 	}
 
-	// Note: No need to broadcast user join separately since DocumentSync already contains all users
-
-	log.Printf("User %s joined document %s", client.UserID, client.DocumentID)
-}
+	// Suggested operations are held for review instead of committed
+	if payload.SuggestionID != "" {
+		h.suggestOperation(client, &payload)
+		return
+	}
 
-func (h *Handlers) handleOperationMessage(client *ws.Client, message *types.WebSocketMessage) {
-	log.Printf("Received operation message from user %s for document %s", client.UserID, client.DocumentID)
-	
-	payloadBytes, _ := json.Marshal(message.Payload)
-	var payload types.OperationPayload
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Printf("Error unmarshaling operation payload: %v", err)
+	// Only the owning node sequences operations for a document
+	owner, err := h.documentOwner(payload.DocumentID)
+	if err != nil {
+		log.Printf("Error resolving owner of document %s: %v", payload.DocumentID, err)
+		return
+	}
+	if owner != h.hub.NodeID() {
+		h.forwardOperation(owner, client, &payload)
 		return
 	}
 
-	log.Printf("Operation details: %+v", payload.Operation)
+	h.commitOperation(client.UserID, client.ID, &payload)
+}
+
+// commitOperation applies an operation and broadcasts the result to the
+// document, excluding the originating connection from the echo
+func (h *Handlers) commitOperation(userID, clientID string, payload *types.OperationPayload) {
+	// Authorship is taken from the connection, not from the client
+	payload.Operation.UserID = userID
+	payload.Operation.Timestamp = time.Now()
 
 	// Apply operation to document
 	doc, err := h.documentService.ApplyOperation(payload.DocumentID, &payload.Operation)
@@ -308,28 +478,35 @@ func (h *Handlers) handleOperationMessage(client *ws.Client, message *types.WebS
 		log.Printf("Error applying operation: %v", err)
 		return
 	}
+	payload.Operation.Version = doc.Version
 
 	log.Printf("Operation applied successfully. Document version: %d, content length: %d", doc.Version, len(doc.Content))
+	h.broadcastOperation(userID, clientID, payload)
+	h.broadcastDocumentUpdate(doc)
+	h.scheduleMentionScan(doc.ID, doc.Version)
+	h.webhooks.Edited(doc, userID)
+}
 
+// broadcastOperation relays a committed operation to the document,
+// excluding clientID which already has it
+func (h *Handlers) broadcastOperation(userID, clientID string, payload *types.OperationPayload) {
 	// Get document clients for debugging
-	clients := h.hub.GetDocumentClients(client.DocumentID)
-	log.Printf("Broadcasting operation to %d clients in document %s", len(clients)-1, client.DocumentID)
+	clients := h.hub.GetDocumentClients(payload.DocumentID)
+	log.Printf("Broadcasting operation to %d local clients in document %s", len(clients), payload.DocumentID)
 
 	// Broadcast operation to other clients
 	broadcastMessage := types.WebSocketMessage{
 		Type:    types.MessageTypeOperation,
 		Payload: payload,
-		UserID:  client.UserID,
+		UserID:  userID,
 	}
 
-	if opBytes, err := json.Marshal(broadcastMessage); err == nil {
-		h.hub.BroadcastToDocument(client.DocumentID, opBytes, client)
-		log.Printf("Operation broadcast sent to other clients")
-	} else {
-		log.Printf("Error marshaling operation broadcast: %v", err)
-	}
+	h.hub.BroadcastToDocumentExcept(payload.DocumentID, &broadcastMessage, clientID)
+	log.Printf("Operation broadcast sent to other clients")
+}
 
-	// Also broadcast document update
+// broadcastDocumentUpdate sends the committed document state to everyone
+func (h *Handlers) broadcastDocumentUpdate(doc *types.Document) {
 	updateMessage := types.WebSocketMessage{
 		Type: types.MessageTypeDocumentUpdate,
 		Payload: types.DocumentUpdatePayload{
@@ -337,22 +514,28 @@ func (h *Handlers) handleOperationMessage(client *ws.Client, message *types.WebS
 		},
 	}
 
-	if updateBytes, err := json.Marshal(updateMessage); err == nil {
-		h.hub.BroadcastToDocument(client.DocumentID, updateBytes, nil)
-		log.Printf("Document update broadcast sent to all clients")
-	} else {
-		log.Printf("Error marshaling document update broadcast: %v", err)
-	}
+	h.hub.BroadcastToDocument(doc.ID, &updateMessage, nil)
+	log.Printf("Document update broadcast sent to all clients")
 }
 
 func (h *Handlers) handleCursorMessage(client *ws.Client, message *types.WebSocketMessage) {
-	payloadBytes, _ := json.Marshal(message.Payload)
 	var payload types.CursorPayload
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Printf("Error unmarshaling cursor payload: %v", err)
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
 		return
 	}
 
+	// Cursors always belong to the connection's user
+	payload.Position.UserID = client.UserID
+	payload.Position.Color = ""
+	if user, err := h.userService.GetUser(client.UserID); err == nil {
+		payload.Position.Color = user.Color
+	}
+
+	h.touchPresence(client)
+
 	// Update cursor position
 	h.userService.UpdateCursor(payload.DocumentID, &payload.Position)
 
@@ -363,29 +546,42 @@ func (h *Handlers) handleCursorMessage(client *ws.Client, message *types.WebSock
 		UserID:  client.UserID,
 	}
 
-	if cursorBytes, err := json.Marshal(broadcastMessage); err == nil {
-		h.hub.BroadcastToDocument(client.DocumentID, cursorBytes, client)
-	}
+	h.cursors.publish(client, &broadcastMessage)
+}
+
+// syncRemoteCursor stores a cursor moved by a client of another node
+func (h *Handlers) syncRemoteCursor(documentID string, payload *types.CursorPayload) {
+	h.userService.UpdateCursor(documentID, &payload.Position)
 }
 
 func (h *Handlers) handleTitleUpdateMessage(client *ws.Client, message *types.WebSocketMessage) {
 	log.Printf("Received title update message from user %s for document %s", client.UserID, client.DocumentID)
 	
-	payloadBytes, _ := json.Marshal(message.Payload)
 	var payload types.TitleUpdatePayload
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Printf("Error unmarshaling title update payload: %v", err)
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+
+	if !h.checkDocument(client, payload.DocumentID) {
 		return
 	}
 
 	log.Printf("Title update details: %+v", payload)
 
+	h.touchPresence(client)
+
+	var previousTitle string
+	if doc, err := h.documentService.GetDocument(payload.DocumentID); err == nil {
+		previousTitle = doc.Title
+	}
+
 	// Update document title
 	doc, err := h.documentService.UpdateDocumentTitle(payload.DocumentID, payload.NewTitle)
 	if err != nil {
 		log.Printf("Error updating document title: %v", err)
 		return
 	}
+	h.emitTitleChanged(doc, previousTitle, client.UserID)
 
 	log.Printf("Title updated successfully. Document: %s, New title: %s", doc.ID, doc.Title)
 
@@ -396,43 +592,39 @@ func (h *Handlers) handleTitleUpdateMessage(client *ws.Client, message *types.We
 		UserID:  client.UserID,
 	}
 
-	if titleBytes, err := json.Marshal(broadcastMessage); err == nil {
-		h.hub.BroadcastToDocument(client.DocumentID, titleBytes, nil) // nil means broadcast to all clients
-		log.Printf("Title update broadcast sent to all clients")
-	} else {
-		log.Printf("Error marshaling title update broadcast: %v", err)
-	}
+	h.hub.BroadcastToDocument(client.DocumentID, &broadcastMessage, nil) // nil means broadcast to all clients
+	log.Printf("Title update broadcast sent to all clients")
 }
 
 func (h *Handlers) handleCreateRoomMessage(client *ws.Client, message *types.WebSocketMessage) {
 	log.Printf("Received create room message")
 	
-	payloadBytes, _ := json.Marshal(message.Payload)
 	var payload types.CreateRoomPayload
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Printf("Error unmarshaling create room payload: %v", err)
-		h.sendError(client, "Invalid create room payload", "INVALID_PAYLOAD")
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.authenticateJoin(client, payload.User.ID, payload.Token) {
 		return
 	}
 
 	log.Printf("Creating room with title: %s", payload.Title)
 
+	if utf8.RuneCountInString(payload.Content) > h.config.MaxOperationSize {
+		h.sendError(client, "Room content too large", "MESSAGE_TOO_LARGE")
+		return
+	}
+
 	// Create new room/document
-	doc, err := h.documentService.CreateRoom(payload.Title, payload.Content)
+	doc, err := h.documentService.CreateRoom(payload.Title, payload.Content, ownerFor(client, payload.User.ID))
 	if err != nil {
 		log.Printf("Error creating room: %v", err)
 		h.sendError(client, "Failed to create room", "CREATE_ROOM_ERROR")
 		return
 	}
+	h.documentCreated(doc)
 
-	// Set client details
-	client.UserID = payload.User.ID
-	client.DocumentID = doc.ID
-
-	// Register client and add user to storage
-	h.hub.RegisterClient(client)
-	h.userService.AddUser(&payload.User)
-	h.userService.JoinDocument(client.UserID, client.DocumentID)
+	// Register client and add user to the room
+	h.joinDocument(client, &payload.User, doc.ID)
 
 	log.Printf("Room created successfully. Room code: %s, Document ID: %s", doc.RoomCode, doc.ID)
 
@@ -447,8 +639,7 @@ func (h *Handlers) handleCreateRoomMessage(client *ws.Client, message *types.Web
 		Payload: response,
 	}
 
-	if responseBytes, err := json.Marshal(responseMessage); err == nil {
-		client.Send <- responseBytes
+	if err := client.SendMessage(&responseMessage); err == nil {
 		log.Printf("Create room response sent to client")
 	} else {
 		log.Printf("Error marshaling create room response: %v", err)
@@ -458,11 +649,11 @@ func (h *Handlers) handleCreateRoomMessage(client *ws.Client, message *types.Web
 func (h *Handlers) handleJoinRoomMessage(client *ws.Client, message *types.WebSocketMessage) {
 	log.Printf("Received join room message")
 	
-	payloadBytes, _ := json.Marshal(message.Payload)
 	var payload types.JoinRoomPayload
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		log.Printf("Error unmarshaling join room payload: %v", err)
-		h.sendError(client, "Invalid join room payload", "INVALID_PAYLOAD")
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.authenticateJoin(client, payload.User.ID, payload.Token) {
 		return
 	}
 
@@ -476,43 +667,11 @@ func (h *Handlers) handleJoinRoomMessage(client *ws.Client, message *types.WebSo
 		return
 	}
 
-	// Set client details
-	client.UserID = payload.User.ID
-	client.DocumentID = doc.ID
-
-	// Register client and add user to storage
-	h.hub.RegisterClient(client)
-	h.userService.AddUser(&payload.User)
-	h.userService.JoinDocument(client.UserID, client.DocumentID)
-
-	// Get all users in the document
-	users, err := h.userService.GetDocumentUsers(client.DocumentID)
-	if err != nil {
-		log.Printf("Error getting document users: %v", err)
-		users = []*types.User{}
-	}
+	// Register client and announce the user to the room
+	h.joinDocument(client, &payload.User, doc.ID)
 
 	// Send document sync to the joining user
-	syncPayload := types.DocumentSyncPayload{
-		Document: *doc,
-		Users:    make([]types.User, len(users)),
-	}
-
-	for i, user := range users {
-		syncPayload.Users[i] = *user
-	}
-
-	syncMessage := types.WebSocketMessage{
-		Type:    types.MessageTypeDocumentSync,
-		Payload: syncPayload,
-	}
-
-	if syncBytes, err := json.Marshal(syncMessage); err == nil {
-		client.Send <- syncBytes
-		log.Printf("Document sync sent to joining user")
-	}
-
-	// Note: No need to broadcast user join separately since DocumentSync already contains all users
+	h.sendDocumentSync(client, doc)
 
 	log.Printf("User %s joined room %s successfully", client.UserID, payload.RoomCode)
 }
@@ -526,7 +685,7 @@ func (h *Handlers) sendError(client *ws.Client, message, code string) {
 		},
 	}
 
-	if errorBytes, err := json.Marshal(errorMessage); err == nil {
-		client.Send <- errorBytes
+	if err := client.SendMessage(&errorMessage); err != nil {
+		log.Printf("Error marshaling error message: %v", err)
 	}
 }
\ No newline at end of file
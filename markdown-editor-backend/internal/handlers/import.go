@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"markdown-editor-backend/internal/importer"
+	"markdown-editor-backend/internal/validation"
+	"markdown-editor-backend/pkg/types"
+)
+
+// ImportDocument handles POST /api/documents/import, a multipart form with
+// a .md, .txt or .html file in "file" and optionally a "title" overriding
+// the one found in the file. The user of the bearer token owns the new
+// room. It answers with the new room like create_room does.
+func (h *Handlers) ImportDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ownerID, ok := h.requireUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.config.MaxMessageSize)
+	if err := r.ParseMultipartForm(h.config.MaxMessageSize); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Expected a multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "A file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imported, err := importer.Parse(header.Filename, header.Header.Get("Content-Type"), data)
+	if err != nil {
+		if errors.Is(err, importer.ErrUnsupportedType) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	title := r.FormValue("title")
+	if title == "" {
+		title = importTitle(imported.Title, header.Filename)
+	}
+	if err := validation.ValidateTitle("title", &title); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	content := validation.StripControl(imported.Content, true)
+	if utf8.RuneCountInString(content) > h.config.MaxOperationSize {
+		http.Error(w, "Document content too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	doc, err := h.documentService.CreateRoomWithMetadata(title, content, ownerID, imported.Metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.documentCreated(doc)
+
+	log.Printf("Imported %s as room %s", header.Filename, doc.RoomCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(types.CreateRoomResponse{
+		Document: *doc,
+		RoomCode: doc.RoomCode,
+	})
+}
+
+// importTitle picks the title found in the file, else the file name,
+// shortened to the allowed length
+func importTitle(title, filename string) string {
+	title = strings.TrimSpace(validation.StripControl(title, false))
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	if title == "" {
+		title = "Untitled Document"
+	}
+
+	if utf8.RuneCountInString(title) > validation.MaxTitleLength {
+		title = string([]rune(title)[:validation.MaxTitleLength])
+	}
+	return title
+}
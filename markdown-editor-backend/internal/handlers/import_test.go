@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"markdown-editor-backend/internal/config"
+	"markdown-editor-backend/pkg/types"
+)
+
+// importFile posts a file to POST /api/documents/import with a user token
+func importFile(t *testing.T, h *Handlers, token, filename string, data []byte) (*httptest.ResponseRecorder, types.CreateRoomResponse) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/api/documents/import", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	h.ImportDocument(recorder, request)
+
+	var created types.CreateRoomResponse
+	if recorder.Code == http.StatusCreated {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder, created
+}
+
+func TestImportExportRoundTrip(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, token := createTestUser(t, h, "Ada")
+	source := "---\r\ntitle: \"Trip: Notes\"\r\nversion: 12\r\ntags:\r\n  - travel\r\nauthor:\r\n  name: Ada\r\n---\r\n\r\n" +
+		"# Day one\r\n\r\nCaf\xe9 au lait.\r\n"
+
+	recorder, imported := importFile(t, h, token, "trip.md", []byte(source))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("import returned %d: %s", recorder.Code, recorder.Body)
+	}
+	if imported.Document.OwnerID != ada.ID {
+		t.Fatalf("imported document is owned by %q, want the token's user", imported.Document.OwnerID)
+	}
+	if imported.Document.Title != "Trip: Notes" || imported.Document.Content != "# Day one\n\nCafé au lait.\n" {
+		t.Fatalf("imported %q: %q", imported.Document.Title, imported.Document.Content)
+	}
+
+	exported := exportDocument(h, imported.Document.ID, "")
+	if exported.Code != http.StatusOK {
+		t.Fatalf("export returned %d", exported.Code)
+	}
+	markdown := exported.Body.String()
+	for _, line := range []string{`title: "Trip: Notes"`, "version: 1\n", "author:\n    name: Ada\n", "tags:\n    - travel\n", "# Day one\n\nCafé au lait.\n"} {
+		if !strings.Contains(markdown, line) {
+			t.Fatalf("export lacks %q:\n%s", line, markdown)
+		}
+	}
+
+	// Importing the export again gives the same document
+	recorder, reimported := importFile(t, h, token, "trip-notes.md", exported.Body.Bytes())
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("second import returned %d: %s", recorder.Code, recorder.Body)
+	}
+	first, second := imported.Document, reimported.Document
+	if second.Title != first.Title || second.Content != first.Content || !reflect.DeepEqual(second.Metadata, first.Metadata) {
+		t.Fatalf("round trip changed the document:\n%+v\n%+v", first, second)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	h := newTestHandlers(t, func(cfg *config.Config) {
+		cfg.MaxOperationSize = 10
+	})
+	_, token := createTestUser(t, h, "Ada")
+
+	tests := []struct {
+		name     string
+		token    string
+		filename string
+		data     string
+		want     int
+	}{
+		{"no token", "", "notes.md", "text", http.StatusUnauthorized},
+		{"forged token", "ada.deadbeef", "notes.md", "text", http.StatusUnauthorized},
+		{"unsupported type", token, "notes.docx", "text", http.StatusUnsupportedMediaType},
+		{"content too large", token, "notes.md", strings.Repeat("x", 11), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if recorder, _ := importFile(t, h, tt.token, tt.filename, []byte(tt.data)); recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"markdown-editor-backend/internal/models"
+	"markdown-editor-backend/pkg/types"
+)
+
+// mentionScanDelay is how long a document must stay unchanged before its
+// content is scanned for mentions, so that names are typed out
+const mentionScanDelay = 2 * time.Second
+
+// mentionScanner debounces content scans per document and remembers the
+// mentions already notified
+type mentionScanner struct {
+	timers   map[string]*time.Timer     // documentID -> pending scan
+	scanned  map[string]int             // documentID -> version at the last scan
+	notified map[string]map[string]bool // documentID -> mention keys
+	mutex    sync.Mutex
+}
+
+func newMentionScanner() *mentionScanner {
+	return &mentionScanner{
+		timers:   make(map[string]*time.Timer),
+		scanned:  make(map[string]int),
+		notified: make(map[string]map[string]bool),
+	}
+}
+
+// schedule delays the scan of a document until edits pause. The first
+// time, text older than version is taken as already scanned.
+func (ms *mentionScanner) schedule(documentID string, version int, scan func()) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.scanned[documentID]; !exists {
+		ms.scanned[documentID] = version - 1
+	}
+	if timer, exists := ms.timers[documentID]; exists {
+		timer.Reset(mentionScanDelay)
+		return
+	}
+	ms.timers[documentID] = time.AfterFunc(mentionScanDelay, func() {
+		ms.mutex.Lock()
+		delete(ms.timers, documentID)
+		ms.mutex.Unlock()
+
+		recoverCallback("mention scan", scan)
+	})
+}
+
+// advance records a scan at version and returns the previous one
+func (ms *mentionScanner) advance(documentID string, version int) int {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	since := ms.scanned[documentID]
+	ms.scanned[documentID] = version
+	return since
+}
+
+// markNotified reports whether key is new for the document, recording it
+func (ms *mentionScanner) markNotified(documentID, key string) bool {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.notified[documentID] == nil {
+		ms.notified[documentID] = make(map[string]bool)
+	}
+	if ms.notified[documentID][key] {
+		return false
+	}
+	ms.notified[documentID][key] = true
+	return true
+}
+
+// forget stops the pending scan of a deleted document and drops its state
+func (ms *mentionScanner) forget(documentID string) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if timer, exists := ms.timers[documentID]; exists {
+		timer.Stop()
+		delete(ms.timers, documentID)
+	}
+	delete(ms.scanned, documentID)
+	delete(ms.notified, documentID)
+}
+
+// scheduleMentionScan looks for new mentions once edits to a document
+// pause. version is the first version produced by the edit.
+func (h *Handlers) scheduleMentionScan(documentID string, version int) {
+	h.mentions.schedule(documentID, version, func() {
+		h.scanMentions(documentID)
+	})
+}
+
+// scanMentions notifies users mentioned in text written since the last
+// scan. Each mention is credited to the author of its @.
+func (h *Handlers) scanMentions(documentID string) {
+	content, version, runs, err := h.documentService.Authorship(documentID)
+	if err != nil {
+		log.Printf("Error scanning document %s for mentions: %v", documentID, err)
+		return
+	}
+	since := h.mentions.advance(documentID, version)
+
+	runes := []rune(content)
+	for _, mention := range models.ParseMentions(content) {
+		if models.RunAt(runs, mention.End-1).Version <= since {
+			continue
+		}
+		at := models.RunAt(runs, mention.Start)
+		author, err := h.userService.GetUser(at.UserID)
+		if err != nil {
+			continue
+		}
+
+		for _, user := range h.userService.ResolveMention(documentID, mention.Name) {
+			if user.ID == author.ID {
+				continue
+			}
+			key := fmt.Sprintf("%s|%s|%d", user.ID, at.UserID, at.Version)
+			if !h.mentions.markNotified(documentID, key) {
+				continue
+			}
+			excerpt := lineAround(runes, mention.Start)
+			h.pushNotification(h.notifications.NotifyMention(user.ID, documentID, "", author, excerpt))
+		}
+	}
+}
+
+// notifyCommentMentions notifies the users mentioned in a comment
+func (h *Handlers) notifyCommentMentions(thread *types.CommentThread, author *types.User, content string) {
+	notified := make(map[string]bool)
+	for _, mention := range models.ParseMentions(content) {
+		for _, user := range h.userService.ResolveMention(thread.DocumentID, mention.Name) {
+			if user.ID == author.ID || notified[user.ID] {
+				continue
+			}
+			notified[user.ID] = true
+			h.pushNotification(h.notifications.NotifyMention(user.ID, thread.DocumentID, thread.ID, author, content))
+		}
+	}
+}
+
+// pushNotification delivers a new notification to the open connections of
+// its recipient
+func (h *Handlers) pushNotification(notification *types.Notification) {
+	log.Printf("Notifying user %s of a %s by %s in document %s", notification.UserID, notification.Type, notification.ActorID, notification.DocumentID)
+
+	message := types.WebSocketMessage{
+		Type: types.MessageTypeNotification,
+		Payload: types.NotificationPayload{
+			Notification: *notification,
+		},
+	}
+	h.hub.SendToUser(notification.UserID, &message)
+}
+
+// syncRemoteNotification stores a notification recorded on another node
+func (h *Handlers) syncRemoteNotification(documentID string, payload *types.NotificationPayload) {
+	h.notifications.SyncNotification(&payload.Notification)
+}
+
+// syncRemoteNotificationRead applies notifications marked read elsewhere
+func (h *Handlers) syncRemoteNotificationRead(documentID string, payload *types.NotificationsReadPayload) {
+	h.notifications.MarkRead(payload.UserID, payload.IDs)
+}
+
+// excerptRadius bounds how many runes of the line are quoted on each side
+// of a mention
+const excerptRadius = 100
+
+// lineAround returns the line of text containing offset, cut to
+// excerptRadius runes either side of it
+func lineAround(runes []rune, offset int) string {
+	start := offset
+	for start > 0 && offset-start < excerptRadius && runes[start-1] != '\n' {
+		start--
+	}
+	end := offset
+	for end < len(runes) && end-offset < excerptRadius && runes[end] != '\n' {
+		end++
+	}
+	return string(runes[start:end])
+}
+
+// ListNotifications handles GET /api/notifications for the user of the
+// bearer token, returning their inbox newest first, or only unread entries
+// with ?unread=true
+func (h *Handlers) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.requireUser(w, r, r.URL.Query().Get("userId"))
+	if !ok {
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.notifications.GetInbox(userID, unreadOnly))
+}
+
+// MarkNotificationsRead handles POST /api/notifications/read for the user
+// of the bearer token, with the notification IDs to mark, or none to mark
+// the whole inbox
+func (h *Handlers) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		UserID string   `json:"userId"`
+		IDs    []string `json:"ids"`
+	}
+	if !h.decodeJSONBody(w, r, &request) {
+		return
+	}
+	userID, ok := h.requireUser(w, r, request.UserID)
+	if !ok {
+		return
+	}
+	request.UserID = userID
+
+	marked := h.notifications.MarkRead(request.UserID, request.IDs)
+
+	// Other tabs and nodes update their unread state
+	if len(marked) > 0 {
+		h.hub.SendToUser(request.UserID, &types.WebSocketMessage{
+			Type: types.MessageTypeNotificationRead,
+			Payload: types.NotificationsReadPayload{
+				UserID: request.UserID,
+				IDs:    marked,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.NotificationsReadPayload{
+		UserID: request.UserID,
+		IDs:    marked,
+	})
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// createTestUser registers a user through the API and returns it with its
+// bearer token
+func createTestUser(t *testing.T, h *Handlers, name string) (types.User, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	h.CreateUser(recorder, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"name":"`+name+`"}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("create user returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var created struct {
+		types.User
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.Token == "" {
+		t.Fatalf("create user returned %+v", created)
+	}
+	return created.User, created.Token
+}
+
+func TestNotificationsRequireTheUsersToken(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, adaToken := createTestUser(t, h, "Ada")
+	_, bobToken := createTestUser(t, h, "Bob")
+	h.notifications.NotifyMention(ada.ID, "doc", "thread", &types.User{ID: "carol", Name: "Carol"}, "hi @Ada")
+
+	list := func(query, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/api/notifications"+query, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		h.ListNotifications(recorder, request)
+		return recorder
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		token string
+		want  int
+	}{
+		{"no token", "?userId=" + ada.ID, "", http.StatusUnauthorized},
+		{"forged token", "", ada.ID + ".deadbeef", http.StatusUnauthorized},
+		{"someone else's token", "?userId=" + ada.ID, bobToken, http.StatusForbidden},
+		{"own token", "", adaToken, http.StatusOK},
+		{"own token and userId", "?userId=" + ada.ID, adaToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if recorder := list(tt.query, tt.token); recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+
+	var inbox []types.Notification
+	if err := json.NewDecoder(list("", adaToken).Body).Decode(&inbox); err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 || inbox[0].UserID != ada.ID {
+		t.Fatalf("inbox = %+v, want ada's mention", inbox)
+	}
+	if err := json.NewDecoder(list("", bobToken).Body).Decode(&inbox); err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 0 {
+		t.Fatalf("bob's inbox = %+v, want empty", inbox)
+	}
+}
+
+func TestMarkNotificationsReadUsesTheTokensUser(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, adaToken := createTestUser(t, h, "Ada")
+	_, bobToken := createTestUser(t, h, "Bob")
+	h.notifications.NotifyMention(ada.ID, "doc", "thread", &types.User{ID: "carol", Name: "Carol"}, "hi @Ada")
+
+	markRead := func(body, token string) int {
+		request := httptest.NewRequest(http.MethodPost, "/api/notifications/read", strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		h.MarkNotificationsRead(recorder, request)
+		return recorder.Code
+	}
+
+	if code := markRead(`{"userId":"`+ada.ID+`"}`, bobToken); code != http.StatusForbidden {
+		t.Fatalf("marking another user's inbox returned %d, want 403", code)
+	}
+	if code := markRead(`{}`, bobToken); code != http.StatusOK {
+		t.Fatalf("marking own inbox returned %d", code)
+	}
+	if unread := h.notifications.GetInbox(ada.ID, true); len(unread) != 1 {
+		t.Fatalf("bob's request changed ada's inbox: %+v", unread)
+	}
+
+	if code := markRead(`{}`, adaToken); code != http.StatusOK {
+		t.Fatalf("marking own inbox returned %d", code)
+	}
+	if unread := h.notifications.GetInbox(ada.ID, true); len(unread) != 0 {
+		t.Fatalf("unread after marking = %+v, want none", unread)
+	}
+}
+
+func TestNotificationsReachOnlyTheUsersTokenHolders(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, adaToken := createTestUser(t, h, "Ada")
+	doc, err := h.documentService.CreateDocument("Doc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A guest claiming ada's ID shares her room but not her inbox
+	owner, guest := newTestClient(h.hub, "owner"), newTestClient(h.hub, "guest")
+	for _, joining := range []struct {
+		client *ws.Client
+		token  string
+	}{{owner, adaToken}, {guest, ""}} {
+		h.processMessage(joining.client, &types.WebSocketMessage{
+			Type:    types.MessageTypeJoin,
+			Payload: types.JoinPayload{User: ada, DocumentID: doc.ID, Token: joining.token},
+		})
+	}
+	eventually(t, "both connections joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 2
+	})
+	drain(t, guest)
+
+	h.pushNotification(h.notifications.NotifyMention(ada.ID, doc.ID, "", &types.User{ID: "bob", Name: "Bob"}, "hi @Ada"))
+	nextMessage(t, owner, types.MessageTypeNotification)
+	if kinds := drain(t, guest); count(kinds, types.MessageTypeNotification) != 0 {
+		t.Fatalf("guest received %v", kinds)
+	}
+}
+
+func TestMentionExcerptsAreCapped(t *testing.T) {
+	long := strings.Repeat("a", 500)
+	tests := []struct {
+		name   string
+		text   string
+		offset int
+		want   string
+	}{
+		{"whole line", "first\nhi @Ada there\nlast", 9, "hi @Ada there"},
+		{"first line", "@Ada\nnext", 0, "@Ada"},
+		{"long line", long + "@Ada" + long, 500, strings.Repeat("a", excerptRadius) + "@Ada" + strings.Repeat("a", excerptRadius-4)},
+		{"multibyte", strings.Repeat("é", 300) + "@Ada", 300, strings.Repeat("é", excerptRadius) + "@Ada"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineAround([]rune(tt.text), tt.offset); got != tt.want {
+				t.Fatalf("lineAround = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
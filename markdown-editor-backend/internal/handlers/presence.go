@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"log"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// joinDocument registers the connection in a document and announces the
+// membership change to the room. A join is only broadcast for the user's
+// first connection; every change refreshes the user list.
+func (h *Handlers) joinDocument(client *ws.Client, user *types.User, documentID string) {
+	// A connection is in one document at a time: leave the previous one
+	// before the hub moves it, so nothing keeps a reference to it there
+	if client.UserID != "" && client.DocumentID != "" {
+		h.cursors.forget(client)
+		h.viewports.forget(client)
+		h.leaveDocument(client)
+	}
+
+	client.UserID = user.ID
+	client.DocumentID = documentID
+
+	// Now register client to hub with proper UserID and DocumentID
+	h.hub.RegisterClient(client)
+
+	if err := h.userService.AddUser(user); err != nil {
+		log.Printf("Error adding user to storage: %v", err)
+		// Continue anyway, user might already exist
+	}
+
+	first, err := h.userService.JoinDocument(client.UserID, client.DocumentID, client.ID)
+	if err != nil {
+		log.Printf("Error joining document: %v", err)
+		return
+	}
+
+	// Other nodes mirror the connection before the room hears of it, so
+	// that the user list and presence they compute include it
+	presence, presenceChanged := h.presence.track(client)
+	h.replicatePresence(client)
+
+	if first {
+		joinMessage := types.WebSocketMessage{
+			Type: types.MessageTypeJoin,
+			Payload: types.JoinPayload{
+				User:       *user,
+				DocumentID: documentID,
+			},
+			UserID: user.ID,
+		}
+		h.hub.BroadcastToDocument(documentID, &joinMessage, client)
+		h.emitUserJoined(documentID, user)
+	}
+
+	h.broadcastUserList(documentID)
+
+	if presenceChanged {
+		h.broadcastPresence(presence)
+	}
+}
+
+// leaveDocument removes the connection from its document. Other tabs of
+// the same user keep them in the room, so leave is only broadcast for
+// the last one.
+func (h *Handlers) leaveDocument(client *ws.Client) {
+	h.stopFollowing(client)
+	h.clearAwareness(client)
+
+	last, err := h.userService.LeaveDocument(client.UserID, client.DocumentID, client.ID)
+	if err != nil {
+		log.Printf("Error leaving document: %v", err)
+	}
+
+	presence, presenceChanged := h.presence.forget(client)
+	h.replicateConnection(types.ConnectionPayload{
+		DocumentID:   client.DocumentID,
+		ConnectionID: client.ID,
+		User:         types.User{ID: client.UserID},
+		Left:         true,
+	})
+
+	if last {
+		h.userService.RemoveCursor(client.DocumentID, client.UserID)
+		h.hub.ReleaseFollowers(client.DocumentID, client.UserID)
+
+		leaveMessage := types.WebSocketMessage{
+			Type: types.MessageTypeLeave,
+			Payload: types.LeavePayload{
+				UserID: client.UserID,
+			},
+		}
+		h.hub.BroadcastToDocument(client.DocumentID, &leaveMessage, client)
+	}
+
+	h.broadcastUserList(client.DocumentID)
+
+	if presenceChanged {
+		h.broadcastPresence(presence)
+	}
+}
+
+// syncRemoteLeave drops what this node keeps of a user who left the
+// document on another node
+func (h *Handlers) syncRemoteLeave(documentID string, payload *types.LeavePayload) {
+	h.userService.RemoveCursor(documentID, payload.UserID)
+	h.hub.ReleaseFollowers(documentID, payload.UserID)
+}
+
+// replicateConnection sends a connection's membership to the other nodes,
+// with the details of its user so they can list them
+func (h *Handlers) replicateConnection(state types.ConnectionPayload) {
+	if user, err := h.userService.GetUser(state.User.ID); err == nil {
+		state.User = *user
+	}
+
+	connectionMessage := types.WebSocketMessage{
+		Type:    types.MessageTypeConnection,
+		Payload: state,
+	}
+	h.hub.Replicate(state.DocumentID, &connectionMessage)
+}
+
+// syncRemoteConnection mirrors the membership and presence of a
+// connection on another node
+func (h *Handlers) syncRemoteConnection(documentID string, payload *types.ConnectionPayload) {
+	if payload.Left {
+		if _, err := h.userService.LeaveDocument(payload.User.ID, documentID, payload.ConnectionID); err != nil {
+			log.Printf("Error removing remote connection %s: %v", payload.ConnectionID, err)
+		}
+	} else {
+		user := payload.User
+		user.Connections = 0
+		if err := h.userService.AddUser(&user); err != nil {
+			log.Printf("Error adding remote user %s: %v", user.ID, err)
+		}
+		if _, err := h.userService.JoinDocument(user.ID, documentID, payload.ConnectionID); err != nil {
+			log.Printf("Error adding remote connection %s: %v", payload.ConnectionID, err)
+		}
+	}
+	h.presence.syncRemote(*payload)
+}
+
+// broadcastUserList sends the full member list to everyone in the room
+func (h *Handlers) broadcastUserList(documentID string) {
+	userListMessage := types.WebSocketMessage{
+		Type: types.MessageTypeUserList,
+		Payload: types.UserListPayload{
+			Users: h.documentUsers(documentID),
+		},
+	}
+	h.hub.BroadcastToDocument(documentID, &userListMessage, nil)
+}
+
+// documentUsers returns the members of a document with their connection
+// counts
+func (h *Handlers) documentUsers(documentID string) []types.User {
+	users, err := h.userService.GetDocumentUsers(documentID)
+	if err != nil {
+		log.Printf("Error getting document users: %v", err)
+		return []types.User{}
+	}
+
+	result := make([]types.User, len(users))
+	for i, user := range users {
+		result[i] = *user
+	}
+	return result
+}
+
+// documentCursors returns the stored cursors and selections of the given
+// users, each with its owner's color
+func (h *Handlers) documentCursors(documentID string, users []types.User) []types.CursorPosition {
+	cursors, err := h.userService.GetCursors(documentID)
+	if err != nil {
+		log.Printf("Error getting document cursors: %v", err)
+		return []types.CursorPosition{}
+	}
+
+	colors := make(map[string]string, len(users))
+	for _, user := range users {
+		colors[user.ID] = user.Color
+	}
+
+	result := make([]types.CursorPosition, 0, len(cursors))
+	for _, cursor := range cursors {
+		color, present := colors[cursor.UserID]
+		if !present {
+			continue
+		}
+		cursor.Color = color
+		result = append(result, *cursor)
+	}
+	return result
+}
+
+// documentSyncPayload builds the full state sent to a joining client and
+// returned by GET /api/documents/get
+func (h *Handlers) documentSyncPayload(doc *types.Document) types.DocumentSyncPayload {
+	users := h.documentUsers(doc.ID)
+	return types.DocumentSyncPayload{
+		Document:    *doc,
+		Users:       users,
+		Cursors:     h.documentCursors(doc.ID, users),
+		Presence:    h.presence.documentPresence(doc.ID),
+		Awareness:   h.awarenessStates.document(doc.ID),
+		Comments:    h.documentComments(doc.ID),
+		Suggestions: h.documentSuggestions(doc.ID),
+		Chat:        h.documentChat(doc.ID),
+	}
+}
+
+// sendDocumentSync sends the full document state to one client
+func (h *Handlers) sendDocumentSync(client *ws.Client, doc *types.Document) {
+	syncMessage := types.WebSocketMessage{
+		Type:    types.MessageTypeDocumentSync,
+		Payload: h.documentSyncPayload(doc),
+	}
+
+	if err := client.SendMessage(&syncMessage); err != nil {
+		log.Printf("Error marshaling document sync: %v", err)
+		return
+	}
+	log.Printf("Document sync sent to user %s", client.UserID)
+}
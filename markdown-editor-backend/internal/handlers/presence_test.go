@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// drain returns the types of the messages queued for a client
+func drain(t *testing.T, client *ws.Client) []string {
+	t.Helper()
+	var kinds []string
+	for len(client.Send) > 0 {
+		var message types.WebSocketMessage
+		if err := json.Unmarshal(<-client.Send, &message); err != nil {
+			t.Fatalf("invalid message: %v", err)
+		}
+		kinds = append(kinds, message.Type)
+	}
+	return kinds
+}
+
+func count(kinds []string, kind string) int {
+	n := 0
+	for _, k := range kinds {
+		if k == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func userIDs(users []types.User) map[string]bool {
+	ids := make(map[string]bool, len(users))
+	for _, user := range users {
+		ids[user.ID] = true
+	}
+	return ids
+}
+
+func TestJoinAndLeaveAreOnlyBroadcastForTheFirstAndLastTab(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, _ := h.documentService.CreateDocument("Notes", "")
+
+	ada := types.User{ID: "user-1", Name: "Ada", Color: types.UserColors[0]}
+	grace := types.User{ID: "user-2", Name: "Grace", Color: types.UserColors[1]}
+	watcher := newTestClient(h.hub, grace.ID)
+	h.joinDocument(watcher, &grace, doc.ID)
+	eventually(t, "the watcher joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 1
+	})
+	drain(t, watcher)
+
+	firstTab := &ws.Client{ID: "tab-1", Hub: h.hub, Send: make(chan []byte, 64)}
+	secondTab := &ws.Client{ID: "tab-2", Hub: h.hub, Send: make(chan []byte, 64)}
+	tests := []struct {
+		name      string
+		apply     func()
+		wantJoin  int
+		wantLeave int
+		wantUsers int
+	}{
+		{"first tab joins", func() { h.joinDocument(firstTab, &ada, doc.ID) }, 1, 0, 2},
+		{"second tab joins", func() { h.joinDocument(secondTab, &ada, doc.ID) }, 0, 0, 2},
+		{"first tab leaves", func() { h.leaveDocument(firstTab) }, 0, 0, 2},
+		{"second tab leaves", func() { h.leaveDocument(secondTab) }, 0, 1, 1},
+	}
+	for _, tt := range tests {
+		tt.apply()
+		kinds := drain(t, watcher)
+		if got := count(kinds, types.MessageTypeJoin); got != tt.wantJoin {
+			t.Errorf("%s: watcher got %d joins, want %d", tt.name, got, tt.wantJoin)
+		}
+		if got := count(kinds, types.MessageTypeLeave); got != tt.wantLeave {
+			t.Errorf("%s: watcher got %d leaves, want %d", tt.name, got, tt.wantLeave)
+		}
+		if count(kinds, types.MessageTypeUserList) != 1 {
+			t.Errorf("%s: watcher got %v, want one user list", tt.name, kinds)
+		}
+		if users := h.documentUsers(doc.ID); len(users) != tt.wantUsers {
+			t.Errorf("%s: document lists %v, want %d users", tt.name, users, tt.wantUsers)
+		}
+	}
+}
+
+func TestJoiningAnotherDocumentLeavesThePreviousOne(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	first, _ := h.documentService.CreateDocument("First", "")
+	second, _ := h.documentService.CreateDocument("Second", "")
+
+	user := types.User{ID: "user-1", Name: "Ada", Color: types.UserColors[0]}
+	client := newTestClient(h.hub, user.ID)
+	h.joinDocument(client, &user, first.ID)
+	h.joinDocument(client, &user, second.ID)
+
+	eventually(t, "the connection moved to the second document", func() bool {
+		return len(h.hub.GetDocumentClients(first.ID)) == 0 && len(h.hub.GetDocumentClients(second.ID)) == 1
+	})
+	if users := h.documentUsers(first.ID); len(users) != 0 {
+		t.Fatalf("first document still lists %v", users)
+	}
+	if users := h.documentUsers(second.ID); len(users) != 1 || users[0].ID != user.ID {
+		t.Fatalf("second document lists %v, want only %s", users, user.ID)
+	}
+
+	// Broadcasting to the old room after the connection closed used to
+	// send on its closed channel
+	h.hub.UnregisterClient(client)
+	h.hub.BroadcastToDocument(first.ID, &types.WebSocketMessage{Type: types.MessageTypeUserList}, nil)
+	h.hub.BroadcastToDocument(second.ID, &types.WebSocketMessage{Type: types.MessageTypeUserList}, nil)
+}
+
+func TestMembershipIsMergedAcrossNodes(t *testing.T) {
+	nodeA, nodeB := newTestCluster(t)
+	doc, err := nodeA.handlers.documentService.CreateDocument("Shared", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeA.handlers.documentCreated(doc)
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	bob := types.User{ID: "bob", Name: "Bob", Color: types.UserColors[1]}
+	adaOnA := newTestClient(nodeA.hub, ada.ID)
+	bobOnB := newTestClient(nodeB.hub, bob.ID)
+	nodeA.handlers.joinDocument(adaOnA, &ada, doc.ID)
+	nodeB.handlers.joinDocument(bobOnB, &bob, doc.ID)
+
+	for name, node := range map[string]*testNode{"node-a": nodeA, "node-b": nodeB} {
+		eventually(t, name+" lists both users", func() bool {
+			ids := userIDs(node.handlers.documentUsers(doc.ID))
+			return len(ids) == 2 && ids["ada"] && ids["bob"]
+		})
+	}
+
+	// Ada also opens a tab on node-b, so closing the one on node-a keeps
+	// her in the room
+	adaOnB := &ws.Client{ID: "ada-conn-2", Hub: nodeB.hub, Send: make(chan []byte, 64)}
+	nodeB.handlers.joinDocument(adaOnB, &ada, doc.ID)
+	eventually(t, "node-a counts both of ada's tabs", func() bool {
+		for _, user := range nodeA.handlers.documentUsers(doc.ID) {
+			if user.ID == "ada" {
+				return user.Connections == 2
+			}
+		}
+		return false
+	})
+
+	nodeA.handlers.leaveDocument(adaOnA)
+	nodeB.handlers.leaveDocument(bobOnB)
+	for name, node := range map[string]*testNode{"node-a": nodeA, "node-b": nodeB} {
+		eventually(t, name+" lists only ada", func() bool {
+			ids := userIDs(node.handlers.documentUsers(doc.ID))
+			return len(ids) == 1 && ids["ada"]
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// logPanic logs a recovered panic value with its stack and context
+func logPanic(recovered interface{}, format string, args ...interface{}) {
+	log.Printf("PANIC %s: %v\n%s", fmt.Sprintf(format, args...), recovered, debug.Stack())
+}
+
+// Recover keeps a panicking HTTP handler from taking the server down and
+// answers 500 instead
+func (h *Handlers) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				// net/http uses this to abort a response on purpose
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				logPanic(recovered, "serving %s %s for %s", r.Method, r.URL.Path, h.clientIP(r))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// dispatchMessage processes one message, isolating a panic to that
+// message so the connection and the server stay up
+func (h *Handlers) dispatchMessage(client *ws.Client, message *types.WebSocketMessage) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logPanic(recovered, "handling %s message from client %s (user %s, document %s, %s)",
+				message.Type, client.ID, client.UserID, client.DocumentID, client.RemoteAddr)
+			h.sendError(client, "Internal error while processing message", "INTERNAL_ERROR")
+		}
+	}()
+
+	h.processMessage(client, message)
+}
+
+// recoverCallback wraps a hub callback that runs on a broker goroutine
+func recoverCallback(name string, callback func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logPanic(recovered, "in %s", name)
+		}
+	}()
+
+	callback()
+}
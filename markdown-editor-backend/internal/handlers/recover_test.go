@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestRecoverAnswersInternalServerError(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	handler := h.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("status after a panic = %d, want 204", recorder.Code)
+	}
+}
+
+func TestRecoverRepanicsOnAbort(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	handler := h.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler", recovered)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	t.Fatal("abort was swallowed")
+}
+
+func TestDispatchMessageIsolatesPanics(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	client := newTestClient(h.hub, "ada")
+	join := &types.WebSocketMessage{
+		Type: types.MessageTypeJoin,
+		Payload: types.JoinPayload{
+			User:       types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]},
+			DocumentID: "doc",
+		},
+	}
+
+	// Without a document service the join handler panics
+	documentService := h.documentService
+	h.documentService = nil
+	h.dispatchMessage(client, join)
+	h.documentService = documentService
+
+	message := nextMessage(t, client, types.MessageTypeError)
+	var reply types.ErrorPayload
+	if err := message.DecodePayload(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Code != "INTERNAL_ERROR" {
+		t.Fatalf("error code = %q, want INTERNAL_ERROR", reply.Code)
+	}
+
+	// The same client keeps working
+	h.dispatchMessage(client, join)
+	nextMessage(t, client, types.MessageTypeDocumentSync)
+}
+
+func TestPanicsAfterDroppingASlowClient(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Busy", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := &ws.Client{ID: "slow-conn", Hub: h.hub, Send: make(chan []byte, 1), UserID: "slow", DocumentID: doc.ID}
+	h.hub.RegisterClient(slow)
+	eventually(t, "the client is registered", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 1
+	})
+
+	// The queue is never read, so the hub drops the client and closes it
+	for i := 0; i < 3; i++ {
+		h.hub.BroadcastToDocument(doc.ID, &types.WebSocketMessage{Type: types.MessageTypeCursor}, nil)
+	}
+	eventually(t, "the slow client is dropped", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 0
+	})
+
+	// The error reply for a panicking handler must not send on the closed
+	// queue
+	documentService := h.documentService
+	h.documentService = nil
+	h.dispatchMessage(slow, &types.WebSocketMessage{
+		Type:    types.MessageTypeJoin,
+		Payload: types.JoinPayload{User: types.User{ID: "slow", Name: "Slow", Color: types.UserColors[0]}, DocumentID: doc.ID},
+	})
+	h.documentService = documentService
+
+	if err := slow.SendMessage(&types.WebSocketMessage{Type: types.MessageTypeCursor}); err != ws.ErrClientClosed {
+		t.Fatalf("SendMessage after unregister: err = %v, want ErrClientClosed", err)
+	}
+}
+
+func TestRecoverCallback(t *testing.T) {
+	ran := false
+	recoverCallback("test callback", func() {
+		ran = true
+		panic("callback failed")
+	})
+	if !ran {
+		t.Fatal("callback did not run")
+	}
+}
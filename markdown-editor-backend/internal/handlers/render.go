@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"markdown-editor-backend/internal/storage"
+)
+
+// RenderDocument handles GET /api/documents/{id}/render, returning the
+// content as sanitized HTML. The ETag is the document version, so clients
+// can revalidate with If-None-Match.
+func (h *Handlers) RenderDocument(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Edits may land while rendering, work on one version
+	doc, err := h.documentService.GetDocumentCopy(documentID)
+	if err != nil {
+		if err == storage.ErrDocumentNotFound {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	version := doc.Version
+
+	etag := fmt.Sprintf(`"%s-%d"`, doc.ID, version)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	html, err := h.renderer.RenderDocument(&doc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write([]byte(html))
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestRenderEndpoint(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Rendered", "# Title\n\n**bold**\n\n<script>alert(1)</script>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	render := func(method, documentID, etag string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "/api/documents/"+documentID+"/render", nil)
+		if etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		recorder := httptest.NewRecorder()
+		h.DocumentRoutes(recorder, request)
+		return recorder
+	}
+
+	recorder := render(http.MethodGet, doc.ID, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, "<h1") || !strings.Contains(body, "<strong>bold</strong>") || strings.Contains(body, "<script") {
+		t.Fatalf("rendered %q", body)
+	}
+
+	// The ETag names the version, so an unchanged document is not sent again
+	etag := recorder.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if recorder := render(http.MethodGet, doc.ID, etag); recorder.Code != http.StatusNotModified {
+		t.Fatalf("revalidating: %d, want 304", recorder.Code)
+	}
+
+	if recorder := render(http.MethodGet, "missing", ""); recorder.Code != http.StatusNotFound {
+		t.Fatalf("missing document: %d, want 404", recorder.Code)
+	}
+	if recorder := render(http.MethodPost, doc.ID, ""); recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: %d, want 405", recorder.Code)
+	}
+}
+
+func TestRenderAndExportWhileEditing(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Busy", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			op := &types.Operation{Type: "insert", Position: 0, Content: "x", UserID: "ada"}
+			if _, err := h.documentService.ApplyOperation(doc.ID, op); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		recorder := httptest.NewRecorder()
+		h.RenderDocument(recorder, httptest.NewRequest(http.MethodGet, "/api/documents/"+doc.ID+"/render", nil), doc.ID)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("render returned %d", recorder.Code)
+		}
+
+		// The ETag names the version whose content was rendered
+		var version int
+		fmt.Sscanf(strings.TrimPrefix(recorder.Header().Get("ETag"), `"`+doc.ID+"-"), "%d", &version)
+		if got := strings.Count(recorder.Body.String(), "x"); version > 1 && got != version-1 {
+			t.Fatalf("version %d rendered %d characters", version, got)
+		}
+
+		if recorder := exportDocument(h, doc.ID, ""); recorder.Code != http.StatusOK {
+			t.Fatalf("export returned %d", recorder.Code)
+		}
+	}
+	wg.Wait()
+
+	stored, _ := h.documentService.GetDocument(doc.ID)
+	if stored.Version != 201 {
+		t.Fatalf("version after 200 operations = %d, want 201", stored.Version)
+	}
+}
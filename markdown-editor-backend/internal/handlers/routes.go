@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// documentRoutePrefix is where per-document resources are served
+const documentRoutePrefix = "/api/documents/"
+
+// DocumentRoutes dispatches /api/documents/{id} and
+// /api/documents/{id}/{resource} requests
+func (h *Handlers) DocumentRoutes(w http.ResponseWriter, r *http.Request) {
+	documentID, resource, ok := parseDocumentPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// One budget per resource, however many documents are asked for
+	if !h.allowRequest(w, r, documentRoutePrefix+"{id}/"+resource) {
+		return
+	}
+
+	switch resource {
+	case "":
+		h.DeleteDocument(w, r, documentID)
+	case "comments":
+		h.ListComments(w, r, documentID)
+	case "blame":
+		h.GetBlame(w, r, documentID)
+	case "chat":
+		h.ListChat(w, r, documentID)
+	case "render":
+		h.RenderDocument(w, r, documentID)
+	case "export":
+		h.ExportDocument(w, r, documentID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// parseDocumentPath splits /api/documents/{id}/{resource}, with an empty
+// resource for the document itself
+func parseDocumentPath(path string) (documentID, resource string, ok bool) {
+	rest := strings.TrimPrefix(path, documentRoutePrefix)
+	if rest == path {
+		return "", "", false
+	}
+
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	if parts[0] == "" {
+		return "", "", false
+	}
+	switch len(parts) {
+	case 1:
+		return parts[0], "", true
+	case 2:
+		if parts[1] == "" {
+			return "", "", false
+		}
+		return parts[0], parts[1], true
+	}
+	return "", "", false
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// presenceSweepInterval is how often connections are checked for idleness
+const presenceSweepInterval = time.Second
+
+// presenceRank orders statuses so a user with several tabs shows the most
+// present one
+var presenceRank = map[string]int{
+	types.PresenceAway:   0,
+	types.PresenceIdle:   1,
+	types.PresenceActive: 2,
+}
+
+// presenceTracker keeps the presence of each connection and derives one
+// status per user and document from them. Connections on other nodes of a
+// cluster are mirrored with the status their node last reported, so that
+// every node derives the same one.
+type presenceTracker struct {
+	idleAfter   time.Duration
+	connections map[string]*connectionPresence                       // clientID -> state
+	users       map[string]map[string]map[string]*connectionPresence // documentID -> userID -> clientID -> state
+	reported    map[string]map[string]string                         // documentID -> userID -> last broadcast status
+	mutex       sync.Mutex
+}
+
+type connectionPresence struct {
+	id         string
+	documentID string
+	userID     string
+	hidden     bool
+	lastActive time.Time
+	// remote connections report their status through their node; for
+	// local ones shared is the status last replicated to the cluster
+	remote bool
+	shared string
+}
+
+func (c *connectionPresence) status(now time.Time, idleAfter time.Duration) string {
+	if c.remote {
+		return c.shared
+	}
+	if c.hidden {
+		return types.PresenceAway
+	}
+	if now.Sub(c.lastActive) >= idleAfter {
+		return types.PresenceIdle
+	}
+	return types.PresenceActive
+}
+
+func newPresenceTracker(idleAfter time.Duration) *presenceTracker {
+	return &presenceTracker{
+		idleAfter:   idleAfter,
+		connections: make(map[string]*connectionPresence),
+		users:       make(map[string]map[string]map[string]*connectionPresence),
+		reported:    make(map[string]map[string]string),
+	}
+}
+
+// track starts tracking a connection that just joined a document
+func (pt *presenceTracker) track(client *ws.Client) (types.PresencePayload, bool) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	now := time.Now()
+	pt.add(&connectionPresence{
+		id:         client.ID,
+		documentID: client.DocumentID,
+		userID:     client.UserID,
+		lastActive: now,
+	})
+	return pt.update(client.DocumentID, client.UserID, now)
+}
+
+// touch records activity on a connection
+func (pt *presenceTracker) touch(client *ws.Client) (types.PresencePayload, bool) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	conn, exists := pt.connections[client.ID]
+	if !exists {
+		return types.PresencePayload{}, false
+	}
+
+	now := time.Now()
+	wasActive := conn.status(now, pt.idleAfter) == types.PresenceActive
+	conn.lastActive = now
+	if wasActive {
+		return types.PresencePayload{}, false
+	}
+	return pt.update(conn.documentID, conn.userID, now)
+}
+
+// setHidden records the connection's tab being hidden or shown again.
+// Showing a tab counts as activity.
+func (pt *presenceTracker) setHidden(client *ws.Client, hidden bool) (types.PresencePayload, bool) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	conn, exists := pt.connections[client.ID]
+	if !exists {
+		return types.PresencePayload{}, false
+	}
+
+	now := time.Now()
+	conn.hidden = hidden
+	if !hidden {
+		conn.lastActive = now
+	}
+	return pt.update(conn.documentID, conn.userID, now)
+}
+
+// forget stops tracking a connection. A change is only reported while the
+// user still has other connections; otherwise the leave message covers it.
+func (pt *presenceTracker) forget(client *ws.Client) (types.PresencePayload, bool) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	conn, exists := pt.connections[client.ID]
+	if !exists {
+		return types.PresencePayload{}, false
+	}
+
+	pt.remove(conn)
+	return pt.update(conn.documentID, conn.userID, time.Now())
+}
+
+// sweep returns the users whose status changed because they went idle,
+// and the local connections whose new status must be replicated
+func (pt *presenceTracker) sweep() ([]types.PresencePayload, []types.ConnectionPayload) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	now := time.Now()
+	var changes []types.PresencePayload
+	var states []types.ConnectionPayload
+	for documentID, users := range pt.users {
+		for userID, conns := range users {
+			for _, conn := range conns {
+				if state, changed := pt.share(conn, now); changed {
+					states = append(states, state)
+				}
+			}
+			if presence, changed := pt.update(documentID, userID, now); changed {
+				changes = append(changes, presence)
+			}
+		}
+	}
+	return changes, states
+}
+
+// connectionState returns the state of a local connection to replicate,
+// reporting false when its status is the one the cluster already has
+func (pt *presenceTracker) connectionState(clientID string) (types.ConnectionPayload, bool) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	conn, exists := pt.connections[clientID]
+	if !exists {
+		return types.ConnectionPayload{}, false
+	}
+	return pt.share(conn, time.Now())
+}
+
+// syncRemote mirrors a connection of another node, without reporting the
+// resulting change: the node it lives on broadcasts it
+func (pt *presenceTracker) syncRemote(state types.ConnectionPayload) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	conn, exists := pt.connections[state.ConnectionID]
+	if exists && !conn.remote {
+		return
+	}
+
+	if state.Left {
+		if exists {
+			pt.remove(conn)
+		}
+	} else {
+		if !exists {
+			conn = &connectionPresence{
+				id:         state.ConnectionID,
+				documentID: state.DocumentID,
+				userID:     state.User.ID,
+				remote:     true,
+			}
+			pt.add(conn)
+		}
+		conn.shared = state.Status
+		conn.lastActive = state.LastActive
+	}
+	pt.update(state.DocumentID, state.User.ID, time.Now())
+}
+
+// documentPresence returns the current status of every user in a document
+func (pt *presenceTracker) documentPresence(documentID string) []types.PresencePayload {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	now := time.Now()
+	presence := []types.PresencePayload{}
+	for userID := range pt.reported[documentID] {
+		if current, exists := pt.userPresence(documentID, userID, now); exists {
+			presence = append(presence, current)
+		}
+	}
+	return presence
+}
+
+// add indexes a connection. Must be called with the lock held.
+func (pt *presenceTracker) add(conn *connectionPresence) {
+	pt.connections[conn.id] = conn
+
+	users, exists := pt.users[conn.documentID]
+	if !exists {
+		users = make(map[string]map[string]*connectionPresence)
+		pt.users[conn.documentID] = users
+	}
+	if _, exists := users[conn.userID]; !exists {
+		users[conn.userID] = make(map[string]*connectionPresence)
+	}
+	users[conn.userID][conn.id] = conn
+}
+
+// remove drops a connection from the indexes. Must be called with the lock
+// held.
+func (pt *presenceTracker) remove(conn *connectionPresence) {
+	delete(pt.connections, conn.id)
+
+	users := pt.users[conn.documentID]
+	delete(users[conn.userID], conn.id)
+	if len(users[conn.userID]) == 0 {
+		delete(users, conn.userID)
+	}
+	if len(users) == 0 {
+		delete(pt.users, conn.documentID)
+	}
+}
+
+// share records the status of a local connection as replicated and
+// reports whether it changed. Must be called with the lock held.
+func (pt *presenceTracker) share(conn *connectionPresence, now time.Time) (types.ConnectionPayload, bool) {
+	status := conn.status(now, pt.idleAfter)
+	if conn.remote || conn.shared == status {
+		return types.ConnectionPayload{}, false
+	}
+
+	conn.shared = status
+	return types.ConnectionPayload{
+		DocumentID:   conn.documentID,
+		ConnectionID: conn.id,
+		User:         types.User{ID: conn.userID},
+		Status:       status,
+		LastActive:   conn.lastActive,
+	}, true
+}
+
+// update recomputes a user's status in a document and reports whether it
+// differs from the last one broadcast. Must be called with the lock held.
+func (pt *presenceTracker) update(documentID, userID string, now time.Time) (types.PresencePayload, bool) {
+	users := pt.reported[documentID]
+
+	current, exists := pt.userPresence(documentID, userID, now)
+	if !exists {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(pt.reported, documentID)
+		}
+		return types.PresencePayload{}, false
+	}
+
+	if users == nil {
+		users = make(map[string]string)
+		pt.reported[documentID] = users
+	}
+	if users[userID] == current.Status {
+		return types.PresencePayload{}, false
+	}
+	users[userID] = current.Status
+	return current, true
+}
+
+// userPresence combines the user's connections in a document. Must be
+// called with the lock held.
+func (pt *presenceTracker) userPresence(documentID, userID string, now time.Time) (types.PresencePayload, bool) {
+	presence := types.PresencePayload{DocumentID: documentID, UserID: userID}
+	exists := false
+	for _, conn := range pt.users[documentID][userID] {
+		status := conn.status(now, pt.idleAfter)
+		if !exists || presenceRank[status] > presenceRank[presence.Status] {
+			presence.Status = status
+		}
+		if conn.lastActive.After(presence.LastActive) {
+			presence.LastActive = conn.lastActive
+		}
+		exists = true
+	}
+	return presence, exists
+}
+
+// runPresenceSweep periodically broadcasts users that went idle
+func (h *Handlers) runPresenceSweep() {
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		recoverCallback("presence sweep", func() {
+			changes, states := h.presence.sweep()
+			for _, state := range states {
+				h.replicateConnection(state)
+			}
+			for _, presence := range changes {
+				h.broadcastPresence(presence)
+			}
+		})
+	}
+}
+
+// touchPresence records activity from a client, broadcasting if it brings
+// the user back from idle or away
+func (h *Handlers) touchPresence(client *ws.Client) {
+	presence, changed := h.presence.touch(client)
+	if !changed {
+		return
+	}
+	h.replicatePresence(client)
+	h.broadcastPresence(presence)
+}
+
+// replicatePresence sends the state of a local connection to the other
+// nodes when its status changed since they last heard of it
+func (h *Handlers) replicatePresence(client *ws.Client) {
+	if state, changed := h.presence.connectionState(client.ID); changed {
+		h.replicateConnection(state)
+	}
+}
+
+// broadcastPresence sends a user's status to everyone in the document
+func (h *Handlers) broadcastPresence(presence types.PresencePayload) {
+	presenceMessage := types.WebSocketMessage{
+		Type:    types.MessageTypePresence,
+		Payload: presence,
+		UserID:  presence.UserID,
+	}
+	h.hub.BroadcastToDocument(presence.DocumentID, &presenceMessage, nil)
+}
+
+// handlePresenceMessage records a client reporting its tab as visible
+// (active) or hidden (away)
+func (h *Handlers) handlePresenceMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.PresencePayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+
+	presence, changed := h.presence.setHidden(client, payload.Status == types.PresenceAway)
+	h.replicatePresence(client)
+	if !changed {
+		return
+	}
+
+	log.Printf("User %s is now %s in document %s", client.UserID, presence.Status, presence.DocumentID)
+	h.broadcastPresence(presence)
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// statusOf returns the presence a node reports for a user, "" if none
+func statusOf(h *Handlers, documentID, userID string) string {
+	for _, presence := range h.presence.documentPresence(documentID) {
+		if presence.UserID == userID {
+			return presence.Status
+		}
+	}
+	return ""
+}
+
+func TestPresenceShowsTheMostPresentTab(t *testing.T) {
+	pt := newPresenceTracker(time.Hour)
+	first := &ws.Client{ID: "first", DocumentID: "doc", UserID: "ada"}
+	second := &ws.Client{ID: "second", DocumentID: "doc", UserID: "ada"}
+	pt.track(first)
+	if _, changed := pt.track(second); changed {
+		t.Fatal("a second active tab changed the user's status")
+	}
+
+	status := func() string {
+		for _, presence := range pt.documentPresence("doc") {
+			if presence.UserID == "ada" {
+				return presence.Status
+			}
+		}
+		return ""
+	}
+
+	steps := []struct {
+		name    string
+		apply   func() (types.PresencePayload, bool)
+		changed bool
+		status  string
+	}{
+		{"one tab hidden", func() (types.PresencePayload, bool) { return pt.setHidden(first, true) }, false, types.PresenceActive},
+		{"both tabs hidden", func() (types.PresencePayload, bool) { return pt.setHidden(second, true) }, true, types.PresenceAway},
+		{"a tab shown again", func() (types.PresencePayload, bool) { return pt.setHidden(first, false) }, true, types.PresenceActive},
+		{"the shown tab closed", func() (types.PresencePayload, bool) { return pt.forget(first) }, true, types.PresenceAway},
+	}
+	for _, step := range steps {
+		presence, changed := step.apply()
+		if changed != step.changed {
+			t.Fatalf("%s: changed = %v, want %v", step.name, changed, step.changed)
+		}
+		if got := status(); got != step.status {
+			t.Fatalf("%s: status = %q, want %q", step.name, got, step.status)
+		}
+		if changed && (presence.Status != step.status || presence.UserID != "ada") {
+			t.Fatalf("%s: reported %+v", step.name, presence)
+		}
+	}
+}
+
+func TestActivityEndsIdleness(t *testing.T) {
+	pt := newPresenceTracker(10 * time.Millisecond)
+	client := &ws.Client{ID: "conn", DocumentID: "doc", UserID: "ada"}
+	pt.track(client)
+
+	if _, changed := pt.touch(client); changed {
+		t.Fatal("activity on an active connection reported a change")
+	}
+	time.Sleep(20 * time.Millisecond)
+	pt.sweep()
+
+	presence, changed := pt.touch(client)
+	if !changed || presence.Status != types.PresenceActive {
+		t.Fatalf("touch after idling = %+v, %v; want active", presence, changed)
+	}
+}
+
+func TestPresenceSweepReportsIdleConnections(t *testing.T) {
+	pt := newPresenceTracker(10 * time.Millisecond)
+	client := &ws.Client{ID: "conn", DocumentID: "doc", UserID: "ada"}
+	if presence, changed := pt.track(client); !changed || presence.Status != types.PresenceActive {
+		t.Fatalf("track = %+v, %v; want active", presence, changed)
+	}
+	if _, changed := pt.connectionState(client.ID); !changed {
+		t.Fatal("a new connection must be replicated")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	changes, states := pt.sweep()
+	if len(changes) != 1 || changes[0].Status != types.PresenceIdle {
+		t.Fatalf("sweep changes = %+v, want ada idle", changes)
+	}
+	if len(states) != 1 || states[0].ConnectionID != "conn" || states[0].Status != types.PresenceIdle {
+		t.Fatalf("sweep states = %+v, want conn idle", states)
+	}
+
+	if changes, states := pt.sweep(); len(changes) != 0 || len(states) != 0 {
+		t.Fatalf("second sweep = %+v, %+v; want no change", changes, states)
+	}
+
+	pt.forget(client)
+	if len(pt.connections) != 0 || len(pt.users) != 0 || len(pt.reported) != 0 {
+		t.Fatalf("forgotten connection left state behind: %v %v %v", pt.connections, pt.users, pt.reported)
+	}
+}
+
+func TestPresenceMessagesAreBroadcastAndSynced(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	doc, err := h.documentService.CreateDocument("Presence", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	bob := types.User{ID: "bob", Name: "Bob", Color: types.UserColors[1]}
+	adaClient, bobClient := newTestClient(h.hub, ada.ID), newTestClient(h.hub, bob.ID)
+	h.joinDocument(adaClient, &ada, doc.ID)
+	h.joinDocument(bobClient, &bob, doc.ID)
+	eventually(t, "both users joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 2
+	})
+	drain(t, bobClient)
+
+	h.processMessage(adaClient, &types.WebSocketMessage{
+		Type:    types.MessageTypePresence,
+		Payload: types.PresencePayload{DocumentID: doc.ID, Status: types.PresenceAway},
+	})
+	message := nextMessage(t, bobClient, types.MessageTypePresence)
+	var presence types.PresencePayload
+	if err := message.DecodePayload(&presence); err != nil {
+		t.Fatal(err)
+	}
+	if presence.UserID != "ada" || presence.Status != types.PresenceAway {
+		t.Fatalf("broadcast %+v, want ada away", presence)
+	}
+
+	statuses := make(map[string]string)
+	for _, presence := range h.documentSyncPayload(doc).Presence {
+		statuses[presence.UserID] = presence.Status
+	}
+	if statuses["ada"] != types.PresenceAway || statuses["bob"] != types.PresenceActive {
+		t.Fatalf("sync presence = %v", statuses)
+	}
+}
+
+func TestPresenceIsMergedAcrossNodes(t *testing.T) {
+	nodeA, nodeB := newTestCluster(t)
+	doc, err := nodeA.handlers.documentService.CreateDocument("Shared", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeA.handlers.documentCreated(doc)
+
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+	adaOnA := newTestClient(nodeA.hub, ada.ID)
+	nodeA.handlers.joinDocument(adaOnA, &ada, doc.ID)
+
+	// Ada also opens a hidden tab on node-b: her visible tab on node-a
+	// keeps her active everywhere
+	adaOnB := &ws.Client{ID: "ada-conn-2", Hub: nodeB.hub, Send: make(chan []byte, 64)}
+	nodeB.handlers.joinDocument(adaOnB, &ada, doc.ID)
+	nodeB.handlers.presence.setHidden(adaOnB, true)
+	nodeB.handlers.replicatePresence(adaOnB)
+	for name, node := range map[string]*testNode{"node-a": nodeA, "node-b": nodeB} {
+		eventually(t, name+" shows ada active", func() bool {
+			return statusOf(node.handlers, doc.ID, "ada") == types.PresenceActive
+		})
+	}
+
+	nodeA.handlers.presence.setHidden(adaOnA, true)
+	nodeA.handlers.replicatePresence(adaOnA)
+	for name, node := range map[string]*testNode{"node-a": nodeA, "node-b": nodeB} {
+		eventually(t, name+" shows ada away", func() bool {
+			return statusOf(node.handlers, doc.ID, "ada") == types.PresenceAway
+		})
+	}
+
+	// Closing the tab on node-b leaves only node-a's connection to count
+	nodeB.handlers.leaveDocument(adaOnB)
+	eventually(t, "node-a forgot the remote connection", func() bool {
+		nodeA.handlers.presence.mutex.Lock()
+		defer nodeA.handlers.presence.mutex.Unlock()
+		return len(nodeA.handlers.presence.connections) == 1
+	})
+}
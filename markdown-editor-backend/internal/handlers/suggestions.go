@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"markdown-editor-backend/internal/models"
+	"markdown-editor-backend/internal/storage"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// suggestOperation adds an operation to a pending suggestion and shows the
+// updated suggestion to the room
+func (h *Handlers) suggestOperation(client *ws.Client, payload *types.OperationPayload) {
+	author, ok := h.joinedUser(client, "suggesting")
+	if !ok {
+		return
+	}
+
+	suggestion, err := h.suggestions.AddOperation(payload.DocumentID, payload.SuggestionID, author, payload.Operation)
+	if err != nil {
+		h.sendSuggestionError(client, err)
+		return
+	}
+
+	h.broadcastSuggestion(client.UserID, suggestion)
+}
+
+func (h *Handlers) handleSuggestionAcceptMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.SuggestionResolvePayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+	// The owner is known by user ID, which only a token proves
+	if !h.requireAuthenticated(client, "accepting suggestions") {
+		return
+	}
+
+	// The rebased operations are sequenced by the owning node like any other
+	owner, err := h.documentOwner(payload.DocumentID)
+	if err != nil {
+		log.Printf("Error resolving owner of document %s: %v", payload.DocumentID, err)
+		return
+	}
+	if owner != h.hub.NodeID() {
+		h.forwardSuggestionAccept(owner, client, &payload)
+		return
+	}
+
+	if err := h.acceptSuggestion(client.UserID, payload.DocumentID, payload.SuggestionID); err != nil {
+		h.sendSuggestionError(client, err)
+	}
+}
+
+// acceptSuggestion commits a suggestion and broadcasts its operations as
+// made by the suggestion's author
+func (h *Handlers) acceptSuggestion(userID, documentID, suggestionID string) error {
+	suggestion, applied, doc, err := h.suggestions.Accept(documentID, suggestionID, userID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("User %s accepted suggestion %s in document %s, now at version %d", userID, suggestionID, documentID, doc.Version)
+
+	// Nobody has these operations yet, not even the author
+	for _, op := range applied {
+		h.broadcastOperation(suggestion.UserID, "", &types.OperationPayload{
+			Operation:  op,
+			DocumentID: documentID,
+		})
+		h.webhooks.Edited(doc, suggestion.UserID)
+	}
+	h.broadcastDocumentUpdate(doc)
+	h.broadcastSuggestion(userID, suggestion)
+	if len(applied) > 0 {
+		h.scheduleMentionScan(documentID, applied[0].Version)
+	}
+	return nil
+}
+
+func (h *Handlers) forwardSuggestionAccept(owner string, client *ws.Client, payload *types.SuggestionResolvePayload) {
+	forwarded := forwardedOperation{
+		UserID:           client.UserID,
+		ClientID:         client.ID,
+		Payload:          types.OperationPayload{DocumentID: payload.DocumentID},
+		AcceptSuggestion: payload.SuggestionID,
+	}
+
+	forwardedBytes, err := json.Marshal(forwarded)
+	if err != nil {
+		log.Printf("Error marshaling forwarded suggestion: %v", err)
+		return
+	}
+
+	if err := h.hub.SendToNode(owner, forwardedBytes); err != nil {
+		log.Printf("Error forwarding suggestion to node %s: %v", owner, err)
+		h.sendError(client, "Failed to accept suggestion", "FORWARD_ERROR")
+	}
+}
+
+func (h *Handlers) handleSuggestionRejectMessage(client *ws.Client, message *types.WebSocketMessage) {
+	var payload types.SuggestionResolvePayload
+	if !h.decodePayload(client, message, &payload) {
+		return
+	}
+	if !h.checkDocument(client, payload.DocumentID) {
+		return
+	}
+	if !h.requireAuthenticated(client, "rejecting suggestions") {
+		return
+	}
+
+	suggestion, err := h.suggestions.Reject(payload.DocumentID, payload.SuggestionID, client.UserID)
+	if err != nil {
+		h.sendSuggestionError(client, err)
+		return
+	}
+
+	log.Printf("User %s rejected suggestion %s in document %s", client.UserID, suggestion.ID, suggestion.DocumentID)
+	h.broadcastSuggestion(client.UserID, suggestion)
+}
+
+// broadcastSuggestion sends the current state of a suggestion to the whole
+// room, including the sender
+func (h *Handlers) broadcastSuggestion(userID string, suggestion *types.Suggestion) {
+	suggestionMessage := types.WebSocketMessage{
+		Type: types.MessageTypeSuggestion,
+		Payload: types.SuggestionPayload{
+			DocumentID: suggestion.DocumentID,
+			Suggestion: *suggestion,
+		},
+		UserID: userID,
+	}
+	h.hub.BroadcastToDocument(suggestion.DocumentID, &suggestionMessage, nil)
+}
+
+// syncRemoteSuggestion stores a suggestion changed on another node
+func (h *Handlers) syncRemoteSuggestion(documentID string, payload *types.SuggestionPayload) {
+	if err := h.suggestions.SyncSuggestion(&payload.Suggestion); err != nil {
+		log.Printf("Error syncing suggestion %s: %v", payload.Suggestion.ID, err)
+	}
+}
+
+func (h *Handlers) sendSuggestionError(client *ws.Client, err error) {
+	switch err {
+	case storage.ErrSuggestionNotFound:
+		h.sendError(client, "Suggestion not found", "SUGGESTION_NOT_FOUND")
+	case models.ErrSuggestionResolved:
+		h.sendError(client, "Suggestion is already resolved", "SUGGESTION_RESOLVED")
+	case models.ErrSuggestionTooLarge:
+		h.sendError(client, "Suggestion has too many operations", "MESSAGE_TOO_LARGE")
+	case models.ErrNotSuggestionAuthor, models.ErrNotDocumentOwner:
+		h.sendError(client, err.Error(), "FORBIDDEN")
+	case storage.ErrHistoryUnavailable, models.ErrChangeSetConflict:
+		h.sendError(client, "Suggestion is too old to apply", "SUGGESTION_CONFLICT")
+	default:
+		log.Printf("Error updating suggestions: %v", err)
+		h.sendError(client, "Failed to update suggestion", "SUGGESTION_ERROR")
+	}
+}
+
+// documentSuggestions returns the pending suggestions of a document for
+// sync
+func (h *Handlers) documentSuggestions(documentID string) []types.Suggestion {
+	suggestions, err := h.suggestions.GetPending(documentID)
+	if err != nil {
+		log.Printf("Error getting suggestions: %v", err)
+		return []types.Suggestion{}
+	}
+
+	result := make([]types.Suggestion, len(suggestions))
+	for i, suggestion := range suggestions {
+		result[i] = *suggestion
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"testing"
+
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// nextError waits for an error message and returns its code
+func nextError(t *testing.T, client *ws.Client) string {
+	t.Helper()
+	message := nextMessage(t, client, types.MessageTypeError)
+	var payload types.ErrorPayload
+	if err := message.DecodePayload(&payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload.Code
+}
+
+func TestOnlyTheOwnersTokenResolvesSuggestions(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, adaToken := createTestUser(t, h, "Ada")
+	bob, bobToken := createTestUser(t, h, "Bob")
+	doc, err := h.documentService.CreateDocumentWithID("doc", "Doc", "Hello", ada.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.suggestions.AddOperation(doc.ID, "s", &bob, types.Operation{Type: "insert", Position: 5, Content: "!"}); err != nil {
+		t.Fatal(err)
+	}
+
+	join := func(client *ws.Client, user types.User, token string) {
+		h.processMessage(client, &types.WebSocketMessage{
+			Type:    types.MessageTypeJoin,
+			Payload: types.JoinPayload{User: user, DocumentID: doc.ID, Token: token},
+		})
+	}
+	resolve := func(client *ws.Client, messageType string) {
+		h.processMessage(client, &types.WebSocketMessage{
+			Type:    messageType,
+			Payload: types.SuggestionResolvePayload{DocumentID: doc.ID, SuggestionID: "s"},
+		})
+	}
+
+	// Someone else's token does not prove the claimed ID
+	impostor := newTestClient(h.hub, "impostor")
+	join(impostor, ada, bobToken)
+	if code := nextError(t, impostor); code != "UNAUTHORIZED" {
+		t.Fatalf("joining with another user's token: %s", code)
+	}
+	if impostor.DocumentID != "" {
+		t.Fatalf("impostor joined %s", impostor.DocumentID)
+	}
+
+	// Claiming the owner's ID without a token joins as a guest
+	guest := newTestClient(h.hub, "guest")
+	join(guest, ada, "")
+	nextMessage(t, guest, types.MessageTypeDocumentSync)
+	for _, messageType := range []string{types.MessageTypeSuggestionAccept, types.MessageTypeSuggestionReject} {
+		resolve(guest, messageType)
+		if code := nextError(t, guest); code != "UNAUTHORIZED" {
+			t.Fatalf("%s as a guest: %s", messageType, code)
+		}
+	}
+	if pending, _ := h.suggestions.GetPending(doc.ID); len(pending) != 1 {
+		t.Fatalf("pending after a guest resolved = %+v", pending)
+	}
+
+	owner := newTestClient(h.hub, "owner")
+	join(owner, ada, adaToken)
+	nextMessage(t, owner, types.MessageTypeDocumentSync)
+	eventually(t, "the owner is registered", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 2
+	})
+	resolve(owner, types.MessageTypeSuggestionAccept)
+	nextMessage(t, owner, types.MessageTypeSuggestion)
+	if current, _ := h.documentService.GetDocument(doc.ID); current.Content != "Hello!" {
+		t.Fatalf("content after the owner accepted = %q", current.Content)
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"markdown-editor-backend/internal/ratelimit"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// cursorInterval is the minimum delay between cursor broadcasts for one
+// connection; updates arriving faster are coalesced into the latest one
+const cursorInterval = 50 * time.Millisecond
+
+// messageLimits holds the budgets for one message type at each scope.
+// The limiters are only used under mutex, so checking every scope before
+// taking any token is atomic.
+type messageLimits struct {
+	perConnection *ratelimit.Limiter
+	perUser       *ratelimit.Limiter
+	perIP         *ratelimit.Limiter
+	mutex         sync.Mutex
+}
+
+func newMessageLimits(connection, user, ip ratelimit.Limit) *messageLimits {
+	return &messageLimits{
+		perConnection: ratelimit.NewLimiter(connection),
+		perUser:       ratelimit.NewLimiter(user),
+		perIP:         ratelimit.NewLimiter(ip),
+	}
+}
+
+// allow takes a token at every scope when all of them have one, and
+// otherwise takes none and reports the longest wait. A message refused by
+// one scope does not spend the budget of the others.
+func (ml *messageLimits) allow(client *ws.Client) (bool, time.Duration) {
+	scopes := []struct {
+		limiter *ratelimit.Limiter
+		key     string
+	}{
+		{ml.perConnection, client.ID},
+		{ml.perUser, client.UserID},
+		{ml.perIP, client.RemoteAddr},
+	}
+
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+
+	var wait time.Duration
+	for _, scope := range scopes {
+		if scope.key == "" {
+			continue
+		}
+		if retry := scope.limiter.Available(scope.key); retry > wait {
+			wait = retry
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, scope := range scopes {
+		if scope.key != "" {
+			scope.limiter.Allow(scope.key)
+		}
+	}
+	return true, 0
+}
+
+// defaultMessageLimits returns the budgets per message type. Cursor,
+// viewport and awareness updates are also coalesced by broadcastThrottle,
+// their budgets only bound the work spent decoding them.
+func defaultMessageLimits() map[string]*messageLimits {
+	return map[string]*messageLimits{
+		types.MessageTypeCursor: newMessageLimits(
+			ratelimit.Limit{Rate: 60, Burst: 120},
+			ratelimit.Limit{Rate: 100, Burst: 200},
+			ratelimit.Limit{Rate: 300, Burst: 600},
+		),
+		types.MessageTypeViewport: newMessageLimits(
+			ratelimit.Limit{Rate: 30, Burst: 60},
+			ratelimit.Limit{Rate: 60, Burst: 120},
+			ratelimit.Limit{Rate: 200, Burst: 400},
+		),
+		types.MessageTypeAwareness: newMessageLimits(
+			ratelimit.Limit{Rate: 30, Burst: 60},
+			ratelimit.Limit{Rate: 60, Burst: 120},
+			ratelimit.Limit{Rate: 200, Burst: 400},
+		),
+		types.MessageTypeOperation: newMessageLimits(
+			ratelimit.Limit{Rate: 50, Burst: 100},
+			ratelimit.Limit{Rate: 80, Burst: 200},
+			ratelimit.Limit{Rate: 200, Burst: 400},
+		),
+		types.MessageTypeTitleUpdate: newMessageLimits(
+			ratelimit.Limit{Rate: 2, Burst: 5},
+			ratelimit.Limit{Rate: 2, Burst: 5},
+			ratelimit.Limit{Rate: 10, Burst: 20},
+		),
+		types.MessageTypeCreateRoom: newMessageLimits(
+			ratelimit.Every(2*time.Second, 3),
+			ratelimit.Every(10*time.Second, 5),
+			ratelimit.Every(6*time.Second, 10),
+		),
+		types.MessageTypePresence: newMessageLimits(
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 4, Burst: 20},
+			ratelimit.Limit{Rate: 20, Burst: 50},
+		),
+		types.MessageTypeFollow: newMessageLimits(
+			ratelimit.Limit{Rate: 1, Burst: 5},
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 10, Burst: 20},
+		),
+		types.MessageTypeUnfollow: newMessageLimits(
+			ratelimit.Limit{Rate: 1, Burst: 5},
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 10, Burst: 20},
+		),
+		types.MessageTypeCommentAdd: newMessageLimits(
+			ratelimit.Limit{Rate: 1, Burst: 10},
+			ratelimit.Limit{Rate: 2, Burst: 20},
+			ratelimit.Limit{Rate: 10, Burst: 40},
+		),
+		types.MessageTypeCommentReply: newMessageLimits(
+			ratelimit.Limit{Rate: 1, Burst: 10},
+			ratelimit.Limit{Rate: 2, Burst: 20},
+			ratelimit.Limit{Rate: 10, Burst: 40},
+		),
+		types.MessageTypeCommentResolve: newMessageLimits(
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 4, Burst: 20},
+			ratelimit.Limit{Rate: 10, Burst: 40},
+		),
+		types.MessageTypeSuggestionAccept: newMessageLimits(
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 4, Burst: 20},
+			ratelimit.Limit{Rate: 10, Burst: 40},
+		),
+		types.MessageTypeSuggestionReject: newMessageLimits(
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 4, Burst: 20},
+			ratelimit.Limit{Rate: 10, Burst: 40},
+		),
+		types.MessageTypeChat: newMessageLimits(
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 3, Burst: 15},
+			ratelimit.Limit{Rate: 10, Burst: 40},
+		),
+		types.MessageTypeChatEdit: newMessageLimits(
+			ratelimit.Limit{Rate: 1, Burst: 5},
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 10, Burst: 20},
+		),
+		types.MessageTypeChatDelete: newMessageLimits(
+			ratelimit.Limit{Rate: 1, Burst: 5},
+			ratelimit.Limit{Rate: 2, Burst: 10},
+			ratelimit.Limit{Rate: 10, Burst: 20},
+		),
+		// Joining an unknown document ID also spends the create_room
+		// budget, since it creates the document
+		types.MessageTypeJoin: newMessageLimits(
+			ratelimit.Every(time.Second, 5),
+			ratelimit.Every(time.Second, 10),
+			ratelimit.Every(200*time.Millisecond, 30),
+		),
+		// Also slows down guessing room codes
+		types.MessageTypeJoinRoom: newMessageLimits(
+			ratelimit.Every(time.Second, 5),
+			ratelimit.Every(time.Second, 5),
+			ratelimit.Every(500*time.Millisecond, 20),
+		),
+	}
+}
+
+// checkMessageRate reports whether the message fits the client's budgets,
+// replying with RATE_LIMITED when it does not
+func (h *Handlers) checkMessageRate(client *ws.Client, message *types.WebSocketMessage) bool {
+	limits, exists := h.messageLimits[message.Type]
+	if !exists {
+		return true
+	}
+
+	allowed, retry := limits.allow(client)
+	if allowed {
+		return true
+	}
+
+	log.Printf("Rate limited %s message from user %s (%s)", message.Type, client.UserID, client.RemoteAddr)
+
+	errorMessage := types.WebSocketMessage{
+		Type: types.MessageTypeError,
+		Payload: types.ErrorPayload{
+			Message:    fmt.Sprintf("Too many %s messages", message.Type),
+			Code:       "RATE_LIMITED",
+			RetryAfter: retry.Milliseconds(),
+		},
+	}
+	if err := client.SendMessage(&errorMessage); err != nil {
+		log.Printf("Error marshaling rate limit error: %v", err)
+	}
+	return false
+}
+
+// WithRateLimit limits a REST handler per remote IP and path
+func (h *Handlers) WithRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.allowRequest(w, r, r.URL.Path) {
+			next(w, r)
+		}
+	}
+}
+
+// allowRequest takes a token from the remote IP's budget for route,
+// answering 429 with Retry-After when it is exhausted
+func (h *Handlers) allowRequest(w http.ResponseWriter, r *http.Request, route string) bool {
+	allowed, retry := h.restLimiter.Allow(h.clientIP(r) + " " + route)
+	if !allowed {
+		seconds := int(math.Ceil(retry.Seconds()))
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	}
+	return allowed
+}
+
+// clientIP returns the address rate limits are keyed on
+func (h *Handlers) clientIP(r *http.Request) string {
+	if h.config.TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// broadcastThrottle coalesces updates per connection so that at most one
+// per interval is sent; updates arriving faster replace the queued one
+type broadcastThrottle struct {
+	send     func(client *ws.Client, message *types.WebSocketMessage)
+	interval time.Duration
+	pending  map[string]*pendingBroadcast // clientID -> latest update
+	mutex    sync.Mutex
+}
+
+type pendingBroadcast struct {
+	client   *ws.Client
+	message  *types.WebSocketMessage
+	timer    *time.Timer
+	lastSent time.Time
+}
+
+func newBroadcastThrottle(interval time.Duration, send func(client *ws.Client, message *types.WebSocketMessage)) *broadcastThrottle {
+	return &broadcastThrottle{
+		send:     send,
+		interval: interval,
+		pending:  make(map[string]*pendingBroadcast),
+	}
+}
+
+// publish sends the message now if the interval has passed, otherwise
+// replaces any queued update with it. Sending happens outside the lock, a
+// slow broadcast must not hold up every other connection.
+func (bt *broadcastThrottle) publish(client *ws.Client, message *types.WebSocketMessage) {
+	if bt.queue(client, message) {
+		bt.send(client, message)
+	}
+}
+
+// queue reports whether message is due now, otherwise it replaces the
+// queued update and arms the flush timer
+func (bt *broadcastThrottle) queue(client *ws.Client, message *types.WebSocketMessage) bool {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	state, exists := bt.pending[client.ID]
+	if !exists {
+		state = &pendingBroadcast{client: client}
+		bt.pending[client.ID] = state
+	}
+
+	if state.timer != nil {
+		state.message = message
+		return false
+	}
+
+	elapsed := time.Since(state.lastSent)
+	if elapsed >= bt.interval {
+		state.lastSent = time.Now()
+		return true
+	}
+
+	state.message = message
+	state.timer = time.AfterFunc(bt.interval-elapsed, func() {
+		recoverCallback("throttled broadcast flush", func() { bt.flush(client.ID) })
+	})
+	return false
+}
+
+func (bt *broadcastThrottle) flush(clientID string) {
+	bt.mutex.Lock()
+	state, exists := bt.pending[clientID]
+	if !exists || state.message == nil {
+		bt.mutex.Unlock()
+		return
+	}
+
+	client, message := state.client, state.message
+	state.message = nil
+	state.timer = nil
+	state.lastSent = time.Now()
+	bt.mutex.Unlock()
+
+	bt.send(client, message)
+}
+
+// forget drops any queued update when the connection closes
+func (bt *broadcastThrottle) forget(client *ws.Client) {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	if state, exists := bt.pending[client.ID]; exists {
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+		delete(bt.pending, client.ID)
+	}
+}
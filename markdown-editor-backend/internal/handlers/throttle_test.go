@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"markdown-editor-backend/internal/ratelimit"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestBroadcastThrottleCoalescesOutsideLock(t *testing.T) {
+	sent := make(chan string, 10)
+	var bt *broadcastThrottle
+	bt = newBroadcastThrottle(20*time.Millisecond, func(client *ws.Client, message *types.WebSocketMessage) {
+		// A send blocked on a full room must not hold the throttle
+		if !bt.mutex.TryLock() {
+			t.Error("send called with the throttle locked")
+		} else {
+			bt.mutex.Unlock()
+		}
+		sent <- message.Type
+	})
+	client := &ws.Client{ID: "conn"}
+
+	for _, messageType := range []string{"first", "second", "third"} {
+		bt.publish(client, &types.WebSocketMessage{Type: messageType})
+	}
+
+	for _, want := range []string{"first", "third"} {
+		select {
+		case got := <-sent:
+			if got != want {
+				t.Fatalf("sent %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q was never sent", want)
+		}
+	}
+	select {
+	case got := <-sent:
+		t.Fatalf("coalesced update %q was sent too", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCursorMessagesAreRateLimited(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	client := newTestClient(h.hub, "user-1")
+	client.RemoteAddr = "192.0.2.1"
+	cursor := &types.WebSocketMessage{Type: types.MessageTypeCursor}
+
+	limited := false
+	for i := 0; i < 500 && !limited; i++ {
+		limited = !h.checkMessageRate(client, cursor)
+	}
+	if !limited {
+		t.Fatal("500 cursor updates in a burst were all allowed")
+	}
+
+	reply := nextMessage(t, client, types.MessageTypeError)
+	var payload types.ErrorPayload
+	if err := reply.DecodePayload(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Code != "RATE_LIMITED" || payload.RetryAfter <= 0 {
+		t.Fatalf("error = %+v, want RATE_LIMITED with a delay", payload)
+	}
+}
+
+func TestRefusedMessagesSpendNoBudget(t *testing.T) {
+	limits := newMessageLimits(
+		ratelimit.Limit{Rate: 1, Burst: 2},
+		ratelimit.Limit{Rate: 1, Burst: 1},
+		ratelimit.Limit{Rate: 1, Burst: 2},
+	)
+	tab := &ws.Client{ID: "tab-1", UserID: "ada", RemoteAddr: "192.0.2.1"}
+	if allowed, _ := limits.allow(tab); !allowed {
+		t.Fatal("first message refused")
+	}
+
+	// The user's budget is spent, the connection and IP keep theirs
+	if allowed, wait := limits.allow(tab); allowed || wait <= 0 {
+		t.Fatalf("allow = %v, %v; want refused with a delay", allowed, wait)
+	}
+	other := &ws.Client{ID: "tab-2", UserID: "bob", RemoteAddr: "192.0.2.1"}
+	if allowed, _ := limits.allow(other); !allowed {
+		t.Fatal("the refused message spent the IP's last token")
+	}
+	if wait := limits.perConnection.Available(tab.ID); wait != 0 {
+		t.Fatalf("the refused message spent a connection token, next in %v", wait)
+	}
+}
+
+func TestJoinsCreatingDocumentsSpendTheCreateRoomBudget(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada := types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+
+	// A new tab for every join, so only the user's budget applies
+	created, refused := 0, 0
+	for i := 0; i < 20; i++ {
+		documentID := fmt.Sprintf("doc-%d", i)
+		client := newTestClient(h.hub, "ada")
+		client.ID = fmt.Sprintf("tab-%d", i)
+		client.RemoteAddr = "192.0.2.1"
+		h.processMessage(client, &types.WebSocketMessage{
+			Type:    types.MessageTypeJoin,
+			Payload: types.JoinPayload{DocumentID: documentID, User: ada},
+		})
+		if _, err := h.documentService.GetDocument(documentID); err == nil {
+			created++
+		} else if count(drain(t, client), types.MessageTypeError) > 0 {
+			refused++
+		}
+	}
+	if created == 0 || created+refused != 20 || created > 10 {
+		t.Fatalf("20 joins created %d documents and refused %d, want the create_room burst", created, refused)
+	}
+}
+
+func TestRestRequestsAreRateLimitedPerPath(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ok := h.WithRateLimit(func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		ok(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+		return recorder
+	}
+
+	for i := 0; ; i++ {
+		recorder := request("/api/documents")
+		if recorder.Code == http.StatusTooManyRequests {
+			if recorder.Header().Get("Retry-After") == "" {
+				t.Fatal("429 without Retry-After")
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatal("100 requests in a burst were all allowed")
+		}
+	}
+	if recorder := request("/api/users"); recorder.Code != http.StatusOK {
+		t.Fatalf("another path got %d, want its own budget", recorder.Code)
+	}
+}
+
+func TestDocumentRoutesShareOneBudget(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	// Asking for a different document each time must not reset the budget
+	for i := 0; i < 100; i++ {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/documents/doc-%d/comments", i), nil)
+		h.DocumentRoutes(recorder, request)
+		if recorder.Code == http.StatusTooManyRequests {
+			if recorder.Header().Get("Retry-After") == "" {
+				t.Fatal("429 without Retry-After")
+			}
+			return
+		}
+	}
+	t.Fatal("100 comment listings in a burst were all allowed")
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"markdown-editor-backend/internal/validation"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// decodePayload decodes and validates a message payload. On failure it
+// replies with INVALID_PAYLOAD naming the offending field.
+func (h *Handlers) decodePayload(client *ws.Client, message *types.WebSocketMessage, payload interface{}) bool {
+	err := message.DecodePayload(payload)
+	if err != nil {
+		err = validation.DecodeError(err)
+	} else {
+		err = validation.Validate(payload)
+	}
+	if err == nil {
+		return true
+	}
+
+	log.Printf("Invalid %s payload from user %s: %v", message.Type, client.UserID, err)
+	h.sendInvalidPayload(client, err)
+	return false
+}
+
+// checkDocument rejects payloads addressed to a document other than the
+// one the connection joined
+func (h *Handlers) checkDocument(client *ws.Client, documentID string) bool {
+	if client.DocumentID != "" && documentID == client.DocumentID {
+		return true
+	}
+
+	log.Printf("User %s sent a payload for document %s while in %q", client.UserID, documentID, client.DocumentID)
+	h.sendInvalidPayload(client, &validation.FieldError{
+		Field:   "documentId",
+		Message: "does not match the joined document",
+	})
+	return false
+}
+
+func (h *Handlers) sendInvalidPayload(client *ws.Client, err error) {
+	errorMessage := types.WebSocketMessage{
+		Type:    types.MessageTypeError,
+		Payload: invalidPayloadError(err),
+	}
+
+	if err := client.SendMessage(&errorMessage); err != nil {
+		log.Printf("Error marshaling error message: %v", err)
+	}
+}
+
+func invalidPayloadError(err error) types.ErrorPayload {
+	payload := types.ErrorPayload{
+		Message: err.Error(),
+		Code:    "INVALID_PAYLOAD",
+	}
+
+	var fieldErr *validation.FieldError
+	if errors.As(err, &fieldErr) {
+		payload.Field = fieldErr.Field
+	}
+	return payload
+}
+
+// decodeJSONBody decodes a bounded REST body, rejecting unknown fields.
+// It writes a 400 response and returns false on failure.
+func (h *Handlers) decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, h.config.MaxMessageSize)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		writeValidationError(w, validation.DecodeError(err))
+		return false
+	}
+	return true
+}
+
+// writeValidationError answers 400 with a structured INVALID_PAYLOAD body
+func writeValidationError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(invalidPayloadError(err))
+}
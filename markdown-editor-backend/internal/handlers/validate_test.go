@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"markdown-editor-backend/internal/config"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestInvalidPayloadsNameTheField(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	client := newTestClient(h.hub, "ada")
+	client.DocumentID = "doc"
+
+	tests := []struct {
+		name    string
+		payload string
+		field   string
+	}{
+		{"unknown field", `{"documentId":"doc","operation":{"type":"insert","content":"x"},"admin":true}`, "admin"},
+		{"wrong type", `{"documentId":"doc","operation":{"type":"insert","position":"one"}}`, "operation.position"},
+		{"failed rule", `{"documentId":"doc","operation":{"type":"insert","content":""}}`, "operation.content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &types.WebSocketMessage{Type: types.MessageTypeOperation, Payload: json.RawMessage(tt.payload)}
+			var payload types.OperationPayload
+			if h.decodePayload(client, message, &payload) {
+				t.Fatal("invalid payload accepted")
+			}
+
+			received := nextMessage(t, client, types.MessageTypeError)
+			var reply types.ErrorPayload
+			if err := received.DecodePayload(&reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Code != "INVALID_PAYLOAD" || reply.Field != tt.field {
+				t.Fatalf("error = %+v, want INVALID_PAYLOAD for %s", reply, tt.field)
+			}
+		})
+	}
+
+	if h.checkDocument(client, "other") {
+		t.Fatal("payload for another document accepted")
+	}
+	message := nextMessage(t, client, types.MessageTypeError)
+	var reply types.ErrorPayload
+	if err := message.DecodePayload(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Field != "documentId" {
+		t.Fatalf("error = %+v, want one for documentId", reply)
+	}
+}
+
+func TestRESTBodiesAreValidated(t *testing.T) {
+	h := newTestHandlers(t, func(cfg *config.Config) {
+		cfg.MaxMessageSize = 256
+	})
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"unknown field", `{"name":"Ada","admin":true}`, http.StatusBadRequest},
+		{"blank name", `{"name":"  "}`, http.StatusBadRequest},
+		{"too large", `{"name":"` + strings.Repeat("a", 300) + `"}`, http.StatusRequestEntityTooLarge},
+		{"valid", `{"name":"Ada"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			h.CreateUser(recorder, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(tt.body)))
+			if recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"markdown-editor-backend/internal/config"
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/internal/validation"
+	"markdown-editor-backend/internal/webhooks"
+	"markdown-editor-backend/pkg/types"
+)
+
+// webhookRoutePrefix is where single subscriptions are served
+const webhookRoutePrefix = "/api/webhooks/"
+
+// newWebhookDispatcher creates the dispatcher with the subscriptions from
+// the configuration, which receive every event of every document
+func newWebhookDispatcher(cfg *config.Config) *webhooks.Dispatcher {
+	dispatcher := webhooks.NewDispatcher(webhooks.Options{
+		EditDebounce:        cfg.WebhookEditDebounce,
+		SnapshotEvery:       cfg.WebhookSnapshotEvery,
+		AllowPrivateTargets: cfg.WebhookAllowPrivate,
+	})
+
+	if len(cfg.WebhookURLs) > 0 && cfg.WebhookSecret == "" {
+		log.Printf("Ignoring WEBHOOK_URLS, WEBHOOK_SECRET is not set")
+		return dispatcher
+	}
+	for _, url := range cfg.WebhookURLs {
+		if _, err := dispatcher.Subscribe(webhooks.Subscription{URL: url, Secret: cfg.WebhookSecret}); err != nil {
+			log.Printf("Ignoring webhook %q: %v", url, err)
+		}
+	}
+	return dispatcher
+}
+
+// documentEvent is the data sent with document.created
+type documentEvent struct {
+	ID       string `json:"id"`
+	RoomCode string `json:"roomCode,omitempty"`
+	Title    string `json:"title"`
+	OwnerID  string `json:"ownerId,omitempty"`
+	Version  int    `json:"version"`
+}
+
+func (h *Handlers) emitDocumentCreated(doc *types.Document) {
+	h.webhooks.Emit(webhooks.EventDocumentCreated, doc.ID, documentEvent{
+		ID:       doc.ID,
+		RoomCode: doc.RoomCode,
+		Title:    doc.Title,
+		OwnerID:  doc.OwnerID,
+		Version:  doc.Version,
+	})
+}
+
+func (h *Handlers) emitTitleChanged(doc *types.Document, previous, userID string) {
+	h.webhooks.Emit(webhooks.EventTitleChanged, doc.ID, map[string]string{
+		"title":         doc.Title,
+		"previousTitle": previous,
+		"userId":        userID,
+	})
+}
+
+func (h *Handlers) emitUserJoined(documentID string, user *types.User) {
+	h.webhooks.Emit(webhooks.EventUserJoined, documentID, map[string]string{
+		"userId":   user.ID,
+		"userName": user.Name,
+	})
+}
+
+// Webhooks handles GET /api/webhooks, optionally ?documentId= to list the
+// caller's subscriptions to one document, and POST /api/webhooks with
+// {url, secret, documentId, events} to subscribe to a document the caller
+// owns. The secret is generated when omitted and only returned by the
+// POST. Both need the caller's user token; the configured subscriptions
+// are not listed.
+func (h *Handlers) Webhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		h.createWebhook(w, r, userID)
+		return
+	}
+
+	documentID := r.URL.Query().Get("documentId")
+	if documentID != "" {
+		if err := validation.ValidateID("documentId", documentID); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.webhooks.Subscriptions(userID, documentID))
+}
+
+func (h *Handlers) createWebhook(w http.ResponseWriter, r *http.Request, userID string) {
+	var request struct {
+		URL        string   `json:"url"`
+		Secret     string   `json:"secret"`
+		DocumentID string   `json:"documentId"`
+		Events     []string `json:"events"`
+	}
+	if !h.decodeJSONBody(w, r, &request) {
+		return
+	}
+
+	// Webhooks for every document are only set up with WEBHOOK_URLS
+	if err := validation.ValidateID("documentId", request.DocumentID); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if _, ok := h.requireDocumentOwner(w, r, request.DocumentID); !ok {
+		return
+	}
+
+	subscription, err := h.webhooks.Subscribe(webhooks.Subscription{
+		URL:        request.URL,
+		Secret:     request.Secret,
+		DocumentID: request.DocumentID,
+		OwnerID:    userID,
+		Events:     request.Events,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Webhook %s subscribed to %s", subscription.ID, subscription.URL)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+// WebhookRoutes handles DELETE /api/webhooks/{id} and
+// GET /api/webhooks/{id}/deliveries, the delivery log newest first. Only
+// the user who subscribed may use them; other subscriptions, configured
+// ones included, are reported as not found.
+func (h *Handlers) WebhookRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, webhookRoutePrefix), "/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 || (len(parts) == 2 && parts[1] != "deliveries") {
+		http.NotFound(w, r)
+		return
+	}
+
+	userID, ok := h.requireUser(w, r, "")
+	if !ok {
+		return
+	}
+	if subscription, err := h.webhooks.Subscription(id); err != nil || subscription.OwnerID != userID {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := h.webhooks.Unsubscribe(id); err != nil {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deliveries, err := h.webhooks.Deliveries(id)
+	if err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// DeleteDocument handles DELETE /api/documents/{id}, telling the room and
+// the webhooks that the document is gone. Only the owner may delete it.
+func (h *Handlers) DeleteDocument(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireDocumentOwner(w, r, documentID); !ok {
+		return
+	}
+
+	if err := h.documentService.DeleteDocument(documentID); err != nil {
+		if errors.Is(err, storage.ErrDocumentNotFound) {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Document %s deleted", documentID)
+
+	h.hub.BroadcastToDocument(documentID, &types.WebSocketMessage{
+		Type:    types.MessageTypeDocumentDeleted,
+		Payload: types.DocumentDeletedPayload{DocumentID: documentID},
+	}, nil)
+	h.closeRoom(documentID)
+	h.webhooks.Deleted(documentID)
+
+	if err := h.ownership.Release(documentID, h.hub.NodeID()); err != nil {
+		log.Printf("Error releasing document %s: %v", documentID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// syncRemoteDeletion drops the local copy of a document deleted elsewhere
+func (h *Handlers) syncRemoteDeletion(documentID string, payload *types.DocumentDeletedPayload) {
+	if err := h.documentService.DeleteDocument(documentID); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+		log.Printf("Error deleting document %s: %v", documentID, err)
+	}
+	h.closeRoom(documentID)
+}
+
+// closeRoom drops what is kept for a deleted document once the room has
+// been told. Its connections on this node are unregistered, which closes
+// them; each leaves the room as its read loop ends.
+func (h *Handlers) closeRoom(documentID string) {
+	for _, client := range h.hub.GetDocumentClients(documentID) {
+		h.hub.UnregisterClient(client)
+	}
+	h.mentions.forget(documentID)
+	h.renderer.Forget(documentID)
+	h.exporter.Forget(documentID)
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"markdown-editor-backend/internal/webhooks"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// authorized sends a request through handler with a user token
+func authorized(handler http.HandlerFunc, method, target, body, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+func TestOnlyTheOwnerDeletesADocument(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, adaToken := createTestUser(t, h, "Ada")
+	_, bobToken := createTestUser(t, h, "Bob")
+	owned, err := h.documentService.CreateRoom("Owned", "", ada.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unowned, err := h.documentService.CreateDocument("Unowned", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		documentID string
+		token      string
+		want       int
+	}{
+		{"no token", owned.ID, "", http.StatusUnauthorized},
+		{"someone else", owned.ID, bobToken, http.StatusForbidden},
+		{"ownerless document", unowned.ID, adaToken, http.StatusForbidden},
+		{"missing document", "missing", adaToken, http.StatusNotFound},
+		{"owner", owned.ID, adaToken, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := authorized(h.DocumentRoutes, http.MethodDelete, "/api/documents/"+tt.documentID, "", tt.token)
+			if recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+
+	if _, err := h.documentService.GetDocument(owned.ID); err == nil {
+		t.Fatal("owned document still exists")
+	}
+	if _, err := h.documentService.GetDocument(unowned.ID); err != nil {
+		t.Fatalf("ownerless document was deleted: %v", err)
+	}
+}
+
+func TestDocumentsAreOwnedByTheTokensUser(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, adaToken := createTestUser(t, h, "Ada")
+
+	create := func(token string) (*httptest.ResponseRecorder, types.Document) {
+		recorder := authorized(h.CreateDocument, http.MethodPost, "/api/documents", `{"title":"Notes"}`, token)
+		var doc types.Document
+		if recorder.Code == http.StatusOK {
+			if err := json.NewDecoder(recorder.Body).Decode(&doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recorder, doc
+	}
+	if _, doc := create(adaToken); doc.OwnerID != ada.ID {
+		t.Fatalf("document created with a token is owned by %q", doc.OwnerID)
+	}
+	if _, doc := create(""); doc.ID == "" || doc.OwnerID != "" {
+		t.Fatalf("document created without a token = %+v, want it unowned", doc)
+	}
+	if recorder, _ := create("ada.deadbeef"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("forged token: status = %d, want 401", recorder.Code)
+	}
+
+	// Over WebSocket only a connection holding the token creates for ada
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"guest", "", ""},
+		{"token", adaToken, ada.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomClient := newTestClient(h.hub, tt.name+"-room")
+			h.processMessage(roomClient, &types.WebSocketMessage{
+				Type:    types.MessageTypeCreateRoom,
+				Payload: types.CreateRoomPayload{User: ada, Title: "Room", Token: tt.token},
+			})
+			var created types.CreateRoomResponse
+			message := nextMessage(t, roomClient, types.MessageTypeCreateRoom)
+			if err := message.DecodePayload(&created); err != nil {
+				t.Fatal(err)
+			}
+			if created.Document.OwnerID != tt.want {
+				t.Fatalf("room is owned by %q, want %q", created.Document.OwnerID, tt.want)
+			}
+
+			joinClient := newTestClient(h.hub, tt.name+"-join")
+			h.processMessage(joinClient, &types.WebSocketMessage{
+				Type:    types.MessageTypeJoin,
+				Payload: types.JoinPayload{User: ada, DocumentID: tt.name + "-doc", Token: tt.token},
+			})
+			nextMessage(t, joinClient, types.MessageTypeDocumentSync)
+			if doc, err := h.documentService.GetDocument(tt.name + "-doc"); err != nil || doc.OwnerID != tt.want {
+				t.Fatalf("document created on join = %+v, %v; want it owned by %q", doc, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeletingADocumentClosesItsRoom(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, adaToken := createTestUser(t, h, "Ada")
+	doc, err := h.documentService.CreateRoom("Doomed", "text", ada.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := func(client *ws.Client) {
+		h.processMessage(client, &types.WebSocketMessage{
+			Type:    types.MessageTypeJoin,
+			Payload: types.JoinPayload{User: ada, DocumentID: doc.ID, Token: adaToken},
+		})
+	}
+	member := newTestClient(h.hub, "member")
+	join(member)
+	eventually(t, "the member joined", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 1
+	})
+
+	if recorder := authorized(h.DocumentRoutes, http.MethodDelete, "/api/documents/"+doc.ID, "", adaToken); recorder.Code != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// The room hears of the deletion, then its connections are closed
+	nextMessage(t, member, types.MessageTypeDocumentDeleted)
+	eventually(t, "the room is empty", func() bool {
+		return len(h.hub.GetDocumentClients(doc.ID)) == 0
+	})
+	for range member.Send {
+	}
+
+	// Joining again does not bring the document back, nor does a stale sync
+	rejoining := newTestClient(h.hub, "rejoining")
+	join(rejoining)
+	if code := nextError(t, rejoining); code != "DOCUMENT_DELETED" {
+		t.Fatalf("joining a deleted document: %s", code)
+	}
+	if err := h.documentService.SyncDocument(&types.Document{ID: doc.ID, Content: "stale", Version: 2}); err == nil {
+		t.Fatal("a stale sync recreated the deleted document")
+	}
+	if _, err := h.documentService.GetDocument(doc.ID); err == nil {
+		t.Fatal("deleted document exists again")
+	}
+}
+
+func TestWebhooksAreManagedByTheirOwner(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	ada, adaToken := createTestUser(t, h, "Ada")
+	bob, bobToken := createTestUser(t, h, "Bob")
+	adaDoc, _ := h.documentService.CreateRoom("Ada's", "", ada.ID)
+	bobDoc, _ := h.documentService.CreateRoom("Bob's", "", bob.ID)
+	configured, err := h.webhooks.Subscribe(webhooks.Subscription{URL: "https://hooks.example.com/all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscribe := func(body, token string) *httptest.ResponseRecorder {
+		return authorized(h.Webhooks, http.MethodPost, "/api/webhooks", body, token)
+	}
+	hook := func(documentID, url string) string {
+		return `{"url":"` + url + `","documentId":"` + documentID + `"}`
+	}
+
+	creates := []struct {
+		name  string
+		body  string
+		token string
+		want  int
+	}{
+		{"no token", hook(adaDoc.ID, "https://hooks.example.com/a"), "", http.StatusUnauthorized},
+		{"every document", `{"url":"https://hooks.example.com/a"}`, adaToken, http.StatusBadRequest},
+		{"someone else's document", hook(bobDoc.ID, "https://hooks.example.com/a"), adaToken, http.StatusForbidden},
+		{"metadata address", hook(adaDoc.ID, "http://169.254.169.254/latest"), adaToken, http.StatusBadRequest},
+		{"loopback address", hook(adaDoc.ID, "http://127.0.0.1:6379/"), adaToken, http.StatusBadRequest},
+		{"own document", hook(adaDoc.ID, "https://hooks.example.com/a"), adaToken, http.StatusCreated},
+	}
+	for _, tt := range creates {
+		t.Run(tt.name, func(t *testing.T) {
+			if recorder := subscribe(tt.body, tt.token); recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+	if recorder := subscribe(hook(bobDoc.ID, "https://hooks.example.com/b"), bobToken); recorder.Code != http.StatusCreated {
+		t.Fatalf("bob subscribing returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	list := func(token string) []webhooks.Subscription {
+		t.Helper()
+		recorder := authorized(h.Webhooks, http.MethodGet, "/api/webhooks", "", token)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("listing returned %d", recorder.Code)
+		}
+		var subscriptions []webhooks.Subscription
+		if err := json.NewDecoder(recorder.Body).Decode(&subscriptions); err != nil {
+			t.Fatal(err)
+		}
+		return subscriptions
+	}
+	if recorder := authorized(h.Webhooks, http.MethodGet, "/api/webhooks", "", ""); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("listing without a token returned %d", recorder.Code)
+	}
+	adaHooks := list(adaToken)
+	if len(adaHooks) != 1 || adaHooks[0].DocumentID != adaDoc.ID || adaHooks[0].Secret != "" {
+		t.Fatalf("ada lists %+v, want only her secretless subscription", adaHooks)
+	}
+	bobHooks := list(bobToken)
+	if len(bobHooks) != 1 || bobHooks[0].DocumentID != bobDoc.ID {
+		t.Fatalf("bob lists %+v, want only his subscription", bobHooks)
+	}
+
+	routes := []struct {
+		name   string
+		method string
+		id     string
+		token  string
+		want   int
+	}{
+		{"deliveries without a token", http.MethodGet, adaHooks[0].ID + "/deliveries", "", http.StatusUnauthorized},
+		{"someone else's deliveries", http.MethodGet, adaHooks[0].ID + "/deliveries", bobToken, http.StatusNotFound},
+		{"configured deliveries", http.MethodGet, configured.ID + "/deliveries", adaToken, http.StatusNotFound},
+		{"own deliveries", http.MethodGet, adaHooks[0].ID + "/deliveries", adaToken, http.StatusOK},
+		{"delete without a token", http.MethodDelete, adaHooks[0].ID, "", http.StatusUnauthorized},
+		{"delete someone else's", http.MethodDelete, adaHooks[0].ID, bobToken, http.StatusNotFound},
+		{"delete configured", http.MethodDelete, configured.ID, adaToken, http.StatusNotFound},
+		{"delete own", http.MethodDelete, adaHooks[0].ID, adaToken, http.StatusNoContent},
+	}
+	for _, tt := range routes {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := authorized(h.WebhookRoutes, tt.method, webhookRoutePrefix+tt.id, "", tt.token)
+			if recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+
+	if _, err := h.webhooks.Subscription(configured.ID); err != nil {
+		t.Fatalf("configured subscription was removed: %v", err)
+	}
+	if remaining := list(adaToken); len(remaining) != 0 {
+		t.Fatalf("ada still lists %+v", remaining)
+	}
+}
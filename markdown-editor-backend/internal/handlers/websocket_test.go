@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"markdown-editor-backend/internal/cluster"
+	"markdown-editor-backend/internal/config"
+	"markdown-editor-backend/internal/protocol"
+	"markdown-editor-backend/internal/storage"
+	ws "markdown-editor-backend/internal/websocket"
+	"markdown-editor-backend/pkg/types"
+)
+
+// newTestHandlers returns single-node handlers, letting the test adjust
+// the configuration first
+func newTestHandlers(t *testing.T, configure func(cfg *config.Config)) *Handlers {
+	t.Helper()
+	hub := ws.NewHub()
+	go hub.Run()
+
+	cfg := config.Load()
+	if configure != nil {
+		configure(cfg)
+	}
+	return NewHandlers(storage.NewMemoryStorage(), hub, cluster.NewMemoryOwnership(cluster.DefaultLeaseTTL), cfg)
+}
+
+// dialTestServer opens a WebSocket connection to a test server running
+// HandleWebSocket
+func dialTestServer(t *testing.T, h *Handlers, dialer *websocket.Dialer) *websocket.Conn {
+	t.Helper()
+	conn, _, err := tryDial(t, h, dialer, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// tryDial attempts an upgrade with the given query string and headers
+func tryDial(t *testing.T, h *Handlers, dialer *websocket.Dialer, query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	t.Cleanup(server.Close)
+
+	target := "ws" + strings.TrimPrefix(server.URL, "http")
+	if query != "" {
+		target += "?" + query
+	}
+	conn, response, err := dialer.Dial(target, header)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, response, err
+}
+
+func TestUpgradeChecksOrigin(t *testing.T) {
+	h := newTestHandlers(t, func(cfg *config.Config) {
+		cfg.AllowedOrigins = []string{"https://editor.example.com"}
+	})
+
+	tests := []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{"allowed origin", "https://editor.example.com", true},
+		{"allowed origin, other case", "HTTPS://Editor.Example.com/", true},
+		{"disallowed origin", "https://evil.example.net", false},
+		{"missing origin", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			_, response, err := tryDial(t, h, websocket.DefaultDialer, "", header)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("upgrade failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("upgrade succeeded from a disallowed origin")
+			}
+			if response == nil || response.StatusCode != http.StatusForbidden {
+				t.Fatalf("response = %v, want 403", response)
+			}
+		})
+	}
+}
+
+func TestUpgradeRequiresValidToken(t *testing.T) {
+	const origin = "https://editor.example.com"
+	h := newTestHandlers(t, func(cfg *config.Config) {
+		cfg.AllowedOrigins = []string{origin}
+		cfg.RequireWSToken = true
+	})
+
+	// Issue a token the way the frontend gets one
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/ws-token", nil)
+	request.Header.Set("Origin", origin)
+	h.GetWSToken(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("ws-token returned %d", recorder.Code)
+	}
+	var issued struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&issued); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip the last hex digit of the signature
+	tampered := issued.Token[:len(issued.Token)-1] + "0"
+	if tampered == issued.Token {
+		tampered = issued.Token[:len(issued.Token)-1] + "1"
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		origin  string
+		allowed bool
+	}{
+		{"issued token", issued.Token, origin, true},
+		{"no token", "", origin, false},
+		{"malformed token", "not-a-token", origin, false},
+		{"tampered token", tampered, origin, false},
+		{"expired token", h.signWSToken(origin, time.Now().Add(-time.Second)), origin, false},
+		{"token for another origin", h.signWSToken("https://evil.example.net", time.Now().Add(time.Minute)), origin, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Origin": {tt.origin}}
+			query := ""
+			if tt.token != "" {
+				query = "token=" + url.QueryEscape(tt.token)
+			}
+			_, response, err := tryDial(t, h, websocket.DefaultDialer, query, header)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("upgrade failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("upgrade succeeded with an invalid token")
+			}
+			if response == nil || response.StatusCode != http.StatusForbidden {
+				t.Fatalf("response = %v, want 403", response)
+			}
+		})
+	}
+}
+
+func TestWSTokenRefusesDisallowedOrigin(t *testing.T) {
+	h := newTestHandlers(t, func(cfg *config.Config) {
+		cfg.AllowedOrigins = []string{"https://editor.example.com"}
+	})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/ws-token", nil)
+	request.Header.Set("Origin", "https://evil.example.net")
+	h.GetWSToken(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("ws-token returned %d, want 403", recorder.Code)
+	}
+}
+
+// expectClose reads until the server closes the connection and returns
+// the close code
+func expectClose(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, message, err := conn.ReadMessage()
+		if err == nil {
+			t.Logf("received %s before close", message)
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("read failed without a close frame: %v", err)
+		}
+		return closeErr.Code
+	}
+}
+
+func TestOversizedMessagesCloseTheConnection(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression bool
+		message     string
+	}{
+		{"raw", false, strings.Repeat("a", 4096)},
+		// Deflates to a few dozen bytes on the wire, well under the read limit
+		{"compressed", true, `{"type":"join","payload":{"documentId":"` + strings.Repeat("a", 64<<10) + `"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandlers(t, func(cfg *config.Config) {
+				cfg.EnableCompression = tt.compression
+				cfg.MaxMessageSize = 1024
+			})
+			dialer := *websocket.DefaultDialer
+			dialer.EnableCompression = tt.compression
+			conn := dialTestServer(t, h, &dialer)
+			conn.EnableWriteCompression(tt.compression)
+
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.message)); err != nil {
+				t.Fatal(err)
+			}
+			if code := expectClose(t, conn); code != websocket.CloseMessageTooBig {
+				t.Fatalf("close code = %d, want %d", code, websocket.CloseMessageTooBig)
+			}
+		})
+	}
+}
+
+func TestMessagePackIsNegotiated(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	dialer := &websocket.Dialer{Subprotocols: []string{protocol.SubprotocolMsgpack, protocol.SubprotocolJSON}}
+	conn := dialTestServer(t, h, dialer)
+	if conn.Subprotocol() != protocol.SubprotocolMsgpack {
+		t.Fatalf("negotiated %q, want MessagePack", conn.Subprotocol())
+	}
+
+	request, err := protocol.MessagePack.Encode(&types.WebSocketMessage{
+		Type: types.MessageTypeCreateRoom,
+		Payload: types.CreateRoomPayload{
+			User:  types.User{ID: "ada", Name: "Ada", Color: "#FF6B6B"},
+			Title: "Binary",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, request); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		frameType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frameType != websocket.BinaryMessage {
+			t.Fatalf("frame type %d, want binary", frameType)
+		}
+		message, err := protocol.MessagePack.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.Type != types.MessageTypeCreateRoom {
+			continue
+		}
+		var response types.CreateRoomResponse
+		if err := message.DecodePayload(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Document.Title != "Binary" || response.RoomCode == "" {
+			t.Fatalf("create room response = %+v", response)
+		}
+		return
+	}
+}
+
+func TestOversizedOperationsAreRejected(t *testing.T) {
+	h := newTestHandlers(t, func(cfg *config.Config) {
+		cfg.MaxOperationSize = 4
+	})
+	client := newTestClient(h.hub, "ada")
+	client.DocumentID = "doc"
+
+	tests := []struct {
+		name    string
+		message types.WebSocketMessage
+	}{
+		{"operation", types.WebSocketMessage{
+			Type:    types.MessageTypeOperation,
+			Payload: types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert", Content: "hello"}},
+		}},
+		{"room content", types.WebSocketMessage{
+			Type:    types.MessageTypeCreateRoom,
+			Payload: types.CreateRoomPayload{User: types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}, Title: "Big", Content: "hello"},
+		}},
+	}
+	for _, tt := range tests {
+		h.processMessage(client, &tt.message)
+		reply := nextMessage(t, client, types.MessageTypeError)
+		var payload types.ErrorPayload
+		if err := reply.DecodePayload(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Code != "MESSAGE_TOO_LARGE" {
+			t.Errorf("%s: error code = %q, want MESSAGE_TOO_LARGE", tt.name, payload.Code)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/JohannesKaufmann/html-to-markdown/plugin"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/charmap"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnsupportedType = errors.New("unsupported file type, expected .md, .markdown, .txt, .html or .htm")
+
+// Document is the result of importing a file. Title is empty when the file
+// does not name one.
+type Document struct {
+	Title    string
+	Content  string
+	Metadata map[string]interface{}
+}
+
+// frontMatterIgnored are front matter keys describing the exported source
+// document, which do not carry over to a new one
+var frontMatterIgnored = []string{"title", "version", "lastModified"}
+
+// Parse converts an uploaded file to Markdown. contentType is the type the
+// upload was sent with; its charset, a byte order mark or an HTML meta tag
+// select the encoding, falling back to UTF-8 and then Windows-1252.
+func Parse(filename, contentType string, data []byte) (*Document, error) {
+	extension := strings.ToLower(filepath.Ext(filename))
+	switch extension {
+	case ".md", ".markdown", ".txt", ".html", ".htm":
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	text, err := decode(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	text = normalizeLineEndings(text)
+
+	if extension == ".html" || extension == ".htm" {
+		return parseHTML(text)
+	}
+
+	doc := &Document{Content: text}
+	if metadata, body, found := splitFrontMatter(text); found {
+		doc.Content = body
+		if title, ok := metadata["title"].(string); ok {
+			doc.Title = title
+		}
+		for _, key := range frontMatterIgnored {
+			delete(metadata, key)
+		}
+		if len(metadata) > 0 {
+			doc.Metadata = metadata
+		}
+	}
+	return doc, nil
+}
+
+// decode converts data to UTF-8 and drops a byte order mark
+func decode(data []byte, contentType string) (string, error) {
+	encoding, name, certain := charset.DetermineEncoding(data, contentType)
+	// Only the start of the file is sniffed
+	if !certain && name == "utf-8" && !utf8.Valid(data) {
+		encoding = charmap.Windows1252
+	}
+
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return strings.TrimPrefix(string(decoded), "\ufeff"), nil
+}
+
+func normalizeLineEndings(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
+}
+
+// splitFrontMatter separates a leading YAML block delimited by --- lines.
+// A block that is not a YAML mapping is left in the content, it is more
+// likely a thematic break.
+func splitFrontMatter(text string) (map[string]interface{}, string, bool) {
+	if !strings.HasPrefix(text, "---\n") {
+		return nil, text, false
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	closing := -1
+	for i := 1; i < len(lines); i++ {
+		if line := strings.TrimSuffix(lines[i], "\n"); line == "---" || line == "..." {
+			closing = i
+			break
+		}
+	}
+	if closing < 0 {
+		return nil, text, false
+	}
+	block := strings.Join(lines[1:closing], "")
+	body := strings.Join(lines[closing+1:], "")
+
+	var metadata map[string]interface{}
+	if err := yaml.Unmarshal([]byte(block), &metadata); err != nil {
+		return nil, text, false
+	}
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	for key, value := range metadata {
+		metadata[key] = normalizeValue(value)
+	}
+	return metadata, strings.TrimLeft(body, "\n"), true
+}
+
+// normalizeValue gives nested YAML mappings string keys so the metadata
+// can be encoded as JSON
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			v[key] = normalizeValue(nested)
+		}
+		return v
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			converted[fmt.Sprint(key)] = normalizeValue(nested)
+		}
+		return converted
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = normalizeValue(nested)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// parseHTML converts an HTML page to GitHub flavored Markdown, taking the
+// title from the title element
+func parseHTML(text string) (*Document, error) {
+	page, err := goquery.NewDocumentFromReader(bytes.NewReader([]byte(text)))
+	if err != nil {
+		return nil, err
+	}
+
+	title := strings.TrimSpace(page.Find("head title").First().Text())
+	page.Find("head").Remove()
+
+	converter := md.NewConverter("", true, &md.Options{
+		HeadingStyle:     "atx",
+		CodeBlockStyle:   "fenced",
+		BulletListMarker: "-",
+	})
+	converter.Use(plugin.GitHubFlavored())
+
+	content := converter.Convert(page.Selection)
+	if content != "" {
+		content += "\n"
+	}
+	return &Document{Title: title, Content: content}, nil
+}
@@ -0,0 +1,90 @@
+package importer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		filename    string
+		contentType string
+		data        string
+		want        Document
+	}{
+		{
+			name:     "crlf line endings",
+			filename: "notes.md",
+			data:     "# One\r\n\r\ntwo\rthree\r\n",
+			want:     Document{Content: "# One\n\ntwo\nthree\n"},
+		},
+		{
+			name:     "windows-1252 without a charset",
+			filename: "notes.txt",
+			data:     "caf\xe9 \x93quoted\x94 \x80\r\n",
+			want:     Document{Content: "café “quoted” €\n"},
+		},
+		{
+			name:        "declared charset",
+			filename:    "notes.txt",
+			contentType: "text/plain; charset=iso-8859-1",
+			data:        "na\xefve",
+			want:        Document{Content: "naïve"},
+		},
+		{
+			name:     "byte order mark",
+			filename: "notes.md",
+			data:     "\xef\xbb\xbf# Title\n",
+			want:     Document{Content: "# Title\n"},
+		},
+		{
+			name:     "front matter",
+			filename: "notes.md",
+			data: "---\r\ntitle: Trip\r\nversion: 4\r\nlastModified: 2024-05-01T12:00:00Z\r\n" +
+				"tags: [travel, 2024]\r\nauthor:\r\n  name: Ada\r\n---\r\n\r\n# Day one\r\n",
+			want: Document{
+				Title:   "Trip",
+				Content: "# Day one\n",
+				Metadata: map[string]interface{}{
+					"tags":   []interface{}{"travel", 2024},
+					"author": map[string]interface{}{"name": "Ada"},
+				},
+			},
+		},
+		{
+			name:     "thematic break",
+			filename: "notes.md",
+			data:     "---\nnot: [yaml\n---\ntext\n",
+			want:     Document{Content: "---\nnot: [yaml\n---\ntext\n"},
+		},
+		{
+			name:     "html page",
+			filename: "page.html",
+			data: "<html><head><meta charset=\"windows-1252\"><title> Caf\xe9 </title></head>" +
+				"<body><h1>Menu</h1><ul><li><strong>Tea</strong></li></ul></body></html>",
+			want: Document{Title: "Café", Content: "# Menu\n\n- **Tea**\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.filename, tt.contentType, []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("Parse = %#v\nwant %#v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsOtherFiles(t *testing.T) {
+	for _, filename := range []string{"notes.docx", "image.png", "notes"} {
+		if _, err := Parse(filename, "", []byte("text")); !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("Parse(%q) = %v, want ErrUnsupportedType", filename, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+// attributeInsert returns runs with length characters at position
+// attributed to run
+func attributeInsert(runs []types.AuthorshipRun, position int, run types.AuthorshipRun) []types.AuthorshipRun {
+	left, right := splitRuns(runs, position)
+	result := make([]types.AuthorshipRun, 0, len(left)+len(right)+1)
+	result = append(result, left...)
+	result = append(result, run)
+	return mergeRuns(append(result, right...))
+}
+
+// attributeDelete returns runs without the length characters at position
+func attributeDelete(runs []types.AuthorshipRun, position, length int) []types.AuthorshipRun {
+	left, rest := splitRuns(runs, position)
+	_, right := splitRuns(rest, length)
+	result := make([]types.AuthorshipRun, 0, len(left)+len(right))
+	result = append(result, left...)
+	return mergeRuns(append(result, right...))
+}
+
+// splitRuns cuts runs at a character offset, splitting the run it falls in
+func splitRuns(runs []types.AuthorshipRun, offset int) ([]types.AuthorshipRun, []types.AuthorshipRun) {
+	for i, run := range runs {
+		if offset <= 0 {
+			return runs[:i], runs[i:]
+		}
+		if offset < run.Length {
+			head, tail := run, run
+			head.Length = offset
+			tail.Length = run.Length - offset
+
+			left := append(append([]types.AuthorshipRun(nil), runs[:i]...), head)
+			right := append([]types.AuthorshipRun{tail}, runs[i+1:]...)
+			return left, right
+		}
+		offset -= run.Length
+	}
+	return runs, nil
+}
+
+// mergeRuns joins neighbouring runs from the same insert and drops empty
+// ones
+func mergeRuns(runs []types.AuthorshipRun) []types.AuthorshipRun {
+	merged := make([]types.AuthorshipRun, 0, len(runs))
+	for _, run := range runs {
+		if run.Length <= 0 {
+			continue
+		}
+		if last := len(merged) - 1; last >= 0 && merged[last].UserID == run.UserID && merged[last].Version == run.Version {
+			merged[last].Length += run.Length
+			continue
+		}
+		merged = append(merged, run)
+	}
+	return merged
+}
+
+func runsLength(runs []types.AuthorshipRun) int {
+	total := 0
+	for _, run := range runs {
+		total += run.Length
+	}
+	return total
+}
+
+// unknownAuthorship attributes length characters to nobody, for text
+// whose history this node did not see
+func unknownAuthorship(length int) []types.AuthorshipRun {
+	return mergeRuns([]types.AuthorshipRun{{Length: length}})
+}
+
+// trackAuthorship updates the authorship of a document for an operation
+// that produced version. oldLength is the content length before it and
+// deleted the number of characters a delete removed.
+func (ds *DocumentService) trackAuthorship(documentID string, operation *types.Operation, version int, timestamp time.Time, oldLength, deleted int) {
+	ds.storage.UpdateAuthorship(documentID, func(runs []types.AuthorshipRun) []types.AuthorshipRun {
+		if runsLength(runs) != oldLength {
+			runs = unknownAuthorship(oldLength)
+		}
+
+		switch operation.Type {
+		case "insert":
+			return attributeInsert(runs, operation.Position, types.AuthorshipRun{
+				UserID:    operation.UserID,
+				Version:   version,
+				Timestamp: timestamp,
+				Length:    utf8.RuneCountInString(operation.Content),
+			})
+		case "delete":
+			return attributeDelete(runs, operation.Position, deleted)
+		}
+		return runs
+	})
+}
+
+// Authorship returns the content and version of a document together with
+// the authorship runs covering it
+func (ds *DocumentService) Authorship(documentID string) (string, int, []types.AuthorshipRun, error) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	doc, err := ds.storage.GetDocument(documentID)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	runs := ds.storage.GetAuthorship(documentID)
+	if contentLength := utf8.RuneCountInString(doc.Content); runsLength(runs) != contentLength {
+		runs = unknownAuthorship(contentLength)
+	}
+	return doc.Content, doc.Version, runs, nil
+}
+
+// RunAt returns the run covering the character at offset
+func RunAt(runs []types.AuthorshipRun, offset int) types.AuthorshipRun {
+	for _, run := range runs {
+		if offset < run.Length {
+			return run
+		}
+		offset -= run.Length
+	}
+	return types.AuthorshipRun{}
+}
+
+// Blame attributes every line of a document to the users who wrote it.
+// userName resolves user IDs to display names.
+func (ds *DocumentService) Blame(documentID string, userName func(userID string) string) (*types.BlameResponse, error) {
+	content, version, runs, err := ds.Authorship(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	nameOf := func(userID string) string {
+		if userID == "" {
+			return ""
+		}
+		name, ok := names[userID]
+		if !ok {
+			name = userName(userID)
+			names[userID] = name
+		}
+		return name
+	}
+
+	response := &types.BlameResponse{
+		DocumentID: documentID,
+		Version:    version,
+		Lines:      []types.BlameLine{},
+	}
+
+	for i, text := range strings.Split(content, "\n") {
+		length := utf8.RuneCountInString(text)
+		lineRuns, rest := splitRuns(runs, length)
+		lineBreak, remaining := splitRuns(rest, 1)
+		runs = remaining
+
+		line := types.BlameLine{
+			Line:     i + 1,
+			Content:  text,
+			Segments: []types.BlameSegment{},
+		}
+		for _, run := range lineRuns {
+			line.Segments = append(line.Segments, types.BlameSegment{
+				UserID:    run.UserID,
+				UserName:  nameOf(run.UserID),
+				Version:   run.Version,
+				Timestamp: run.Timestamp,
+				Length:    run.Length,
+			})
+			if run.Version >= line.Version {
+				line.UserID = run.UserID
+				line.UserName = nameOf(run.UserID)
+				line.Version = run.Version
+				line.Timestamp = run.Timestamp
+			}
+		}
+		// An empty line belongs to whoever wrote its line break
+		if len(lineRuns) == 0 && len(lineBreak) > 0 {
+			line.UserID = lineBreak[0].UserID
+			line.UserName = nameOf(lineBreak[0].UserID)
+			line.Version = lineBreak[0].Version
+			line.Timestamp = lineBreak[0].Timestamp
+		}
+		response.Lines = append(response.Lines, line)
+	}
+	return response, nil
+}
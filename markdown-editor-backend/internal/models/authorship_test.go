@@ -0,0 +1,163 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+func run(userID string, version, length int) types.AuthorshipRun {
+	return types.AuthorshipRun{UserID: userID, Version: version, Length: length}
+}
+
+func TestAttributeRuns(t *testing.T) {
+	runs := []types.AuthorshipRun{run("ada", 1, 5), run("bob", 2, 3)}
+
+	tests := []struct {
+		name string
+		got  []types.AuthorshipRun
+		want []types.AuthorshipRun
+	}{
+		{"insert inside a run", attributeInsert(runs, 2, run("eve", 3, 4)),
+			[]types.AuthorshipRun{run("ada", 1, 2), run("eve", 3, 4), run("ada", 1, 3), run("bob", 2, 3)}},
+		{"insert between runs", attributeInsert(runs, 5, run("eve", 3, 1)),
+			[]types.AuthorshipRun{run("ada", 1, 5), run("eve", 3, 1), run("bob", 2, 3)}},
+		{"insert at the end", attributeInsert(runs, 8, run("eve", 3, 2)),
+			[]types.AuthorshipRun{run("ada", 1, 5), run("bob", 2, 3), run("eve", 3, 2)}},
+		{"delete across runs", attributeDelete(runs, 3, 3),
+			[]types.AuthorshipRun{run("ada", 1, 3), run("bob", 2, 2)}},
+		{"delete rejoins a split run", attributeDelete(attributeInsert(runs, 2, run("eve", 3, 4)), 2, 4),
+			runs},
+		{"delete past the end", attributeDelete(runs, 4, 100),
+			[]types.AuthorshipRun{run("ada", 1, 4)}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: runs = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if len(runs) != 2 || runs[0].Length != 5 {
+		t.Fatalf("input runs were modified: %v", runs)
+	}
+}
+
+func TestBlame(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	names := map[string]string{"ada": "Ada", "bob": "Bob"}
+
+	doc, err := documents.CreateDocumentWithID("doc", "Blame", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(op types.Operation) {
+		t.Helper()
+		if _, err := documents.ApplyOperation(doc.ID, &op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply(types.Operation{Type: "insert", Position: 0, Content: "title\n\nworld", UserID: "ada"})
+	apply(types.Operation{Type: "insert", Position: 7, Content: "big ", UserID: "bob"})
+	apply(types.Operation{Type: "delete", Position: 0, Length: 2, UserID: "bob"})
+
+	blame, err := documents.Blame(doc.ID, func(userID string) string { return names[userID] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := documents.GetDocument(doc.ID)
+	if blame.Version != 4 || blame.Version != current.Version || len(blame.Lines) != 3 {
+		t.Fatalf("blame = %+v", blame)
+	}
+
+	tests := []struct {
+		content  string
+		userName string
+		segments []string
+	}{
+		{"tle", "Ada", []string{"ada"}},
+		// An empty line belongs to the author of its line break
+		{"", "Ada", nil},
+		{"big world", "Bob", []string{"bob", "ada"}},
+	}
+	for i, tt := range tests {
+		line := blame.Lines[i]
+		if line.Line != i+1 || line.Content != tt.content || line.UserName != tt.userName {
+			t.Errorf("line %d = %+v", i+1, line)
+		}
+		var segments []string
+		for _, segment := range line.Segments {
+			segments = append(segments, segment.UserID)
+		}
+		if !reflect.DeepEqual(segments, tt.segments) {
+			t.Errorf("line %d segments = %v, want %v", i+1, segments, tt.segments)
+		}
+	}
+
+	if _, err := documents.Blame("missing", nil); err != storage.ErrDocumentNotFound {
+		t.Fatalf("missing document: err = %v", err)
+	}
+}
+
+func TestBlameOfCreatedAndUnseenText(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	lineAuthors := func() []string {
+		t.Helper()
+		blame, err := documents.Blame("doc", func(userID string) string { return userID })
+		if err != nil {
+			t.Fatal(err)
+		}
+		var authors []string
+		for _, line := range blame.Lines {
+			authors = append(authors, line.UserID)
+		}
+		return authors
+	}
+
+	if _, err := documents.CreateDocumentWithID("doc", "Blame", "one\ntwo", "ada"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lineAuthors(); !reflect.DeepEqual(got, []string{"ada", "ada"}) {
+		t.Fatalf("line authors = %v, want the creator", got)
+	}
+
+	// Runs that no longer cover the content, as for text synced from a
+	// node whose history this one missed, are attributed to nobody
+	store.UpdateAuthorship("doc", func([]types.AuthorshipRun) []types.AuthorshipRun {
+		return []types.AuthorshipRun{run("ada", 1, 3)}
+	})
+	if got := lineAuthors(); !reflect.DeepEqual(got, []string{"", ""}) {
+		t.Fatalf("line authors = %v, want nobody", got)
+	}
+}
+
+func TestAuthorshipCoversTheContent(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+
+	doc, err := documents.CreateDocumentWithID("doc", "Blame", "one\ntwo", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _, runs, err := documents.Authorship(doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "one\ntwo" || len(runs) != 1 || runs[0].UserID != "ada" || runs[0].Version != 1 || runs[0].Length != 7 {
+		t.Fatalf("authorship of %q = %v, want it all by the creator", content, runs)
+	}
+
+	store.UpdateAuthorship(doc.ID, func([]types.AuthorshipRun) []types.AuthorshipRun {
+		return []types.AuthorshipRun{run("ada", 1, 3)}
+	})
+	_, _, runs, _ = documents.Authorship(doc.ID)
+	if want := []types.AuthorshipRun{run("", 0, 7)}; !reflect.DeepEqual(runs, want) {
+		t.Fatalf("authorship = %v, want %v", runs, want)
+	}
+	if got := RunAt(runs, 6); got.UserID != "" || got.Length != 7 {
+		t.Fatalf("RunAt = %+v", got)
+	}
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+// MaxChatHistory is how many chat messages are kept per document
+const MaxChatHistory = 5000
+
+var (
+	ErrNotChatAuthor = errors.New("chat message belongs to another user")
+	ErrChatDeleted   = errors.New("chat message was deleted")
+)
+
+// ChatService handles the chat of each room
+type ChatService struct {
+	storage *storage.MemoryStorage
+}
+
+// NewChatService creates a new chat service
+func NewChatService(storage *storage.MemoryStorage) *ChatService {
+	return &ChatService{
+		storage: storage,
+	}
+}
+
+// Post adds a message to a room's chat
+func (cs *ChatService) Post(documentID string, author *types.User, content string) (*types.ChatMessage, error) {
+	message := &types.ChatMessage{
+		ID:         uuid.New().String(),
+		DocumentID: documentID,
+		UserID:     author.ID,
+		UserName:   author.Name,
+		Content:    content,
+		CreatedAt:  time.Now(),
+	}
+
+	if err := cs.storage.SaveChatMessage(message, MaxChatHistory); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Edit replaces the text of a message, only for its author
+func (cs *ChatService) Edit(documentID, messageID, userID, content string) (*types.ChatMessage, error) {
+	now := time.Now()
+	return cs.update(documentID, messageID, userID, func(message *types.ChatMessage) {
+		message.Content = content
+		message.EditedAt = &now
+	})
+}
+
+// Delete removes the text of a message, only for its author. The message
+// keeps its place in the history.
+func (cs *ChatService) Delete(documentID, messageID, userID string) (*types.ChatMessage, error) {
+	return cs.update(documentID, messageID, userID, func(message *types.ChatMessage) {
+		message.Content = ""
+		message.Deleted = true
+	})
+}
+
+func (cs *ChatService) update(documentID, messageID, userID string, change func(message *types.ChatMessage)) (*types.ChatMessage, error) {
+	return cs.storage.UpdateChatMessage(documentID, messageID, func(message *types.ChatMessage) error {
+		if message.UserID != userID {
+			return ErrNotChatAuthor
+		}
+		if message.Deleted {
+			return ErrChatDeleted
+		}
+		change(message)
+		return nil
+	})
+}
+
+// SyncMessage stores a message changed on another node
+func (cs *ChatService) SyncMessage(message *types.ChatMessage) error {
+	return cs.storage.SaveChatMessage(message, MaxChatHistory)
+}
+
+// History returns up to limit messages older than the message before, or
+// the latest ones when before is empty, oldest first
+func (cs *ChatService) History(documentID, before string, limit int) ([]types.ChatMessage, bool, error) {
+	return cs.storage.GetChatMessages(documentID, before, limit)
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestChatEditsBelongToTheirAuthor(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	chat := NewChatService(store)
+	ada := &types.User{ID: "ada", Name: "Ada"}
+
+	doc, err := documents.CreateDocumentWithID("doc", "Chat", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chat.Post("missing", ada, "hi"); err != storage.ErrDocumentNotFound {
+		t.Fatalf("post to a missing document: err = %v", err)
+	}
+
+	posted, err := chat.Post(doc.ID, ada, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posted.ID == "" || posted.CreatedAt.IsZero() || posted.UserName != "Ada" {
+		t.Fatalf("posted %+v", posted)
+	}
+
+	if _, err := chat.Edit(doc.ID, posted.ID, "bob", "hijacked"); err != ErrNotChatAuthor {
+		t.Fatalf("edit by another user: err = %v", err)
+	}
+	if _, err := chat.Delete(doc.ID, posted.ID, "bob"); err != ErrNotChatAuthor {
+		t.Fatalf("delete by another user: err = %v", err)
+	}
+	if _, err := chat.Edit(doc.ID, "missing", "ada", "hello"); err != storage.ErrChatNotFound {
+		t.Fatalf("edit of a missing message: err = %v", err)
+	}
+
+	edited, err := chat.Edit(doc.ID, posted.ID, "ada", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Content != "hello" || edited.EditedAt == nil {
+		t.Fatalf("edited %+v", edited)
+	}
+
+	deleted, err := chat.Delete(doc.ID, posted.ID, "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.Deleted || deleted.Content != "" {
+		t.Fatalf("deleted %+v", deleted)
+	}
+	if _, err := chat.Edit(doc.ID, posted.ID, "ada", "back"); err != ErrChatDeleted {
+		t.Fatalf("edit after delete: err = %v", err)
+	}
+
+	// The deleted message keeps its place in the history
+	history, _, _ := chat.History(doc.ID, "", 10)
+	if len(history) != 1 || !history[0].Deleted {
+		t.Fatalf("history = %+v", history)
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	chat := NewChatService(store)
+
+	doc, err := documents.CreateDocumentWithID("doc", "Chat", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Synced messages arriving out of order are kept in time order
+	start := time.Now()
+	for _, i := range []int{0, 2, 1, 4, 3} {
+		message := &types.ChatMessage{ID: string(rune('a' + i)), DocumentID: doc.ID, UserID: "ada", CreatedAt: start.Add(time.Duration(i) * time.Second)}
+		if err := chat.SyncMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(messages []types.ChatMessage) string {
+		var result string
+		for _, message := range messages {
+			result += message.ID
+		}
+		return result
+	}
+	tests := []struct {
+		before  string
+		limit   int
+		want    string
+		hasMore bool
+	}{
+		{"", 10, "abcde", false},
+		{"", 2, "de", true},
+		{"d", 2, "bc", true},
+		{"b", 2, "a", false},
+	}
+	for _, tt := range tests {
+		page, hasMore, err := chat.History(doc.ID, tt.before, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(page); got != tt.want || hasMore != tt.hasMore {
+			t.Errorf("History(%q, %d) = %s, %t, want %s, %t", tt.before, tt.limit, got, hasMore, tt.want, tt.hasMore)
+		}
+	}
+	if _, _, err := chat.History(doc.ID, "missing", 2); err != storage.ErrChatNotFound {
+		t.Fatalf("history before a missing message: err = %v", err)
+	}
+
+	// Only the newest messages are kept
+	for i := 0; i < 3; i++ {
+		store.SaveChatMessage(&types.ChatMessage{ID: string(rune('f' + i)), DocumentID: doc.ID, CreatedAt: start.Add(time.Minute + time.Duration(i))}, 4)
+	}
+	if page, _, _ := chat.History(doc.ID, "", 10); ids(page) != "efgh" {
+		t.Fatalf("bounded history = %s, want efgh", ids(page))
+	}
+}
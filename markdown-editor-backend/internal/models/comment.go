@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+// ErrInvalidCommentRange is returned for a thread anchored outside the
+// document
+var ErrInvalidCommentRange = errors.New("comment range is outside the document")
+
+// CommentService handles comment threads anchored in documents
+type CommentService struct {
+	storage *storage.MemoryStorage
+}
+
+// NewCommentService creates a new comment service
+func NewCommentService(storage *storage.MemoryStorage) *CommentService {
+	return &CommentService{
+		storage: storage,
+	}
+}
+
+// AddThread opens a thread on the text between start and end
+func (cs *CommentService) AddThread(documentID string, start, end int, author *types.User, content string) (*types.CommentThread, error) {
+	doc, err := cs.storage.GetDocument(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(doc.Content)
+	if start < 0 || end < start || end > len(runes) {
+		return nil, ErrInvalidCommentRange
+	}
+
+	now := time.Now()
+	thread := &types.CommentThread{
+		ID:         uuid.New().String(),
+		DocumentID: documentID,
+		Start:      start,
+		End:        end,
+		Quote:      string(runes[start:end]),
+		Comments:   []types.Comment{newComment(author, content, now)},
+		CreatedAt:  now,
+	}
+
+	if err := cs.storage.SaveCommentThread(thread); err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+// Reply adds a comment to a thread
+func (cs *CommentService) Reply(documentID, threadID string, author *types.User, content string) (*types.CommentThread, error) {
+	comment := newComment(author, content, time.Now())
+	return cs.storage.UpdateCommentThread(documentID, threadID, func(thread *types.CommentThread) {
+		thread.Comments = append(thread.Comments, comment)
+	})
+}
+
+// Resolve marks a thread as resolved by a user
+func (cs *CommentService) Resolve(documentID, threadID, userID string) (*types.CommentThread, error) {
+	now := time.Now()
+	return cs.storage.UpdateCommentThread(documentID, threadID, func(thread *types.CommentThread) {
+		thread.Resolved = true
+		thread.ResolvedBy = userID
+		thread.ResolvedAt = &now
+	})
+}
+
+// SyncThread stores a thread changed on another node
+func (cs *CommentService) SyncThread(thread *types.CommentThread) error {
+	return cs.storage.SaveCommentThread(thread)
+}
+
+// GetThreads returns the threads of a document in document order,
+// optionally including resolved ones
+func (cs *CommentService) GetThreads(documentID string, includeResolved bool) ([]*types.CommentThread, error) {
+	threads, err := cs.storage.GetCommentThreads(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*types.CommentThread, 0, len(threads))
+	for _, thread := range threads {
+		if includeResolved || !thread.Resolved {
+			result = append(result, thread)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start < result[j].Start
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func newComment(author *types.User, content string, now time.Time) types.Comment {
+	return types.Comment{
+		ID:        uuid.New().String(),
+		UserID:    author.ID,
+		UserName:  author.Name,
+		Content:   content,
+		CreatedAt: now,
+	}
+}
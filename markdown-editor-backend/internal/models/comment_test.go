@@ -0,0 +1,115 @@
+package models
+
+import (
+	"testing"
+
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestCommentAnchorsFollowTheText(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	comments := NewCommentService(store)
+	author := &types.User{ID: "ada", Name: "Ada"}
+
+	doc, err := documents.CreateDocumentWithID("doc", "Spec", "The quick brown fox", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread, err := comments.AddThread(doc.ID, 4, 9, author, "Too fast?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thread.Quote != "quick" {
+		t.Fatalf("quote = %q, want quick", thread.Quote)
+	}
+
+	anchor := func() (int, int) {
+		t.Helper()
+		threads, err := comments.GetThreads(doc.ID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return threads[0].Start, threads[0].End
+	}
+
+	tests := []struct {
+		name       string
+		op         types.Operation
+		start, end int
+	}{
+		{"insert before", types.Operation{Type: "insert", Position: 0, Content: "So, "}, 8, 13},
+		{"insert at the start stays outside", types.Operation{Type: "insert", Position: 8, Content: "very "}, 13, 18},
+		{"insert at the end stays outside", types.Operation{Type: "insert", Position: 18, Content: "!"}, 13, 18},
+		{"insert inside grows the range", types.Operation{Type: "insert", Position: 15, Content: "i"}, 13, 19},
+		{"delete after", types.Operation{Type: "delete", Position: 20, Length: 6}, 13, 19},
+		{"delete overlapping the start", types.Operation{Type: "delete", Position: 8, Length: 7}, 8, 12},
+		{"delete covering the range", types.Operation{Type: "delete", Position: 4, Length: 100}, 4, 4},
+	}
+	for _, tt := range tests {
+		op := tt.op
+		if _, err := documents.ApplyOperation(doc.ID, &op); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if start, end := anchor(); start != tt.start || end != tt.end {
+			t.Fatalf("%s: anchor = [%d, %d), want [%d, %d)", tt.name, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestCommentThreads(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	comments := NewCommentService(store)
+	ada := &types.User{ID: "ada", Name: "Ada"}
+	bob := &types.User{ID: "bob", Name: "Bob"}
+
+	doc, err := documents.CreateDocumentWithID("doc", "Spec", "héllo world", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range [][2]int{{-1, 2}, {3, 2}, {0, 12}} {
+		if _, err := comments.AddThread(doc.ID, r[0], r[1], ada, "hm"); err != ErrInvalidCommentRange {
+			t.Fatalf("range %v: err = %v, want ErrInvalidCommentRange", r, err)
+		}
+	}
+	if _, err := comments.AddThread("missing", 0, 0, ada, "hm"); err != storage.ErrDocumentNotFound {
+		t.Fatalf("missing document: err = %v", err)
+	}
+
+	later, _ := comments.AddThread(doc.ID, 6, 11, ada, "world?")
+	first, _ := comments.AddThread(doc.ID, 0, 5, ada, "hello?")
+	if first.Quote != "héllo" {
+		t.Fatalf("quote = %q, want héllo", first.Quote)
+	}
+
+	replied, err := comments.Reply(doc.ID, first.ID, bob, "yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replied.Comments) != 2 || replied.Comments[1].UserID != "bob" || replied.Comments[1].UserName != "Bob" {
+		t.Fatalf("comments after reply = %+v", replied.Comments)
+	}
+	if _, err := comments.Reply(doc.ID, "missing", bob, "yes"); err != storage.ErrCommentNotFound {
+		t.Fatalf("reply to a missing thread: err = %v", err)
+	}
+
+	resolved, err := comments.Resolve(doc.ID, later.ID, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resolved.Resolved || resolved.ResolvedBy != "bob" || resolved.ResolvedAt == nil {
+		t.Fatalf("resolved thread = %+v", resolved)
+	}
+
+	open, _ := comments.GetThreads(doc.ID, false)
+	if len(open) != 1 || open[0].ID != first.ID {
+		t.Fatalf("open threads = %+v", open)
+	}
+	all, _ := comments.GetThreads(doc.ID, true)
+	if len(all) != 2 || all[0].ID != first.ID || all[1].ID != later.ID {
+		t.Fatalf("threads are not in document order: %+v", all)
+	}
+}
@@ -0,0 +1,69 @@
+package models
+
+import "markdown-editor-backend/pkg/types"
+
+// transformCursor shifts a stored cursor and its selections through an
+// applied operation and recomputes Line and Column from the new content.
+// deleted is the number of characters the operation actually removed.
+func transformCursor(cursor *types.CursorPosition, op *types.Operation, deleted int, content []rune) {
+	// The author's own caret follows their insert; everyone else's stays
+	// in front of text inserted at their position
+	own := cursor.UserID == op.UserID
+
+	cursor.Position = transformOffset(cursor.Position, op, deleted, own)
+	for i := range cursor.Selections {
+		cursor.Selections[i].Anchor = transformOffset(cursor.Selections[i].Anchor, op, deleted, own)
+		cursor.Selections[i].Head = transformOffset(cursor.Selections[i].Head, op, deleted, own)
+	}
+
+	cursor.Line, cursor.Column = lineColumn(content, cursor.Position)
+}
+
+// transformRange maps a range through an operation. Text inserted at
+// either edge stays outside the range.
+func transformRange(start, end int, op *types.Operation, deleted int) (int, int) {
+	if start == end {
+		start = transformOffset(start, op, deleted, false)
+		return start, start
+	}
+	return transformOffset(start, op, deleted, true), transformOffset(end, op, deleted, false)
+}
+
+// transformOffset maps a character offset from before an operation to
+// after it. moveWithInsert decides whether text inserted exactly at the
+// offset lands before it.
+func transformOffset(offset int, op *types.Operation, deleted int, moveWithInsert bool) int {
+	switch op.Type {
+	case "insert":
+		if offset > op.Position || (offset == op.Position && moveWithInsert) {
+			return offset + len([]rune(op.Content))
+		}
+	case "delete":
+		if offset >= op.Position+deleted {
+			return offset - deleted
+		}
+		if offset > op.Position {
+			return op.Position
+		}
+	}
+	return offset
+}
+
+// lineColumn returns the 1-based line and column of an offset, clamping
+// it to the content
+func lineColumn(content []rune, offset int) (int, int) {
+	if offset > len(content) {
+		offset = len(content)
+	}
+
+	line, column := 1, 1
+	for _, r := range content[:offset] {
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestTransformOffset(t *testing.T) {
+	insert := &types.Operation{Type: "insert", Position: 4, Content: "héllo"}
+	remove := &types.Operation{Type: "delete", Position: 4, Length: 3}
+
+	tests := []struct {
+		name           string
+		offset         int
+		op             *types.Operation
+		moveWithInsert bool
+		want           int
+	}{
+		{"before an insert", 2, insert, false, 2},
+		{"at an insert", 4, insert, false, 4},
+		{"at an insert, moving with it", 4, insert, true, 9},
+		{"after an insert", 6, insert, false, 11},
+		{"before a delete", 3, remove, false, 3},
+		{"inside a delete", 5, remove, false, 4},
+		{"at the end of a delete", 7, remove, false, 4},
+		{"after a delete", 10, remove, false, 7},
+	}
+	for _, tt := range tests {
+		if got := transformOffset(tt.offset, tt.op, 3, tt.moveWithInsert); got != tt.want {
+			t.Errorf("%s: transformOffset(%d) = %d, want %d", tt.name, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestApplyOperationTransformsCursors(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	users := NewUserService(store)
+
+	doc, err := documents.CreateDocumentWithID("doc", "Cursors", "one\ntwo\nthree", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ada's caret is in "three", Bob's at the start of "two" and he has
+	// selected "two"
+	users.UpdateCursor(doc.ID, &types.CursorPosition{UserID: "ada", Position: 10})
+	users.UpdateCursor(doc.ID, &types.CursorPosition{UserID: "bob", Position: 4,
+		Selections: []types.SelectionRange{{Anchor: 7, Head: 4}}})
+
+	apply := func(op types.Operation) {
+		t.Helper()
+		if _, err := documents.ApplyOperation(doc.ID, &op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cursorOf := func(userID string) types.CursorPosition {
+		t.Helper()
+		cursors, err := users.GetCursors(doc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cursor := range cursors {
+			if cursor.UserID == userID {
+				return *cursor
+			}
+		}
+		t.Fatalf("no cursor for %s", userID)
+		return types.CursorPosition{}
+	}
+
+	// Ada adds a line above both carets; Bob's stays in front of her text
+	apply(types.Operation{Type: "insert", Position: 4, Content: "new\n", UserID: "ada"})
+	if got := cursorOf("ada"); got.Position != 14 || got.Line != 4 || got.Column != 3 {
+		t.Fatalf("ada after insert = %+v", got)
+	}
+	want := types.CursorPosition{UserID: "bob", Position: 4, Line: 2, Column: 1,
+		Selections: []types.SelectionRange{{Anchor: 11, Head: 4}}}
+	if got := cursorOf("bob"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bob after insert = %+v, want %+v", got, want)
+	}
+
+	// Bob deletes past the end of the content
+	apply(types.Operation{Type: "delete", Position: 9, Length: 100, UserID: "bob"})
+	if got := cursorOf("ada"); got.Position != 9 || got.Line != 3 || got.Column != 2 {
+		t.Fatalf("ada after delete = %+v", got)
+	}
+	if got := cursorOf("bob").Selections[0]; got.Anchor != 9 || got.Head != 4 {
+		t.Fatalf("bob's selection after delete = %+v", got)
+	}
+}
+
+func TestMirrorOperationTransformsCursors(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	users := NewUserService(store)
+
+	doc, err := documents.CreateDocumentWithID("doc", "Cursors", "abc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.UpdateCursor(doc.ID, &types.CursorPosition{UserID: "ada", Position: 2})
+
+	// The operation was committed elsewhere; the content here is unchanged
+	op := &types.Operation{Type: "insert", Position: 0, Content: "xy", Version: doc.Version + 1}
+	if err := documents.MirrorOperation(doc.ID, op); err != nil {
+		t.Fatal(err)
+	}
+	cursors, _ := users.GetCursors(doc.ID)
+	if cursors[0].Position != 4 || cursors[0].Column != 5 {
+		t.Fatalf("cursor = %+v, want position 4 at column 5", cursors[0])
+	}
+	if current, _ := documents.GetDocument(doc.ID); current.Content != "abc" {
+		t.Fatalf("content changed to %q", current.Content)
+	}
+}
@@ -1,18 +1,31 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"markdown-editor-backend/internal/storage"
 	"markdown-editor-backend/pkg/types"
 )
 
+// operationHistoryLimit is how many committed operations are kept per
+// document for rebasing suggestions
+const operationHistoryLimit = 1000
+
+// ErrChangeSetConflict is returned when a change set no longer applies to
+// the document after rebasing
+var ErrChangeSetConflict = errors.New("change set no longer applies")
+
 // DocumentService handles document-related operations
 type DocumentService struct {
 	storage *storage.MemoryStorage
+	// mutex serializes edits so change sets are applied as one unit
+	mutex sync.Mutex
 }
 
 // NewDocumentService creates a new document service
@@ -37,12 +50,13 @@ func (ds *DocumentService) CreateDocument(title, content string) (*types.Documen
 	if err != nil {
 		return nil, err
 	}
+	ds.seedAuthorship(doc)
 
 	return doc, nil
 }
 
 // CreateDocumentWithID creates a new document with a specific ID
-func (ds *DocumentService) CreateDocumentWithID(id, title, content string) (*types.Document, error) {
+func (ds *DocumentService) CreateDocumentWithID(id, title, content, ownerID string) (*types.Document, error) {
 	doc := &types.Document{
 		ID:           id,
 		RoomCode:     ds.generateRoomCode(),
@@ -50,46 +64,96 @@ func (ds *DocumentService) CreateDocumentWithID(id, title, content string) (*typ
 		Content:      content,
 		LastModified: time.Now(),
 		Version:      1,
+		OwnerID:      ownerID,
 	}
 
 	err := ds.storage.CreateDocument(doc)
 	if err != nil {
 		return nil, err
 	}
+	ds.seedAuthorship(doc)
 
 	return doc, nil
 }
 
+// seedAuthorship attributes the content a document was created with to
+// its owner
+func (ds *DocumentService) seedAuthorship(doc *types.Document) {
+	runs := mergeRuns([]types.AuthorshipRun{{
+		UserID:    doc.OwnerID,
+		Version:   doc.Version,
+		Timestamp: doc.LastModified,
+		Length:    utf8.RuneCountInString(doc.Content),
+	}})
+	ds.storage.UpdateAuthorship(doc.ID, func([]types.AuthorshipRun) []types.AuthorshipRun {
+		return runs
+	})
+}
+
 // GetDocument retrieves a document by ID
 func (ds *DocumentService) GetDocument(id string) (*types.Document, error) {
 	return ds.storage.GetDocument(id)
 }
 
+// GetDocumentCopy returns a copy of one version of a document
+func (ds *DocumentService) GetDocumentCopy(id string) (types.Document, error) {
+	return ds.storage.GetDocumentCopy(id)
+}
+
 // UpdateDocument updates an existing document
 func (ds *DocumentService) UpdateDocument(doc *types.Document) error {
 	return ds.storage.UpdateDocument(doc)
 }
 
+// SyncDocument mirrors a document committed on another node, ignoring
+// states older than the local copy
+func (ds *DocumentService) SyncDocument(doc *types.Document) error {
+	existing, err := ds.storage.GetDocument(doc.ID)
+	if err == nil && existing.Version >= doc.Version {
+		return nil
+	}
+	if err := ds.storage.SaveDocument(doc); err != nil {
+		return err
+	}
+	// A document replicated on creation starts with its owner's text
+	if existing == nil && doc.Version == 1 {
+		ds.seedAuthorship(doc)
+	}
+	return nil
+}
+
 // UpdateDocumentTitle updates only the title of a document
 func (ds *DocumentService) UpdateDocumentTitle(documentID, newTitle string) (*types.Document, error) {
-	doc, err := ds.storage.GetDocument(documentID)
-	if err != nil {
-		return nil, err
-	}
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
 
-	doc.Title = newTitle
-	doc.LastModified = time.Now()
+	return ds.storage.UpdateDocumentTitle(documentID, newTitle, operationHistoryLimit)
+}
 
-	err = ds.storage.UpdateDocument(doc)
-	if err != nil {
-		return nil, err
+// DeleteDocument removes a document and everything attached to it
+func (ds *DocumentService) DeleteDocument(documentID string) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	if _, err := ds.storage.GetDocument(documentID); err != nil {
+		return err
 	}
+	return ds.storage.DeleteDocument(documentID)
+}
 
-	return doc, nil
+// IsDeleted reports whether a document was deleted; its ID is not reused
+func (ds *DocumentService) IsDeleted(documentID string) bool {
+	return ds.storage.IsDeleted(documentID)
 }
 
-// CreateRoom creates a new room with a generated room code
-func (ds *DocumentService) CreateRoom(title, content string) (*types.Document, error) {
+// CreateRoom creates a new room with a generated room code, owned by the
+// user creating it
+func (ds *DocumentService) CreateRoom(title, content, ownerID string) (*types.Document, error) {
+	return ds.CreateRoomWithMetadata(title, content, ownerID, nil)
+}
+
+// CreateRoomWithMetadata creates a room for an imported file
+func (ds *DocumentService) CreateRoomWithMetadata(title, content, ownerID string, metadata map[string]interface{}) (*types.Document, error) {
 	roomCode := ds.generateRoomCode()
 	
 	doc := &types.Document{
@@ -99,12 +163,15 @@ func (ds *DocumentService) CreateRoom(title, content string) (*types.Document, e
 		Content:      content,
 		LastModified: time.Now(),
 		Version:      1,
+		OwnerID:      ownerID,
+		Metadata:     metadata,
 	}
 
 	err := ds.storage.CreateDocument(doc)
 	if err != nil {
 		return nil, err
 	}
+	ds.seedAuthorship(doc)
 
 	return doc, nil
 }
@@ -126,7 +193,54 @@ func (ds *DocumentService) generateRoomCode() string {
 
 // ApplyOperation applies a text operation to a document
 func (ds *DocumentService) ApplyOperation(documentID string, operation *types.Operation) (*types.Document, error) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	return ds.applyOperation(documentID, operation)
+}
+
+// ApplyChangeSet rebases operations made against baseVersion over the
+// operations committed since, then applies them all or none. It returns
+// the operations as applied, each carrying the version it produced.
+func (ds *DocumentService) ApplyChangeSet(documentID string, baseVersion int, operations []types.Operation) ([]types.Operation, *types.Document, error) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
 	doc, err := ds.storage.GetDocument(documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	committed, err := ds.storage.GetOperationsSince(documentID, baseVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	rebased, _ := transformOperations(operations, committed)
+
+	// Check the whole set applies before committing any of it
+	content := doc.Content
+	for i := range rebased {
+		content, err = ds.applyOperationToText(content, &rebased[i])
+		if err != nil {
+			return nil, nil, ErrChangeSetConflict
+		}
+	}
+
+	for i := range rebased {
+		rebased[i].Timestamp = time.Now()
+		doc, err = ds.applyOperation(documentID, &rebased[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		rebased[i].Version = doc.Version
+	}
+	return rebased, doc, nil
+}
+
+func (ds *DocumentService) applyOperation(documentID string, operation *types.Operation) (*types.Document, error) {
+	// Work on a copy so readers of the stored document never see a half
+	// applied operation; UpdateDocument advances the version
+	doc, err := ds.storage.GetDocumentCopy(documentID)
 	if err != nil {
 		return nil, err
 	}
@@ -137,16 +251,75 @@ func (ds *DocumentService) ApplyOperation(documentID string, operation *types.Op
 		return nil, err
 	}
 
+	oldContent := doc.Content
+	oldVersion := doc.Version
 	doc.Content = newContent
-	doc.Version++
-	doc.LastModified = time.Now()
 
-	err = ds.storage.UpdateDocument(doc)
+	err = ds.storage.UpdateDocument(&doc)
 	if err != nil {
 		return nil, err
 	}
 
-	return doc, nil
+	// Keep stored cursors and comment anchors pointing at the same text
+	deleted := ds.transformCursors(documentID, operation, oldContent, newContent)
+	ds.trackAuthorship(documentID, operation, doc.Version, doc.LastModified, utf8.RuneCountInString(oldContent), deleted)
+
+	// Record what was actually removed, a delete may run past the end
+	recorded := *operation
+	recorded.Version = doc.Version
+	if recorded.Type == "delete" {
+		recorded.Length = deleted
+	}
+	ds.storage.AppendOperation(documentID, oldVersion, recorded, operationHistoryLimit)
+
+	return &doc, nil
+}
+
+// MirrorOperation shifts the stored cursors, comment anchors and
+// authorship of a document through an operation committed on another
+// node, before the new content is synced. The operation is added to the
+// history so this node can rebase suggestions if it becomes the writer.
+func (ds *DocumentService) MirrorOperation(documentID string, operation *types.Operation) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	doc, err := ds.storage.GetDocument(documentID)
+	if err != nil {
+		return err
+	}
+
+	newContent, err := ds.applyOperationToText(doc.Content, operation)
+	if err != nil {
+		return err
+	}
+
+	deleted := ds.transformCursors(documentID, operation, doc.Content, newContent)
+	ds.trackAuthorship(documentID, operation, operation.Version, operation.Timestamp, utf8.RuneCountInString(doc.Content), deleted)
+
+	recorded := *operation
+	if recorded.Type == "delete" {
+		recorded.Length = deleted
+	}
+	ds.storage.AppendOperation(documentID, operation.Version-1, recorded, operationHistoryLimit)
+	return nil
+}
+
+// transformCursors returns the number of runes a delete removed
+func (ds *DocumentService) transformCursors(documentID string, operation *types.Operation, oldContent, newContent string) int {
+	// A delete may run past the end of the content
+	deleted := 0
+	if operation.Type == "delete" {
+		deleted = utf8.RuneCountInString(oldContent) - utf8.RuneCountInString(newContent)
+	}
+
+	content := []rune(newContent)
+	ds.storage.TransformCursors(documentID, func(cursor *types.CursorPosition) {
+		transformCursor(cursor, operation, deleted, content)
+	})
+	ds.storage.TransformCommentThreads(documentID, func(thread *types.CommentThread) {
+		thread.Start, thread.End = transformRange(thread.Start, thread.End, operation, deleted)
+	})
+	return deleted
 }
 
 // applyOperationToText applies a single operation to text content
@@ -171,13 +344,16 @@ func (ds *DocumentService) applyOperationToText(content string, op *types.Operat
 		if op.Position < 0 || op.Position >= len(runes) {
 			return content, fmt.Errorf("invalid delete position: %d", op.Position)
 		}
+		if op.Length < 0 {
+			return content, fmt.Errorf("invalid delete length: %d", op.Length)
+		}
 		
 		endPos := op.Position + op.Length
 		if endPos > len(runes) {
 			endPos = len(runes)
 		}
 		
-		result := make([]rune, 0, len(runes)-op.Length)
+		result := make([]rune, 0, len(runes)-(endPos-op.Position))
 		result = append(result, runes[:op.Position]...)
 		result = append(result, runes[endPos:]...)
 		
@@ -202,15 +378,10 @@ func NewUserService(storage *storage.MemoryStorage) *UserService {
 
 // CreateUser creates a new user with a random color
 func (us *UserService) CreateUser(name string) (*types.User, error) {
-	colors := []string{
-		"#FF6B6B", "#4ECDC4", "#45B7D1", "#96CEB4", "#FFEAA7",
-		"#DDA0DD", "#98D8C8", "#F7DC6F", "#BB8FCE", "#85C1E9",
-	}
-
 	user := &types.User{
 		ID:       uuid.New().String(),
 		Name:     name,
-		Color:    colors[rand.Intn(len(colors))],
+		Color:    types.UserColors[rand.Intn(len(types.UserColors))],
 		JoinedAt: time.Now(),
 	}
 
@@ -232,14 +403,16 @@ func (us *UserService) GetUser(id string) (*types.User, error) {
 	return us.storage.GetUser(id)
 }
 
-// JoinDocument adds a user to a document
-func (us *UserService) JoinDocument(userID, documentID string) error {
-	return us.storage.AddUserToDocument(documentID, userID)
+// JoinDocument adds a connection of a user to a document and reports
+// whether the user was not present before
+func (us *UserService) JoinDocument(userID, documentID, connectionID string) (bool, error) {
+	return us.storage.AddConnection(documentID, userID, connectionID)
 }
 
-// LeaveDocument removes a user from a document
-func (us *UserService) LeaveDocument(userID, documentID string) error {
-	return us.storage.RemoveUserFromDocument(documentID, userID)
+// LeaveDocument removes a connection of a user from a document and
+// reports whether the user has no connection left
+func (us *UserService) LeaveDocument(userID, documentID, connectionID string) (bool, error) {
+	return us.storage.RemoveConnection(documentID, userID, connectionID)
 }
 
 // GetDocumentUsers retrieves all users in a document
@@ -252,6 +425,11 @@ func (us *UserService) UpdateCursor(documentID string, position *types.CursorPos
 	return us.storage.UpdateCursor(documentID, position)
 }
 
+// RemoveCursor drops a user's cursor from a document
+func (us *UserService) RemoveCursor(documentID, userID string) error {
+	return us.storage.RemoveCursor(documentID, userID)
+}
+
 // GetCursors retrieves all cursor positions in a document
 func (us *UserService) GetCursors(documentID string) ([]*types.CursorPosition, error) {
 	return us.storage.GetCursors(documentID)
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+// mentionPattern matches @name not preceded by a word character, so email
+// addresses are not taken for mentions
+var mentionPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_])@([\p{L}\p{N}_.\-]+)`)
+
+// Mention is an @name found in a text. Start and End are rune offsets of
+// the whole mention, including the @.
+type Mention struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// ParseMentions returns the mentions in text in order
+func ParseMentions(text string) []Mention {
+	var mentions []Mention
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		// Sentence punctuation after a name is not part of it
+		name := strings.TrimRight(text[match[4]:match[5]], ".-")
+		if name == "" {
+			continue
+		}
+
+		at := match[4] - 1
+		start := utf8.RuneCountInString(text[:at])
+		mentions = append(mentions, Mention{
+			Name:  name,
+			Start: start,
+			End:   start + 1 + utf8.RuneCountInString(name),
+		})
+	}
+	return mentions
+}
+
+// mentionKey is the form names are compared in: lower case without spaces,
+// so "@AliceSmith" finds "Alice Smith"
+func mentionKey(name string) string {
+	return strings.ToLower(strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, name))
+}
+
+// ResolveMention returns the users a mention refers to. Members of the
+// document are preferred; other known users are only considered when no
+// member matches.
+func (us *UserService) ResolveMention(documentID, name string) []*types.User {
+	key := mentionKey(name)
+
+	members, err := us.storage.GetDocumentUsers(documentID)
+	if err == nil {
+		var matches []*types.User
+		for _, member := range members {
+			if mentionKey(member.Name) == key {
+				matches = append(matches, member)
+			}
+		}
+		if len(matches) > 0 {
+			return matches
+		}
+	}
+
+	return us.storage.FindUsersByName(func(userName string) bool {
+		return mentionKey(userName) == key
+	})
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+// Inbox bounds
+const (
+	MaxNotifications = 200
+	MaxExcerptLength = 200
+)
+
+// NotificationService keeps the notification inbox of each user
+type NotificationService struct {
+	storage *storage.MemoryStorage
+}
+
+// NewNotificationService creates a new notification service
+func NewNotificationService(storage *storage.MemoryStorage) *NotificationService {
+	return &NotificationService{
+		storage: storage,
+	}
+}
+
+// NotifyMention records that actor mentioned a user in a document, or in
+// one of its comment threads when threadID is set
+func (ns *NotificationService) NotifyMention(recipientID, documentID, threadID string, actor *types.User, excerpt string) *types.Notification {
+	notification := &types.Notification{
+		ID:         uuid.New().String(),
+		UserID:     recipientID,
+		Type:       types.NotificationMention,
+		DocumentID: documentID,
+		ActorID:    actor.ID,
+		ActorName:  actor.Name,
+		ThreadID:   threadID,
+		Excerpt:    truncateRunes(excerpt, MaxExcerptLength),
+		CreatedAt:  time.Now(),
+	}
+	ns.storage.AddNotification(notification, MaxNotifications)
+	return notification
+}
+
+// SyncNotification stores a notification recorded on another node
+func (ns *NotificationService) SyncNotification(notification *types.Notification) {
+	ns.storage.AddNotification(notification, MaxNotifications)
+}
+
+// GetInbox returns a user's notifications, newest first, optionally only
+// the unread ones
+func (ns *NotificationService) GetInbox(userID string, unreadOnly bool) []types.Notification {
+	notifications := ns.storage.GetNotifications(userID)
+
+	result := make([]types.Notification, 0, len(notifications))
+	for _, notification := range notifications {
+		if !unreadOnly || !notification.Read {
+			result = append(result, notification)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// MarkRead marks notifications as read, all of them when ids is empty, and
+// returns the IDs that changed
+func (ns *NotificationService) MarkRead(userID string, ids []string) []string {
+	return ns.storage.MarkNotificationsRead(userID, ids)
+}
+
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
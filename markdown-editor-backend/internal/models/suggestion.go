@@ -0,0 +1,177 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+// MaxSuggestionOperations bounds the operations in one suggestion
+const MaxSuggestionOperations = 500
+
+var (
+	ErrSuggestionResolved  = errors.New("suggestion is already resolved")
+	ErrSuggestionTooLarge  = errors.New("suggestion has too many operations")
+	ErrNotSuggestionAuthor = errors.New("suggestion belongs to another user")
+	ErrNotDocumentOwner    = errors.New("only the document owner can do this")
+)
+
+// SuggestionService handles change sets proposed instead of committed
+type SuggestionService struct {
+	storage   *storage.MemoryStorage
+	documents *DocumentService
+}
+
+// NewSuggestionService creates a new suggestion service
+func NewSuggestionService(storage *storage.MemoryStorage, documents *DocumentService) *SuggestionService {
+	return &SuggestionService{
+		storage:   storage,
+		documents: documents,
+	}
+}
+
+// AddOperation appends an operation to the author's suggestion. The first
+// operation creates it, based on the version the operation was made at.
+func (ss *SuggestionService) AddOperation(documentID, suggestionID string, author *types.User, op types.Operation) (*types.Suggestion, error) {
+	op.UserID = author.ID
+	op.Timestamp = time.Now()
+
+	suggestion, err := ss.storage.UpdateSuggestion(documentID, suggestionID, func(suggestion *types.Suggestion) error {
+		if suggestion.UserID != author.ID {
+			return ErrNotSuggestionAuthor
+		}
+		if suggestion.Status != types.SuggestionPending {
+			return ErrSuggestionResolved
+		}
+		if len(suggestion.Operations) >= MaxSuggestionOperations {
+			return ErrSuggestionTooLarge
+		}
+		suggestion.Operations = append(suggestion.Operations, op)
+		return nil
+	})
+	if err != storage.ErrSuggestionNotFound {
+		return suggestion, err
+	}
+
+	doc, err := ss.storage.GetDocument(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clients send the version they expect the operation to produce
+	baseVersion := op.Version - 1
+	if baseVersion < 1 || baseVersion > doc.Version {
+		baseVersion = doc.Version
+	}
+
+	suggestion = &types.Suggestion{
+		ID:          suggestionID,
+		DocumentID:  documentID,
+		UserID:      author.ID,
+		UserName:    author.Name,
+		BaseVersion: baseVersion,
+		Operations:  []types.Operation{op},
+		Status:      types.SuggestionPending,
+		CreatedAt:   op.Timestamp,
+	}
+	if err := ss.storage.SaveSuggestion(suggestion); err != nil {
+		return nil, err
+	}
+	return suggestion, nil
+}
+
+// Accept commits a pending suggestion on behalf of the document owner. It
+// returns the operations as applied and the resulting document. Documents
+// without an owner cannot accept suggestions.
+func (ss *SuggestionService) Accept(documentID, suggestionID, userID string) (*types.Suggestion, []types.Operation, *types.Document, error) {
+	doc, err := ss.storage.GetDocument(documentID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !isOwner(doc, userID) {
+		return nil, nil, nil, ErrNotDocumentOwner
+	}
+
+	// Claim the suggestion first so it cannot be applied twice
+	suggestion, err := ss.resolve(documentID, suggestionID, userID, types.SuggestionAccepted)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	applied, doc, err := ss.documents.ApplyChangeSet(documentID, suggestion.BaseVersion, suggestion.Operations)
+	if err != nil {
+		ss.storage.UpdateSuggestion(documentID, suggestionID, func(suggestion *types.Suggestion) error {
+			suggestion.Status = types.SuggestionPending
+			suggestion.ResolvedBy = ""
+			suggestion.ResolvedAt = nil
+			return nil
+		})
+		return nil, nil, nil, err
+	}
+	return suggestion, applied, doc, nil
+}
+
+// Reject discards a pending suggestion. The document owner and the author
+// may reject it.
+func (ss *SuggestionService) Reject(documentID, suggestionID, userID string) (*types.Suggestion, error) {
+	doc, err := ss.storage.GetDocument(documentID)
+	if err != nil {
+		return nil, err
+	}
+	suggestion, err := ss.storage.GetSuggestion(documentID, suggestionID)
+	if err != nil {
+		return nil, err
+	}
+	if !isOwner(doc, userID) && suggestion.UserID != userID {
+		return nil, ErrNotDocumentOwner
+	}
+
+	return ss.resolve(documentID, suggestionID, userID, types.SuggestionRejected)
+}
+
+// isOwner reports whether userID owns the document; nobody does when it
+// has no owner
+func isOwner(doc *types.Document, userID string) bool {
+	return doc.OwnerID != "" && doc.OwnerID == userID
+}
+
+func (ss *SuggestionService) resolve(documentID, suggestionID, userID, status string) (*types.Suggestion, error) {
+	now := time.Now()
+	return ss.storage.UpdateSuggestion(documentID, suggestionID, func(suggestion *types.Suggestion) error {
+		if suggestion.Status != types.SuggestionPending {
+			return ErrSuggestionResolved
+		}
+		suggestion.Status = status
+		suggestion.ResolvedBy = userID
+		suggestion.ResolvedAt = &now
+		return nil
+	})
+}
+
+// SyncSuggestion stores a suggestion changed on another node
+func (ss *SuggestionService) SyncSuggestion(suggestion *types.Suggestion) error {
+	return ss.storage.SaveSuggestion(suggestion)
+}
+
+// GetPending returns the pending suggestions of a document, oldest first
+func (ss *SuggestionService) GetPending(documentID string) ([]*types.Suggestion, error) {
+	suggestions, err := ss.storage.GetSuggestions(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*types.Suggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		if suggestion.Status == types.SuggestionPending {
+			result = append(result, suggestion)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"markdown-editor-backend/internal/storage"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestOnlyTheOwnerResolvesSuggestions(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	suggestions := NewSuggestionService(store, documents)
+
+	owned, err := documents.CreateDocumentWithID("owned", "Owned", "text", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unowned, err := documents.CreateDocument("Unowned", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	author := &types.User{ID: "author", Name: "Author"}
+	suggest := func(documentID, suggestionID string) {
+		t.Helper()
+		op := types.Operation{Type: "insert", Position: 0, Content: "more ", Version: 2}
+		if _, err := suggestions.AddOperation(documentID, suggestionID, author, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		documentID string
+		accept     bool
+		userID     string
+		want       error
+	}{
+		{"owner accepts", owned.ID, true, "owner", nil},
+		{"someone else accepts", owned.ID, true, "intruder", ErrNotDocumentOwner},
+		{"author accepts own suggestion", owned.ID, true, "author", ErrNotDocumentOwner},
+		{"anyone accepts on an ownerless document", unowned.ID, true, "intruder", ErrNotDocumentOwner},
+		{"owner rejects", owned.ID, false, "owner", nil},
+		{"author rejects own suggestion", unowned.ID, false, "author", nil},
+		{"someone else rejects", owned.ID, false, "intruder", ErrNotDocumentOwner},
+		{"anyone rejects on an ownerless document", unowned.ID, false, "intruder", ErrNotDocumentOwner},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestionID := string(rune('a' + i))
+			suggest(tt.documentID, suggestionID)
+
+			var err error
+			if tt.accept {
+				_, _, _, err = suggestions.Accept(tt.documentID, suggestionID, tt.userID)
+			} else {
+				_, err = suggestions.Reject(tt.documentID, suggestionID, tt.userID)
+			}
+			if err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptedSuggestionsAreRebased(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	suggestions := NewSuggestionService(store, documents)
+	author := &types.User{ID: "author", Name: "Author"}
+
+	doc, err := documents.CreateDocumentWithID("doc", "Doc", "Hello world", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := doc.Version
+
+	// Two edits in one suggestion, the second made on top of the first
+	for _, op := range []types.Operation{
+		{Type: "insert", Position: 6, Content: "big ", Version: base + 1},
+		{Type: "insert", Position: 15, Content: "!", Version: base + 2},
+	} {
+		if _, err := suggestions.AddOperation(doc.ID, "s", author, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := suggestions.AddOperation(doc.ID, "s", &types.User{ID: "other"}, types.Operation{Type: "insert", Content: "x"}); err != ErrNotSuggestionAuthor {
+		t.Fatalf("adding to another user's suggestion: err = %v", err)
+	}
+
+	// Pending suggestions leave the content alone while others keep editing
+	if _, err := documents.ApplyOperation(doc.ID, &types.Operation{Type: "insert", Position: 0, Content: "Oh, ", Version: base + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := documents.GetDocument(doc.ID); current.Content != "Oh, Hello world" {
+		t.Fatalf("content before accepting = %q", current.Content)
+	}
+
+	suggestion, applied, accepted, err := suggestions.Accept(doc.ID, "s", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Content != "Oh, Hello big world!" {
+		t.Fatalf("content after accepting = %q", accepted.Content)
+	}
+	if len(applied) != 2 || applied[0].Position != 10 || suggestion.Status != types.SuggestionAccepted {
+		t.Fatalf("applied %+v as %+v", applied, suggestion)
+	}
+	if pending, _ := suggestions.GetPending(doc.ID); len(pending) != 0 {
+		t.Fatalf("pending after accepting = %+v", pending)
+	}
+	if _, _, _, err := suggestions.Accept(doc.ID, "s", "owner"); err != ErrSuggestionResolved {
+		t.Fatalf("accepting twice: err = %v", err)
+	}
+}
+
+func TestRejectedSuggestionsAreNotApplied(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	suggestions := NewSuggestionService(store, documents)
+	author := &types.User{ID: "author", Name: "Author"}
+
+	doc, err := documents.CreateDocumentWithID("doc", "Doc", "text", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := suggestions.AddOperation(doc.ID, "s", author, types.Operation{Type: "delete", Position: 0, Length: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected, err := suggestions.Reject(doc.ID, "s", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Status != types.SuggestionRejected || rejected.ResolvedBy != "owner" {
+		t.Fatalf("rejected %+v", rejected)
+	}
+	if current, _ := documents.GetDocument(doc.ID); current.Content != "text" || current.Version != doc.Version {
+		t.Fatalf("rejecting changed the document to %q at version %d", current.Content, current.Version)
+	}
+	if _, err := suggestions.AddOperation(doc.ID, "s", author, types.Operation{Type: "insert", Content: "x"}); err != ErrSuggestionResolved {
+		t.Fatalf("adding to a rejected suggestion: err = %v", err)
+	}
+}
+
+func TestSuggestionsRebaseOverLaterVersionsOnly(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	suggestions := NewSuggestionService(store, documents)
+	author := &types.User{ID: "author", Name: "Author"}
+
+	doc, err := documents.CreateDocumentWithID("doc", "Doc", "world", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := doc.Version
+
+	// Every operation advances the version by one
+	first, err := documents.ApplyOperation(doc.ID, &types.Operation{Type: "insert", Position: 0, Content: "Hello ", Version: base + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != base+1 {
+		t.Fatalf("version after one operation = %d, want %d", first.Version, base+1)
+	}
+
+	// The suggestion is made on top of the first edit, so only the second
+	// edit is rebased over
+	if _, err := suggestions.AddOperation(doc.ID, "s", author, types.Operation{Type: "insert", Position: 11, Content: "!", Version: base + 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := documents.ApplyOperation(doc.ID, &types.Operation{Type: "insert", Position: 0, Content: "Oh, ", Version: base + 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, applied, accepted, err := suggestions.Accept(doc.ID, "s", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Content != "Oh, Hello world!" || accepted.Version != base+3 {
+		t.Fatalf("accepted %q at version %d", accepted.Content, accepted.Version)
+	}
+	if len(applied) != 1 || applied[0].Position != 15 || applied[0].Version != base+3 {
+		t.Fatalf("applied %+v", applied)
+	}
+}
+
+func TestSuggestionsSurviveRenames(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	documents := NewDocumentService(store)
+	suggestions := NewSuggestionService(store, documents)
+	author := &types.User{ID: "author", Name: "Author"}
+
+	doc, err := documents.CreateDocumentWithID("doc", "Doc", "world", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := suggestions.AddOperation(doc.ID, "s", author, types.Operation{Type: "insert", Position: 5, Content: "!", Version: doc.Version + 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed, err := documents.UpdateDocumentTitle(doc.ID, "Greeting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := documents.ApplyOperation(doc.ID, &types.Operation{Type: "insert", Position: 0, Content: "Hello ", Version: renamed.Version + 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, accepted, err := suggestions.Accept(doc.ID, "s", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Content != "Hello world!" || accepted.Title != "Greeting" {
+		t.Fatalf("accepted %q titled %q", accepted.Content, accepted.Title)
+	}
+}
+
+func TestRenamesDoNotLoseOperations(t *testing.T) {
+	documents := NewDocumentService(storage.NewMemoryStorage())
+	doc, err := documents.CreateDocument("Doc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if _, err := documents.UpdateDocumentTitle(doc.ID, fmt.Sprintf("Doc %d", i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := documents.ApplyOperation(doc.ID, &types.Operation{Type: "insert", Content: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	current, _ := documents.GetDocument(doc.ID)
+	if current.Content != strings.Repeat("x", 100) || current.Title != "Doc 99" || current.Version != 201 {
+		t.Fatalf("after concurrent edits: %q titled %q at version %d", current.Content, current.Title, current.Version)
+	}
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"unicode/utf8"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+// transformOperations rebases xs over as, two operation sequences made
+// against the same text. It returns xs adjusted to apply after as, and as
+// adjusted to apply after xs. When both insert at the same position the
+// text from as comes first.
+func transformOperations(xs, as []types.Operation) ([]types.Operation, []types.Operation) {
+	if len(xs) == 0 || len(as) == 0 {
+		return xs, as
+	}
+	if len(xs) == 1 && len(as) == 1 {
+		return transformPair(xs[0], as[0])
+	}
+
+	if len(xs) > 1 {
+		x1, a1 := transformOperations(xs[:1], as)
+		x2, a2 := transformOperations(xs[1:], a1)
+		return concatOperations(x1, x2), a2
+	}
+
+	x1, a1 := transformOperations(xs, as[:1])
+	x2, a2 := transformOperations(x1, as[1:])
+	return x2, concatOperations(a1, a2)
+}
+
+// transformPair transforms two concurrent operations against each other.
+// A delete spanning the other side's insert is split in two around it, and
+// deletes that overlap only remove what is left.
+func transformPair(x, a types.Operation) ([]types.Operation, []types.Operation) {
+	switch {
+	case x.Type == "insert" && a.Type == "insert":
+		if x.Position < a.Position {
+			return one(x), one(shifted(a, runeLen(x.Content)))
+		}
+		return one(shifted(x, runeLen(a.Content))), one(a)
+
+	case x.Type == "insert" && a.Type == "delete":
+		a2, x2 := transformInsertDelete(x, a)
+		return x2, a2
+
+	case x.Type == "delete" && a.Type == "insert":
+		return transformInsertDelete(a, x)
+
+	default:
+		return transformDeletes(x, a), transformDeletes(a, x)
+	}
+}
+
+// transformInsertDelete transforms a delete over a concurrent insert and
+// the insert over the delete, in that order
+func transformInsertDelete(ins, del types.Operation) ([]types.Operation, []types.Operation) {
+	insLen := runeLen(ins.Content)
+	end := del.Position + del.Length
+
+	switch {
+	case ins.Position <= del.Position:
+		return one(shifted(del, insLen)), one(ins)
+	case ins.Position >= end:
+		return one(del), one(shifted(ins, -del.Length))
+	default:
+		before := del
+		before.Length = ins.Position - del.Position
+		after := del
+		after.Position = del.Position + insLen
+		after.Length = end - ins.Position
+
+		moved := ins
+		moved.Position = del.Position
+		return []types.Operation{before, after}, one(moved)
+	}
+}
+
+// transformDeletes returns x without the text a already removed, moved to
+// apply after a
+func transformDeletes(x, a types.Operation) []types.Operation {
+	xEnd := x.Position + x.Length
+	aEnd := a.Position + a.Length
+
+	overlap := overlapLength(x.Position, xEnd, a.Position, aEnd)
+	before := overlapLength(0, x.Position, a.Position, aEnd)
+
+	x.Position -= before
+	x.Length -= overlap
+	if x.Length <= 0 {
+		return nil
+	}
+	return one(x)
+}
+
+func overlapLength(start, end, otherStart, otherEnd int) int {
+	if otherStart > start {
+		start = otherStart
+	}
+	if otherEnd < end {
+		end = otherEnd
+	}
+	if end < start {
+		return 0
+	}
+	return end - start
+}
+
+func shifted(op types.Operation, delta int) types.Operation {
+	op.Position += delta
+	return op
+}
+
+func one(op types.Operation) []types.Operation {
+	return []types.Operation{op}
+}
+
+func concatOperations(a, b []types.Operation) []types.Operation {
+	result := make([]types.Operation, 0, len(a)+len(b))
+	result = append(result, a...)
+	return append(result, b...)
+}
+
+func runeLen(s string) int {
+	return utf8.RuneCountInString(s)
+}
@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"markdown-editor-backend/pkg/types"
+)
+
+// Subprotocol names a client can request in Sec-WebSocket-Protocol
+const (
+	SubprotocolJSON    = "markdowntogether.json"
+	SubprotocolMsgpack = "markdowntogether.msgpack"
+)
+
+// Codec encodes WebSocket messages for one wire format
+type Codec interface {
+	// Subprotocol is the name negotiated during the upgrade
+	Subprotocol() string
+	// FrameType is the WebSocket frame type messages are written with
+	FrameType() int
+	Encode(message *types.WebSocketMessage) ([]byte, error)
+	// Decode leaves the payload encoded; use WebSocketMessage.DecodePayload
+	Decode(data []byte) (*types.WebSocketMessage, error)
+}
+
+var (
+	JSON        Codec = jsonCodec{}
+	MessagePack Codec = msgpackCodec{}
+)
+
+// Subprotocols lists the supported subprotocols in order of server
+// preference, for use in websocket.Upgrader
+func Subprotocols() []string {
+	return []string{SubprotocolMsgpack, SubprotocolJSON}
+}
+
+// ForSubprotocol returns the codec for a negotiated subprotocol. Clients
+// that did not request one get JSON.
+func ForSubprotocol(subprotocol string) Codec {
+	if subprotocol == SubprotocolMsgpack {
+		return MessagePack
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+
+func (jsonCodec) FrameType() int { return websocket.TextMessage }
+
+func (jsonCodec) Encode(message *types.WebSocketMessage) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonCodec) Decode(data []byte) (*types.WebSocketMessage, error) {
+	var message types.WebSocketMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// msgpackCodec reuses the json struct tags so both encodings share one
+// set of field names
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgpack }
+
+func (msgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(message *types.WebSocketMessage) ([]byte, error) {
+	// Messages relayed from another node still carry a raw JSON payload
+	if raw, ok := message.Payload.(json.RawMessage); ok {
+		var payload interface{}
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return nil, err
+		}
+		copied := *message
+		copied.Payload = payload
+		message = &copied
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte) (*types.WebSocketMessage, error) {
+	var raw struct {
+		Type    string             `msgpack:"type"`
+		Payload msgpack.RawMessage `msgpack:"payload"`
+		UserID  string             `msgpack:"userId"`
+	}
+	if err := msgpack.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	return &types.WebSocketMessage{
+		Type:    raw.Type,
+		Payload: msgpackPayload(raw.Payload),
+		UserID:  raw.UserID,
+	}, nil
+}
+
+// msgpackPayload is a payload still in MessagePack encoding
+type msgpackPayload []byte
+
+func (p msgpackPayload) DecodePayload(v interface{}) error {
+	if len(p) == 0 {
+		return nil
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(p))
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	return dec.Decode(v)
+}
@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	sent := types.CursorPayload{
+		DocumentID: "doc",
+		Position: types.CursorPosition{
+			UserID:   "ada",
+			Position: 12,
+			Line:     2,
+			Column:   4,
+		},
+	}
+
+	for _, codec := range []Codec{JSON, MessagePack} {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			data, err := codec.Encode(&types.WebSocketMessage{Type: types.MessageTypeCursor, Payload: sent, UserID: "ada"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			message, err := codec.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.Type != types.MessageTypeCursor || message.UserID != "ada" {
+				t.Fatalf("decoded %+v", message)
+			}
+
+			var received types.CursorPayload
+			if err := message.DecodePayload(&received); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(received, sent) {
+				t.Fatalf("payload = %+v, want %+v", received, sent)
+			}
+		})
+	}
+}
+
+func TestMessagePackUsesJSONFieldNames(t *testing.T) {
+	data, err := MessagePack.Encode(&types.WebSocketMessage{
+		Type:    types.MessageTypeTitleUpdate,
+		Payload: types.TitleUpdatePayload{DocumentID: "doc", NewTitle: "Notes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if err := msgpack.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"type":    types.MessageTypeTitleUpdate,
+		"payload": map[string]interface{}{"documentId": "doc", "newTitle": "Notes"},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Fatalf("encoded %v, want %v", decoded, want)
+	}
+}
+
+func TestMessagePackEncodesRelayedJSONPayloads(t *testing.T) {
+	// Messages from other nodes arrive with their payload still in JSON
+	relayed := &types.WebSocketMessage{
+		Type:    types.MessageTypeTitleUpdate,
+		Payload: json.RawMessage(`{"documentId":"doc","newTitle":"Notes"}`),
+	}
+	data, err := MessagePack.Encode(relayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := relayed.Payload.(json.RawMessage); !ok {
+		t.Fatal("encoding changed the relayed message")
+	}
+
+	message, err := MessagePack.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload types.TitleUpdatePayload
+	if err := message.DecodePayload(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.DocumentID != "doc" || payload.NewTitle != "Notes" {
+		t.Fatalf("payload = %+v", payload)
+	}
+}
+
+func TestMessagePackRejectsUnknownFields(t *testing.T) {
+	data, err := msgpack.Marshal(map[string]interface{}{
+		"type":    types.MessageTypeTitleUpdate,
+		"payload": map[string]interface{}{"documentId": "doc", "newTitle": "Notes", "admin": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := MessagePack.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload types.TitleUpdatePayload
+	if err := message.DecodePayload(&payload); err == nil {
+		t.Fatal("unknown payload field accepted")
+	}
+
+	if _, err := MessagePack.Decode([]byte{0xc1}); err == nil {
+		t.Fatal("invalid MessagePack accepted")
+	}
+}
+
+func TestForSubprotocol(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		want        Codec
+		frameType   int
+	}{
+		{SubprotocolMsgpack, MessagePack, websocket.BinaryMessage},
+		{SubprotocolJSON, JSON, websocket.TextMessage},
+		{"", JSON, websocket.TextMessage},
+		{"unknown", JSON, websocket.TextMessage},
+	}
+	for _, tt := range tests {
+		codec := ForSubprotocol(tt.subprotocol)
+		if codec != tt.want || codec.FrameType() != tt.frameType {
+			t.Errorf("ForSubprotocol(%q) = %s, frame type %d", tt.subprotocol, codec.Subprotocol(), codec.FrameType())
+		}
+	}
+	if Subprotocols()[0] != SubprotocolMsgpack {
+		t.Fatalf("Subprotocols() = %v, want MessagePack preferred", Subprotocols())
+	}
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit describes a token bucket: Rate tokens are added per second, up
+// to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Every returns a limit allowing one event per interval
+func Every(interval time.Duration, burst int) Limit {
+	return Limit{Rate: float64(time.Second) / float64(interval), Burst: burst}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps one token bucket per key (connection, user, IP, ...)
+type Limiter struct {
+	limit     Limit
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+// NewLimiter creates a new keyed limiter
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit:     limit,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token for key. When the bucket is empty it returns false
+// and how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b := l.refill(key, time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, l.wait(b)
+}
+
+// Available returns how long until key has a token, zero if it has one
+// now. Unlike Allow it does not take the token.
+func (l *Limiter) Available(key string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b := l.refill(key, time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return l.wait(b)
+}
+
+// refill returns the bucket of key with the tokens added since it was
+// last used. It must be called with the mutex held.
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	l.sweep(now)
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+elapsed*l.limit.Rate)
+	b.last = now
+	return b
+}
+
+// wait returns how long until b holds a whole token
+func (l *Limiter) wait(b *bucket) time.Duration {
+	return time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, since they behave
+// exactly like new ones
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
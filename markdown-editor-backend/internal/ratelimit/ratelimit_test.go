@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterDeniesOnceTheBurstIsSpent(t *testing.T) {
+	limiter := NewLimiter(Limit{Rate: 1, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("ada"); !allowed {
+			t.Fatalf("request %d of the burst was denied", i+1)
+		}
+	}
+	allowed, wait := limiter.Allow("ada")
+	if allowed {
+		t.Fatal("a request past the burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("wait = %v, want up to one second for the next token", wait)
+	}
+
+	// Every key has its own bucket
+	if allowed, _ := limiter.Allow("bob"); !allowed {
+		t.Fatal("another key was denied")
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	limiter := NewLimiter(Every(10*time.Millisecond, 1))
+
+	if allowed, _ := limiter.Allow("ada"); !allowed {
+		t.Fatal("first request denied")
+	}
+	allowed, wait := limiter.Allow("ada")
+	if allowed {
+		t.Fatal("second request allowed before a refill")
+	}
+
+	time.Sleep(wait + 5*time.Millisecond)
+	if allowed, _ := limiter.Allow("ada"); !allowed {
+		t.Fatal("request denied after the announced wait")
+	}
+}
+
+func TestEvery(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		rate     float64
+	}{
+		{time.Second, 1},
+		{500 * time.Millisecond, 2},
+		{10 * time.Second, 0.1},
+	}
+	for _, tt := range tests {
+		if limit := Every(tt.interval, 4); limit.Rate != tt.rate || limit.Burst != 4 {
+			t.Errorf("Every(%v, 4) = %+v, want rate %v", tt.interval, limit, tt.rate)
+		}
+	}
+}
+
+func TestAvailableDoesNotTakeTokens(t *testing.T) {
+	limiter := NewLimiter(Limit{Rate: 1, Burst: 1})
+
+	for i := 0; i < 3; i++ {
+		if wait := limiter.Available("ada"); wait != 0 {
+			t.Fatalf("Available = %v before any token was taken", wait)
+		}
+	}
+	if allowed, _ := limiter.Allow("ada"); !allowed {
+		t.Fatal("the token reported available was refused")
+	}
+	if wait := limiter.Available("ada"); wait <= 0 || wait > time.Second {
+		t.Fatalf("Available = %v after the burst, want up to one second", wait)
+	}
+}
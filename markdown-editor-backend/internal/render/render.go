@@ -0,0 +1,111 @@
+package render
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"markdown-editor-backend/pkg/types"
+)
+
+// maxCachedDocuments bounds the cache; one entry is dropped when full
+const maxCachedDocuments = 1000
+
+// Renderer converts Markdown to sanitized HTML: CommonMark with GFM
+// tables, strikethrough, autolinks and task lists, footnotes and heading
+// anchors. Results are cached for each document version.
+type Renderer struct {
+	markdown goldmark.Markdown
+	policy   *bluemonday.Policy
+	cache    map[string]cachedRender // documentID -> latest rendering
+	mutex    sync.Mutex
+}
+
+type cachedRender struct {
+	version int
+	html    string
+}
+
+// NewRenderer creates a renderer with an empty cache
+func NewRenderer() *Renderer {
+	return &Renderer{
+		markdown: goldmark.New(
+			goldmark.WithExtensions(extension.GFM, extension.Footnote),
+			goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+			// Raw HTML is passed through and cleaned up by the policy
+			goldmark.WithRendererOptions(html.WithUnsafe()),
+		),
+		policy: newPolicy(),
+		cache:  make(map[string]cachedRender),
+	}
+}
+
+// newPolicy allows user generated content plus what the Markdown
+// extensions produce
+func newPolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^footnote(s|-ref|-backref)$`)).OnElements("a", "div")
+	policy.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|endnotes|backlink)$`)).OnElements("a", "div")
+	policy.AllowAttrs("style").Matching(regexp.MustCompile(`^text-align:(left|center|right)$`)).OnElements("th", "td")
+	// Keeps exports self-contained; the image data is validated
+	policy.AllowDataURIImages()
+	return policy
+}
+
+// Render converts Markdown to sanitized HTML without caching
+func (r *Renderer) Render(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := r.markdown.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return r.policy.Sanitize(buf.String()), nil
+}
+
+// RenderDocument renders the document content, reusing the cached HTML
+// while the version is unchanged
+func (r *Renderer) RenderDocument(doc *types.Document) (string, error) {
+	documentID, version, content := doc.ID, doc.Version, doc.Content
+
+	r.mutex.Lock()
+	cached, exists := r.cache[documentID]
+	r.mutex.Unlock()
+	if exists && cached.version == version {
+		return cached.html, nil
+	}
+
+	rendered, err := r.Render(content)
+	if err != nil {
+		return "", err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.cache[documentID]; !exists && len(r.cache) >= maxCachedDocuments {
+		for id := range r.cache {
+			delete(r.cache, id)
+			break
+		}
+	}
+	// A slower render of an older version must not replace a newer one
+	if current, exists := r.cache[documentID]; !exists || current.version <= version {
+		r.cache[documentID] = cachedRender{version: version, html: rendered}
+	}
+	return rendered, nil
+}
+
+// Forget drops the cached rendering of a deleted document
+func (r *Renderer) Forget(documentID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.cache, documentID)
+}
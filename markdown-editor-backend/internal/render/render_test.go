@@ -0,0 +1,81 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestSanitizationPolicy(t *testing.T) {
+	r := NewRenderer()
+
+	tests := []struct {
+		name     string
+		source   string
+		want     []string
+		stripped []string
+	}{
+		{"script", "<script>alert(1)</script>after", []string{"after"}, []string{"<script", "alert(1)"}},
+		{"event handler", `<img src="x.png" onerror="alert(1)">`, []string{`<img src="x.png"`}, []string{"onerror", "alert"}},
+		{"javascript link", "[click](javascript:alert(1))", []string{"click"}, []string{"href", "javascript"}},
+		{"javascript href", `<a href="JaVaScRiPt:alert(1)">click</a>`, []string{"click"}, []string{"href", "alert"}},
+		{"inline style", `<div style="position:fixed">x</div>`, []string{"<div>x</div>"}, []string{"style"}},
+		{"task list", "- [x] done\n- [ ] todo",
+			[]string{`<input checked="" disabled="" type="checkbox">`, `<input disabled="" type="checkbox">`}, nil},
+		{"footnotes", "text[^1]\n\n[^1]: note",
+			[]string{
+				`class="footnote-ref" role="doc-noteref"`,
+				`<div class="footnotes" role="doc-endnotes">`,
+				`class="footnote-backref" role="doc-backlink"`,
+				`id="fn:1"`,
+			}, nil},
+		{"footnote class on other elements", `<span class="footnotes" role="doc-endnotes">x</span>`,
+			[]string{"<span>x</span>"}, []string{"class", "role"}},
+		{"heading id", "# Hello World", []string{`<h1 id="hello-world">Hello World</h1>`}, nil},
+		{"table alignment", "| a | b | c |\n|:--|:-:|--:|\n| 1 | 2 | 3 |",
+			[]string{`<th style="text-align:left">`, `<td style="text-align:center">`, `<td style="text-align:right">`}, nil},
+		{"other cell styles", `<table><tr><td style="background:url(x)">1</td></tr></table>`,
+			[]string{"<td>1</td>"}, []string{"style"}},
+		{"code language", "```go\nfmt.Println()\n```", []string{`<code class="language-go">`}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, err := r.Render(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(html, want) {
+					t.Errorf("missing %q in %q", want, html)
+				}
+			}
+			for _, stripped := range tt.stripped {
+				if strings.Contains(strings.ToLower(html), strings.ToLower(stripped)) {
+					t.Errorf("%q kept in %q", stripped, html)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderDocumentCachesByVersion(t *testing.T) {
+	r := NewRenderer()
+	doc := &types.Document{ID: "doc", Version: 1, Content: "first"}
+	if html, _ := r.RenderDocument(doc); !strings.Contains(html, "first") {
+		t.Fatalf("rendered %q", html)
+	}
+
+	// The same version is served from the cache
+	stale := *doc
+	stale.Content = "changed"
+	if html, _ := r.RenderDocument(&stale); !strings.Contains(html, "first") {
+		t.Fatalf("same version rendered again: %q", html)
+	}
+
+	stale.Version = 2
+	if html, _ := r.RenderDocument(&stale); !strings.Contains(html, "changed") {
+		t.Fatalf("new version served from the cache: %q", html)
+	}
+}
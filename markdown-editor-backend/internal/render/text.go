@@ -0,0 +1,146 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// Parse returns the syntax tree of the Markdown, for renderers other than
+// HTML. Segments in the tree point into source.
+func (r *Renderer) Parse(source []byte) ast.Node {
+	return r.markdown.Parser().Parse(text.NewReader(source))
+}
+
+// PlainText converts Markdown to readable text: markup is dropped, lists
+// and quotes keep their markers and link targets follow their text
+func (r *Renderer) PlainText(source string) string {
+	src := []byte(source)
+	return strings.TrimSpace(plainBlocks(r.Parse(src), src, "\n\n")) + "\n"
+}
+
+// plainBlocks converts the block children of n, separated by sep
+func plainBlocks(n ast.Node, source []byte, sep string) string {
+	var parts []string
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		if part := plainBlock(child, source); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func plainBlock(n ast.Node, source []byte) string {
+	switch node := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock, *ast.Heading:
+		return plainInline(node, source)
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		var buf bytes.Buffer
+		lines := node.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			buf.Write(segment.Value(source))
+		}
+		return strings.TrimRight(buf.String(), "\n")
+	case *ast.Blockquote:
+		return prefixLines(plainBlocks(node, source, "\n\n"), "> ", "> ")
+	case *ast.List:
+		sep := "\n\n"
+		if node.IsTight {
+			sep = "\n"
+		}
+		var items []string
+		number := node.Start
+		for item := node.FirstChild(); item != nil; item = item.NextSibling() {
+			marker := "- "
+			if node.IsOrdered() {
+				marker = fmt.Sprintf("%d. ", number)
+				number++
+			}
+			items = append(items, prefixLines(plainBlocks(item, source, sep), marker, strings.Repeat(" ", len(marker))))
+		}
+		return strings.Join(items, sep)
+	case *ast.ThematicBreak:
+		return "----"
+	case *ast.HTMLBlock:
+		return ""
+	case *east.Table:
+		var rows []string
+		for row := node.FirstChild(); row != nil; row = row.NextSibling() {
+			var cells []string
+			for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+				cells = append(cells, plainInline(cell, source))
+			}
+			rows = append(rows, strings.Join(cells, " | "))
+		}
+		return strings.Join(rows, "\n")
+	case *east.FootnoteList:
+		var notes []string
+		for note := node.FirstChild(); note != nil; note = note.NextSibling() {
+			if footnote, ok := note.(*east.Footnote); ok {
+				notes = append(notes, prefixLines(plainBlocks(footnote, source, "\n"), fmt.Sprintf("[%d] ", footnote.Index), "    "))
+			}
+		}
+		return "----\n" + strings.Join(notes, "\n")
+	default:
+		return plainBlocks(node, source, "\n\n")
+	}
+}
+
+// plainInline converts the inline children of n
+func plainInline(n ast.Node, source []byte) string {
+	var buf strings.Builder
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		switch node := child.(type) {
+		case *ast.Text:
+			buf.Write(node.Segment.Value(source))
+			if node.SoftLineBreak() || node.HardLineBreak() {
+				buf.WriteByte('\n')
+			}
+		case *ast.String:
+			buf.Write(node.Value)
+		case *ast.AutoLink:
+			buf.Write(node.Label(source))
+		case *ast.Link:
+			label := plainInline(node, source)
+			buf.WriteString(label)
+			if destination := string(node.Destination); destination != label && !strings.HasPrefix(destination, "#") {
+				buf.WriteString(" (" + destination + ")")
+			}
+		case *ast.RawHTML:
+		case *east.TaskCheckBox:
+			if node.IsChecked {
+				buf.WriteString("[x] ")
+			} else {
+				buf.WriteString("[ ] ")
+			}
+		case *east.FootnoteLink:
+			fmt.Fprintf(&buf, "[%d]", node.Index)
+		case *east.FootnoteBacklink:
+		default:
+			buf.WriteString(plainInline(node, source))
+		}
+	}
+	return buf.String()
+}
+
+// prefixLines puts first before the first line and rest before the others
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" && i > 0 {
+			lines[i] = strings.TrimRight(prefix, " ")
+			continue
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"errors"
+	"sort"
 	"sync"
 	"time"
 
@@ -9,8 +10,17 @@ import (
 )
 
 var (
-	ErrDocumentNotFound = errors.New("document not found")
-	ErrUserNotFound     = errors.New("user not found")
+	ErrDocumentNotFound   = errors.New("document not found")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrCommentNotFound    = errors.New("comment thread not found")
+	ErrSuggestionNotFound = errors.New("suggestion not found")
+	ErrChatNotFound       = errors.New("chat message not found")
+	// ErrDocumentDeleted refuses to create a document again under the ID
+	// of a deleted one
+	ErrDocumentDeleted = errors.New("document was deleted")
+	// ErrHistoryUnavailable means operations since a version were
+	// trimmed from the history or never recorded on this node
+	ErrHistoryUnavailable = errors.New("operation history unavailable")
 )
 
 // MemoryStorage provides in-memory storage for documents and users
@@ -19,7 +29,16 @@ type MemoryStorage struct {
 	users     map[string]*types.User
 	docUsers  map[string][]string // documentID -> userIDs
 	cursors   map[string]map[string]*types.CursorPosition // documentID -> userID -> position
-	mutex     sync.RWMutex
+	// documentID -> userID -> connectionIDs, one per open tab or device
+	connections map[string]map[string]map[string]bool
+	comments    map[string]map[string]*types.CommentThread // documentID -> threadID -> thread
+	suggestions map[string]map[string]*types.Suggestion    // documentID -> suggestionID -> suggestion
+	history     map[string][]recordedOperation             // documentID -> recent committed operations
+	authorship  map[string][]types.AuthorshipRun           // documentID -> runs covering the content
+	chats       map[string][]*types.ChatMessage            // documentID -> messages, oldest first
+	inboxes     map[string][]*types.Notification           // userID -> notifications, oldest first
+	deleted     map[string]bool                            // IDs of deleted documents, never reused
+	mutex       sync.RWMutex
 }
 
 // NewMemoryStorage creates a new in-memory storage instance
@@ -29,6 +48,15 @@ func NewMemoryStorage() *MemoryStorage {
 		users:     make(map[string]*types.User),
 		docUsers:  make(map[string][]string),
 		cursors:   make(map[string]map[string]*types.CursorPosition),
+
+		connections: make(map[string]map[string]map[string]bool),
+		comments:    make(map[string]map[string]*types.CommentThread),
+		suggestions: make(map[string]map[string]*types.Suggestion),
+		history:     make(map[string][]recordedOperation),
+		authorship:  make(map[string][]types.AuthorshipRun),
+		chats:       make(map[string][]*types.ChatMessage),
+		inboxes:     make(map[string][]*types.Notification),
+		deleted:     make(map[string]bool),
 	}
 }
 
@@ -36,6 +64,10 @@ func NewMemoryStorage() *MemoryStorage {
 func (ms *MemoryStorage) CreateDocument(doc *types.Document) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
+
+	if ms.deleted[doc.ID] {
+		return ErrDocumentDeleted
+	}
 	
 	doc.LastModified = time.Now()
 	doc.Version = 1
@@ -58,6 +90,19 @@ func (ms *MemoryStorage) GetDocument(id string) (*types.Document, error) {
 	return doc, nil
 }
 
+// GetDocumentCopy returns a copy of a document taken under the lock, for
+// readers that must see a single version while edits are committed
+func (ms *MemoryStorage) GetDocumentCopy(id string) (types.Document, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	doc, exists := ms.documents[id]
+	if !exists {
+		return types.Document{}, ErrDocumentNotFound
+	}
+	return *doc, nil
+}
+
 func (ms *MemoryStorage) GetDocumentByRoomCode(roomCode string) (*types.Document, error) {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
@@ -87,6 +132,50 @@ func (ms *MemoryStorage) UpdateDocument(doc *types.Document) error {
 	return nil
 }
 
+// UpdateDocumentTitle renames a document, advancing its version. The new
+// version is recorded in the history with no operation, so operations
+// made before the rename can still be rebased.
+func (ms *MemoryStorage) UpdateDocumentTitle(id, title string, limit int) (*types.Document, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	existing, exists := ms.documents[id]
+	if !exists {
+		return nil, ErrDocumentNotFound
+	}
+
+	doc := *existing
+	doc.Title = title
+	doc.Version = existing.Version + 1
+	doc.LastModified = time.Now()
+	ms.documents[id] = &doc
+
+	ms.record(id, recordedOperation{before: existing.Version, renamed: true}, limit)
+	return &doc, nil
+}
+
+// SaveDocument stores a document exactly as given, creating it if needed.
+// Used to mirror state committed by another node.
+func (ms *MemoryStorage) SaveDocument(doc *types.Document) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	// A sync overtaken by the deletion must not bring the document back
+	if ms.deleted[doc.ID] {
+		return ErrDocumentDeleted
+	}
+
+	ms.documents[doc.ID] = doc
+	if _, exists := ms.docUsers[doc.ID]; !exists {
+		ms.docUsers[doc.ID] = make([]string, 0)
+	}
+	if _, exists := ms.cursors[doc.ID]; !exists {
+		ms.cursors[doc.ID] = make(map[string]*types.CursorPosition)
+	}
+
+	return nil
+}
+
 func (ms *MemoryStorage) DeleteDocument(id string) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
@@ -94,10 +183,25 @@ func (ms *MemoryStorage) DeleteDocument(id string) error {
 	delete(ms.documents, id)
 	delete(ms.docUsers, id)
 	delete(ms.cursors, id)
+	delete(ms.connections, id)
+	delete(ms.comments, id)
+	delete(ms.suggestions, id)
+	delete(ms.history, id)
+	delete(ms.authorship, id)
+	delete(ms.chats, id)
+	ms.deleted[id] = true
 	
 	return nil
 }
 
+// IsDeleted reports whether a document with the ID was deleted
+func (ms *MemoryStorage) IsDeleted(id string) bool {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return ms.deleted[id]
+}
+
 // User operations
 func (ms *MemoryStorage) AddUser(user *types.User) error {
 	ms.mutex.Lock()
@@ -163,7 +267,18 @@ func (ms *MemoryStorage) AddUserToDocument(documentID, userID string) error {
 func (ms *MemoryStorage) RemoveUserFromDocument(documentID, userID string) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	
+
+	ms.removeUserFromDocument(documentID, userID)
+	return nil
+}
+
+// removeUserFromDocument drops the user, their connections and cursor.
+// Callers must hold the write lock.
+func (ms *MemoryStorage) removeUserFromDocument(documentID, userID string) {
+	if conns, exists := ms.connections[documentID]; exists {
+		delete(conns, userID)
+	}
+
 	userIDs := ms.docUsers[documentID]
 	for i, id := range userIDs {
 		if id == userID {
@@ -176,10 +291,59 @@ func (ms *MemoryStorage) RemoveUserFromDocument(documentID, userID string) error
 	if cursors, exists := ms.cursors[documentID]; exists {
 		delete(cursors, userID)
 	}
-	
-	return nil
 }
 
+// AddConnection records one connection of a user to a document, adding
+// the user if needed. It reports whether this is the user's first
+// connection to the document.
+func (ms *MemoryStorage) AddConnection(documentID, userID, connectionID string) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.connections[documentID]; !exists {
+		ms.connections[documentID] = make(map[string]map[string]bool)
+	}
+	conns, exists := ms.connections[documentID][userID]
+	if !exists {
+		conns = make(map[string]bool)
+		ms.connections[documentID][userID] = conns
+	}
+
+	first := len(conns) == 0
+	conns[connectionID] = true
+
+	for _, existingUserID := range ms.docUsers[documentID] {
+		if existingUserID == userID {
+			return first, nil
+		}
+	}
+	ms.docUsers[documentID] = append(ms.docUsers[documentID], userID)
+
+	return first, nil
+}
+
+// RemoveConnection drops one connection of a user. When it was the last
+// one the user leaves the document, which is reported by returning true.
+func (ms *MemoryStorage) RemoveConnection(documentID, userID, connectionID string) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	conns := ms.connections[documentID][userID]
+	if _, exists := conns[connectionID]; !exists {
+		return false, nil
+	}
+
+	delete(conns, connectionID)
+	if len(conns) > 0 {
+		return false, nil
+	}
+
+	ms.removeUserFromDocument(documentID, userID)
+	return true, nil
+}
+
+// GetDocumentUsers returns copies of the users in a document with their
+// connection count filled in
 func (ms *MemoryStorage) GetDocumentUsers(documentID string) ([]*types.User, error) {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
@@ -192,7 +356,9 @@ func (ms *MemoryStorage) GetDocumentUsers(documentID string) ([]*types.User, err
 	users := make([]*types.User, 0, len(userIDs))
 	for _, userID := range userIDs {
 		if user, exists := ms.users[userID]; exists {
-			users = append(users, user)
+			copied := *user
+			copied.Connections = len(ms.connections[documentID][userID])
+			users = append(users, &copied)
 		}
 	}
 	
@@ -208,10 +374,20 @@ func (ms *MemoryStorage) UpdateCursor(documentID string, position *types.CursorP
 		ms.cursors[documentID] = make(map[string]*types.CursorPosition)
 	}
 	
-	ms.cursors[documentID][position.UserID] = position
+	ms.cursors[documentID][position.UserID] = copyCursor(position)
 	return nil
 }
 
+// TransformCursors updates every stored cursor of a document in place
+func (ms *MemoryStorage) TransformCursors(documentID string, transform func(position *types.CursorPosition)) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, position := range ms.cursors[documentID] {
+		transform(position)
+	}
+}
+
 func (ms *MemoryStorage) GetCursors(documentID string) ([]*types.CursorPosition, error) {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
@@ -223,7 +399,7 @@ func (ms *MemoryStorage) GetCursors(documentID string) ([]*types.CursorPosition,
 	
 	positions := make([]*types.CursorPosition, 0, len(cursors))
 	for _, position := range cursors {
-		positions = append(positions, position)
+		positions = append(positions, copyCursor(position))
 	}
 	
 	return positions, nil
@@ -238,4 +414,400 @@ func (ms *MemoryStorage) RemoveCursor(documentID, userID string) error {
 	}
 	
 	return nil
-}
\ No newline at end of file
+}
+
+// copyCursor copies a cursor so stored ones are only changed under the lock
+func copyCursor(position *types.CursorPosition) *types.CursorPosition {
+	copied := *position
+	copied.Selections = append([]types.SelectionRange(nil), position.Selections...)
+	return &copied
+}
+
+// Comment operations
+func (ms *MemoryStorage) SaveCommentThread(thread *types.CommentThread) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.documents[thread.DocumentID]; !exists {
+		return ErrDocumentNotFound
+	}
+	if _, exists := ms.comments[thread.DocumentID]; !exists {
+		ms.comments[thread.DocumentID] = make(map[string]*types.CommentThread)
+	}
+
+	ms.comments[thread.DocumentID][thread.ID] = copyCommentThread(thread)
+	return nil
+}
+
+func (ms *MemoryStorage) GetCommentThread(documentID, threadID string) (*types.CommentThread, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	thread, exists := ms.comments[documentID][threadID]
+	if !exists {
+		return nil, ErrCommentNotFound
+	}
+	return copyCommentThread(thread), nil
+}
+
+// UpdateCommentThread changes a stored thread under the lock and returns
+// a copy of the result
+func (ms *MemoryStorage) UpdateCommentThread(documentID, threadID string, update func(thread *types.CommentThread)) (*types.CommentThread, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	thread, exists := ms.comments[documentID][threadID]
+	if !exists {
+		return nil, ErrCommentNotFound
+	}
+
+	update(thread)
+	return copyCommentThread(thread), nil
+}
+
+func (ms *MemoryStorage) GetCommentThreads(documentID string) ([]*types.CommentThread, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	threads := make([]*types.CommentThread, 0, len(ms.comments[documentID]))
+	for _, thread := range ms.comments[documentID] {
+		threads = append(threads, copyCommentThread(thread))
+	}
+	return threads, nil
+}
+
+// TransformCommentThreads updates the anchors of every thread of a
+// document in place
+func (ms *MemoryStorage) TransformCommentThreads(documentID string, transform func(thread *types.CommentThread)) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, thread := range ms.comments[documentID] {
+		transform(thread)
+	}
+}
+
+// copyCommentThread copies a thread so stored ones are only changed under
+// the lock
+func copyCommentThread(thread *types.CommentThread) *types.CommentThread {
+	copied := *thread
+	copied.Comments = append([]types.Comment(nil), thread.Comments...)
+	return &copied
+}
+
+// Suggestion operations
+func (ms *MemoryStorage) SaveSuggestion(suggestion *types.Suggestion) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.documents[suggestion.DocumentID]; !exists {
+		return ErrDocumentNotFound
+	}
+	if _, exists := ms.suggestions[suggestion.DocumentID]; !exists {
+		ms.suggestions[suggestion.DocumentID] = make(map[string]*types.Suggestion)
+	}
+
+	ms.suggestions[suggestion.DocumentID][suggestion.ID] = copySuggestion(suggestion)
+	return nil
+}
+
+func (ms *MemoryStorage) GetSuggestion(documentID, suggestionID string) (*types.Suggestion, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	suggestion, exists := ms.suggestions[documentID][suggestionID]
+	if !exists {
+		return nil, ErrSuggestionNotFound
+	}
+	return copySuggestion(suggestion), nil
+}
+
+// UpdateSuggestion changes a stored suggestion under the lock and returns
+// a copy of the result. Nothing is changed when update returns an error.
+func (ms *MemoryStorage) UpdateSuggestion(documentID, suggestionID string, update func(suggestion *types.Suggestion) error) (*types.Suggestion, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stored, exists := ms.suggestions[documentID][suggestionID]
+	if !exists {
+		return nil, ErrSuggestionNotFound
+	}
+
+	suggestion := copySuggestion(stored)
+	if err := update(suggestion); err != nil {
+		return nil, err
+	}
+	ms.suggestions[documentID][suggestionID] = suggestion
+	return copySuggestion(suggestion), nil
+}
+
+func (ms *MemoryStorage) GetSuggestions(documentID string) ([]*types.Suggestion, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	suggestions := make([]*types.Suggestion, 0, len(ms.suggestions[documentID]))
+	for _, suggestion := range ms.suggestions[documentID] {
+		suggestions = append(suggestions, copySuggestion(suggestion))
+	}
+	return suggestions, nil
+}
+
+// copySuggestion copies a suggestion so stored ones are only changed under
+// the lock
+func copySuggestion(suggestion *types.Suggestion) *types.Suggestion {
+	copied := *suggestion
+	copied.Operations = append([]types.Operation(nil), suggestion.Operations...)
+	return &copied
+}
+
+// Operation history
+
+// recordedOperation is a committed operation with the document version it
+// was applied to. Renames advance the version without an operation.
+type recordedOperation struct {
+	before    int
+	operation types.Operation
+	renamed   bool
+}
+
+// AppendOperation records an operation committed on top of version
+// before, keeping at most limit operations per document
+func (ms *MemoryStorage) AppendOperation(documentID string, before int, op types.Operation, limit int) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.record(documentID, recordedOperation{before: before, operation: op}, limit)
+}
+
+// record appends to the history of a document; callers hold the lock
+func (ms *MemoryStorage) record(documentID string, recorded recordedOperation, limit int) {
+	history := append(ms.history[documentID], recorded)
+	if len(history) > limit {
+		history = append([]recordedOperation(nil), history[len(history)-limit:]...)
+	}
+	ms.history[documentID] = history
+}
+
+// GetOperationsSince returns the operations committed after a version, in
+// order. It fails when some of them are no longer recorded.
+func (ms *MemoryStorage) GetOperationsSince(documentID string, version int) ([]types.Operation, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	doc, exists := ms.documents[documentID]
+	if !exists {
+		return nil, ErrDocumentNotFound
+	}
+	if version > doc.Version {
+		return nil, ErrHistoryUnavailable
+	}
+	if version == doc.Version {
+		return nil, nil
+	}
+
+	history := ms.history[documentID]
+	if len(history) == 0 || history[0].before > version {
+		return nil, ErrHistoryUnavailable
+	}
+
+	start := sort.Search(len(history), func(i int) bool {
+		return history[i].before >= version
+	})
+	operations := make([]types.Operation, 0, len(history)-start)
+	for _, recorded := range history[start:] {
+		if recorded.renamed {
+			continue
+		}
+		operations = append(operations, recorded.operation)
+	}
+	return operations, nil
+}
+
+// Authorship operations
+
+// UpdateAuthorship replaces the authorship runs of a document with the
+// result of update, under the lock
+func (ms *MemoryStorage) UpdateAuthorship(documentID string, update func(runs []types.AuthorshipRun) []types.AuthorshipRun) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.documents[documentID]; !exists {
+		return
+	}
+	ms.authorship[documentID] = update(ms.authorship[documentID])
+}
+
+func (ms *MemoryStorage) GetAuthorship(documentID string) []types.AuthorshipRun {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return append([]types.AuthorshipRun(nil), ms.authorship[documentID]...)
+}
+
+// Chat operations
+
+// SaveChatMessage stores a new message, or replaces the stored one with the
+// same ID, keeping at most limit messages per document
+func (ms *MemoryStorage) SaveChatMessage(message *types.ChatMessage, limit int) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.documents[message.DocumentID]; !exists {
+		return ErrDocumentNotFound
+	}
+
+	messages := ms.chats[message.DocumentID]
+	copied := *message
+	if i := chatIndex(messages, message.ID); i >= 0 {
+		messages[i] = &copied
+		return nil
+	}
+
+	// Messages synced from other nodes may arrive out of order
+	i := sort.Search(len(messages), func(i int) bool {
+		return messages[i].CreatedAt.After(message.CreatedAt)
+	})
+	messages = append(messages, nil)
+	copy(messages[i+1:], messages[i:])
+	messages[i] = &copied
+
+	if len(messages) > limit {
+		messages = append([]*types.ChatMessage(nil), messages[len(messages)-limit:]...)
+	}
+	ms.chats[message.DocumentID] = messages
+	return nil
+}
+
+// UpdateChatMessage changes a stored message under the lock and returns a
+// copy of the result. Nothing is changed when update returns an error.
+func (ms *MemoryStorage) UpdateChatMessage(documentID, messageID string, update func(message *types.ChatMessage) error) (*types.ChatMessage, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	messages := ms.chats[documentID]
+	i := chatIndex(messages, messageID)
+	if i < 0 {
+		return nil, ErrChatNotFound
+	}
+
+	message := *messages[i]
+	if err := update(&message); err != nil {
+		return nil, err
+	}
+	messages[i] = &message
+	copied := message
+	return &copied, nil
+}
+
+// GetChatMessages returns up to limit messages older than the message
+// before, or the latest ones when before is empty, oldest first. It also
+// reports whether older messages remain.
+func (ms *MemoryStorage) GetChatMessages(documentID, before string, limit int) ([]types.ChatMessage, bool, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	if _, exists := ms.documents[documentID]; !exists {
+		return nil, false, ErrDocumentNotFound
+	}
+
+	messages := ms.chats[documentID]
+	end := len(messages)
+	if before != "" {
+		end = chatIndex(messages, before)
+		if end < 0 {
+			return nil, false, ErrChatNotFound
+		}
+	}
+
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+
+	page := make([]types.ChatMessage, 0, end-start)
+	for _, message := range messages[start:end] {
+		page = append(page, *message)
+	}
+	return page, start > 0, nil
+}
+
+func chatIndex(messages []*types.ChatMessage, messageID string) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].ID == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindUsersByName returns the known users whose name matches according to
+// match, in no particular order
+func (ms *MemoryStorage) FindUsersByName(match func(name string) bool) []*types.User {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	var users []*types.User
+	for _, user := range ms.users {
+		if match(user.Name) {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users
+}
+
+// Notification operations
+
+// AddNotification stores a notification in its recipient's inbox, keeping
+// at most limit per user. A notification with a known ID is ignored.
+func (ms *MemoryStorage) AddNotification(notification *types.Notification, limit int) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	inbox := ms.inboxes[notification.UserID]
+	for _, existing := range inbox {
+		if existing.ID == notification.ID {
+			return
+		}
+	}
+
+	copied := *notification
+	inbox = append(inbox, &copied)
+	if len(inbox) > limit {
+		inbox = append([]*types.Notification(nil), inbox[len(inbox)-limit:]...)
+	}
+	ms.inboxes[notification.UserID] = inbox
+}
+
+func (ms *MemoryStorage) GetNotifications(userID string) []types.Notification {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	notifications := make([]types.Notification, 0, len(ms.inboxes[userID]))
+	for _, notification := range ms.inboxes[userID] {
+		notifications = append(notifications, *notification)
+	}
+	return notifications
+}
+
+// MarkNotificationsRead marks notifications of a user as read, all of them
+// when ids is empty, and returns the IDs that changed
+func (ms *MemoryStorage) MarkNotificationsRead(userID string, ids []string) []string {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	marked := []string{}
+	for _, notification := range ms.inboxes[userID] {
+		if notification.Read || (len(ids) > 0 && !wanted[notification.ID]) {
+			continue
+		}
+		notification.Read = true
+		marked = append(marked, notification.ID)
+	}
+	return marked
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"testing"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+func TestDocumentVersions(t *testing.T) {
+	ms := NewMemoryStorage()
+	if err := ms.CreateDocument(&types.Document{ID: "doc", Title: "Notes", Version: 7}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ms.GetDocument("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 1 || doc.LastModified.IsZero() {
+		t.Fatalf("created document = %+v, want version 1", doc)
+	}
+
+	if err := ms.UpdateDocument(&types.Document{ID: "doc", Title: "Plans"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ := ms.GetDocument("doc"); doc.Version != 2 || doc.Title != "Plans" {
+		t.Fatalf("updated document = %+v, want Plans at version 2", doc)
+	}
+
+	if err := ms.UpdateDocument(&types.Document{ID: "missing"}); err != ErrDocumentNotFound {
+		t.Fatalf("updating a missing document: err = %v", err)
+	}
+	if _, err := ms.GetDocument("missing"); err != ErrDocumentNotFound {
+		t.Fatalf("getting a missing document: err = %v", err)
+	}
+}
+
+func TestSaveDocumentMirrorsAsGiven(t *testing.T) {
+	ms := NewMemoryStorage()
+	if err := ms.SaveDocument(&types.Document{ID: "doc", Content: "remote", Version: 5}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ms.GetDocument("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 5 || doc.Content != "remote" {
+		t.Fatalf("saved document = %+v, want it unchanged", doc)
+	}
+
+	// A mirrored document takes users and cursors like a local one
+	ms.AddUser(&types.User{ID: "ada", Name: "Ada"})
+	ms.AddUserToDocument("doc", "ada")
+	ms.UpdateCursor("doc", &types.CursorPosition{UserID: "ada", Position: 3})
+	if err := ms.SaveDocument(&types.Document{ID: "doc", Content: "remote!", Version: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if users, _ := ms.GetDocumentUsers("doc"); len(users) != 1 {
+		t.Fatalf("users after a second save = %v", users)
+	}
+	if cursors, _ := ms.GetCursors("doc"); len(cursors) != 1 || cursors[0].Position != 3 {
+		t.Fatalf("cursors after a second save = %v", cursors)
+	}
+}
+
+func TestConnectionsAreCountedPerTab(t *testing.T) {
+	ms := NewMemoryStorage()
+	ms.CreateDocument(&types.Document{ID: "doc"})
+	ms.AddUser(&types.User{ID: "ada", Name: "Ada"})
+
+	tests := []struct {
+		name       string
+		apply      func() (bool, error)
+		want       bool
+		wantLength int
+	}{
+		{"first tab joins", func() (bool, error) { return ms.AddConnection("doc", "ada", "tab-1") }, true, 1},
+		{"second tab joins", func() (bool, error) { return ms.AddConnection("doc", "ada", "tab-2") }, false, 1},
+		{"first tab leaves", func() (bool, error) { return ms.RemoveConnection("doc", "ada", "tab-1") }, false, 1},
+		{"unknown tab leaves", func() (bool, error) { return ms.RemoveConnection("doc", "ada", "tab-9") }, false, 1},
+		{"last tab leaves", func() (bool, error) { return ms.RemoveConnection("doc", "ada", "tab-2") }, true, 0},
+	}
+	for _, tt := range tests {
+		got, err := tt.apply()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s reported %v, want %v", tt.name, got, tt.want)
+		}
+		if users, _ := ms.GetDocumentUsers("doc"); len(users) != tt.wantLength {
+			t.Errorf("%s: document lists %d users, want %d", tt.name, len(users), tt.wantLength)
+		}
+	}
+}
+
+func TestCursorsAreStoredAsCopies(t *testing.T) {
+	ms := NewMemoryStorage()
+	position := &types.CursorPosition{UserID: "ada", Position: 3, Selections: []types.SelectionRange{{Anchor: 1, Head: 3}}}
+	ms.UpdateCursor("doc", position)
+
+	// Changing what was passed in or read out leaves the stored cursor alone
+	position.Selections[0].Head = 9
+	cursors, _ := ms.GetCursors("doc")
+	cursors[0].Position = 7
+	if stored, _ := ms.GetCursors("doc"); stored[0].Position != 3 || stored[0].Selections[0].Head != 3 {
+		t.Fatalf("stored cursor = %+v", stored[0])
+	}
+
+	ms.TransformCursors("doc", func(cursor *types.CursorPosition) { cursor.Position++ })
+	if stored, _ := ms.GetCursors("doc"); stored[0].Position != 4 {
+		t.Fatalf("transformed cursor = %+v, want position 4", stored[0])
+	}
+}
+
+func TestRenamesKeepTheHistoryWhole(t *testing.T) {
+	ms := NewMemoryStorage()
+	if err := ms.CreateDocument(&types.Document{ID: "doc", Title: "Notes"}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := ms.GetDocument("doc")
+
+	renamed, err := ms.UpdateDocumentTitle("doc", "Plans", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Title != "Plans" || renamed.Version != 2 || before.Title != "Notes" {
+		t.Fatalf("renamed %+v, earlier copy %+v", renamed, before)
+	}
+	if _, err := ms.UpdateDocumentTitle("missing", "Plans", 10); err != ErrDocumentNotFound {
+		t.Fatalf("renaming a missing document: err = %v", err)
+	}
+
+	// Operations made before the rename are still rebased over later ones
+	ms.UpdateDocument(&types.Document{ID: "doc", Title: "Plans", Content: "x"})
+	ms.AppendOperation("doc", 2, types.Operation{Type: "insert", Content: "x", Version: 3}, 10)
+	operations, err := ms.GetOperationsSince("doc", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(operations) != 1 || operations[0].Version != 3 {
+		t.Fatalf("operations since version 1 = %+v", operations)
+	}
+}
+
+func TestDeletedDocumentIDsAreNotReused(t *testing.T) {
+	ms := NewMemoryStorage()
+	if err := ms.CreateDocument(&types.Document{ID: "doc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.DeleteDocument("doc"); err != nil {
+		t.Fatal(err)
+	}
+	if !ms.IsDeleted("doc") || ms.IsDeleted("other") {
+		t.Fatal("IsDeleted does not match the deleted documents")
+	}
+
+	if err := ms.CreateDocument(&types.Document{ID: "doc"}); err != ErrDocumentDeleted {
+		t.Fatalf("creating a deleted document: err = %v", err)
+	}
+	if err := ms.SaveDocument(&types.Document{ID: "doc", Version: 3}); err != ErrDocumentDeleted {
+		t.Fatalf("saving a deleted document: err = %v", err)
+	}
+	if _, err := ms.GetDocument("doc"); err != ErrDocumentNotFound {
+		t.Fatalf("getting a deleted document: err = %v", err)
+	}
+}
@@ -0,0 +1,467 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+// Bounds applied to user-supplied fields
+const (
+	MaxIDLength    = 64
+	MaxNameLength  = 50
+	MaxTitleLength = 200
+	MaxSelections  = 100
+
+	MaxAwarenessKeys  = 20
+	MaxAwarenessBytes = 2048
+
+	MaxCommentLength = 10000
+	MaxChatLength    = 2000
+)
+
+var (
+	roomCodePattern = regexp.MustCompile(`^[A-Z0-9]{6}$`)
+	unknownField    = regexp.MustCompile(`unknown field "([^"]+)"`)
+)
+
+// FieldError reports the payload field that failed validation
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func fieldError(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// DecodeError turns a payload decoding error into a FieldError naming the
+// offending field where it can be determined
+func DecodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fieldError(typeErr.Field, "must be of type %s", typeErr.Type)
+	}
+	if match := unknownField.FindStringSubmatch(err.Error()); match != nil {
+		return fieldError(match[1], "unknown field")
+	}
+	return fieldError("payload", "malformed payload")
+}
+
+// Validate checks a decoded payload and normalizes it in place. Types
+// without rules are accepted as is.
+func Validate(payload interface{}) error {
+	switch p := payload.(type) {
+	case *types.JoinPayload:
+		return ValidateJoin(p)
+	case *types.OperationPayload:
+		return ValidateOperation(p)
+	case *types.CursorPayload:
+		return ValidateCursor(p)
+	case *types.TitleUpdatePayload:
+		return ValidateTitleUpdate(p)
+	case *types.CreateRoomPayload:
+		return ValidateCreateRoom(p)
+	case *types.JoinRoomPayload:
+		return ValidateJoinRoom(p)
+	case *types.PresencePayload:
+		return ValidatePresence(p)
+	case *types.FollowPayload:
+		return ValidateFollow(p)
+	case *types.ViewportPayload:
+		return ValidateViewport(p)
+	case *types.AwarenessPayload:
+		return ValidateAwareness(p)
+	case *types.CommentAddPayload:
+		return ValidateCommentAdd(p)
+	case *types.CommentReplyPayload:
+		return ValidateCommentReply(p)
+	case *types.CommentResolvePayload:
+		return ValidateCommentResolve(p)
+	case *types.SuggestionResolvePayload:
+		return ValidateSuggestionResolve(p)
+	case *types.ChatPayload:
+		return ValidateChat(p)
+	case *types.ChatEditPayload:
+		return ValidateChatEdit(p)
+	case *types.ChatDeletePayload:
+		return ValidateChatDelete(p)
+	case *types.User:
+		return ValidateUser("", p)
+	default:
+		return nil
+	}
+}
+
+// ValidateJoin checks a join payload
+func ValidateJoin(p *types.JoinPayload) error {
+	if err := ValidateUser("user", &p.User); err != nil {
+		return err
+	}
+	return ValidateID("documentId", p.DocumentID)
+}
+
+// ValidateOperation checks an operation payload. Inserted content may hold
+// newlines and tabs but no other control characters; it is refused rather
+// than rewritten, since the sender's copy would no longer match.
+func ValidateOperation(p *types.OperationPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if p.SuggestionID != "" {
+		if err := ValidateID("suggestionId", p.SuggestionID); err != nil {
+			return err
+		}
+	}
+
+	op := &p.Operation
+	if op.Position < 0 {
+		return fieldError("operation.position", "must not be negative")
+	}
+	if op.Length < 0 {
+		return fieldError("operation.length", "must not be negative")
+	}
+
+	switch op.Type {
+	case "insert":
+		if op.Content == "" {
+			return fieldError("operation.content", "is required for insert")
+		}
+		if !utf8.ValidString(op.Content) || strings.ContainsFunc(op.Content, isStrippedControl) {
+			return fieldError("operation.content", "must be valid UTF-8 without control characters")
+		}
+	case "delete":
+		if op.Length == 0 {
+			return fieldError("operation.length", "must be positive for delete")
+		}
+	default:
+		return fieldError("operation.type", "must be insert or delete")
+	}
+	return nil
+}
+
+// ValidateCursor checks a cursor payload. When selections are given the
+// primary caret is taken from the head of the first one.
+func ValidateCursor(p *types.CursorPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if len(p.Position.Selections) > MaxSelections {
+		return fieldError("position.selections", "must have at most %d ranges", MaxSelections)
+	}
+	for i, selection := range p.Position.Selections {
+		if selection.Anchor < 0 {
+			return fieldError(fmt.Sprintf("position.selections[%d].anchor", i), "must not be negative")
+		}
+		if selection.Head < 0 {
+			return fieldError(fmt.Sprintf("position.selections[%d].head", i), "must not be negative")
+		}
+	}
+	if len(p.Position.Selections) > 0 {
+		p.Position.Position = p.Position.Selections[0].Head
+	}
+	if p.Position.Position < 0 {
+		return fieldError("position.position", "must not be negative")
+	}
+	if p.Position.Line < 0 {
+		return fieldError("position.line", "must not be negative")
+	}
+	if p.Position.Column < 0 {
+		return fieldError("position.column", "must not be negative")
+	}
+	return nil
+}
+
+// ValidatePresence checks a presence report. Idle is decided by the
+// server, so clients may only report active or away.
+func ValidatePresence(p *types.PresencePayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if p.Status != types.PresenceActive && p.Status != types.PresenceAway {
+		return fieldError("status", "must be active or away")
+	}
+	return nil
+}
+
+// ValidateFollow checks a follow or unfollow payload. The followed user is
+// only required when following.
+func ValidateFollow(p *types.FollowPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if p.UserID != "" {
+		return ValidateID("userId", p.UserID)
+	}
+	return nil
+}
+
+// ValidateViewport checks a viewport payload
+func ValidateViewport(p *types.ViewportPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if p.StartLine < 1 {
+		return fieldError("startLine", "must be at least 1")
+	}
+	if p.EndLine < p.StartLine {
+		return fieldError("endLine", "must not be before startLine")
+	}
+	return nil
+}
+
+// ValidateAwareness checks an awareness payload stays small, since it is
+// relayed to the whole room on every change
+func ValidateAwareness(p *types.AwarenessPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if len(p.State) > MaxAwarenessKeys {
+		return fieldError("state", "must have at most %d keys", MaxAwarenessKeys)
+	}
+	for key := range p.State {
+		if key == "" || len(key) > MaxIDLength {
+			return fieldError("state", "keys must be 1 to %d characters", MaxIDLength)
+		}
+	}
+
+	encoded, err := json.Marshal(p.State)
+	if err != nil {
+		return fieldError("state", "must be JSON-compatible")
+	}
+	if len(encoded) > MaxAwarenessBytes {
+		return fieldError("state", "must be at most %d bytes", MaxAwarenessBytes)
+	}
+	return nil
+}
+
+// ValidateCommentAdd checks a new comment thread. The range is checked
+// against the document when the thread is created.
+func ValidateCommentAdd(p *types.CommentAddPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if p.Start < 0 {
+		return fieldError("start", "must not be negative")
+	}
+	if p.End < p.Start {
+		return fieldError("end", "must not be before start")
+	}
+	return ValidateComment("content", &p.Content)
+}
+
+// ValidateCommentReply checks a reply to a comment thread
+func ValidateCommentReply(p *types.CommentReplyPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if err := ValidateID("threadId", p.ThreadID); err != nil {
+		return err
+	}
+	return ValidateComment("content", &p.Content)
+}
+
+// ValidateCommentResolve checks a request to resolve a comment thread
+func ValidateCommentResolve(p *types.CommentResolvePayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	return ValidateID("threadId", p.ThreadID)
+}
+
+// ValidateComment sanitizes comment text in place and checks its length
+func ValidateComment(field string, content *string) error {
+	*content = strings.TrimSpace(StripControl(*content, true))
+	if *content == "" {
+		return fieldError(field, "is required")
+	}
+	if utf8.RuneCountInString(*content) > MaxCommentLength {
+		return fieldError(field, "must be at most %d characters", MaxCommentLength)
+	}
+	return nil
+}
+
+// ValidateSuggestionResolve checks a request to accept or reject a
+// suggestion
+func ValidateSuggestionResolve(p *types.SuggestionResolvePayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	return ValidateID("suggestionId", p.SuggestionID)
+}
+
+// ValidateChat checks a chat message
+func ValidateChat(p *types.ChatPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	return ValidateChatContent("content", &p.Content)
+}
+
+// ValidateChatEdit checks an edit of a chat message
+func ValidateChatEdit(p *types.ChatEditPayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	if err := ValidateID("messageId", p.MessageID); err != nil {
+		return err
+	}
+	return ValidateChatContent("content", &p.Content)
+}
+
+// ValidateChatDelete checks a request to delete a chat message
+func ValidateChatDelete(p *types.ChatDeletePayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	return ValidateID("messageId", p.MessageID)
+}
+
+// ValidateChatContent sanitizes chat text in place and checks its length
+func ValidateChatContent(field string, content *string) error {
+	*content = strings.TrimSpace(StripControl(*content, true))
+	if *content == "" {
+		return fieldError(field, "is required")
+	}
+	if utf8.RuneCountInString(*content) > MaxChatLength {
+		return fieldError(field, "must be at most %d characters", MaxChatLength)
+	}
+	return nil
+}
+
+// ValidateTitleUpdate checks a title update payload
+func ValidateTitleUpdate(p *types.TitleUpdatePayload) error {
+	if err := ValidateID("documentId", p.DocumentID); err != nil {
+		return err
+	}
+	return ValidateTitle("newTitle", &p.NewTitle)
+}
+
+// ValidateCreateRoom checks a create room payload
+func ValidateCreateRoom(p *types.CreateRoomPayload) error {
+	if err := ValidateUser("user", &p.User); err != nil {
+		return err
+	}
+	if err := ValidateTitle("title", &p.Title); err != nil {
+		return err
+	}
+	p.Content = StripControl(p.Content, true)
+	return nil
+}
+
+// ValidateJoinRoom checks a join room payload, upper-casing the code
+func ValidateJoinRoom(p *types.JoinRoomPayload) error {
+	if err := ValidateUser("user", &p.User); err != nil {
+		return err
+	}
+	p.RoomCode = strings.ToUpper(strings.TrimSpace(p.RoomCode))
+	if !roomCodePattern.MatchString(p.RoomCode) {
+		return fieldError("roomCode", "must be 6 letters or digits")
+	}
+	return nil
+}
+
+// ValidateUser checks a user sent by a client. prefix is the path of the
+// user object in the payload, "" at the top level.
+func ValidateUser(prefix string, user *types.User) error {
+	if err := ValidateID(join(prefix, "id"), user.ID); err != nil {
+		return err
+	}
+	if err := ValidateName(join(prefix, "name"), &user.Name); err != nil {
+		return err
+	}
+	if !IsAllowedColor(user.Color) {
+		return fieldError(join(prefix, "color"), "must be one of the user colors")
+	}
+	return nil
+}
+
+// ValidateID checks an identifier is present and bounded
+func ValidateID(field, id string) error {
+	if id == "" {
+		return fieldError(field, "is required")
+	}
+	if len(id) > MaxIDLength {
+		return fieldError(field, "must be at most %d characters", MaxIDLength)
+	}
+	for _, r := range id {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return fieldError(field, "contains invalid characters")
+		}
+	}
+	return nil
+}
+
+// ValidateName sanitizes a display name in place and checks its length
+func ValidateName(field string, name *string) error {
+	*name = strings.TrimSpace(StripControl(*name, false))
+	if *name == "" {
+		return fieldError(field, "is required")
+	}
+	if utf8.RuneCountInString(*name) > MaxNameLength {
+		return fieldError(field, "must be at most %d characters", MaxNameLength)
+	}
+	return nil
+}
+
+// ValidateTitle sanitizes a document title in place and checks its length
+func ValidateTitle(field string, title *string) error {
+	*title = strings.TrimSpace(StripControl(*title, false))
+	if *title == "" {
+		return fieldError(field, "is required")
+	}
+	if utf8.RuneCountInString(*title) > MaxTitleLength {
+		return fieldError(field, "must be at most %d characters", MaxTitleLength)
+	}
+	return nil
+}
+
+// IsAllowedColor reports whether color is in the shared user palette
+func IsAllowedColor(color string) bool {
+	for _, allowed := range types.UserColors {
+		if strings.EqualFold(color, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripControl removes control characters and invalid UTF-8. Newlines and
+// tabs are kept when multiline is true.
+func StripControl(s string, multiline bool) string {
+	return strings.Map(func(r rune) rune {
+		if r == utf8.RuneError {
+			return -1
+		}
+		if multiline && (r == '\n' || r == '\t') {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// isStrippedControl reports whether StripControl drops r from multiline
+// text
+func isStrippedControl(r rune) bool {
+	return r != '\n' && r != '\t' && unicode.IsControl(r)
+}
+
+func join(prefix, field string) string {
+	if prefix == "" {
+		return field
+	}
+	return prefix + "." + field
+}
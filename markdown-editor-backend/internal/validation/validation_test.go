@@ -0,0 +1,149 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+func validUser() types.User {
+	return types.User{ID: "ada", Name: "Ada", Color: types.UserColors[0]}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload interface{}
+		field   string // "" when valid
+	}{
+		{"join", &types.JoinPayload{User: validUser(), DocumentID: "doc"}, ""},
+		{"join without document", &types.JoinPayload{User: validUser()}, "documentId"},
+		{"join with a foreign color", &types.JoinPayload{User: types.User{ID: "ada", Name: "Ada", Color: "#123456"}, DocumentID: "doc"}, "user.color"},
+		{"join with a blank name", &types.JoinPayload{User: types.User{ID: "ada", Name: " \t", Color: types.UserColors[0]}, DocumentID: "doc"}, "user.name"},
+		{"join with a long name", &types.JoinPayload{User: types.User{ID: "ada", Name: strings.Repeat("a", MaxNameLength+1), Color: types.UserColors[0]}, DocumentID: "doc"}, "user.name"},
+		{"id with spaces", &types.JoinPayload{User: validUser(), DocumentID: "a doc"}, "documentId"},
+		{"long id", &types.JoinPayload{User: validUser(), DocumentID: strings.Repeat("d", MaxIDLength+1)}, "documentId"},
+		{"insert", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert", Content: "x"}}, ""},
+		{"empty insert", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert"}}, "operation.content"},
+		{"insert with newlines and tabs", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert", Content: "a\n\tb"}}, ""},
+		{"insert with a control character", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert", Content: "a\x00b"}}, "operation.content"},
+		{"insert with a replacement character", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert", Content: "\uFFFD"}}, ""},
+		{"insert with invalid UTF-8", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert", Content: "a\xffb"}}, "operation.content"},
+		{"negative position", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert", Content: "x", Position: -1}}, "operation.position"},
+		{"empty delete", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "delete"}}, "operation.length"},
+		{"unknown operation", &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "replace"}}, "operation.type"},
+		{"cursor", &types.CursorPayload{DocumentID: "doc", Position: types.CursorPosition{Position: 3}}, ""},
+		{"blank title", &types.TitleUpdatePayload{DocumentID: "doc", NewTitle: "\x07"}, "newTitle"},
+		{"room code", &types.JoinRoomPayload{User: validUser(), RoomCode: " ab12cd "}, ""},
+		{"short room code", &types.JoinRoomPayload{User: validUser(), RoomCode: "AB12"}, "roomCode"},
+		{"idle reported by a client", &types.PresencePayload{DocumentID: "doc", Status: types.PresenceIdle}, "status"},
+		{"long chat", &types.ChatPayload{DocumentID: "doc", Content: strings.Repeat("x", MaxChatLength+1)}, "content"},
+		{"negative selection", &types.CursorPayload{DocumentID: "doc", Position: types.CursorPosition{Selections: []types.SelectionRange{{Anchor: -1}}}}, "position.selections[0].anchor"},
+		{"too many selections", &types.CursorPayload{DocumentID: "doc", Position: types.CursorPosition{Selections: make([]types.SelectionRange, MaxSelections+1)}}, "position.selections"},
+		{"viewport ending early", &types.ViewportPayload{DocumentID: "doc", StartLine: 5, EndLine: 4}, "endLine"},
+		{"large awareness", &types.AwarenessPayload{DocumentID: "doc", State: map[string]interface{}{"k": strings.Repeat("x", MaxAwarenessBytes)}}, "state"},
+		{"comment over no text", &types.CommentAddPayload{DocumentID: "doc", Start: 4, End: 2, Content: "hm"}, "end"},
+		{"blank comment", &types.CommentAddPayload{DocumentID: "doc", Content: "  "}, "content"},
+		{"type without rules", &types.LeavePayload{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.payload)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) || fieldErr.Field != tt.field {
+				t.Fatalf("error = %v, want one for %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestValidateNormalizes(t *testing.T) {
+	room := &types.JoinRoomPayload{User: validUser(), RoomCode: " ab12cd "}
+	if err := Validate(room); err != nil {
+		t.Fatal(err)
+	}
+	if room.RoomCode != "AB12CD" {
+		t.Fatalf("room code = %q", room.RoomCode)
+	}
+
+	// Inserted text is checked but never rewritten
+	op := &types.OperationPayload{DocumentID: "doc", Operation: types.Operation{Type: "insert", Content: " a\n\tc "}}
+	if err := Validate(op); err != nil {
+		t.Fatal(err)
+	}
+	if op.Operation.Content != " a\n\tc " {
+		t.Fatalf("content = %q", op.Operation.Content)
+	}
+
+	title := &types.TitleUpdatePayload{DocumentID: "doc", NewTitle: "  Notes\n\x00 "}
+	if err := Validate(title); err != nil {
+		t.Fatal(err)
+	}
+	if title.NewTitle != "Notes" {
+		t.Fatalf("title = %q", title.NewTitle)
+	}
+
+	cursor := &types.CursorPayload{DocumentID: "doc", Position: types.CursorPosition{
+		Position:   99,
+		Selections: []types.SelectionRange{{Anchor: 2, Head: 5}, {Anchor: 8, Head: 8}},
+	}}
+	if err := Validate(cursor); err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Position.Position != 5 {
+		t.Fatalf("caret = %d, want the head of the first selection", cursor.Position.Position)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	decode := func(data string, v interface{}) error {
+		message := types.WebSocketMessage{Payload: json.RawMessage(data)}
+		return DecodeError(message.DecodePayload(v))
+	}
+
+	tests := []struct {
+		name  string
+		data  string
+		field string
+	}{
+		{"wrong type", `{"documentId":"doc","operation":{"position":"three"}}`, "operation.position"},
+		{"unknown field", `{"documentId":"doc","admin":true}`, "admin"},
+		{"malformed", `{"documentId":`, "payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload types.OperationPayload
+			var fieldErr *FieldError
+			if err := decode(tt.data, &payload); !errors.As(err, &fieldErr) || fieldErr.Field != tt.field {
+				t.Fatalf("error = %v, want one for %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestStripControl(t *testing.T) {
+	tests := []struct {
+		in        string
+		multiline bool
+		want      string
+	}{
+		{"a\nb\tc\x00", true, "a\nb\tc"},
+		{"a\nb\tc\x00", false, "abc"},
+		{"caf\xe9", false, "caf"},
+	}
+	for _, tt := range tests {
+		if got := StripControl(tt.in, tt.multiline); got != tt.want {
+			t.Errorf("StripControl(%q, %t) = %q, want %q", tt.in, tt.multiline, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,551 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"markdown-editor-backend/pkg/types"
+)
+
+// Event types
+const (
+	EventDocumentCreated = "document.created"
+	EventTitleChanged    = "document.title_changed"
+	EventDocumentEdited  = "document.edited"
+	EventSnapshot        = "document.snapshot"
+	EventDocumentDeleted = "document.deleted"
+	EventUserJoined      = "user.joined"
+)
+
+// EventTypes lists every event a subscription can ask for
+var EventTypes = []string{
+	EventDocumentCreated,
+	EventTitleChanged,
+	EventDocumentEdited,
+	EventSnapshot,
+	EventDocumentDeleted,
+	EventUserJoined,
+}
+
+// Headers sent with each delivery. The signature header has the form
+// t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed by the secret>.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderEventID   = "X-Webhook-Id"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// deliveryLogSize is how many deliveries are kept per subscription
+const deliveryLogSize = 100
+
+var (
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrInvalidURL           = errors.New("webhook url must be an absolute http or https url")
+	ErrUnknownEvent         = errors.New("unknown webhook event")
+	ErrForbiddenAddress     = errors.New("webhook target is a loopback, private or link-local address")
+)
+
+// Subscription sends the selected events to URL, for one document or, with
+// an empty DocumentID, for all of them. No events means all events.
+// OwnerID is the user who subscribed, empty for configured subscriptions.
+type Subscription struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	DocumentID string    `json:"documentId,omitempty"`
+	OwnerID    string    `json:"ownerId,omitempty"`
+	Events     []string  `json:"events,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (s *Subscription) wants(event *Event) bool {
+	if s.DocumentID != "" && s.DocumentID != event.DocumentID {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, eventType := range s.Events {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the JSON body posted to subscribers
+type Event struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	DocumentID string      `json:"documentId"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Data       interface{} `json:"data"`
+}
+
+// Delivery records the attempts to send one event to one subscription
+type Delivery struct {
+	ID             string     `json:"id"`
+	SubscriptionID string     `json:"subscriptionId"`
+	EventID        string     `json:"eventId"`
+	EventType      string     `json:"eventType"`
+	Attempts       int        `json:"attempts"`
+	StatusCode     int        `json:"statusCode,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	Success        bool       `json:"success"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty"`
+}
+
+// Options tune delivery. Zero values take the defaults.
+type Options struct {
+	// Client posts the events. The default one refuses to connect to
+	// loopback, private and link-local addresses.
+	Client *http.Client
+	// AllowPrivateTargets accepts subscriptions to those addresses, for
+	// receivers on a trusted network
+	AllowPrivateTargets bool
+	// MaxAttempts is the number of tries per delivery, retrying network
+	// errors, 408, 429 and 5xx responses
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled on each retry
+	Backoff time.Duration
+	// EditDebounce is how long edits must pause before document.edited
+	EditDebounce time.Duration
+	// SnapshotEvery sends document.snapshot each time edits take the
+	// document version past a multiple of it
+	SnapshotEvery int
+}
+
+// Dispatcher keeps the subscriptions of this node and delivers events to
+// them in the background
+type Dispatcher struct {
+	options       Options
+	subscriptions map[string]*Subscription
+	deliveries    map[string][]*Delivery // subscriptionID -> log, oldest first
+	edits         map[string]*editState  // documentID -> edits since the last events
+	mutex         sync.Mutex
+}
+
+// editState collects the edits of a document until document.edited is
+// sent
+type editState struct {
+	timer      *time.Timer
+	editors    map[string]bool
+	operations int
+	version    int
+}
+
+// NewDispatcher creates a dispatcher with no subscriptions
+func NewDispatcher(options Options) *Dispatcher {
+	if options.Client == nil {
+		if options.AllowPrivateTargets {
+			options.Client = &http.Client{Timeout: 10 * time.Second}
+		} else {
+			options.Client = publicClient()
+		}
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 5
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = time.Second
+	}
+	if options.EditDebounce <= 0 {
+		options.EditDebounce = 5 * time.Second
+	}
+	if options.SnapshotEvery <= 0 {
+		options.SnapshotEvery = 100
+	}
+
+	return &Dispatcher{
+		options:       options,
+		subscriptions: make(map[string]*Subscription),
+		deliveries:    make(map[string][]*Delivery),
+		edits:         make(map[string]*editState),
+	}
+}
+
+// Subscribe validates and adds a subscription, generating its ID and, if
+// none is given, its secret
+func (d *Dispatcher) Subscribe(subscription Subscription) (*Subscription, error) {
+	parsed, err := url.Parse(subscription.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	// Names are only resolved when delivering, where they are checked too
+	addr, err := netip.ParseAddr(parsed.Hostname())
+	if err == nil && !isPublic(addr) && !d.options.AllowPrivateTargets {
+		return nil, ErrForbiddenAddress
+	}
+	for _, eventType := range subscription.Events {
+		if !isEventType(eventType) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, eventType)
+		}
+	}
+
+	subscription.ID = uuid.New().String()
+	subscription.CreatedAt = time.Now()
+	if subscription.Secret == "" {
+		subscription.Secret = randomSecret()
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stored := subscription
+	d.subscriptions[stored.ID] = &stored
+	return &subscription, nil
+}
+
+// Unsubscribe removes a subscription and its delivery log
+func (d *Dispatcher) Unsubscribe(id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, exists := d.subscriptions[id]; !exists {
+		return ErrSubscriptionNotFound
+	}
+	delete(d.subscriptions, id)
+	delete(d.deliveries, id)
+	return nil
+}
+
+// Subscription returns a subscription without its secret
+func (d *Dispatcher) Subscription(id string) (Subscription, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	subscription, exists := d.subscriptions[id]
+	if !exists {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	return withoutSecret(subscription), nil
+}
+
+// Subscriptions lists the subscriptions of a user, without their secrets,
+// oldest first. A non-empty documentID keeps those of that document.
+func (d *Dispatcher) Subscriptions(ownerID, documentID string) []Subscription {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	result := make([]Subscription, 0, len(d.subscriptions))
+	for _, subscription := range d.subscriptions {
+		if subscription.OwnerID != ownerID {
+			continue
+		}
+		if documentID != "" && subscription.DocumentID != documentID {
+			continue
+		}
+		result = append(result, withoutSecret(subscription))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+func withoutSecret(subscription *Subscription) Subscription {
+	copied := *subscription
+	copied.Secret = ""
+	copied.Events = append([]string(nil), subscription.Events...)
+	return copied
+}
+
+// Deliveries returns the delivery log of a subscription, newest first
+func (d *Dispatcher) Deliveries(subscriptionID string) ([]Delivery, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, exists := d.subscriptions[subscriptionID]; !exists {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	log := d.deliveries[subscriptionID]
+	result := make([]Delivery, 0, len(log))
+	for i := len(log) - 1; i >= 0; i-- {
+		result = append(result, *log[i])
+	}
+	return result, nil
+}
+
+// Emit sends an event to every interested subscription
+func (d *Dispatcher) Emit(eventType, documentID string, data interface{}) {
+	event := &Event{
+		ID:         uuid.New().String(),
+		Type:       eventType,
+		DocumentID: documentID,
+		Timestamp:  time.Now(),
+		Data:       data,
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling webhook event %s: %v", eventType, err)
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, subscription := range d.subscriptions {
+		if !subscription.wants(event) {
+			continue
+		}
+
+		delivery := &Delivery{
+			ID:             uuid.New().String(),
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			CreatedAt:      time.Now(),
+		}
+		deliveries := append(d.deliveries[subscription.ID], delivery)
+		if len(deliveries) > deliveryLogSize {
+			deliveries = append([]*Delivery(nil), deliveries[len(deliveries)-deliveryLogSize:]...)
+		}
+		d.deliveries[subscription.ID] = deliveries
+
+		go d.deliver(*subscription, event, body, delivery)
+	}
+}
+
+// EditedData is sent with document.edited
+type EditedData struct {
+	Version    int      `json:"version"`
+	Operations int      `json:"operations"`
+	Editors    []string `json:"editors"`
+}
+
+// SnapshotData is sent with document.snapshot
+type SnapshotData struct {
+	Version int    `json:"version"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+// Edited records an operation committed by userID. document.edited is
+// sent once edits to the document pause, and document.snapshot each time
+// the version passes a multiple of SnapshotEvery.
+func (d *Dispatcher) Edited(doc *types.Document, userID string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	state, exists := d.edits[doc.ID]
+	if !exists {
+		// Each operation adds one version
+		state = &editState{editors: make(map[string]bool), version: doc.Version - 1}
+		d.edits[doc.ID] = state
+	}
+	previous := state.version
+	state.editors[userID] = true
+	state.operations++
+	state.version = doc.Version
+
+	// Several operations can commit at one version, as when accepting a
+	// suggestion, and title changes add versions in between
+	if doc.Version/d.options.SnapshotEvery > previous/d.options.SnapshotEvery {
+		snapshot := SnapshotData{Version: doc.Version, Title: doc.Title, Content: doc.Content}
+		go d.Emit(EventSnapshot, doc.ID, snapshot)
+	}
+
+	documentID := doc.ID
+	if state.timer != nil {
+		state.timer.Reset(d.options.EditDebounce)
+		return
+	}
+	state.timer = time.AfterFunc(d.options.EditDebounce, func() {
+		d.flushEdits(documentID, state)
+	})
+}
+
+func (d *Dispatcher) flushEdits(documentID string, flushed *editState) {
+	d.mutex.Lock()
+	// A timer reset while it fired runs again after its edits were sent
+	state, exists := d.edits[documentID]
+	if !exists || state != flushed {
+		d.mutex.Unlock()
+		return
+	}
+
+	editors := make([]string, 0, len(state.editors))
+	for editor := range state.editors {
+		editors = append(editors, editor)
+	}
+	sort.Strings(editors)
+	data := EditedData{Version: state.version, Operations: state.operations, Editors: editors}
+
+	// The next edit starts afresh, idle documents keep nothing here
+	delete(d.edits, documentID)
+	d.mutex.Unlock()
+
+	d.Emit(EventDocumentEdited, documentID, data)
+}
+
+// Deleted sends document.deleted, dropping pending edit events and the
+// subscriptions scoped to the document with their delivery logs
+func (d *Dispatcher) Deleted(documentID string) {
+	d.mutex.Lock()
+	if state, exists := d.edits[documentID]; exists {
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+		delete(d.edits, documentID)
+	}
+	d.mutex.Unlock()
+
+	d.Emit(EventDocumentDeleted, documentID, map[string]string{"documentId": documentID})
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for id, subscription := range d.subscriptions {
+		if subscription.DocumentID == documentID {
+			delete(d.subscriptions, id)
+			delete(d.deliveries, id)
+		}
+	}
+}
+
+// deliver posts the event, retrying with exponential backoff
+func (d *Dispatcher) deliver(subscription Subscription, event *Event, body []byte, delivery *Delivery) {
+	backoff := d.options.Backoff
+
+	for attempt := 1; attempt <= d.options.MaxAttempts; attempt++ {
+		status, err := d.post(subscription, event, body)
+		success := err == nil && status >= 200 && status < 300
+
+		d.mutex.Lock()
+		delivery.Attempts = attempt
+		delivery.StatusCode = status
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.Success = success
+		done := success || !retryable(status, err) || attempt == d.options.MaxAttempts
+		if done {
+			now := time.Now()
+			delivery.CompletedAt = &now
+		}
+		d.mutex.Unlock()
+
+		if done {
+			if !success {
+				log.Printf("Webhook %s to %s failed after %d attempts", event.Type, subscription.URL, attempt)
+			}
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) post(subscription Subscription, event *Event, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderEventID, event.ID)
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, time.Now(), body))
+
+	resp, err := d.options.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value for a body sent at timestamp
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// publicClient returns a client that only connects to public addresses,
+// so that subscribers cannot make the server reach internal services. The
+// check runs on the resolved address of every connection, redirects
+// included.
+func publicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublic(addrPort.Addr()) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// No proxy: it would dial internal targets on our behalf
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// isPublic reports whether an address may receive webhooks
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !(addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast())
+}
+
+func retryable(status int, err error) bool {
+	if err != nil {
+		return true
+	}
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+func isEventType(eventType string) bool {
+	for _, known := range EventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func randomSecret() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Error generating webhook secret: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,318 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"markdown-editor-backend/pkg/types"
+)
+
+// receiver records the requests of an httptest server answering with the
+// statuses it is given, then 204
+type receiver struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []received
+}
+
+type received struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+	event  Event
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rc := &receiver{statuses: statuses}
+	rc.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var event Event
+		json.Unmarshal(body, &event)
+
+		rc.mutex.Lock()
+		rc.requests = append(rc.requests, received{at: time.Now(), header: r.Header, body: body, event: event})
+		status := http.StatusNoContent
+		if len(rc.statuses) > 0 {
+			status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+		}
+		rc.mutex.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rc.server.Close)
+	return rc
+}
+
+// events returns the requests received for one event type
+func (rc *receiver) events(eventType string) []received {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	var matching []received
+	for _, request := range rc.requests {
+		if request.event.Type == eventType {
+			matching = append(matching, request)
+		}
+	}
+	return matching
+}
+
+func newTestDispatcher(options Options) *Dispatcher {
+	options.AllowPrivateTargets = true
+	return NewDispatcher(options)
+}
+
+func subscribe(t *testing.T, d *Dispatcher, subscription Subscription) *Subscription {
+	t.Helper()
+	created, err := d.Subscribe(subscription)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+// waitForDelivery waits until the subscription's latest delivery is done
+func waitForDelivery(t *testing.T, d *Dispatcher, subscriptionID string, count int) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		deliveries, err := d.Deliveries(subscriptionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := len(deliveries) >= count
+		for _, delivery := range deliveries {
+			done = done && delivery.CompletedAt != nil
+		}
+		if done {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries not done: %+v", deliveries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeliveriesAreSigned(t *testing.T) {
+	rc := newReceiver(t)
+	d := newTestDispatcher(Options{})
+	subscription := subscribe(t, d, Subscription{URL: rc.server.URL, Secret: "shh"})
+
+	d.Emit(EventDocumentCreated, "doc", map[string]string{"title": "Notes"})
+	waitForDelivery(t, d, subscription.ID, 1)
+
+	requests := rc.events(EventDocumentCreated)
+	if len(requests) != 1 {
+		t.Fatalf("received %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	if request.header.Get(HeaderEvent) != EventDocumentCreated || request.header.Get(HeaderEventID) != request.event.ID {
+		t.Fatalf("event headers = %v", request.header)
+	}
+
+	var timestamp, signature string
+	for _, part := range strings.Split(request.header.Get(HeaderSignature), ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write([]byte(timestamp + "." + string(request.body)))
+	if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		t.Fatalf("signature %q does not verify", request.header.Get(HeaderSignature))
+	}
+}
+
+func TestServerErrorsAreRetriedWithBackoff(t *testing.T) {
+	rc := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	backoff := 20 * time.Millisecond
+	d := newTestDispatcher(Options{Backoff: backoff})
+	subscription := subscribe(t, d, Subscription{URL: rc.server.URL})
+
+	d.Emit(EventDocumentCreated, "doc", nil)
+	deliveries := waitForDelivery(t, d, subscription.ID, 1)
+
+	if delivery := deliveries[0]; !delivery.Success || delivery.Attempts != 3 || delivery.StatusCode != http.StatusNoContent {
+		t.Fatalf("delivery = %+v, want success on the third attempt", delivery)
+	}
+	requests := rc.events(EventDocumentCreated)
+	if len(requests) != 3 {
+		t.Fatalf("received %d attempts, want 3", len(requests))
+	}
+	// The delay doubles after each attempt
+	if gap := requests[1].at.Sub(requests[0].at); gap < backoff {
+		t.Fatalf("first retry after %v, want at least %v", gap, backoff)
+	}
+	if gap := requests[2].at.Sub(requests[1].at); gap < 2*backoff {
+		t.Fatalf("second retry after %v, want at least %v", gap, 2*backoff)
+	}
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+	rc := newReceiver(t, http.StatusBadRequest)
+	d := newTestDispatcher(Options{Backoff: time.Millisecond})
+	subscription := subscribe(t, d, Subscription{URL: rc.server.URL})
+
+	d.Emit(EventDocumentCreated, "doc", nil)
+	deliveries := waitForDelivery(t, d, subscription.ID, 1)
+
+	if delivery := deliveries[0]; delivery.Success || delivery.Attempts != 1 || delivery.StatusCode != http.StatusBadRequest {
+		t.Fatalf("delivery = %+v, want one failed attempt", delivery)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if requests := rc.events(EventDocumentCreated); len(requests) != 1 {
+		t.Fatalf("received %d attempts, want 1", len(requests))
+	}
+}
+
+func TestEditsAreDebounced(t *testing.T) {
+	rc := newReceiver(t)
+	d := newTestDispatcher(Options{EditDebounce: 30 * time.Millisecond})
+	subscription := subscribe(t, d, Subscription{URL: rc.server.URL, Events: []string{EventDocumentEdited}})
+
+	doc := &types.Document{ID: "doc", Version: 1}
+	for i, user := range []string{"ada", "bob", "ada", "ada"} {
+		doc.Version = 2 + i
+		d.Edited(doc, user)
+	}
+	deliveries := waitForDelivery(t, d, subscription.ID, 1)
+	if len(deliveries) != 1 {
+		t.Fatalf("sent %d document.edited events, want 1", len(deliveries))
+	}
+
+	var data EditedData
+	raw, _ := json.Marshal(rc.events(EventDocumentEdited)[0].event.Data)
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Operations != 4 || data.Version != 5 || strings.Join(data.Editors, ",") != "ada,bob" {
+		t.Fatalf("edited data = %+v", data)
+	}
+
+	d.mutex.Lock()
+	pending := len(d.edits)
+	d.mutex.Unlock()
+	if pending != 0 {
+		t.Fatalf("%d documents still hold edit state after the event", pending)
+	}
+}
+
+func TestSnapshotsFollowTheVersion(t *testing.T) {
+	rc := newReceiver(t)
+	d := newTestDispatcher(Options{EditDebounce: 10 * time.Millisecond, SnapshotEvery: 3})
+	subscription := subscribe(t, d, Subscription{URL: rc.server.URL, Events: []string{EventSnapshot}})
+
+	doc := &types.Document{ID: "doc", Version: 1}
+	for doc.Version < 5 {
+		doc.Version++
+		d.Edited(doc, "ada")
+	}
+	// Edits after a pause keep counting from the version
+	time.Sleep(30 * time.Millisecond)
+	for doc.Version < 9 {
+		doc.Version++
+		d.Edited(doc, "ada")
+		// Accepting a suggestion commits several operations at once
+		d.Edited(doc, "ada")
+	}
+
+	waitForDelivery(t, d, subscription.ID, 3)
+	var versions []int
+	for _, request := range rc.events(EventSnapshot) {
+		var data SnapshotData
+		raw, _ := json.Marshal(request.event.Data)
+		json.Unmarshal(raw, &data)
+		versions = append(versions, data.Version)
+	}
+	sort.Ints(versions)
+	if fmt.Sprint(versions) != "[3 6 9]" {
+		t.Fatalf("snapshots at versions %v, want 3, 6 and 9", versions)
+	}
+}
+
+func TestDeliveryLogIsTrimmed(t *testing.T) {
+	rc := newReceiver(t)
+	d := newTestDispatcher(Options{})
+	subscription := subscribe(t, d, Subscription{URL: rc.server.URL})
+
+	for i := 0; i < deliveryLogSize+20; i++ {
+		d.Emit(EventDocumentCreated, "doc", i)
+	}
+	deliveries := waitForDelivery(t, d, subscription.ID, deliveryLogSize)
+	if len(deliveries) != deliveryLogSize {
+		t.Fatalf("log holds %d deliveries, want %d", len(deliveries), deliveryLogSize)
+	}
+	if deliveries[0].CreatedAt.Before(deliveries[len(deliveries)-1].CreatedAt) {
+		t.Fatal("deliveries are not newest first")
+	}
+}
+
+func TestDeletedDropsDocumentSubscriptions(t *testing.T) {
+	rc := newReceiver(t)
+	d := newTestDispatcher(Options{EditDebounce: time.Hour})
+	scoped := subscribe(t, d, Subscription{URL: rc.server.URL, DocumentID: "doc"})
+	global := subscribe(t, d, Subscription{URL: rc.server.URL})
+
+	d.Edited(&types.Document{ID: "doc", Version: 2}, "ada")
+	d.Deleted("doc")
+
+	if _, err := d.Deliveries(scoped.ID); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Fatalf("scoped subscription log: %v, want it gone", err)
+	}
+	d.mutex.Lock()
+	logs, pending := len(d.deliveries), len(d.edits)
+	d.mutex.Unlock()
+	if logs != 1 || pending != 0 {
+		t.Fatalf("%d delivery logs and %d edit states left, want 1 and 0", logs, pending)
+	}
+	if deliveries := waitForDelivery(t, d, global.ID, 1); deliveries[0].EventType != EventDocumentDeleted {
+		t.Fatalf("global subscription got %+v, want document.deleted", deliveries)
+	}
+}
+
+func TestPrivateTargetsAreRefused(t *testing.T) {
+	d := NewDispatcher(Options{MaxAttempts: 1})
+
+	for _, target := range []string{
+		"http://127.0.0.1/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]:8080/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if _, err := d.Subscribe(Subscription{URL: target}); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("subscribing %s: %v, want ErrForbiddenAddress", target, err)
+		}
+	}
+
+	// Names are checked once resolved
+	rc := newReceiver(t)
+	subscription := subscribe(t, d, Subscription{URL: strings.Replace(rc.server.URL, "127.0.0.1", "localhost", 1)})
+	d.Emit(EventDocumentCreated, "doc", nil)
+	deliveries := waitForDelivery(t, d, subscription.ID, 1)
+	if deliveries[0].Success || !strings.Contains(deliveries[0].Error, ErrForbiddenAddress.Error()) {
+		t.Fatalf("delivery to localhost = %+v, want it refused", deliveries[0])
+	}
+	if requests := rc.events(EventDocumentCreated); len(requests) != 0 {
+		t.Fatalf("localhost received %d requests", len(requests))
+	}
+}
@@ -2,20 +2,21 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
-	"net/http"
 	"sync"
 
 	"github.com/gorilla/websocket"
+	"markdown-editor-backend/internal/cluster"
+	"markdown-editor-backend/internal/protocol"
 	"markdown-editor-backend/pkg/types"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from any origin (adjust for production)
-		return true
-	},
-}
+// Errors returned by Client.SendMessage
+var (
+	ErrClientClosed  = errors.New("client connection closed")
+	ErrSendQueueFull = errors.New("client send queue full")
+)
 
 // Client represents a WebSocket client
 type Client struct {
@@ -25,29 +26,153 @@ type Client struct {
 	Send       chan []byte
 	DocumentID string
 	UserID     string
+	RemoteAddr string
+	// Authenticated is set when the connection joined with a token issued
+	// to UserID, rather than just claiming the ID
+	Authenticated bool
+	// Codec is the wire format negotiated on upgrade, JSON when nil
+	Codec protocol.Codec
+
+	// closed is set when Send is closed on unregister, so that replies
+	// sent afterwards are dropped instead of panicking
+	closed    bool
+	sendMutex sync.Mutex
 }
 
 // Hub maintains the set of active clients and broadcasts messages
 type Hub struct {
-	clients    map[*Client]bool
-	documents  map[string]map[*Client]bool // documentID -> clients
+	clients    map[*Client]string                     // client -> document it is registered in
+	documents  map[string]map[*Client]bool            // documentID -> clients
+	followers  map[string]map[string]map[*Client]bool // documentID -> followed userID -> followers
+	following  map[*Client]string                     // follower -> followed userID
 	broadcast  chan []byte
 	register   chan *Client
 	unregister chan *Client
 	mutex      sync.RWMutex
+
+	// Cluster fan-out, nil when running as a single node
+	nodeID        string
+	broker        cluster.Broker
+	nodeHandler   func(message []byte)
+	remoteHandler func(documentID string, message *types.WebSocketMessage)
+	handlersMutex sync.RWMutex
+}
+
+// envelope wraps a document broadcast relayed between nodes
+type envelope struct {
+	NodeID          string          `json:"nodeId"`
+	DocumentID      string          `json:"documentId"`
+	ExcludeClientID string          `json:"excludeClientId,omitempty"`
+	FollowedUserID  string          `json:"followedUserId,omitempty"` // only deliver to this user's followers
+	UserID          string          `json:"userId,omitempty"`         // only deliver to this user, in any document
+	Replicated      bool            `json:"replicated,omitempty"`     // node state only, not for clients
+	Message         json.RawMessage `json:"message"`
 }
 
 // NewHub creates a new WebSocket hub
 func NewHub() *Hub {
 	return &Hub{
-		clients:    make(map[*Client]bool),
+		clients:    make(map[*Client]string),
 		documents:  make(map[string]map[*Client]bool),
+		followers:  make(map[string]map[string]map[*Client]bool),
+		following:  make(map[*Client]string),
 		broadcast:  make(chan []byte),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 	}
 }
 
+// NewHubWithBroker creates a hub that relays document broadcasts to the
+// other nodes of a cluster through broker
+func NewHubWithBroker(nodeID string, broker cluster.Broker) (*Hub, error) {
+	h := NewHub()
+	h.nodeID = nodeID
+	h.broker = broker
+
+	if err := broker.Subscribe(cluster.DocumentChannel, h.receiveBroadcast); err != nil {
+		return nil, err
+	}
+	if err := broker.Subscribe(cluster.NodeChannel(nodeID), h.receiveNodeMessage); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// NodeID returns the identifier of this server instance
+func (h *Hub) NodeID() string {
+	return h.nodeID
+}
+
+// HandleNodeMessages sets the callback for messages sent to this node
+// with SendToNode
+func (h *Hub) HandleNodeMessages(handler func(message []byte)) {
+	h.handlersMutex.Lock()
+	defer h.handlersMutex.Unlock()
+	h.nodeHandler = handler
+}
+
+// HandleRemoteBroadcasts sets the callback invoked for every document
+// broadcast originating on another node, after local delivery
+func (h *Hub) HandleRemoteBroadcasts(handler func(documentID string, message *types.WebSocketMessage)) {
+	h.handlersMutex.Lock()
+	defer h.handlersMutex.Unlock()
+	h.remoteHandler = handler
+}
+
+// SendToNode delivers a message to a single node of the cluster
+func (h *Hub) SendToNode(nodeID string, message []byte) error {
+	if h.broker == nil {
+		return nil
+	}
+	return h.broker.Publish(cluster.NodeChannel(nodeID), message)
+}
+
+func (h *Hub) receiveNodeMessage(channel string, payload []byte) {
+	h.handlersMutex.RLock()
+	handler := h.nodeHandler
+	h.handlersMutex.RUnlock()
+
+	if handler != nil {
+		handler(payload)
+	}
+}
+
+func (h *Hub) receiveBroadcast(channel string, payload []byte) {
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		log.Printf("Error unmarshaling cluster envelope: %v", err)
+		return
+	}
+	if env.NodeID == h.nodeID {
+		return
+	}
+
+	message, err := protocol.JSON.Decode(env.Message)
+	if err != nil {
+		log.Printf("Error unmarshaling relayed message: %v", err)
+		return
+	}
+
+	switch {
+	case env.Replicated:
+		// Node state, only for the remote handler below
+	case env.UserID != "":
+		h.deliverToUser(env.UserID, message)
+	case env.FollowedUserID != "":
+		h.deliverToFollowers(env.DocumentID, env.FollowedUserID, message)
+	default:
+		h.deliverToDocument(env.DocumentID, message, env.ExcludeClientID)
+	}
+
+	h.handlersMutex.RLock()
+	handler := h.remoteHandler
+	h.handlersMutex.RUnlock()
+
+	if handler != nil {
+		handler(env.DocumentID, message)
+	}
+}
+
 // Run starts the hub
 func (h *Hub) Run() {
 	for {
@@ -64,11 +189,23 @@ func (h *Hub) Run() {
 	}
 }
 
+// registerClient adds a client to its document, moving it out of the one
+// it was registered in before if it switched documents
 func (h *Hub) registerClient(client *Client) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	h.clients[client] = true
+	// A connection unregistered before has no Send channel left, it is
+	// closing and must not be delivered to again
+	if client.isClosed() {
+		log.Printf("Not registering closed client %s for document %s", client.UserID, client.DocumentID)
+		return
+	}
+
+	if previous, registered := h.clients[client]; registered {
+		h.removeFromDocumentLocked(client, previous)
+	}
+	h.clients[client] = client.DocumentID
 	
 	if _, exists := h.documents[client.DocumentID]; !exists {
 		h.documents[client.DocumentID] = make(map[*Client]bool)
@@ -82,55 +219,252 @@ func (h *Hub) unregisterClient(client *Client) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	if _, ok := h.clients[client]; ok {
+	if documentID, ok := h.clients[client]; ok {
 		delete(h.clients, client)
-		close(client.Send)
+		client.closeSend()
+		h.unfollowLocked(client)
+		h.removeFromDocumentLocked(client, documentID)
 
-		if docClients, exists := h.documents[client.DocumentID]; exists {
-			delete(docClients, client)
-			if len(docClients) == 0 {
-				delete(h.documents, client.DocumentID)
-			}
-		}
+		log.Printf("Client %s unregistered from document %s", client.UserID, documentID)
+	}
+}
 
-		log.Printf("Client %s unregistered from document %s", client.UserID, client.DocumentID)
+// removeFromDocumentLocked must be called with the write lock held
+func (h *Hub) removeFromDocumentLocked(client *Client, documentID string) {
+	if docClients, exists := h.documents[documentID]; exists {
+		delete(docClients, client)
+		if len(docClients) == 0 {
+			delete(h.documents, documentID)
+		}
 	}
 }
 
 func (h *Hub) broadcastMessage(message []byte) {
 	h.mutex.RLock()
-	defer h.mutex.RUnlock()
-
+	var slow []*Client
 	for client := range h.clients {
 		select {
 		case client.Send <- message:
 		default:
-			close(client.Send)
-			delete(h.clients, client)
+			slow = append(slow, client)
 		}
 	}
+	h.mutex.RUnlock()
+
+	h.dropClients(slow)
+}
+
+// dropClients unregisters clients whose send buffer is full. It must not
+// be called with the read lock held: maps are only modified under the
+// write lock, since concurrent map writes abort the whole process.
+func (h *Hub) dropClients(clients []*Client) {
+	for _, client := range clients {
+		log.Printf("Dropping slow client %s in document %s", client.UserID, client.DocumentID)
+		h.unregisterClient(client)
+	}
 }
 
 // BroadcastToDocument sends a message to all clients in a specific document
-func (h *Hub) BroadcastToDocument(documentID string, message []byte, excludeClient *Client) {
+func (h *Hub) BroadcastToDocument(documentID string, message *types.WebSocketMessage, excludeClient *Client) {
+	excludeClientID := ""
+	if excludeClient != nil {
+		excludeClientID = excludeClient.ID
+	}
+	h.BroadcastToDocumentExcept(documentID, message, excludeClientID)
+}
+
+// BroadcastToDocumentExcept sends a message to all clients in a document,
+// on every node, except the connection with the given client ID
+func (h *Hub) BroadcastToDocumentExcept(documentID string, message *types.WebSocketMessage, excludeClientID string) {
+	h.deliverToDocument(documentID, message, excludeClientID)
+	h.publish(envelope{DocumentID: documentID, ExcludeClientID: excludeClientID}, message)
+}
+
+// Replicate relays a message to the other nodes of the cluster only, for
+// state they must mirror before any of their clients asks for it
+func (h *Hub) Replicate(documentID string, message *types.WebSocketMessage) {
+	h.publish(envelope{DocumentID: documentID, Replicated: true}, message)
+}
+
+// SendToFollowers sends a message to the connections following a user in
+// a document, on every node
+func (h *Hub) SendToFollowers(documentID, userID string, message *types.WebSocketMessage) {
+	h.deliverToFollowers(documentID, userID, message)
+
+	h.publish(envelope{
+		DocumentID:     documentID,
+		FollowedUserID: userID,
+	}, message)
+}
+
+// SendToUser sends a message to every connection of a user, whatever
+// document it is in, on every node. Only connections that joined with the
+// user's token receive it; guests can claim any user ID.
+func (h *Hub) SendToUser(userID string, message *types.WebSocketMessage) {
+	h.deliverToUser(userID, message)
+
+	h.publish(envelope{
+		UserID: userID,
+	}, message)
+}
+
+// publish relays a message to the other nodes of the cluster
+func (h *Hub) publish(env envelope, message *types.WebSocketMessage) {
+	if h.broker == nil {
+		return
+	}
+
+	messageBytes, err := protocol.JSON.Encode(message)
+	if err != nil {
+		log.Printf("Error marshaling broadcast for document %s: %v", env.DocumentID, err)
+		return
+	}
+
+	env.NodeID = h.nodeID
+	env.Message = messageBytes
+	if envBytes, err := json.Marshal(env); err == nil {
+		if err := h.broker.Publish(cluster.DocumentChannel, envBytes); err != nil {
+			log.Printf("Error publishing broadcast for document %s: %v", env.DocumentID, err)
+		}
+	}
+}
+
+// deliverToDocument sends a message to the clients connected to this node
+func (h *Hub) deliverToDocument(documentID string, message *types.WebSocketMessage, excludeClientID string) {
+	var slow []*Client
+	defer func() { h.dropClients(slow) }()
+
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
-	if docClients, exists := h.documents[documentID]; exists {
-		for client := range docClients {
-			if client != excludeClient {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
-					delete(docClients, client)
+	slow = deliver(h.documents[documentID], message, excludeClientID)
+}
+
+// deliverToFollowers sends a message to the followers of a user connected
+// to this node
+func (h *Hub) deliverToFollowers(documentID, userID string, message *types.WebSocketMessage) {
+	var slow []*Client
+	defer func() { h.dropClients(slow) }()
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	slow = deliver(h.followers[documentID][userID], message, "")
+}
+
+// deliverToUser sends a message to the connections of a user on this node
+func (h *Hub) deliverToUser(userID string, message *types.WebSocketMessage) {
+	var slow []*Client
+	defer func() { h.dropClients(slow) }()
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	clients := make(map[*Client]bool)
+	for client := range h.clients {
+		if client.UserID == userID && client.Authenticated {
+			clients[client] = true
+		}
+	}
+	slow = deliver(clients, message, "")
+}
+
+// deliver queues a message for each client, encoding it once per wire
+// format in use, and returns the clients whose buffer is full
+func deliver(clients map[*Client]bool, message *types.WebSocketMessage, excludeClientID string) []*Client {
+	var slow []*Client
+	encoded := make(map[protocol.Codec][]byte)
+
+	for client := range clients {
+		if excludeClientID == "" || client.ID != excludeClientID {
+			codec := client.codec()
+			messageBytes, ok := encoded[codec]
+			if !ok {
+				var err error
+				if messageBytes, err = codec.Encode(message); err != nil {
+					log.Printf("Error encoding %s message as %s: %v", message.Type, codec.Subprotocol(), err)
+					continue
 				}
+				encoded[codec] = messageBytes
 			}
+
+			select {
+			case client.Send <- messageBytes:
+			default:
+				slow = append(slow, client)
+			}
+		}
+	}
+	return slow
+}
+
+// Follow makes a connection receive the messages sent to the followers of
+// userID, replacing the user it followed before, which is returned
+func (h *Hub) Follow(client *Client, userID string) string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	previous := h.unfollowLocked(client)
+
+	users, exists := h.followers[client.DocumentID]
+	if !exists {
+		users = make(map[string]map[*Client]bool)
+		h.followers[client.DocumentID] = users
+	}
+	if _, exists := users[userID]; !exists {
+		users[userID] = make(map[*Client]bool)
+	}
+	users[userID][client] = true
+	h.following[client] = userID
+
+	return previous
+}
+
+// Unfollow stops a connection following anyone and returns the user it
+// followed, "" if none
+func (h *Hub) Unfollow(client *Client) string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.unfollowLocked(client)
+}
+
+// ReleaseFollowers stops every connection on this node following a user
+// who left the document
+func (h *Hub) ReleaseFollowers(documentID, userID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for client := range h.followers[documentID][userID] {
+		delete(h.following, client)
+	}
+	if users, exists := h.followers[documentID]; exists {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(h.followers, documentID)
 		}
 	}
 }
 
+// unfollowLocked must be called with the write lock held
+func (h *Hub) unfollowLocked(client *Client) string {
+	userID, exists := h.following[client]
+	if !exists {
+		return ""
+	}
+	delete(h.following, client)
+
+	users := h.followers[client.DocumentID]
+	delete(users[userID], client)
+	if len(users[userID]) == 0 {
+		delete(users, userID)
+	}
+	if len(users) == 0 {
+		delete(h.followers, client.DocumentID)
+	}
+	return userID
+}
+
 // GetDocumentClients returns all clients connected to a specific document
 func (h *Hub) GetDocumentClients(documentID string) []*Client {
 	h.mutex.RLock()
@@ -155,53 +489,59 @@ func (h *Hub) UnregisterClient(client *Client) {
 	h.unregister <- client
 }
 
-// WritePump handles outgoing messages to the client
-func (c *Client) WritePump() {
-	defer c.Conn.Close()
+// codec returns the wire format negotiated for the connection
+func (c *Client) codec() protocol.Codec {
+	if c.Codec == nil {
+		return protocol.JSON
+	}
+	return c.Codec
+}
 
-	for {
-		select {
-		case message, ok := <-c.Send:
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			c.Conn.WriteMessage(websocket.TextMessage, message)
-		}
+// SendMessage encodes a message in the client's wire format and queues it.
+// It never blocks: messages to an unregistered client or a full queue are
+// dropped with an error.
+func (c *Client) SendMessage(message *types.WebSocketMessage) error {
+	messageBytes, err := c.codec().Encode(message)
+	if err != nil {
+		return err
+	}
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+	select {
+	case c.Send <- messageBytes:
+		return nil
+	default:
+		return ErrSendQueueFull
 	}
 }
 
-// readPump handles incoming messages from the client
-func (c *Client) readPump() {
-	defer func() {
-		c.Hub.unregister <- c
-		c.Conn.Close()
-	}()
+// isClosed reports whether the send queue was closed on unregister
+func (c *Client) isClosed() bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
 
-	for {
-		_, messageBytes, err := c.Conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
-		}
+	return c.closed
+}
 
-		var message types.WebSocketMessage
-		if err := json.Unmarshal(messageBytes, &message); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
-			continue
-		}
+// closeSend closes the send queue, ending WritePump
+func (c *Client) closeSend() {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
 
-		// Handle the message based on its type
-		c.handleMessage(&message)
-	}
+	c.closed = true
+	close(c.Send)
 }
 
-// writePump handles outgoing messages to the client
-func (c *Client) writePump() {
+// WritePump handles outgoing messages to the client
+func (c *Client) WritePump() {
 	defer c.Conn.Close()
 
+	frameType := c.codec().FrameType()
 	for {
 		select {
 		case message, ok := <-c.Send:
@@ -209,45 +549,7 @@ func (c *Client) writePump() {
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-
-			c.Conn.WriteMessage(websocket.TextMessage, message)
+			c.Conn.WriteMessage(frameType, message)
 		}
 	}
 }
-
-// handleMessage processes incoming WebSocket messages
-func (c *Client) handleMessage(message *types.WebSocketMessage) {
-	switch message.Type {
-	case types.MessageTypeJoin:
-		c.handleJoin(message)
-	case types.MessageTypeOperation:
-		c.handleOperation(message)
-	case types.MessageTypeCursor:
-		c.handleCursor(message)
-	default:
-		log.Printf("Unknown message type: %s", message.Type)
-	}
-}
-
-func (c *Client) handleJoin(message *types.WebSocketMessage) {
-	payloadBytes, _ := json.Marshal(message.Payload)
-	var payload types.JoinPayload
-	json.Unmarshal(payloadBytes, &payload)
-
-	c.UserID = payload.User.ID
-	c.DocumentID = payload.DocumentID
-
-	log.Printf("User %s joined document %s", c.UserID, c.DocumentID)
-}
-
-func (c *Client) handleOperation(message *types.WebSocketMessage) {
-	// Broadcast operation to other clients in the same document
-	messageBytes, _ := json.Marshal(message)
-	c.Hub.BroadcastToDocument(c.DocumentID, messageBytes, c)
-}
-
-func (c *Client) handleCursor(message *types.WebSocketMessage) {
-	// Broadcast cursor position to other clients in the same document
-	messageBytes, _ := json.Marshal(message)
-	c.Hub.BroadcastToDocument(c.DocumentID, messageBytes, c)
-}
\ No newline at end of file
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"markdown-editor-backend/internal/cluster"
+	"markdown-editor-backend/internal/protocol"
+	"markdown-editor-backend/pkg/types"
+)
+
+func newTestNodeHub(t *testing.T, nodeID string, broker cluster.Broker) *Hub {
+	t.Helper()
+	hub, err := NewHubWithBroker(nodeID, broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hub
+}
+
+func joinHub(hub *Hub, id, documentID string) *Client {
+	client := &Client{ID: id, Hub: hub, Send: make(chan []byte, 8), DocumentID: documentID, UserID: id}
+	hub.registerClient(client)
+	return client
+}
+
+// received returns the types of the messages queued for a client
+func received(t *testing.T, client *Client) []string {
+	t.Helper()
+	var messages []string
+	for {
+		select {
+		case data := <-client.Send:
+			message, err := client.codec().Decode(data)
+			if err != nil {
+				t.Fatalf("%s received an invalid message: %v", client.ID, err)
+			}
+			messages = append(messages, message.Type)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestBroadcastReachesEveryNode(t *testing.T) {
+	broker := cluster.NewMemoryBroker()
+	hubA, hubB := newTestNodeHub(t, "node-a", broker), newTestNodeHub(t, "node-b", broker)
+
+	var remote []string
+	hubB.HandleRemoteBroadcasts(func(documentID string, message *types.WebSocketMessage) {
+		remote = append(remote, documentID+":"+message.Type)
+	})
+
+	sender := joinHub(hubA, "sender", "doc")
+	local := joinHub(hubA, "local", "doc")
+	away := joinHub(hubB, "away", "doc")
+	away.Codec = protocol.MessagePack
+	other := joinHub(hubB, "other", "other-doc")
+
+	hubA.BroadcastToDocument("doc", &types.WebSocketMessage{Type: "hello"}, sender)
+
+	tests := []struct {
+		client *Client
+		want   int
+	}{
+		{sender, 0},
+		{local, 1},
+		{away, 1},
+		{other, 0},
+	}
+	for _, tt := range tests {
+		if got := received(t, tt.client); len(got) != tt.want {
+			t.Errorf("%s received %v, want %d messages", tt.client.ID, got, tt.want)
+		}
+	}
+	if len(remote) != 1 || remote[0] != "doc:hello" {
+		t.Fatalf("remote handler got %v", remote)
+	}
+}
+
+func TestReplicateOnlyReachesNodes(t *testing.T) {
+	broker := cluster.NewMemoryBroker()
+	hubA, hubB := newTestNodeHub(t, "node-a", broker), newTestNodeHub(t, "node-b", broker)
+
+	replicated := make(chan string, 1)
+	hubB.HandleRemoteBroadcasts(func(documentID string, message *types.WebSocketMessage) {
+		replicated <- message.Type
+	})
+	hubA.HandleRemoteBroadcasts(func(documentID string, message *types.WebSocketMessage) {
+		t.Errorf("node-a handled its own replica %s", message.Type)
+	})
+	away := joinHub(hubB, "away", "doc")
+
+	hubA.Replicate("doc", &types.WebSocketMessage{Type: "state"})
+
+	select {
+	case message := <-replicated:
+		if message != "state" {
+			t.Fatalf("replicated %q", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("node-b never got the replica")
+	}
+	if got := received(t, away); len(got) != 0 {
+		t.Fatalf("a client received node state: %v", got)
+	}
+}
+
+func TestFollowersAreReachedOnEveryNode(t *testing.T) {
+	broker := cluster.NewMemoryBroker()
+	hubA, hubB := newTestNodeHub(t, "node-a", broker), newTestNodeHub(t, "node-b", broker)
+
+	leader := joinHub(hubA, "ada", "doc")
+	near := joinHub(hubA, "bob", "doc")
+	away := joinHub(hubB, "eve", "doc")
+	bystander := joinHub(hubB, "sam", "doc")
+	hubA.Follow(near, leader.UserID)
+	hubB.Follow(away, leader.UserID)
+
+	hubA.SendToFollowers("doc", leader.UserID, &types.WebSocketMessage{Type: "viewport"})
+
+	tests := []struct {
+		client *Client
+		want   int
+	}{
+		{leader, 0},
+		{near, 1},
+		{away, 1},
+		{bystander, 0},
+	}
+	for _, tt := range tests {
+		if got := received(t, tt.client); len(got) != tt.want {
+			t.Errorf("%s received %v, want %d messages", tt.client.ID, got, tt.want)
+		}
+	}
+
+	// Released followers no longer get the leader's updates
+	hubB.ReleaseFollowers("doc", leader.UserID)
+	hubA.SendToFollowers("doc", leader.UserID, &types.WebSocketMessage{Type: "viewport"})
+	if got := received(t, away); len(got) != 0 {
+		t.Fatalf("released follower received %v", got)
+	}
+}
+
+func TestUnregisteredClientsCannotRejoin(t *testing.T) {
+	hub := NewHub()
+	client := joinHub(hub, "client", "doc")
+	hub.unregisterClient(client)
+
+	// Its read loop may still ask to join another document
+	client.DocumentID = "other-doc"
+	hub.registerClient(client)
+	if clients := hub.GetDocumentClients("other-doc"); len(clients) != 0 {
+		t.Fatalf("closed client registered again: %v", clients)
+	}
+	hub.BroadcastToDocument("other-doc", &types.WebSocketMessage{Type: "hello"}, nil)
+}
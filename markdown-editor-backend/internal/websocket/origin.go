@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NewOriginChecker returns a CheckOrigin function accepting only the given
+// origins, the same list used for CORS. "*" accepts any origin. Requests
+// without an Origin header come from non-browser clients and are allowed,
+// since a browser always sends one on WebSocket upgrades.
+func NewOriginChecker(allowedOrigins []string) func(r *http.Request) bool {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	allowAll := false
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[normalizeOrigin(origin)] = true
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowAll {
+			return true
+		}
+		return allowed[normalizeOrigin(origin)]
+	}
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.TrimRight(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
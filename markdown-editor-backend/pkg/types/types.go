@@ -1,6 +1,8 @@
 package types
 
 import (
+	"bytes"
+	"encoding/json"
 	"time"
 )
 
@@ -12,6 +14,18 @@ type Document struct {
 	Content      string    `json:"content"`
 	LastModified time.Time `json:"lastModified"`
 	Version      int       `json:"version"`
+	// OwnerID is the user who created the document, empty when created
+	// through the REST API. Only the owner may accept suggestions.
+	OwnerID string `json:"ownerId,omitempty"`
+	// Metadata holds the front matter of an imported file, apart from
+	// the title
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// UserColors is the palette user colors are picked from
+var UserColors = []string{
+	"#FF6B6B", "#4ECDC4", "#45B7D1", "#96CEB4", "#FFEAA7",
+	"#DDA0DD", "#98D8C8", "#F7DC6F", "#BB8FCE", "#85C1E9",
 }
 
 // User represents a connected user
@@ -20,14 +34,29 @@ type User struct {
 	Name     string    `json:"name"`
 	Color    string    `json:"color"`
 	JoinedAt time.Time `json:"joinedAt"`
+	// Connections counts the user's open tabs/devices in a document
+	Connections int `json:"connections,omitempty"`
 }
 
-// CursorPosition represents a user's cursor position
+// CursorPosition represents a user's cursor position. Position, Line and
+// Column describe the primary caret; Selections holds every range the user
+// has, the primary one first.
 type CursorPosition struct {
-	UserID   string `json:"userId"`
-	Position int    `json:"position"`
-	Line     int    `json:"line"`
-	Column   int    `json:"column"`
+	UserID     string           `json:"userId"`
+	Position   int              `json:"position"`
+	Line       int              `json:"line"`
+	Column     int              `json:"column"`
+	Selections []SelectionRange `json:"selections,omitempty"`
+	// Color is the owner's user color, filled in by the server
+	Color string `json:"color,omitempty"`
+}
+
+// SelectionRange is a selection from Anchor, where it started, to Head,
+// where the caret is. Both are character offsets and equal for a plain
+// caret.
+type SelectionRange struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
 }
 
 // Operation represents a text operation
@@ -41,6 +70,124 @@ type Operation struct {
 	Version   int    `json:"version"`
 }
 
+// CommentThread is a discussion attached to the text between Start and
+// End. The anchors move with the text as the document is edited.
+type CommentThread struct {
+	ID         string     `json:"id"`
+	DocumentID string     `json:"documentId"`
+	Start      int        `json:"start"`
+	End        int        `json:"end"`
+	Quote      string     `json:"quote"` // anchored text when the thread was opened
+	Comments   []Comment  `json:"comments"`
+	Resolved   bool       `json:"resolved"`
+	ResolvedBy string     `json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Comment is one message in a comment thread
+type Comment struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	UserName  string    `json:"userName"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AuthorshipRun attributes Length consecutive characters to the user and
+// document version that inserted them. An empty UserID marks text whose
+// author is unknown.
+type AuthorshipRun struct {
+	UserID    string    `json:"userId"`
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Length    int       `json:"length"`
+}
+
+// BlameLine attributes one line of a document. Segments cover the line in
+// order, excluding the line break; UserID, Version and Timestamp are those
+// of the most recent segment.
+type BlameLine struct {
+	Line      int            `json:"line"`
+	Content   string         `json:"content"`
+	UserID    string         `json:"userId"`
+	UserName  string         `json:"userName"`
+	Version   int            `json:"version"`
+	Timestamp time.Time      `json:"timestamp"`
+	Segments  []BlameSegment `json:"segments"`
+}
+
+// BlameSegment is the part of a line inserted by one user at one version
+type BlameSegment struct {
+	UserID    string    `json:"userId"`
+	UserName  string    `json:"userName"`
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Length    int       `json:"length"`
+}
+
+// BlameResponse is returned by GET /api/documents/{id}/blame
+type BlameResponse struct {
+	DocumentID string      `json:"documentId"`
+	Version    int         `json:"version"`
+	Lines      []BlameLine `json:"lines"`
+}
+
+// ChatMessage is a message in a room's chat. Deleted messages keep their
+// place in the history with their content removed.
+type ChatMessage struct {
+	ID         string     `json:"id"`
+	DocumentID string     `json:"documentId"`
+	UserID     string     `json:"userId"`
+	UserName   string     `json:"userName"`
+	Content    string     `json:"content"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	EditedAt   *time.Time `json:"editedAt,omitempty"`
+	Deleted    bool       `json:"deleted,omitempty"`
+}
+
+// Notification types
+const (
+	NotificationMention = "mention"
+)
+
+// Notification is an entry in a user's inbox. ThreadID is set when the
+// mention is in a comment rather than in the document.
+type Notification struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	Type       string    `json:"type"`
+	DocumentID string    `json:"documentId"`
+	ActorID    string    `json:"actorId"`
+	ActorName  string    `json:"actorName"`
+	ThreadID   string    `json:"threadId,omitempty"`
+	Excerpt    string    `json:"excerpt"`
+	Read       bool      `json:"read"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Suggestion statuses
+const (
+	SuggestionPending  = "pending"
+	SuggestionAccepted = "accepted"
+	SuggestionRejected = "rejected"
+)
+
+// Suggestion is a change set proposed against BaseVersion instead of being
+// committed. Operations apply in order, each after the previous one.
+type Suggestion struct {
+	ID          string      `json:"id"`
+	DocumentID  string      `json:"documentId"`
+	UserID      string      `json:"userId"`
+	UserName    string      `json:"userName"`
+	BaseVersion int         `json:"baseVersion"`
+	Operations  []Operation `json:"operations"`
+	Status      string      `json:"status"`
+	ResolvedBy  string      `json:"resolvedBy,omitempty"`
+	ResolvedAt  *time.Time  `json:"resolvedAt,omitempty"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
 // WebSocketMessage represents messages sent over WebSocket
 type WebSocketMessage struct {
 	Type    string      `json:"type"`
@@ -48,6 +195,55 @@ type WebSocketMessage struct {
 	UserID  string      `json:"userId,omitempty"`
 }
 
+// PayloadDecoder is implemented by payloads still held in their wire
+// encoding, so they can be decoded once the message type is known
+type PayloadDecoder interface {
+	DecodePayload(v interface{}) error
+}
+
+// UnmarshalJSON keeps the payload as raw JSON until DecodePayload is called
+func (m *WebSocketMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+		UserID  string          `json:"userId,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	m.Type = raw.Type
+	m.Payload = raw.Payload
+	m.UserID = raw.UserID
+	return nil
+}
+
+// DecodePayload unmarshals the message payload into v, rejecting fields
+// that v does not declare
+func (m *WebSocketMessage) DecodePayload(v interface{}) error {
+	switch payload := m.Payload.(type) {
+	case json.RawMessage:
+		if len(payload) == 0 {
+			return nil
+		}
+		return decodeStrict(payload, v)
+	case PayloadDecoder:
+		return payload.DecodePayload(v)
+	default:
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		return decodeStrict(payloadBytes, v)
+	}
+}
+
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
 // Message types
 const (
 	MessageTypeJoin          = "join"
@@ -60,6 +256,24 @@ const (
 	MessageTypeDocumentSync  = "document_sync"
 	MessageTypeCreateRoom    = "create_room"
 	MessageTypeJoinRoom      = "join_room"
+	MessageTypePresence      = "presence"
+	MessageTypeFollow        = "follow"
+	MessageTypeUnfollow      = "unfollow"
+	MessageTypeViewport      = "viewport"
+	MessageTypeAwareness     = "awareness"
+	MessageTypeCommentAdd     = "comment_add"
+	MessageTypeCommentReply   = "comment_reply"
+	MessageTypeCommentResolve = "comment_resolve"
+	MessageTypeSuggestion       = "suggestion"
+	MessageTypeSuggestionAccept = "suggestion_accept"
+	MessageTypeSuggestionReject = "suggestion_reject"
+	MessageTypeChat             = "chat"
+	MessageTypeChatEdit         = "chat_edit"
+	MessageTypeChatDelete       = "chat_delete"
+	MessageTypeNotification     = "notification"
+	MessageTypeNotificationRead = "notifications_read"
+	MessageTypeDocumentDeleted  = "document_deleted"
+	MessageTypeConnection    = "connection" // between cluster nodes only
 	MessageTypeError         = "error"
 )
 
@@ -67,6 +281,9 @@ const (
 type JoinPayload struct {
 	User       User     `json:"user"`
 	DocumentID string   `json:"documentId"`
+	// Token is the user token from POST /api/users. Connections joining
+	// without one are guests and cannot act as a document owner.
+	Token string `json:"token,omitempty"`
 }
 
 type LeavePayload struct {
@@ -80,6 +297,9 @@ type DocumentUpdatePayload struct {
 type OperationPayload struct {
 	Operation  Operation `json:"operation"`
 	DocumentID string    `json:"documentId"`
+	// SuggestionID adds the operation to that pending suggestion instead
+	// of committing it
+	SuggestionID string `json:"suggestionId,omitempty"`
 }
 
 type CursorPayload struct {
@@ -91,9 +311,31 @@ type UserListPayload struct {
 	Users []User `json:"users"`
 }
 
+// ConnectionPayload replicates the membership and presence of one
+// connection to the other nodes of a cluster, so that they all compute the
+// same user list and presence
+type ConnectionPayload struct {
+	DocumentID   string    `json:"documentId"`
+	ConnectionID string    `json:"connectionId"`
+	User         User      `json:"user"`
+	Left         bool      `json:"left,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	LastActive   time.Time `json:"lastActive,omitempty"`
+}
+
 type DocumentSyncPayload struct {
-	Document Document `json:"document"`
-	Users    []User   `json:"users"`
+	Document Document          `json:"document"`
+	Users    []User            `json:"users"`
+	Cursors  []CursorPosition  `json:"cursors,omitempty"`
+	Presence []PresencePayload `json:"presence,omitempty"`
+	// Awareness holds the ephemeral state of each connection
+	Awareness []AwarenessPayload `json:"awareness,omitempty"`
+	// Comments holds the open comment threads
+	Comments []CommentThread `json:"comments,omitempty"`
+	// Suggestions holds the pending suggestions
+	Suggestions []Suggestion `json:"suggestions,omitempty"`
+	// Chat holds the most recent chat messages, oldest first
+	Chat []ChatMessage `json:"chat,omitempty"`
 }
 
 type TitleUpdatePayload struct {
@@ -105,6 +347,7 @@ type CreateRoomPayload struct {
 	User      User   `json:"user"`
 	Title     string `json:"title"`
 	Content   string `json:"content"`
+	Token     string `json:"token,omitempty"`
 }
 
 type CreateRoomResponse struct {
@@ -115,9 +358,144 @@ type CreateRoomResponse struct {
 type JoinRoomPayload struct {
 	User     User   `json:"user"`
 	RoomCode string `json:"roomCode"`
+	Token    string `json:"token,omitempty"`
+}
+
+// Presence statuses. Clients report active or away as their tab becomes
+// visible or hidden; the server marks users idle after a period without
+// activity.
+const (
+	PresenceActive = "active"
+	PresenceIdle   = "idle"
+	PresenceAway   = "away"
+)
+
+type PresencePayload struct {
+	DocumentID string    `json:"documentId"`
+	UserID     string    `json:"userId,omitempty"`
+	Status     string    `json:"status"`
+	LastActive time.Time `json:"lastActive,omitempty"`
+}
+
+// FollowPayload asks to follow UserID, or with unfollow to stop. The
+// server announces both to the room with FollowerID set.
+type FollowPayload struct {
+	DocumentID string `json:"documentId"`
+	UserID     string `json:"userId,omitempty"`
+	FollowerID string `json:"followerId,omitempty"`
+}
+
+// ViewportPayload is the range of lines visible in a user's editor, only
+// relayed to the user's followers
+type ViewportPayload struct {
+	DocumentID string `json:"documentId"`
+	UserID     string `json:"userId,omitempty"`
+	StartLine  int    `json:"startLine"`
+	EndLine    int    `json:"endLine"`
+}
+
+// AwarenessPayload carries free-form ephemeral state of one connection,
+// such as typing or the heading being edited. Each update replaces the
+// connection's previous state; an empty state clears it.
+type AwarenessPayload struct {
+	DocumentID string                 `json:"documentId"`
+	UserID     string                 `json:"userId,omitempty"`
+	ClientID   string                 `json:"clientId,omitempty"`
+	State      map[string]interface{} `json:"state"`
+}
+
+// CommentAddPayload opens a thread on a range of the document
+type CommentAddPayload struct {
+	DocumentID string `json:"documentId"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	Content    string `json:"content"`
+}
+
+// CommentReplyPayload adds a comment to an existing thread
+type CommentReplyPayload struct {
+	DocumentID string `json:"documentId"`
+	ThreadID   string `json:"threadId"`
+	Content    string `json:"content"`
+}
+
+// CommentResolvePayload marks a thread as resolved
+type CommentResolvePayload struct {
+	DocumentID string `json:"documentId"`
+	ThreadID   string `json:"threadId"`
+}
+
+// CommentThreadPayload is broadcast with the thread after each comment
+// message
+type CommentThreadPayload struct {
+	DocumentID string        `json:"documentId"`
+	Thread     CommentThread `json:"thread"`
+}
+
+// ChatPayload posts a chat message to a room
+type ChatPayload struct {
+	DocumentID string `json:"documentId"`
+	Content    string `json:"content"`
+}
+
+// ChatEditPayload replaces the text of one of the sender's messages
+type ChatEditPayload struct {
+	DocumentID string `json:"documentId"`
+	MessageID  string `json:"messageId"`
+	Content    string `json:"content"`
+}
+
+// ChatDeletePayload deletes one of the sender's messages
+type ChatDeletePayload struct {
+	DocumentID string `json:"documentId"`
+	MessageID  string `json:"messageId"`
+}
+
+// ChatMessagePayload is broadcast with the message after each chat message
+type ChatMessagePayload struct {
+	DocumentID string      `json:"documentId"`
+	Message    ChatMessage `json:"message"`
+}
+
+// ChatHistoryResponse is a page of chat history, oldest first. HasMore
+// reports whether older messages exist.
+type ChatHistoryResponse struct {
+	Messages []ChatMessage `json:"messages"`
+	HasMore  bool          `json:"hasMore"`
+}
+
+// NotificationPayload pushes a new notification to its recipient
+type NotificationPayload struct {
+	Notification Notification `json:"notification"`
+}
+
+// NotificationsReadPayload tells a user's connections which notifications
+// were marked as read
+type NotificationsReadPayload struct {
+	UserID string   `json:"userId"`
+	IDs    []string `json:"ids"`
+}
+
+// DocumentDeletedPayload tells a room its document has been deleted
+type DocumentDeletedPayload struct {
+	DocumentID string `json:"documentId"`
+}
+
+// SuggestionPayload is broadcast with a suggestion whenever it changes
+type SuggestionPayload struct {
+	DocumentID string     `json:"documentId"`
+	Suggestion Suggestion `json:"suggestion"`
+}
+
+// SuggestionResolvePayload accepts or rejects a pending suggestion
+type SuggestionResolvePayload struct {
+	DocumentID   string `json:"documentId"`
+	SuggestionID string `json:"suggestionId"`
 }
 
 type ErrorPayload struct {
-	Message string `json:"message"`
-	Code    string `json:"code"`
+	Message    string `json:"message"`
+	Code       string `json:"code"`
+	RetryAfter int64  `json:"retryAfter,omitempty"` // milliseconds, for RATE_LIMITED
+	Field      string `json:"field,omitempty"`      // offending field, for INVALID_PAYLOAD
 }
\ No newline at end of file
@@ -6,7 +6,6 @@ import { useCollaboration } from './hooks/useCollaboration'
 import { useWebSocket } from './hooks/useWebSocket'
 import type { User, CreateRoomResponse, WebSocketMessage } from './types'
 import { MessageTypes } from './types'
-import { v4 as uuidv4 } from 'uuid'
 import './App.css'
 
 function App() {
@@ -32,18 +31,15 @@ function App() {
   // Initialize currentUser for room operations only
   const [currentUser, setCurrentUser] = useState<User | null>(null);
 
-  // Create temporary user for room operations
+  // Register the user with the server, which issues the token that proves
+  // who we are when creating and joining rooms
   useEffect(() => {
     if (!currentUser) {
-      const user: User = {
-        id: uuidv4(),
-        name: userName,
-        color: generateUserColor(),
-        joinedAt: new Date(),
-      };
-      setCurrentUser(user);
+      service.session(userName)
+        .then(({ user }) => setCurrentUser(user))
+        .catch(() => setRoomError('Failed to register with the server'));
     }
-  }, [userName, currentUser]);
+  }, [userName, currentUser, service]);
 
   // Set up room-related WebSocket handlers
   useEffect(() => {
@@ -206,18 +202,14 @@ function App() {
         onUndo={collaboration.undo}
         onRedo={collaboration.redo}
         applyHighlights={collaboration.applyHighlights}
+        following={collaboration.following}
+        followerCount={collaboration.followers.length}
+        followedViewport={collaboration.followedViewport}
+        onFollowUser={collaboration.followUser}
+        onViewportChange={collaboration.updateViewport}
       />
     </div>
   );
 }
 
-// Helper function to generate user colors
-function generateUserColor(): string {
-  const colors = [
-    '#FF6B6B', '#4ECDC4', '#45B7D1', '#96CEB4', '#FFEAA7',
-    '#DDA0DD', '#98D8C8', '#F7DC6F', '#BB8FCE', '#85C1E9',
-  ];
-  return colors[Math.floor(Math.random() * colors.length)];
-}
-
 export default App
@@ -3,7 +3,7 @@ import ReactMarkdown from 'react-markdown';
 import remarkGfm from 'remark-gfm';
 import { Prism as SyntaxHighlighter } from 'react-syntax-highlighter';
 import { oneDark } from 'react-syntax-highlighter/dist/esm/styles/prism';
-import type { ToolbarAction, User, CursorPosition } from '../../types';
+import type { ToolbarAction, User, CursorPosition, SelectionRange, ViewportPayload } from '../../types';
 import Toolbar from '../Toolbar/Toolbar';
 import UserPresence from '../UserPresence/UserPresence';
 import HighlightOverlay from '../HighlightOverlay/HighlightOverlay';
@@ -12,7 +12,7 @@ import './Editor.css';
 interface EditorProps {
   content: string;
   onContentChange: (content: string, selectionStart: number) => void;
-  onCursorChange?: (position: number, line: number, column: number) => void;
+  onCursorChange?: (position: number, line: number, column: number, selections?: SelectionRange[]) => void;
   users?: User[];
   cursors?: CursorPosition[];
   currentUserId?: string;
@@ -22,6 +22,11 @@ interface EditorProps {
   onUndo?: () => void;
   onRedo?: () => void;
   applyHighlights?: (content: string) => React.ReactNode[];
+  following?: string | null;
+  followerCount?: number;
+  followedViewport?: ViewportPayload | null;
+  onFollowUser?: (userId: string | null) => void;
+  onViewportChange?: (startLine: number, endLine: number) => void;
 }
 
 const Editor: React.FC<EditorProps> = ({ 
@@ -36,7 +41,12 @@ const Editor: React.FC<EditorProps> = ({
   canRedo = false,
   onUndo = () => {},
   onRedo = () => {},
-  applyHighlights
+  applyHighlights,
+  following = null,
+  followerCount = 0,
+  followedViewport = null,
+  onFollowUser,
+  onViewportChange
 }) => {
   const [isDragging, setIsDragging] = useState(false);
   const [splitPosition, setSplitPosition] = useState(50);
@@ -121,7 +131,9 @@ const Editor: React.FC<EditorProps> = ({
 
   const handleCursorMove = (e: React.SyntheticEvent<HTMLTextAreaElement>) => {
     const textarea = e.target as HTMLTextAreaElement;
-    const position = textarea.selectionStart;
+    const backward = textarea.selectionDirection === 'backward';
+    const anchor = backward ? textarea.selectionEnd : textarea.selectionStart;
+    const position = backward ? textarea.selectionStart : textarea.selectionEnd;
     const content = textarea.value;
     
     // Calculate line and column
@@ -130,7 +142,7 @@ const Editor: React.FC<EditorProps> = ({
     const line = lines.length;
     const column = lines[lines.length - 1].length + 1;
     
-    onCursorChange?.(position, line, column);
+    onCursorChange?.(position, line, column, [{ anchor, head: position }]);
   };
 
   const handleKeyDown = (e: React.KeyboardEvent<HTMLTextAreaElement>) => {
@@ -210,6 +222,36 @@ const Editor: React.FC<EditorProps> = ({
     setIsDragging(false);
   };
 
+  const getLineHeight = (textarea: HTMLTextAreaElement) => {
+    return parseFloat(getComputedStyle(textarea).lineHeight) || 20;
+  };
+
+  // Report the visible lines for users following us
+  const reportViewport = () => {
+    const textarea = textareaRef.current;
+    if (!textarea || !onViewportChange) return;
+
+    const lineHeight = getLineHeight(textarea);
+    const startLine = Math.floor(textarea.scrollTop / lineHeight) + 1;
+    const endLine = Math.floor((textarea.scrollTop + textarea.clientHeight) / lineHeight) + 1;
+    onViewportChange(startLine, Math.max(startLine, endLine));
+  };
+
+  // Send our viewport as soon as someone starts following
+  useEffect(() => {
+    if (followerCount > 0) {
+      reportViewport();
+    }
+  }, [followerCount]);
+
+  // Scroll along with the user we follow
+  useEffect(() => {
+    const textarea = textareaRef.current;
+    if (!textarea || !followedViewport) return;
+
+    textarea.scrollTop = (followedViewport.startLine - 1) * getLineHeight(textarea);
+  }, [followedViewport]);
+
   // Synchronize scroll between textarea and highlight overlay
   const handleTextareaScroll = (e: React.UIEvent<HTMLTextAreaElement>) => {
     const textarea = e.target as HTMLTextAreaElement;
@@ -219,6 +261,8 @@ const Editor: React.FC<EditorProps> = ({
       overlay.scrollTop = textarea.scrollTop;
       overlay.scrollLeft = textarea.scrollLeft;
     }
+
+    reportViewport();
   };
 
   return (
@@ -228,6 +272,8 @@ const Editor: React.FC<EditorProps> = ({
         cursors={cursors}
         currentUserId={currentUserId}
         isConnected={isConnected}
+        following={following}
+        onFollow={onFollowUser}
       />
       <Toolbar 
         onAction={handleToolbarAction}
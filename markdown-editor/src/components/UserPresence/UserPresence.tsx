@@ -7,12 +7,16 @@ interface UserPresenceProps {
   cursors: CursorPosition[];
   currentUserId?: string;
   isConnected: boolean;
+  following?: string | null;
+  onFollow?: (userId: string | null) => void;
 }
 
 const UserPresence: React.FC<UserPresenceProps> = ({ 
   users, 
   currentUserId, 
-  isConnected 
+  isConnected,
+  following = null,
+  onFollow
 }) => {
   console.log(users);
   const otherUsers = users.filter(user => user.id !== currentUserId);
@@ -31,7 +35,12 @@ const UserPresence: React.FC<UserPresenceProps> = ({
           <span className="users-label">Active users:</span>
           <div className="user-avatars">
             {otherUsers.map(user => (
-              <UserAvatar key={user.id} user={user} />
+              <UserAvatar
+                key={user.id}
+                user={user}
+                isFollowed={user.id === following}
+                onClick={() => onFollow?.(user.id === following ? null : user.id)}
+              />
             ))}
           </div>
         </div>
@@ -42,9 +51,11 @@ const UserPresence: React.FC<UserPresenceProps> = ({
 
 interface UserAvatarProps {
   user: User;
+  isFollowed?: boolean;
+  onClick?: () => void;
 }
 
-const UserAvatar: React.FC<UserAvatarProps> = ({ user }) => {
+const UserAvatar: React.FC<UserAvatarProps> = ({ user, isFollowed = false, onClick }) => {
   const initials = user.name
     .split(' ')
     .map(name => name[0])
@@ -52,11 +63,25 @@ const UserAvatar: React.FC<UserAvatarProps> = ({ user }) => {
     .toUpperCase()
     .slice(0, 2);
 
+  const devices = user.connections ?? 1;
+  const details = [
+    devices > 1 ? `${devices} devices` : '',
+    user.status && user.status !== 'active' ? user.status : '',
+  ].filter(Boolean);
+  if (user.typing) {
+    details.push('typing…');
+  }
+  if (isFollowed) {
+    details.push('following');
+  }
+  const title = details.length > 0 ? `${user.name} (${details.join(', ')})` : user.name;
+
   return (
     <div 
-      className="user-avatar" 
+      className={`user-avatar ${user.status ?? 'active'}${isFollowed ? ' followed' : ''}${user.typing ? ' typing' : ''}`} 
       style={{ backgroundColor: user.color }}
-      title={user.name}
+      title={title}
+      onClick={onClick}
     >
       {initials}
     </div>
@@ -1,4 +1,4 @@
-import { useState, useEffect, useCallback, useRef } from 'react';
+import { useState, useEffect, useCallback, useRef, useMemo } from 'react';
 import { v4 as uuidv4 } from 'uuid';
 import { useWebSocket } from './useWebSocket';
 import { useUndoRedo, generateOperationsWithUndo } from './useUndoRedo';
@@ -7,6 +7,7 @@ import type {
   User, 
   Document, 
   CursorPosition, 
+  SelectionRange,
   Operation, 
   WebSocketMessage,
   DocumentSyncPayload,
@@ -14,6 +15,21 @@ import type {
   CursorPayload,
   JoinPayload,
   LeavePayload,
+  UserListPayload,
+  PresencePayload,
+  PresenceStatus,
+  FollowPayload,
+  ViewportPayload,
+  AwarenessPayload,
+  CommentThread,
+  CommentThreadPayload,
+  Suggestion,
+  SuggestionPayload,
+  ChatMessage,
+  ChatMessagePayload,
+  Notification,
+  NotificationPayload,
+  NotificationsReadPayload,
   TitleUpdatePayload
 } from '../types';
 import { MessageTypes } from '../types';
@@ -22,17 +38,38 @@ interface CollaborationState {
   document: Document | null;
   users: User[];
   cursors: CursorPosition[];
+  presence: Record<string, PresenceStatus>;
+  following: string | null; // user whose viewport we follow
+  followers: string[]; // users following our viewport
+  followedViewport: ViewportPayload | null;
+  awareness: Record<string, AwarenessPayload>; // by connection
+  comments: CommentThread[]; // open threads
+  suggestions: Suggestion[]; // pending, oldest first
+  chat: ChatMessage[]; // oldest first
+  notifications: Notification[]; // pushed live, newest first
   currentUser: User | null;
   isLoading: boolean;
   error: string | null;
 }
 
+// How long after the last keystroke we stop showing as typing
+const TYPING_TIMEOUT = 2000;
+
 export const useCollaboration = (documentId: string, userName: string) => {
   const { isConnected, on, off, service } = useWebSocket();
   const [state, setState] = useState<CollaborationState>({
     document: null,
     users: [],
     cursors: [],
+    presence: {},
+    following: null,
+    followers: [],
+    followedViewport: null,
+    awareness: {},
+    comments: [],
+    suggestions: [],
+    chat: [],
+    notifications: [],
     currentUser: null,
     isLoading: true,
     error: null,
@@ -53,18 +90,15 @@ export const useCollaboration = (documentId: string, userName: string) => {
     return user?.color || '#999999';
   }, [state.users]);
 
-  // Create current user
+  // The same registered user as the room manager, so the rooms it
+  // created are ours
   useEffect(() => {
     if (!state.currentUser && userName) {
-      const user: User = {
-        id: uuidv4(),
-        name: userName,
-        color: generateUserColor(),
-        joinedAt: new Date(),
-      };
-      setState(prev => ({ ...prev, currentUser: user }));
+      service.session(userName)
+        .then(({ user }) => setState(prev => ({ ...prev, currentUser: user })))
+        .catch(() => setState(prev => ({ ...prev, error: 'Failed to register with the server' })));
     }
-  }, [userName, state.currentUser]);
+  }, [userName, state.currentUser, service]);
 
   // Join document when connected and user is ready
   useEffect(() => {
@@ -73,6 +107,18 @@ export const useCollaboration = (documentId: string, userName: string) => {
     }
   }, [isConnected, state.currentUser, documentId, service]);
 
+  // Report the tab as away while it is hidden
+  useEffect(() => {
+    if (!isConnected || !state.currentUser || !documentId) return;
+
+    const handleVisibilityChange = () => {
+      service.sendPresence(document.hidden ? 'away' : 'active', documentId);
+    };
+
+    document.addEventListener('visibilitychange', handleVisibilityChange);
+    return () => document.removeEventListener('visibilitychange', handleVisibilityChange);
+  }, [isConnected, state.currentUser, documentId, service]);
+
   const applyRemoteOperation = useCallback((operation: Operation) => {
     console.log('applyRemoteOperation called with:', operation);
     setState(prev => {
@@ -109,10 +155,28 @@ export const useCollaboration = (documentId: string, userName: string) => {
         ...prev,
         document: payload.document,
         users: deduplicateUsersByName(payload.users, prev.currentUser?.id),
+        cursors: (payload.cursors || []).filter(cursor => cursor.userId !== prev.currentUser?.id),
+        presence: Object.fromEntries(
+          (payload.presence || []).map(p => [p.userId, p.status])
+        ),
+        following: null,
+        followers: [],
+        followedViewport: null,
+        awareness: Object.fromEntries(
+          (payload.awareness || []).map(a => [a.clientId, a])
+        ),
+        comments: payload.comments || [],
+        suggestions: payload.suggestions || [],
+        chat: payload.chat || [],
         isLoading: false,
         error: null,
       }));
       documentVersionRef.current = payload.document.version;
+
+      // Show everyone's carets and selections right away
+      (payload.cursors || []).forEach(cursor => {
+        highlights.addCursorHighlight(selectionRanges(cursor), cursor.userId, cursor.color || '#999999');
+      });
     };
 
     const handleUserJoin = (message: WebSocketMessage) => {
@@ -131,6 +195,122 @@ export const useCollaboration = (documentId: string, userName: string) => {
         ...prev,
         users: prev.users.filter(user => user.id !== payload.userId),
         cursors: prev.cursors.filter(cursor => cursor.userId !== payload.userId),
+        following: prev.following === payload.userId ? null : prev.following,
+        followers: prev.followers.filter(id => id !== payload.userId),
+        followedViewport: prev.following === payload.userId ? null : prev.followedViewport,
+      }));
+    };
+
+    const handleFollow = (message: WebSocketMessage) => {
+      const payload = message.payload as FollowPayload;
+      const following = message.type === MessageTypes.FOLLOW;
+
+      setState(prev => {
+        const me = prev.currentUser?.id;
+        if (!payload.followerId || payload.followerId === me || payload.userId !== me) {
+          return prev;
+        }
+
+        const followers = prev.followers.filter(id => id !== payload.followerId);
+        return {
+          ...prev,
+          followers: following ? [...followers, payload.followerId] : followers,
+        };
+      });
+    };
+
+    const handleAwareness = (message: WebSocketMessage) => {
+      const payload = message.payload as AwarenessPayload;
+      if (!payload.clientId) return;
+
+      setState(prev => {
+        const awareness = { ...prev.awareness };
+        if (payload.state) {
+          awareness[payload.clientId as string] = payload;
+        } else {
+          delete awareness[payload.clientId as string];
+        }
+        return { ...prev, awareness };
+      });
+    };
+
+    const handleCommentThread = (message: WebSocketMessage) => {
+      const { thread } = message.payload as CommentThreadPayload;
+      setState(prev => {
+        const others = prev.comments.filter(t => t.id !== thread.id);
+        return {
+          ...prev,
+          comments: thread.resolved ? others : [...others, thread].sort((a, b) => a.start - b.start),
+        };
+      });
+    };
+
+    const handleSuggestion = (message: WebSocketMessage) => {
+      const { suggestion } = message.payload as SuggestionPayload;
+      setState(prev => {
+        const others = prev.suggestions.filter(s => s.id !== suggestion.id);
+        return {
+          ...prev,
+          suggestions: suggestion.status === 'pending'
+            ? [...others, suggestion].sort((a, b) => new Date(a.createdAt).getTime() - new Date(b.createdAt).getTime())
+            : others,
+        };
+      });
+    };
+
+    const handleChat = (message: WebSocketMessage) => {
+      const { message: chatMessage } = message.payload as ChatMessagePayload;
+      setState(prev => ({
+        ...prev,
+        chat: prev.chat.some(m => m.id === chatMessage.id)
+          ? prev.chat.map(m => (m.id === chatMessage.id ? chatMessage : m))
+          : [...prev.chat, chatMessage],
+      }));
+    };
+
+    const handleNotification = (message: WebSocketMessage) => {
+      const { notification } = message.payload as NotificationPayload;
+      setState(prev => ({
+        ...prev,
+        notifications: [notification, ...prev.notifications.filter(n => n.id !== notification.id)],
+      }));
+    };
+
+    const handleNotificationsRead = (message: WebSocketMessage) => {
+      const { ids } = message.payload as NotificationsReadPayload;
+      const read = new Set(ids);
+      setState(prev => ({
+        ...prev,
+        notifications: prev.notifications.map(n => (read.has(n.id) ? { ...n, read: true } : n)),
+      }));
+    };
+
+    const handleViewport = (message: WebSocketMessage) => {
+      const payload = message.payload as ViewportPayload;
+      setState(prev => (
+        prev.following === payload.userId ? { ...prev, followedViewport: payload } : prev
+      ));
+    };
+
+    const handleUserList = (message: WebSocketMessage) => {
+      const payload = message.payload as UserListPayload;
+      setState(prev => {
+        const userIds = new Set(payload.users.map(user => user.id));
+        return {
+          ...prev,
+          users: deduplicateUsersByName(payload.users, prev.currentUser?.id),
+          cursors: prev.cursors.filter(cursor => userIds.has(cursor.userId)),
+        };
+      });
+    };
+
+    const handlePresence = (message: WebSocketMessage) => {
+      const payload = message.payload as PresencePayload;
+      if (!payload.userId) return;
+
+      setState(prev => ({
+        ...prev,
+        presence: { ...prev.presence, [payload.userId as string]: payload.status },
       }));
     };
 
@@ -163,8 +343,8 @@ export const useCollaboration = (documentId: string, userName: string) => {
       }
 
       // Add visual highlight for cursor movement
-      const userColor = getUserColor(payload.position.userId);
-      highlights.addCursorHighlight(payload.position.position, payload.position.userId, userColor);
+      const userColor = payload.position.color || getUserColor(payload.position.userId);
+      highlights.addCursorHighlight(selectionRanges(payload.position), payload.position.userId, userColor);
 
       setState(prev => ({
         ...prev,
@@ -199,6 +379,15 @@ export const useCollaboration = (documentId: string, userName: string) => {
       });
     };
 
+    const handleDocumentDeleted = () => {
+      setState(prev => ({
+        ...prev,
+        document: null,
+        error: 'This document has been deleted',
+        isLoading: false,
+      }));
+    };
+
     const handleError = (message: WebSocketMessage) => {
       setState(prev => ({
         ...prev,
@@ -210,18 +399,50 @@ export const useCollaboration = (documentId: string, userName: string) => {
     on(MessageTypes.DOCUMENT_SYNC, handleDocumentSync);
     on(MessageTypes.JOIN, handleUserJoin);
     on(MessageTypes.LEAVE, handleUserLeave);
+    on(MessageTypes.USER_LIST, handleUserList);
+    on(MessageTypes.PRESENCE, handlePresence);
+    on(MessageTypes.FOLLOW, handleFollow);
+    on(MessageTypes.UNFOLLOW, handleFollow);
+    on(MessageTypes.VIEWPORT, handleViewport);
+    on(MessageTypes.AWARENESS, handleAwareness);
+    on(MessageTypes.COMMENT_ADD, handleCommentThread);
+    on(MessageTypes.COMMENT_REPLY, handleCommentThread);
+    on(MessageTypes.COMMENT_RESOLVE, handleCommentThread);
+    on(MessageTypes.SUGGESTION, handleSuggestion);
+    on(MessageTypes.CHAT, handleChat);
+    on(MessageTypes.CHAT_EDIT, handleChat);
+    on(MessageTypes.CHAT_DELETE, handleChat);
+    on(MessageTypes.NOTIFICATION, handleNotification);
+    on(MessageTypes.NOTIFICATIONS_READ, handleNotificationsRead);
     on(MessageTypes.OPERATION, handleOperation);
     on(MessageTypes.TITLE_UPDATE, handleTitleUpdate);
     on(MessageTypes.CURSOR, handleCursor);
+    on(MessageTypes.DOCUMENT_DELETED, handleDocumentDeleted);
     on(MessageTypes.ERROR, handleError);
 
     return () => {
       off(MessageTypes.DOCUMENT_SYNC, handleDocumentSync);
       off(MessageTypes.JOIN, handleUserJoin);
       off(MessageTypes.LEAVE, handleUserLeave);
+      off(MessageTypes.USER_LIST, handleUserList);
+      off(MessageTypes.PRESENCE, handlePresence);
+      off(MessageTypes.FOLLOW, handleFollow);
+      off(MessageTypes.UNFOLLOW, handleFollow);
+      off(MessageTypes.VIEWPORT, handleViewport);
+      off(MessageTypes.AWARENESS, handleAwareness);
+      off(MessageTypes.COMMENT_ADD, handleCommentThread);
+      off(MessageTypes.COMMENT_REPLY, handleCommentThread);
+      off(MessageTypes.COMMENT_RESOLVE, handleCommentThread);
+      off(MessageTypes.SUGGESTION, handleSuggestion);
+      off(MessageTypes.CHAT, handleChat);
+      off(MessageTypes.CHAT_EDIT, handleChat);
+      off(MessageTypes.CHAT_DELETE, handleChat);
+      off(MessageTypes.NOTIFICATION, handleNotification);
+      off(MessageTypes.NOTIFICATIONS_READ, handleNotificationsRead);
       off(MessageTypes.OPERATION, handleOperation);
       off(MessageTypes.TITLE_UPDATE, handleTitleUpdate);
       off(MessageTypes.CURSOR, handleCursor);
+      off(MessageTypes.DOCUMENT_DELETED, handleDocumentDeleted);
       off(MessageTypes.ERROR, handleError);
     };
   }, [on, off, state.currentUser?.id, applyRemoteOperation]);
@@ -271,7 +492,7 @@ export const useCollaboration = (documentId: string, userName: string) => {
     service.sendOperation(operation, documentId);
   }, [state.currentUser, state.document, documentId, service, undoRedo]);
 
-  const updateCursor = useCallback((position: number, line: number, column: number) => {
+  const updateCursor = useCallback((position: number, line: number, column: number, selections?: SelectionRange[]) => {
     if (!state.currentUser) return;
 
     const cursorPosition: CursorPosition = {
@@ -279,11 +500,108 @@ export const useCollaboration = (documentId: string, userName: string) => {
       position,
       line,
       column,
+      selections,
     };
 
     service.sendCursorPosition(cursorPosition, documentId);
   }, [state.currentUser, documentId, service]);
 
+  // Follow another user's viewport, or stop following with null
+  const followUser = useCallback((userId: string | null) => {
+    if (userId) {
+      service.followUser(userId, documentId);
+    } else {
+      service.unfollowUser(documentId);
+    }
+    setState(prev => ({ ...prev, following: userId, followedViewport: null }));
+  }, [documentId, service]);
+
+  // Report our visible lines, only while someone follows us
+  const updateViewport = useCallback((startLine: number, endLine: number) => {
+    if (state.followers.length === 0) return;
+    service.sendViewport(startLine, endLine, documentId);
+  }, [state.followers.length, documentId, service]);
+
+  const addComment = useCallback((start: number, end: number, content: string) => {
+    service.addComment(start, end, content, documentId);
+  }, [documentId, service]);
+
+  const replyToComment = useCallback((threadId: string, content: string) => {
+    service.replyToComment(threadId, content, documentId);
+  }, [documentId, service]);
+
+  const resolveComment = useCallback((threadId: string) => {
+    service.resolveComment(threadId, documentId);
+  }, [documentId, service]);
+
+  // Propose edits made against the current version without applying them;
+  // returns the suggestion id, or pass one to extend our own suggestion
+  const suggestChange = useCallback((
+    changes: Pick<Operation, 'type' | 'position' | 'content' | 'length'>[],
+    suggestionId: string = uuidv4(),
+  ) => {
+    if (!state.currentUser) return suggestionId;
+
+    changes.forEach(change => {
+      service.sendOperation({
+        ...change,
+        userId: state.currentUser!.id,
+        timestamp: new Date(),
+        version: documentVersionRef.current + 1,
+      }, documentId, suggestionId);
+    });
+    return suggestionId;
+  }, [state.currentUser, documentId, service]);
+
+  const sendChat = useCallback((content: string) => {
+    service.sendChat(content, documentId);
+  }, [documentId, service]);
+
+  const editChat = useCallback((messageId: string, content: string) => {
+    service.editChat(messageId, content, documentId);
+  }, [documentId, service]);
+
+  const deleteChat = useCallback((messageId: string) => {
+    service.deleteChat(messageId, documentId);
+  }, [documentId, service]);
+
+  // Prepend older messages fetched from the chat history endpoint
+  const prependChatHistory = useCallback((messages: ChatMessage[]) => {
+    setState(prev => {
+      const known = new Set(prev.chat.map(m => m.id));
+      return { ...prev, chat: [...messages.filter(m => !known.has(m.id)), ...prev.chat] };
+    });
+  }, []);
+
+  const acceptSuggestion = useCallback((suggestionId: string) => {
+    service.acceptSuggestion(suggestionId, documentId);
+  }, [documentId, service]);
+
+  const rejectSuggestion = useCallback((suggestionId: string) => {
+    service.rejectSuggestion(suggestionId, documentId);
+  }, [documentId, service]);
+
+  // Publish our ephemeral awareness state, replacing the previous one
+  const awarenessRef = useRef<Record<string, unknown>>({});
+  const setAwareness = useCallback((changes: Record<string, unknown>) => {
+    awarenessRef.current = { ...awarenessRef.current, ...changes };
+    service.sendAwareness(awarenessRef.current, documentId);
+  }, [documentId, service]);
+
+  // Flag ourselves as typing until a short pause
+  const typingTimeoutRef = useRef<NodeJS.Timeout | null>(null);
+  const markTyping = useCallback(() => {
+    if (typingTimeoutRef.current) {
+      clearTimeout(typingTimeoutRef.current);
+    } else {
+      setAwareness({ typing: true });
+    }
+    typingTimeoutRef.current = setTimeout(() => {
+      typingTimeoutRef.current = null;
+      setAwareness({ typing: false });
+    }, TYPING_TIMEOUT);
+  }, [setAwareness]);
+
   const updateContent = useCallback((newContent: string, _selectionStart: number) => {
     if (!state.document) {
       console.log('Cannot update content: no document');
@@ -294,6 +612,8 @@ export const useCollaboration = (documentId: string, userName: string) => {
     console.log('updateContent called:', { oldContent, newContent });
     
     // Use enhanced operation generation that preserves deleted content for undo
+    markTyping();
+
     const operations = generateOperationsWithUndo(oldContent, newContent, state.currentUser?.id || '');
     console.log('Generated operations:', operations);
     
@@ -302,7 +622,7 @@ export const useCollaboration = (documentId: string, userName: string) => {
         sendOperation(op.type, op.position, op.content, op.length);
       }
     });
-  }, [state.document, state.currentUser?.id, sendOperation]);
+  }, [state.document, state.currentUser?.id, sendOperation, markTyping]);
 
   const updateTitle = useCallback((newTitle: string) => {
     if (!state.document) {
@@ -386,11 +706,39 @@ export const useCollaboration = (documentId: string, userName: string) => {
     }
   }, [undoRedo, state.document, sendOperation]);
 
+  // Users with their latest presence status and awareness
+  const users = useMemo(() => {
+    const typing = new Set(
+      Object.values(state.awareness)
+        .filter(a => a.state?.typing)
+        .map(a => a.userId)
+    );
+    return state.users.map(user => ({
+      ...user,
+      status: state.presence[user.id] ?? user.status,
+      typing: typing.has(user.id),
+    }));
+  }, [state.users, state.presence, state.awareness]);
+
   return {
     ...state,
+    users,
     isConnected,
     sendOperation,
     updateCursor,
+    followUser,
+    updateViewport,
+    setAwareness,
+    addComment,
+    replyToComment,
+    resolveComment,
+    suggestChange,
+    acceptSuggestion,
+    rejectSuggestion,
+    sendChat,
+    editChat,
+    deleteChat,
+    prependChatHistory,
     updateContent,
     updateTitle,
     // Undo/Redo functionality
@@ -406,13 +754,16 @@ export const useCollaboration = (documentId: string, userName: string) => {
   };
 };
 
-// Helper functions
-function generateUserColor(): string {
-  const colors = [
-    '#FF6B6B', '#4ECDC4', '#45B7D1', '#96CEB4', '#FFEAA7',
-    '#DDA0DD', '#98D8C8', '#F7DC6F', '#BB8FCE', '#85C1E9',
-  ];
-  return colors[Math.floor(Math.random() * colors.length)];
+// Ranges to highlight for a cursor, falling back to its single caret
+function selectionRanges(cursor: CursorPosition): Array<{ start: number; end: number }> {
+  const selections = cursor.selections?.length
+    ? cursor.selections
+    : [{ anchor: cursor.position, head: cursor.position }];
+
+  return selections.map(selection => ({
+    start: Math.min(selection.anchor, selection.head),
+    end: Math.max(selection.anchor, selection.head),
+  }));
 }
 
 function deduplicateUsersByName(users: User[], currentUserId?: string): User[] {
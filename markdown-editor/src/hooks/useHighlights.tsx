@@ -11,6 +11,11 @@ interface Highlight {
   type: 'insert' | 'delete' | 'cursor';
 }
 
+interface HighlightRange {
+  start: number;
+  end: number;
+}
+
 export const useHighlights = (currentUserId: string | undefined) => {
   const [highlights, setHighlights] = useState<Highlight[]>([]);
   const highlightTimeoutRefs = useRef<Map<string, NodeJS.Timeout>>(new Map());
@@ -59,17 +64,18 @@ export const useHighlights = (currentUserId: string | undefined) => {
     highlightTimeoutRefs.current.set(highlightId, timeoutId);
   }, [currentUserId]);
 
-  // Add a cursor highlight
-  const addCursorHighlight = useCallback((position: number, userId: string, userColor: string) => {
+  // Add a cursor highlight covering each of the user's carets and selections
+  const addCursorHighlight = useCallback((ranges: HighlightRange[], userId: string, userColor: string) => {
     // Don't highlight our own cursor
     if (!currentUserId || userId === currentUserId) {
       return;
     }
 
     const highlightId = `cursor-${userId}`;
+    const isUserCursor = (h: Highlight) => h.type === 'cursor' && h.userId === userId;
     
-    // Remove existing cursor highlight for this user
-    setHighlights(prev => prev.filter(h => h.id !== highlightId));
+    // Remove existing cursor highlights for this user
+    setHighlights(prev => prev.filter(h => !isUserCursor(h)));
     
     // Clear existing timeout
     const existingTimeout = highlightTimeoutRefs.current.get(highlightId);
@@ -77,21 +83,21 @@ export const useHighlights = (currentUserId: string | undefined) => {
       clearTimeout(existingTimeout);
     }
 
-    const highlight: Highlight = {
-      id: highlightId,
-      start: position,
-      end: position,
+    const cursorHighlights: Highlight[] = ranges.map((range, index) => ({
+      id: `${highlightId}-${index}`,
+      start: range.start,
+      end: range.end,
       color: userColor,
       userId,
       timestamp: new Date(),
       type: 'cursor',
-    };
+    }));
 
-    setHighlights(prev => [...prev, highlight]);
+    setHighlights(prev => [...prev, ...cursorHighlights]);
 
     // Set timeout to remove cursor highlight
     const timeoutId = setTimeout(() => {
-      setHighlights(prev => prev.filter(h => h.id !== highlightId));
+      setHighlights(prev => prev.filter(h => !isUserCursor(h)));
       highlightTimeoutRefs.current.delete(highlightId);
     }, HIGHLIGHT_DURATION);
 
@@ -1,4 +1,4 @@
-import type { WebSocketMessage, User, CursorPosition, Operation, CreateRoomPayload, JoinRoomPayload } from '../types';
+import type { WebSocketMessage, User, UserSession, CursorPosition, Operation, CreateRoomPayload, JoinRoomPayload, PresenceStatus } from '../types';
 import { MessageTypes } from '../types';
 
 export type WebSocketEventHandler = (message: WebSocketMessage) => void;
@@ -11,6 +11,8 @@ export class WebSocketService {
   private maxReconnectAttempts = 5;
   private reconnectDelay = 1000;
   private isConnecting = false;
+  private userSession: Promise<UserSession> | null = null;
+  private token: string | null = null;
 
   constructor(url: string = 'ws://localhost:8080/ws') {
     this.url = url;
@@ -23,9 +25,9 @@ export class WebSocketService {
 
     this.isConnecting = true;
 
-    return new Promise((resolve, reject) => {
+    return this.connectionUrl().then((url) => new Promise<void>((resolve, reject) => {
       try {
-        this.ws = new WebSocket(this.url);
+        this.ws = new WebSocket(url);
 
         this.ws.onopen = () => {
           console.log('WebSocket connected');
@@ -58,7 +60,52 @@ export class WebSocketService {
         this.isConnecting = false;
         reject(error);
       }
-    });
+    }));
+  }
+
+  // Appends a short-lived token from /api/ws-token, which the server
+  // requires on upgrade when WS_REQUIRE_TOKEN is set. Without one the
+  // plain URL is used, so servers that don't require it still work.
+  private async connectionUrl(): Promise<string> {
+    try {
+      const response = await fetch(this.apiUrl('/api/ws-token'));
+      if (!response.ok) {
+        return this.url;
+      }
+      const { token } = await response.json();
+      return `${this.url}?token=${encodeURIComponent(token)}`;
+    } catch {
+      return this.url;
+    }
+  }
+
+  // Registers the user with POST /api/users, once per tab. The token it
+  // returns is sent on every join, so the server knows the connection is
+  // that user and not just someone using their ID.
+  session(name: string): Promise<UserSession> {
+    if (!this.userSession) {
+      this.userSession = fetch(this.apiUrl('/api/users'), {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ name }),
+      }).then(async (response) => {
+        if (!response.ok) {
+          throw new Error(`Registering user failed: ${response.status}`);
+        }
+        const { token, ...user } = await response.json();
+        this.token = token;
+        return { user: { ...user, joinedAt: new Date(user.joinedAt) }, token };
+      });
+      // Let a later call try again
+      this.userSession.catch(() => {
+        this.userSession = null;
+      });
+    }
+    return this.userSession;
+  }
+
+  private apiUrl(path: string): string {
+    return this.url.replace(/^ws/, 'http').replace(/\/ws$/, path);
   }
 
   disconnect(): void {
@@ -125,6 +172,7 @@ export class WebSocketService {
       payload: {
         user,
         documentId,
+        token: this.token ?? undefined,
       },
     });
   }
@@ -138,17 +186,69 @@ export class WebSocketService {
     });
   }
 
-  sendOperation(operation: Operation, documentId: string): void {
+  sendOperation(operation: Operation, documentId: string, suggestionId?: string): void {
     this.send({
       type: MessageTypes.OPERATION,
       payload: {
         operation,
         documentId,
+        suggestionId,
       },
       userId: operation.userId,
     });
   }
 
+  sendChat(content: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.CHAT,
+      payload: {
+        content,
+        documentId,
+      },
+    });
+  }
+
+  editChat(messageId: string, content: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.CHAT_EDIT,
+      payload: {
+        messageId,
+        content,
+        documentId,
+      },
+    });
+  }
+
+  deleteChat(messageId: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.CHAT_DELETE,
+      payload: {
+        messageId,
+        documentId,
+      },
+    });
+  }
+
+  acceptSuggestion(suggestionId: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.SUGGESTION_ACCEPT,
+      payload: {
+        suggestionId,
+        documentId,
+      },
+    });
+  }
+
+  rejectSuggestion(suggestionId: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.SUGGESTION_REJECT,
+      payload: {
+        suggestionId,
+        documentId,
+      },
+    });
+  }
+
   sendCursorPosition(position: CursorPosition, documentId: string): void {
     this.send({
       type: MessageTypes.CURSOR,
@@ -159,6 +259,89 @@ export class WebSocketService {
     });
   }
 
+  sendPresence(status: PresenceStatus, documentId: string): void {
+    this.send({
+      type: MessageTypes.PRESENCE,
+      payload: {
+        status,
+        documentId,
+      },
+    });
+  }
+
+  followUser(userId: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.FOLLOW,
+      payload: {
+        userId,
+        documentId,
+      },
+    });
+  }
+
+  unfollowUser(documentId: string): void {
+    this.send({
+      type: MessageTypes.UNFOLLOW,
+      payload: {
+        documentId,
+      },
+    });
+  }
+
+  sendViewport(startLine: number, endLine: number, documentId: string): void {
+    this.send({
+      type: MessageTypes.VIEWPORT,
+      payload: {
+        startLine,
+        endLine,
+        documentId,
+      },
+    });
+  }
+
+  sendAwareness(state: Record<string, unknown>, documentId: string): void {
+    this.send({
+      type: MessageTypes.AWARENESS,
+      payload: {
+        state,
+        documentId,
+      },
+    });
+  }
+
+  addComment(start: number, end: number, content: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.COMMENT_ADD,
+      payload: {
+        start,
+        end,
+        content,
+        documentId,
+      },
+    });
+  }
+
+  replyToComment(threadId: string, content: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.COMMENT_REPLY,
+      payload: {
+        threadId,
+        content,
+        documentId,
+      },
+    });
+  }
+
+  resolveComment(threadId: string, documentId: string): void {
+    this.send({
+      type: MessageTypes.COMMENT_RESOLVE,
+      payload: {
+        threadId,
+        documentId,
+      },
+    });
+  }
+
   sendTitleUpdate(newTitle: string, documentId: string): void {
     this.send({
       type: MessageTypes.TITLE_UPDATE,
@@ -176,6 +359,7 @@ export class WebSocketService {
         user,
         title,
         content,
+        token: this.token ?? undefined,
       },
     });
   }
@@ -186,6 +370,7 @@ export class WebSocketService {
       payload: {
         user,
         roomCode,
+        token: this.token ?? undefined,
       },
     });
   }
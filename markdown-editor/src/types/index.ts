@@ -5,6 +5,8 @@ export interface Document {
   content: string;
   lastModified: Date;
   version: number;
+  ownerId?: string; // may accept suggestions; nobody can when unset
+  metadata?: Record<string, unknown>; // front matter of an imported file
 }
 
 export interface EditorState {
@@ -24,6 +26,18 @@ export interface User {
   name: string;
   color: string;
   joinedAt: Date;
+  connections?: number; // open tabs/devices in the current document
+  status?: PresenceStatus;
+  typing?: boolean; // from awareness state
+}
+
+export type PresenceStatus = 'active' | 'idle' | 'away';
+
+// A user registered with POST /api/users and the token proving it. The
+// token is sent on join, and as a bearer token to the per-user REST API.
+export interface UserSession {
+  user: User;
+  token: string;
 }
 
 export interface CursorPosition {
@@ -31,10 +45,18 @@ export interface CursorPosition {
   position: number;
   line: number;
   column: number;
+  selections?: SelectionRange[]; // primary range first
+  color?: string; // owner's color, set by the server
+}
+
+// A selection from anchor (where it started) to head (the caret)
+export interface SelectionRange {
+  anchor: number;
+  head: number;
 }
 
 export interface Operation {
-  type: 'insert' | 'delete' | 'retain';
+  type: 'insert' | 'delete';
   position: number;
   content?: string;
   length?: number;
@@ -61,6 +83,23 @@ export const MessageTypes = {
   DOCUMENT_SYNC: 'document_sync',
   CREATE_ROOM: 'create_room',
   JOIN_ROOM: 'join_room',
+  PRESENCE: 'presence',
+  FOLLOW: 'follow',
+  UNFOLLOW: 'unfollow',
+  VIEWPORT: 'viewport',
+  AWARENESS: 'awareness',
+  COMMENT_ADD: 'comment_add',
+  COMMENT_REPLY: 'comment_reply',
+  COMMENT_RESOLVE: 'comment_resolve',
+  SUGGESTION: 'suggestion',
+  SUGGESTION_ACCEPT: 'suggestion_accept',
+  SUGGESTION_REJECT: 'suggestion_reject',
+  CHAT: 'chat',
+  CHAT_EDIT: 'chat_edit',
+  CHAT_DELETE: 'chat_delete',
+  NOTIFICATION: 'notification',
+  NOTIFICATIONS_READ: 'notifications_read',
+  DOCUMENT_DELETED: 'document_deleted',
   ERROR: 'error',
 } as const;
 
@@ -68,6 +107,7 @@ export const MessageTypes = {
 export interface JoinPayload {
   user: User;
   documentId: string;
+  token?: string;
 }
 
 export interface LeavePayload {
@@ -81,6 +121,7 @@ export interface DocumentUpdatePayload {
 export interface OperationPayload {
   operation: Operation;
   documentId: string;
+  suggestionId?: string; // held as a suggestion instead of committed
 }
 
 export interface CursorPayload {
@@ -95,6 +136,119 @@ export interface UserListPayload {
 export interface DocumentSyncPayload {
   document: Document;
   users: User[];
+  cursors?: CursorPosition[];
+  presence?: PresencePayload[];
+  awareness?: AwarenessPayload[];
+  comments?: CommentThread[]; // open threads
+  suggestions?: Suggestion[]; // pending suggestions
+  chat?: ChatMessage[]; // latest messages, oldest first
+}
+
+// Deleted messages keep their place with empty content
+export interface ChatMessage {
+  id: string;
+  documentId: string;
+  userId: string;
+  userName: string;
+  content: string;
+  createdAt: Date;
+  editedAt?: Date;
+  deleted?: boolean;
+}
+
+export interface ChatMessagePayload {
+  documentId: string;
+  message: ChatMessage;
+}
+
+// An inbox entry; threadId is set for mentions in comments
+export interface Notification {
+  id: string;
+  userId: string;
+  type: 'mention';
+  documentId: string;
+  actorId: string;
+  actorName: string;
+  threadId?: string;
+  excerpt: string;
+  read: boolean;
+  createdAt: Date;
+}
+
+export interface NotificationPayload {
+  notification: Notification;
+}
+
+export interface NotificationsReadPayload {
+  userId: string;
+  ids: string[];
+}
+
+// A page from GET /api/documents/{id}/chat, oldest first
+export interface ChatHistoryResponse {
+  messages: ChatMessage[];
+  hasMore: boolean;
+}
+
+// A change set proposed against baseVersion; operations apply in order
+export interface Suggestion {
+  id: string;
+  documentId: string;
+  userId: string;
+  userName: string;
+  baseVersion: number;
+  operations: Operation[];
+  status: 'pending' | 'accepted' | 'rejected';
+  resolvedBy?: string;
+  resolvedAt?: Date;
+  createdAt: Date;
+}
+
+export interface SuggestionPayload {
+  documentId: string;
+  suggestion: Suggestion;
+}
+
+// A discussion anchored to the text between start and end
+export interface CommentThread {
+  id: string;
+  documentId: string;
+  start: number;
+  end: number;
+  quote: string;
+  comments: Comment[];
+  resolved: boolean;
+  resolvedBy?: string;
+  resolvedAt?: Date;
+  createdAt: Date;
+}
+
+export interface Comment {
+  id: string;
+  userId: string;
+  userName: string;
+  content: string;
+  createdAt: Date;
+}
+
+export interface CommentThreadPayload {
+  documentId: string;
+  thread: CommentThread;
+}
+
+// Ephemeral per-connection state such as typing; null state clears it
+export interface AwarenessPayload {
+  documentId: string;
+  userId?: string;
+  clientId?: string;
+  state: Record<string, unknown> | null;
+}
+
+export interface PresencePayload {
+  documentId: string;
+  userId?: string;
+  status: PresenceStatus;
+  lastActive?: Date;
 }
 
 export interface TitleUpdatePayload {
@@ -106,6 +260,7 @@ export interface CreateRoomPayload {
   user: User;
   title: string;
   content: string;
+  token?: string;
 }
 
 export interface CreateRoomResponse {
@@ -116,6 +271,22 @@ export interface CreateRoomResponse {
 export interface JoinRoomPayload {
   user: User;
   roomCode: string;
+  token?: string;
+}
+
+// Announced to the room when followerId starts or stops following userId
+export interface FollowPayload {
+  documentId: string;
+  userId?: string;
+  followerId?: string;
+}
+
+// Lines visible in a followed user's editor, 1-based and inclusive
+export interface ViewportPayload {
+  documentId: string;
+  userId?: string;
+  startLine: number;
+  endLine: number;
 }
 
 export interface ErrorPayload {